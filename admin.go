@@ -0,0 +1,39 @@
+package fam100
+
+import "fmt"
+
+// Role is the permission of a bot admin, a role includes the permission of the roles below it
+type Role string
+
+// Admin roles from the most to the least permission
+const (
+	RoleOperator  Role = "operator"  // broadcast, say, merge and granting roles
+	RoleModerator Role = "moderator" // stats, channels and disabling channels
+	RoleEditor    Role = "editor"    // content of the channels: motd, question limit and language
+)
+
+// Roles are the valid admin roles
+var Roles = []Role{RoleOperator, RoleModerator, RoleEditor}
+
+func (r Role) level() int {
+	for i, role := range Roles {
+		if r == role {
+			return len(Roles) - i
+		}
+	}
+	return 0
+}
+
+// Includes returns true if the role has the permission of other
+func (r Role) Includes(other Role) bool {
+	return r.level() > 0 && r.level() >= other.level()
+}
+
+// ParseRole returns the role by name
+func ParseRole(name string) (Role, error) {
+	role := Role(name)
+	if role.level() == 0 {
+		return "", fmt.Errorf("unknown role %q, valid roles are %v", name, Roles)
+	}
+	return role, nil
+}
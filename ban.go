@@ -0,0 +1,71 @@
+package fam100
+
+import (
+	"time"
+
+	"github.com/uber-go/zap"
+)
+
+// Ban stops a player from playing in a channel, or in every channel when ChanID is empty
+type Ban struct {
+	PlayerID PlayerID  `json:"playerID"`
+	Name     string    `json:"name,omitempty"`
+	ChanID   string    `json:"chanID,omitempty"`
+	Reason   string    `json:"reason,omitempty"`
+	BannedBy string    `json:"bannedBy"`
+	BannedAt time.Time `json:"bannedAt"`
+}
+
+// Global returns true if the player is banned from every channel
+func (b Ban) Global() bool {
+	return b.ChanID == ""
+}
+
+// BanPlayer stores the ban and removes the player from the ranking of the channel, or from every ranking
+// for a global ban. The scores are not restored when the ban is removed
+func BanPlayer(b Ban) error {
+	if b.BannedAt.IsZero() {
+		b.BannedAt = time.Now()
+	}
+	if b.Name == "" {
+		if names, err := DefaultDB.playerNames(b.PlayerID); err == nil && len(names) > 0 {
+			b.Name = names[0]
+		}
+	}
+	if err := DefaultDB.AddBan(b); err != nil {
+		return err
+	}
+	if !b.Global() {
+		return DefaultDB.RemoveFromRanking(b.ChanID, b.PlayerID)
+	}
+
+	chanIDs, err := DefaultDB.RankedChannels()
+	if err != nil {
+		return err
+	}
+	// empty channel is the global ranking
+	for _, chanID := range append(chanIDs, "") {
+		if err := DefaultDB.RemoveFromRanking(chanID, b.PlayerID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// withoutBanned returns the rank without the players banned from the channel. The answers of a player
+// banned during the round are already in the round rank
+func withoutBanned(chanID string, rank Rank) Rank {
+	var filtered Rank
+	for _, ps := range rank {
+		banned, err := DefaultDB.Banned(chanID, ps.PlayerID)
+		if err != nil {
+			log.Error("loading ban failed", zap.String("chanID", chanID), zap.String("playerID", string(ps.PlayerID)), zap.Error(err))
+		}
+		if banned {
+			continue
+		}
+		ps.Position = len(filtered) + 1
+		filtered = append(filtered, ps)
+	}
+	return filtered
+}
@@ -0,0 +1,52 @@
+package fam100
+
+import "testing"
+
+func TestBanPlayer(t *testing.T) {
+	oDB := DefaultDB
+	defer func() { DefaultDB = oDB }()
+	DefaultDB = &MemoryDB{}
+
+	for _, chanID := range []string{"-1", "-2"} {
+		rank := Rank{{PlayerID: "griefer", Name: "Griefer", Score: 10}, {PlayerID: "player", Name: "Player", Score: 5}}
+		if err := DefaultDB.saveScore(chanID, "Channel "+chanID, rank); err != nil {
+			t.Fatal(err)
+		}
+	}
+	inRanking := func(rank Rank, playerID PlayerID) bool {
+		for _, ps := range rank {
+			if ps.PlayerID == playerID {
+				return true
+			}
+		}
+		return false
+	}
+
+	if err := BanPlayer(Ban{PlayerID: "griefer", ChanID: "-1", BannedBy: "admin"}); err != nil {
+		t.Fatal(err)
+	}
+	bans, _ := DefaultDB.Bans("-1")
+	if len(bans) != 1 || bans[0].Name != "Griefer" || bans[0].BannedAt.IsZero() {
+		t.Errorf("unexpected bans %+v", bans)
+	}
+	rank1, _ := DefaultDB.ChannelRanking("-1", 10)
+	rank2, _ := DefaultDB.ChannelRanking("-2", 10)
+	if inRanking(rank1, "griefer") || !inRanking(rank2, "griefer") || !inRanking(rank1, "player") {
+		t.Errorf("channel ban removes the player from the channel ranking only, got %v and %v", rank1, rank2)
+	}
+
+	if err := BanPlayer(Ban{PlayerID: "griefer", BannedBy: "admin"}); err != nil {
+		t.Fatal(err)
+	}
+	rank2, _ = DefaultDB.ChannelRanking("-2", 10)
+	global, _ := DefaultDB.playerRanking(10)
+	if inRanking(rank2, "griefer") || inRanking(global, "griefer") || !inRanking(global, "player") {
+		t.Errorf("global ban removes the player from every ranking, got %v and %v", rank2, global)
+	}
+
+	// a banned player answering before the ban does not score at the end of the round
+	round := withoutBanned("-2", Rank{{PlayerID: "griefer", Score: 10, Position: 1}, {PlayerID: "player", Score: 5, Position: 2}})
+	if len(round) != 1 || round[0].PlayerID != "player" || round[0].Position != 1 {
+		t.Errorf("round rank want player at 1 got %v", round)
+	}
+}
@@ -0,0 +1,139 @@
+package fam100
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"time"
+)
+
+// BroadcastStatus is the state of a broadcast job
+type BroadcastStatus string
+
+// Broadcast states, a running broadcast is resumed after restart
+const (
+	BroadcastRunning   BroadcastStatus = "running"
+	BroadcastDone      BroadcastStatus = "done"
+	BroadcastCancelled BroadcastStatus = "cancelled"
+)
+
+// DefaultLang is the language of a channel without "lang" config
+const DefaultLang = "id"
+
+// BroadcastTarget selects the channels of a broadcast, the zero value selects every channel
+type BroadcastTarget struct {
+	Name     string `json:"name,omitempty"`     // regexp of the channel name
+	Lang     string `json:"lang,omitempty"`     // "lang" config of the channel
+	MinGames int    `json:"minGames,omitempty"` // games started in the channel
+}
+
+// Broadcast is a message sent to many channels. The channels are selected when it is created and
+// Next is the channel to send to, so a broadcast resumed after restart does not send twice
+type Broadcast struct {
+	ID        int64           `json:"id"`
+	CreatedBy string          `json:"createdBy"` // chat of the admin receiving the progress
+	CreatedAt time.Time       `json:"createdAt"`
+	Text      string          `json:"text"`
+	Target    BroadcastTarget `json:"target"`
+	Status    BroadcastStatus `json:"status"`
+	Channels  []string        `json:"channels"`
+	Next      int             `json:"next"`
+	Sent      int             `json:"sent"`
+	Failed    int             `json:"failed"`
+	Removed   int             `json:"removed"` // channels that kicked the bot
+}
+
+// BroadcastChannels returns the channels matching the target ordered by ID
+func BroadcastChannels(target BroadcastTarget) ([]string, error) {
+	var nameRe *regexp.Regexp
+	if target.Name != "" {
+		var err error
+		if nameRe, err = regexp.Compile(target.Name); err != nil {
+			return nil, fmt.Errorf("invalid name regexp: %s", err)
+		}
+	}
+	channels, err := DefaultDB.Channels()
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []string
+	for id, name := range channels {
+		if nameRe != nil && !nameRe.MatchString(name) {
+			continue
+		}
+		if target.Lang != "" {
+			lang, err := DefaultDB.ChannelConfig(id, "lang", DefaultLang)
+			if err != nil {
+				return nil, err
+			}
+			if lang != target.Lang {
+				continue
+			}
+		}
+		if target.MinGames > 0 {
+			games, err := statsInt(DefaultDB.channelStats(id, cStatsGameStarted))
+			if err != nil {
+				return nil, err
+			}
+			if games < target.MinGames {
+				continue
+			}
+		}
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	return ids, nil
+}
+
+// NewBroadcast creates a running broadcast to the channels matching the target
+func NewBroadcast(createdBy, text string, target BroadcastTarget) (Broadcast, error) {
+	channels, err := BroadcastChannels(target)
+	if err != nil {
+		return Broadcast{}, err
+	}
+	b := Broadcast{
+		CreatedBy: createdBy,
+		CreatedAt: time.Now(),
+		Text:      text,
+		Target:    target,
+		Status:    BroadcastRunning,
+		Channels:  channels,
+	}
+	if len(channels) == 0 {
+		b.Status = BroadcastDone
+	}
+
+	return DefaultDB.AddBroadcast(b)
+}
+
+// CancelBroadcast stops a running broadcast, channels already sent to are kept in the progress
+func CancelBroadcast(id int64) (Broadcast, error) {
+	b, err := DefaultDB.Broadcast(id)
+	if err != nil {
+		return Broadcast{}, err
+	}
+	if b == nil {
+		return Broadcast{}, fmt.Errorf("broadcast %d not found", id)
+	}
+	if b.Status != BroadcastRunning {
+		return *b, fmt.Errorf("broadcast %d is %s", id, b.Status)
+	}
+	cancelled, err := DefaultDB.SetBroadcastStatus(id, BroadcastRunning, BroadcastCancelled)
+	if err != nil {
+		return *b, err
+	}
+	if !cancelled {
+		// finished in the meantime
+		return *b, fmt.Errorf("broadcast %d is not running anymore", id)
+	}
+	b.Status = BroadcastCancelled
+
+	return *b, nil
+}
+
+// Done returns true if there is no more channel to send to
+func (b Broadcast) Done() bool {
+	return b.Next >= len(b.Channels)
+}
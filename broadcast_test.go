@@ -0,0 +1,70 @@
+package fam100
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBroadcastChannels(t *testing.T) {
+	oDB := DefaultDB
+	defer func() { DefaultDB = oDB }()
+	memoryDB := &MemoryDB{}
+	DefaultDB = memoryDB
+
+	for id, name := range map[string]string{"-1": "Kuis Jakarta", "-2": "Kuis Bandung", "-3": "Arisan"} {
+		if err := memoryDB.saveScore(id, name, Rank{{PlayerID: "p", Name: "P", Score: 1}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	memoryDB.SetChannelConfig("-2", "lang", "en")
+	for i := 0; i < 3; i++ {
+		memoryDB.incChannelStats("-1", cStatsGameStarted)
+	}
+	memoryDB.incChannelStats("-3", cStatsGameStarted)
+
+	tests := []struct {
+		target BroadcastTarget
+		want   string
+	}{
+		{BroadcastTarget{}, "-1 -2 -3"},
+		{BroadcastTarget{Name: "^Kuis"}, "-1 -2"},
+		{BroadcastTarget{Lang: "id"}, "-1 -3"},
+		{BroadcastTarget{Lang: "en"}, "-2"},
+		{BroadcastTarget{MinGames: 1}, "-1 -3"},
+		{BroadcastTarget{Name: "Kuis", MinGames: 2}, "-1"},
+	}
+	for _, tt := range tests {
+		channels, err := BroadcastChannels(tt.target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.Join(channels, " "); tt.want != got {
+			t.Errorf("%+v want %q got %q", tt.target, tt.want, got)
+		}
+	}
+	if _, err := BroadcastChannels(BroadcastTarget{Name: "("}); err == nil {
+		t.Error("expecting invalid regexp error")
+	}
+
+	b, err := NewBroadcast("admin", "hello", BroadcastTarget{Name: "Kuis"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := BroadcastRunning, b.Status; want != got {
+		t.Errorf("status want %s got %s", want, got)
+	}
+	if b, err = CancelBroadcast(b.ID); err != nil {
+		t.Fatal(err)
+	}
+	if want, got := BroadcastCancelled, b.Status; want != got {
+		t.Errorf("status want %s got %s", want, got)
+	}
+	if _, err := CancelBroadcast(b.ID); err == nil {
+		t.Error("expecting error cancelling cancelled broadcast")
+	}
+
+	// nothing to send
+	if b, err = NewBroadcast("admin", "hello", BroadcastTarget{Name: "none"}); err != nil || b.Status != BroadcastDone {
+		t.Errorf("want done broadcast got %s, %v", b.Status, err)
+	}
+}
@@ -19,7 +19,7 @@ var (
 	roundPlayed int
 	seed        int64
 
-	log    = zap.NewJSON()
+	log    = zap.New(zap.NewJSONEncoder())
 	dbPath = "fam100.db"
 )
 
@@ -28,8 +28,7 @@ func main() {
 	flag.StringVar(&dbPath, "db", "fam100.db", "question database")
 	logLevel := zap.LevelFlag("v", zap.ErrorLevel, "log level: all, debug, info, warn, error, panic, fatal, none")
 	flag.Parse()
-	log.SetLevel(*logLevel)
-	zap.AddCaller()
+	log = zap.New(zap.NewJSONEncoder(), zap.AddCaller(), *logLevel)
 
 	fam100.SetLogger(log)
 
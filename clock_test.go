@@ -0,0 +1,51 @@
+package fam100
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFakeClock(t *testing.T) {
+	start := time.Date(2016, 12, 1, 20, 0, 0, 0, time.UTC)
+	clock := NewFakeClock(start)
+
+	after := clock.After(30 * time.Second)
+	ticker := clock.NewTicker(10 * time.Second)
+	stopped := clock.NewTicker(10 * time.Second)
+	stopped.Stop()
+
+	clock.Advance(10 * time.Second)
+	select {
+	case at := <-ticker.C():
+		if want, got := start.Add(10*time.Second), at; !want.Equal(got) {
+			t.Errorf("tick want %s got %s", want, got)
+		}
+	default:
+		t.Errorf("ticker should tick")
+	}
+	select {
+	case <-after:
+		t.Errorf("after should not fire before 30s")
+	case <-stopped.C():
+		t.Errorf("stopped ticker should not tick")
+	default:
+	}
+
+	// ticks are dropped when the receiver is slow
+	clock.Advance(25 * time.Second)
+	if want, got := start.Add(35*time.Second), clock.Now(); !want.Equal(got) {
+		t.Errorf("now want %s got %s", want, got)
+	}
+	select {
+	case <-after:
+	default:
+		t.Errorf("after should fire")
+	}
+	if want, got := 1, len(ticker.C()); want != got {
+		t.Errorf("pending ticks want %d got %d", want, got)
+	}
+	ticker.Stop()
+	if want, got := 0, clock.Timers(); want != got {
+		t.Errorf("timers want %d got %d", want, got)
+	}
+}
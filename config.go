@@ -0,0 +1,72 @@
+package fam100
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// ConfigType is the type of a config value
+type ConfigType string
+
+// Config types, values are stored as string
+const (
+	ConfigString ConfigType = "string"
+	ConfigInt    ConfigType = "int" // positive number
+)
+
+// ConfigKey describes a channel config that can be changed
+type ConfigKey struct {
+	Name        string
+	Type        ConfigType
+	Global      bool // can be set globally, the channel config takes precedence
+	Role        Role // admin role needed to change it
+	Description string
+}
+
+// ConfigKeys are the known channel configs, an empty value means the default
+var ConfigKeys = []ConfigKey{
+	{Name: "disabled", Type: ConfigString, Role: RoleModerator, Description: "message replied instead of starting a game"},
+	{Name: "motd", Type: ConfigString, Global: true, Role: RoleEditor, Description: "message shown when a game starts"},
+	{Name: "questionLimit", Type: ConfigInt, Role: RoleEditor, Description: "questions played before they repeat"},
+	{Name: "lang", Type: ConfigString, Role: RoleEditor, Description: "language of the channel, default " + DefaultLang},
+}
+
+// LookupConfigKey returns the config by name
+func LookupConfigKey(name string) (ConfigKey, bool) {
+	for _, key := range ConfigKeys {
+		if key.Name == name {
+			return key, true
+		}
+	}
+	return ConfigKey{}, false
+}
+
+// ConfigKeyNames returns the name of the known configs
+func ConfigKeyNames() []string {
+	names := make([]string, len(ConfigKeys))
+	for i, key := range ConfigKeys {
+		names[i] = key.Name
+	}
+	return names
+}
+
+// CheckConfig returns an error if the key is unknown or the value does not match its type
+func CheckConfig(name, value string, global bool) error {
+	key, ok := LookupConfigKey(name)
+	if !ok {
+		return fmt.Errorf("unknown config key %q, valid keys are %v", name, ConfigKeyNames())
+	}
+	if global && !key.Global {
+		return fmt.Errorf("%s can not be set globally", name)
+	}
+	if value == "" {
+		return nil
+	}
+	switch key.Type {
+	case ConfigInt:
+		if n, err := strconv.Atoi(value); err != nil || n < 0 {
+			return fmt.Errorf("%s must be a positive number", name)
+		}
+	}
+	return nil
+}
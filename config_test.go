@@ -0,0 +1,25 @@
+package fam100
+
+import "testing"
+
+func TestCheckConfig(t *testing.T) {
+	tests := []struct {
+		key, value string
+		global     bool
+		valid      bool
+	}{
+		{"motd", "selamat datang", false, true},
+		{"motd", "selamat datang", true, true},
+		{"disabled", "maintenance", true, false},
+		{"questionLimit", "100", false, true},
+		{"questionLimit", "-1", false, false},
+		{"questionLimit", "many", false, false},
+		{"questionLimit", "", false, true},
+		{"color", "red", false, false},
+	}
+	for _, tt := range tests {
+		if err := CheckConfig(tt.key, tt.value, tt.global); (err == nil) != tt.valid {
+			t.Errorf("%s=%q global %t want valid %t got %v", tt.key, tt.value, tt.global, tt.valid, err)
+		}
+	}
+}
@@ -1,8 +1,13 @@
 package fam100
 
 import (
+	"encoding/json"
 	"fmt"
 	"hash/crc32"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/garyburd/redigo/redis"
@@ -13,21 +18,36 @@ type db interface {
 	Reset() error
 	Init() (err error)
 	ChannelRanking(chanID string, limit int) (ranking Rank, err error)
+	RankedChannels() (chanIDs []string, err error)
+	ResetChannelRanking(chanID string, exported Rank) error
 	ChannelCount() (total int, err error)
 	Channels() (channels map[string]string, err error)
 	ChannelConfig(chanID, key, defaultValue string) (config string, err error)
+	SetChannelConfig(chanID, key, value string) error
+	DeleteChannelConfig(chanID, key string) error
+	MigrateChannel(fromID, toID string) error
+	RemoveChannel(chanID string) error
 	GlobalConfig(key, defaultValue string) (config string, err error)
+	SetGlobalConfig(key, value string) error
+	DeleteGlobalConfig(key string) error
 
 	PlayerCount() (total int, err error)
 	PlayerChannelScore(chanID string, playerID PlayerID) (PlayerScore, error)
+	MergePlayer(fromID, toID PlayerID) error
 
 	// stats command
 	incStats(key string) error
 	incChannelStats(chanID, key string) error
 	incPlayerStats(playerID PlayerID, key string) error
+	incPlayerStatsBy(playerID PlayerID, key string, n int64) error
+	maxPlayerStats(playerID PlayerID, key string, value int64) error
 	stats(key string) (interface{}, error)
 	channelStats(chanID, key string) (interface{}, error)
 	playerStats(playerID, key string) (interface{}, error)
+	addChannelPlayers(chanID string, playerIDs ...PlayerID) error
+	channelPlayerCount(chanID string) (int, error)
+	incHourStats(chanID string, hour int) error
+	hourStats(chanID string) (map[int]int, error)
 
 	nextGame(chanID string) (seed int64, nextRound int, err error)
 	incRoundPlayed(chanID string) error
@@ -36,13 +56,73 @@ type db interface {
 	saveScore(chanID, chanName string, scores Rank) error
 	playerRanking(limit int) (Rank, error)
 	playerScore(playerID PlayerID) (ps PlayerScore, err error)
+	playerNames(playerID PlayerID) ([]string, error)
+
+	// channel ownership when running multiple instances
+	AcquireLease(key, owner string, ttl time.Duration) (holder string, err error)
+	RenewLease(key, owner string, ttl time.Duration) (renewed bool, err error)
+	ReleaseLease(key, owner string) error
+	LeaseHolder(key string) (string, error)
+	RegisterInstance(id string, ttl time.Duration) error
+	UnregisterInstance(id string) error
+	Instances() ([]string, error)
+	PushUpdate(owner string, update []byte, ttl time.Duration) error
+	PopUpdate(owner string, timeout time.Duration) ([]byte, error)
+	SaveGameSnapshot(s GameSnapshot) error
+	GameSnapshot(chanID string) (*GameSnapshot, error)
+	DeleteGameSnapshot(chanID string) error
+	SnapshotChannels() ([]string, error)
+
+	// scheduled games
+	AddSchedule(s Schedule) (Schedule, error)
+	Schedules(chanID string) ([]Schedule, error)
+	RemoveSchedule(chanID string, id int64) (removed bool, err error)
+
+	// tournaments
+	SaveTournament(t Tournament) error
+	Tournament(name string) (*Tournament, error)
+	Tournaments() ([]Tournament, error)
+	ChannelTournaments(chanID string) ([]Tournament, error)
+	DeleteTournament(t Tournament) error
+	AddTournamentScore(name, table string, rank Rank) error
+	TournamentStandings(name, table string, limit int) (Rank, error)
+
+	// broadcasts
+	AddBroadcast(b Broadcast) (Broadcast, error)
+	SaveBroadcast(b Broadcast) error
+	SetBroadcastStatus(id int64, from, to BroadcastStatus) (changed bool, err error)
+	Broadcast(id int64) (*Broadcast, error)
+	Broadcasts() ([]Broadcast, error)
+
+	// bot admins
+	SetAdmin(userID string, role Role) error
+	RemoveAdmin(userID string) (removed bool, err error)
+	AdminRole(userID string) (Role, error)
+	Admins() (map[string]Role, error)
+
+	// player bans, empty chanID is the global ban
+	AddBan(b Ban) error
+	RemoveBan(chanID string, playerID PlayerID) (removed bool, err error)
+	Banned(chanID string, playerID PlayerID) (bool, error)
+	Bans(chanID string) ([]Ban, error)
+	RemoveFromRanking(chanID string, playerID PlayerID) error
 }
 
 var (
 	redisPrefix = "fam100"
 
 	gStatsKey, cStatsKey, pStatsKey, cRankKey, pNameKey, pRankKey string
-	cNameKey, cConfigKey, gConfigKey                              string
+	cNameKey, cConfigKey, gConfigKey, pNameHistoryKey             string
+	leaseKey, cSnapshotKey, scheduleKey, scheduleIDKey            string
+	tournamentKey, tRankKey, cTournamentKey, broadcastKey         string
+	broadcastIDKey, broadcastStatusKey, adminKey, gBanKey         string
+	cBanKey, updateKey, instanceKey                               string
+
+	// maxNameHistory is the number of names kept per player
+	maxNameHistory = 10
+
+	// SnapshotTTL is how long game snapshot is kept, a game is not resumed after that
+	SnapshotTTL = time.Hour
 )
 
 // DefaultDB default question database
@@ -63,9 +143,31 @@ func SetRedisPrefix(prefix string) {
 	cNameKey = fmt.Sprintf("%s_chan_name", redisPrefix)
 	pNameKey = fmt.Sprintf("%s_player_name", redisPrefix)
 	pRankKey = fmt.Sprintf("%s_player_rank", redisPrefix)
+	pNameHistoryKey = fmt.Sprintf("%s_player_names_", redisPrefix)
 
 	cConfigKey = fmt.Sprintf("%s_chan_config_", redisPrefix)
 	gConfigKey = fmt.Sprintf("%s_config", redisPrefix)
+
+	leaseKey = fmt.Sprintf("%s_lease_", redisPrefix)
+	cSnapshotKey = fmt.Sprintf("%s_chan_snapshot_", redisPrefix)
+	scheduleKey = fmt.Sprintf("%s_schedules", redisPrefix)
+	scheduleIDKey = fmt.Sprintf("%s_schedule_id", redisPrefix)
+	tournamentKey = fmt.Sprintf("%s_tournaments", redisPrefix)
+	tRankKey = fmt.Sprintf("%s_tournament_rank_", redisPrefix)
+	cTournamentKey = fmt.Sprintf("%s_chan_tournaments_", redisPrefix)
+	broadcastKey = fmt.Sprintf("%s_broadcasts", redisPrefix)
+	broadcastIDKey = fmt.Sprintf("%s_broadcast_id", redisPrefix)
+	broadcastStatusKey = fmt.Sprintf("%s_broadcast_status", redisPrefix)
+	adminKey = fmt.Sprintf("%s_admins", redisPrefix)
+	gBanKey = fmt.Sprintf("%s_bans", redisPrefix)
+	cBanKey = fmt.Sprintf("%s_chan_bans_", redisPrefix)
+	updateKey = fmt.Sprintf("%s_updates_", redisPrefix)
+	instanceKey = fmt.Sprintf("%s_instances", redisPrefix)
+}
+
+// ChannelFileName returns name of the channel to be used in file names and urls of the score archive
+func ChannelFileName(chanID string) string {
+	return strings.Replace(chanID, "-", "!", -1)
 }
 
 type RedisDB struct {
@@ -136,6 +238,9 @@ func (r *RedisDB) ChannelConfig(chanID, key, defaultValue string) (config string
 
 	rkey := fmt.Sprintf("%s%s", cConfigKey, chanID)
 	config, err = redis.String(conn.Do("HGET", rkey, key))
+	if err == redis.ErrNil {
+		return defaultValue, nil
+	}
 
 	if err != nil || config == "" {
 		return defaultValue, err
@@ -144,6 +249,236 @@ func (r *RedisDB) ChannelConfig(chanID, key, defaultValue string) (config string
 	return config, nil
 }
 
+// migrateScript moves channel data from one id to another, merging with existing data of the target.
+// KEYS: rank, name hash, config, players, hour, bans (from & to pairs), followed by stats counter pairs
+// ARGV: fromID, toID
+var migrateScript = redis.NewScript(-1, `
+if redis.call('EXISTS', KEYS[1]) == 1 then
+	redis.call('ZUNIONSTORE', KEYS[2], 2, KEYS[1], KEYS[2])
+	redis.call('DEL', KEYS[1])
+end
+local name = redis.call('HGET', KEYS[3], ARGV[1])
+if name then
+	redis.call('HSETNX', KEYS[3], ARGV[2], name)
+	redis.call('HDEL', KEYS[3], ARGV[1])
+end
+local config = redis.call('HGETALL', KEYS[4])
+for i = 1, #config, 2 do
+	redis.call('HSETNX', KEYS[5], config[i], config[i+1])
+end
+redis.call('DEL', KEYS[4])
+if redis.call('EXISTS', KEYS[6]) == 1 then
+	redis.call('PFMERGE', KEYS[7], KEYS[6])
+	redis.call('DEL', KEYS[6])
+end
+local hours = redis.call('HGETALL', KEYS[8])
+for i = 1, #hours, 2 do
+	redis.call('HINCRBY', KEYS[9], hours[i], hours[i+1])
+end
+redis.call('DEL', KEYS[8])
+local bans = redis.call('HGETALL', KEYS[10])
+for i = 1, #bans, 2 do
+	redis.call('HSETNX', KEYS[11], bans[i], bans[i+1])
+end
+redis.call('DEL', KEYS[10])
+for i = 12, #KEYS, 2 do
+	local v = redis.call('GET', KEYS[i])
+	if v then
+		redis.call('INCRBY', KEYS[i+1], v)
+		redis.call('DEL', KEYS[i])
+	end
+end
+return 1`)
+
+// MigrateChannel moves ranking, name, config, stats, schedules, tournaments and game snapshot of a channel to a
+// new id atomically. Schedules, tournaments and snapshot are JSON so they are decoded here, the migration is
+// retried when they are changed before it is applied
+func (r *RedisDB) MigrateChannel(fromID, toID string) error {
+	defer dbMigrateChannelTimer.UpdateSince(time.Now())
+
+	if fromID == toID {
+		// the script would add the channel to itself and delete it
+		return nil
+	}
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	args := []interface{}{
+		cRankKey + fromID, cRankKey + toID,
+		cNameKey,
+		cConfigKey + fromID, cConfigKey + toID,
+		fmt.Sprintf("%splayers_%s", cStatsKey, fromID), fmt.Sprintf("%splayers_%s", cStatsKey, toID),
+		fmt.Sprintf("%shour_%s", cStatsKey, fromID), fmt.Sprintf("%shour_%s", cStatsKey, toID),
+		cBanKey + fromID, cBanKey + toID,
+	}
+	for _, key := range cStatsKeys {
+		args = append(args, fmt.Sprintf("%s%s_%s", cStatsKey, key, fromID), fmt.Sprintf("%s%s_%s", cStatsKey, key, toID))
+	}
+	nKeys := len(args)
+	args = append([]interface{}{nKeys}, args...)
+	args = append(args, fromID, toID)
+
+	for {
+		migrated, err := r.migrateChannel(conn, fromID, toID, args)
+		if err != nil || migrated {
+			return err
+		}
+	}
+}
+
+// migrateChannel runs the migration in a transaction, returns false if a watched key was changed
+func (r RedisDB) migrateChannel(conn redis.Conn, fromID, toID string, scriptArgs []interface{}) (bool, error) {
+	fromSnapshot, toSnapshot := cSnapshotKey+fromID, cSnapshotKey+toID
+	if _, err := conn.Do("WATCH", scheduleKey, tournamentKey, cTournamentKey+fromID, fromSnapshot, toSnapshot); err != nil {
+		return false, err
+	}
+	schedules, err := r.migratedSchedules(conn, fromID, toID)
+	if err != nil {
+		return false, err
+	}
+	tournaments, err := r.migratedTournaments(fromID, toID)
+	if err != nil {
+		return false, err
+	}
+	snapshot, ttl, err := r.migratedSnapshot(conn, fromID, toID)
+	if err != nil {
+		return false, err
+	}
+
+	conn.Send("MULTI")
+	migrateScript.Send(conn, scriptArgs...)
+	if len(schedules) > 0 {
+		conn.Send("HMSET", append([]interface{}{scheduleKey}, schedules...)...)
+	}
+	for name, data := range tournaments {
+		from, to := tRankKey+tournamentTable(name, fromID), tRankKey+tournamentTable(name, toID)
+		conn.Send("HSET", tournamentKey, name, data)
+		conn.Send("SREM", cTournamentKey+fromID, name)
+		conn.Send("SADD", cTournamentKey+toID, name)
+		conn.Send("ZUNIONSTORE", to, 2, to, from)
+		conn.Send("DEL", from)
+	}
+	if snapshot != nil {
+		conn.Send("SET", toSnapshot, snapshot, "PX", ttl)
+	}
+	conn.Send("DEL", fromSnapshot)
+	reply, err := conn.Do("EXEC")
+	if err != nil {
+		return false, err
+	}
+
+	return reply != nil, nil
+}
+
+// migratedSchedules returns the schedules of the channel with the new id as field and value pairs
+func (r RedisDB) migratedSchedules(conn redis.Conn, fromID, toID string) ([]interface{}, error) {
+	values, err := redis.ByteSlices(conn.Do("HVALS", scheduleKey))
+	if err != nil {
+		return nil, err
+	}
+	var pairs []interface{}
+	for _, data := range values {
+		var s Schedule
+		if err := json.Unmarshal(data, &s); err != nil {
+			return nil, err
+		}
+		if s.ChanID != fromID {
+			continue
+		}
+		s.ChanID = toID
+		if data, err = json.Marshal(s); err != nil {
+			return nil, err
+		}
+		pairs = append(pairs, s.ID, data)
+	}
+	return pairs, nil
+}
+
+// migratedTournaments returns the tournaments of the channel with the new id, keyed by name
+func (r RedisDB) migratedTournaments(fromID, toID string) (map[string][]byte, error) {
+	tournaments, err := r.ChannelTournaments(fromID)
+	if err != nil {
+		return nil, err
+	}
+	migrated := make(map[string][]byte)
+	for _, t := range tournaments {
+		if !t.migrateChannel(fromID, toID) {
+			continue
+		}
+		data, err := json.Marshal(t)
+		if err != nil {
+			return nil, err
+		}
+		migrated[t.Name] = data
+	}
+	return migrated, nil
+}
+
+// migratedSnapshot returns the game snapshot of the channel with the new id and its remaining ttl in
+// milliseconds, nil if there is none or the new id already has a snapshot
+func (r RedisDB) migratedSnapshot(conn redis.Conn, fromID, toID string) ([]byte, int64, error) {
+	data, err := redis.Bytes(conn.Do("GET", cSnapshotKey+fromID))
+	if err == redis.ErrNil {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	exists, err := redis.Bool(conn.Do("EXISTS", cSnapshotKey+toID))
+	if err != nil || exists {
+		return nil, 0, err
+	}
+	ttl, err := redis.Int64(conn.Do("PTTL", cSnapshotKey+fromID))
+	if err != nil || ttl <= 0 {
+		return nil, 0, err
+	}
+	var s GameSnapshot
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, 0, err
+	}
+	s.ChanID = toID
+	if data, err = json.Marshal(s); err != nil {
+		return nil, 0, err
+	}
+	return data, ttl, nil
+}
+
+// RemoveChannel removes the channel from the channel list, its scores are kept
+func (r *RedisDB) RemoveChannel(chanID string) error {
+	defer dbRemoveChannelTimer.UpdateSince(time.Now())
+
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	_, err := conn.Do("HDEL", cNameKey, chanID)
+	return err
+}
+
+// SetChannelConfig sets a known channel config, see ConfigKeys
+func (r *RedisDB) SetChannelConfig(chanID, key, value string) error {
+	defer dbSetChannelConfigTimer.UpdateSince(time.Now())
+
+	if err := CheckConfig(key, value, false); err != nil {
+		return err
+	}
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	_, err := conn.Do("HSET", cConfigKey+chanID, key, value)
+	return err
+}
+
+// DeleteChannelConfig removes a channel configuration so the default is used
+func (r *RedisDB) DeleteChannelConfig(chanID, key string) error {
+	defer dbSetChannelConfigTimer.UpdateSince(time.Now())
+
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	_, err := conn.Do("HDEL", cConfigKey+chanID, key)
+	return err
+}
+
 func (r *RedisDB) GlobalConfig(key, defaultValue string) (config string, err error) {
 	defer dbGlobalConfigTimer.UpdateSince(time.Now())
 
@@ -152,6 +487,9 @@ func (r *RedisDB) GlobalConfig(key, defaultValue string) (config string, err err
 
 	rkey := gConfigKey
 	config, err = redis.String(conn.Do("HGET", rkey, key))
+	if err == redis.ErrNil {
+		return defaultValue, nil
+	}
 
 	if err != nil || config == "" {
 		return defaultValue, err
@@ -160,6 +498,30 @@ func (r *RedisDB) GlobalConfig(key, defaultValue string) (config string, err err
 	return config, nil
 }
 
+// SetGlobalConfig sets a known config of every channel, see ConfigKeys
+func (r *RedisDB) SetGlobalConfig(key, value string) error {
+	defer dbSetGlobalConfigTimer.UpdateSince(time.Now())
+
+	if err := CheckConfig(key, value, true); err != nil {
+		return err
+	}
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	_, err := conn.Do("HSET", gConfigKey, key, value)
+	return err
+}
+
+func (r *RedisDB) DeleteGlobalConfig(key string) error {
+	defer dbSetGlobalConfigTimer.UpdateSince(time.Now())
+
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	_, err := conn.Do("HDEL", gConfigKey, key)
+	return err
+}
+
 func (r *RedisDB) PlayerCount() (total int, err error) {
 	defer dbPlayerCountTimer.UpdateSince(time.Now())
 
@@ -224,6 +586,39 @@ func (r RedisDB) incPlayerStats(playerID PlayerID, key string) error {
 	return err
 }
 
+func (r RedisDB) incPlayerStatsBy(playerID PlayerID, key string, n int64) error {
+	defer dbIncPlayerStatsTimer.UpdateSince(time.Now())
+
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	rkey := fmt.Sprintf("%s%s_%s", pStatsKey, key, playerID)
+	_, err := conn.Do("INCRBY", rkey, n)
+
+	return err
+}
+
+// maxScript sets KEYS[1] to ARGV[1] only when it is greater than the current value
+var maxScript = redis.NewScript(1, `
+local current = tonumber(redis.call('GET', KEYS[1]) or '0')
+if tonumber(ARGV[1]) > current then
+	redis.call('SET', KEYS[1], ARGV[1])
+end
+return current`)
+
+// maxPlayerStats keeps the highest value ever stored for the key
+func (r RedisDB) maxPlayerStats(playerID PlayerID, key string, value int64) error {
+	defer dbMaxPlayerStatsTimer.UpdateSince(time.Now())
+
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	rkey := fmt.Sprintf("%s%s_%s", pStatsKey, key, playerID)
+	_, err := maxScript.Do(conn, rkey, value)
+
+	return err
+}
+
 func (r RedisDB) stats(key string) (interface{}, error) {
 	defer dbStatsTimer.UpdateSince(time.Now())
 
@@ -254,6 +649,82 @@ func (r RedisDB) playerStats(playerID, key string) (interface{}, error) {
 	return conn.Do("GET", rkey)
 }
 
+// addChannelPlayers adds players to the unique player counter of the channel and global
+func (r RedisDB) addChannelPlayers(chanID string, playerIDs ...PlayerID) error {
+	defer dbAddChannelPlayersTimer.UpdateSince(time.Now())
+
+	if len(playerIDs) == 0 {
+		return nil
+	}
+
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	ckey := fmt.Sprintf("%splayers_%s", cStatsKey, chanID)
+	gkey := fmt.Sprintf("%splayers", gStatsKey)
+	for _, playerID := range playerIDs {
+		conn.Send("PFADD", ckey, playerID)
+		conn.Send("PFADD", gkey, playerID)
+	}
+
+	return conn.Flush()
+}
+
+// channelPlayerCount returns approximate number of unique players of a channel, empty chanID for global
+func (r RedisDB) channelPlayerCount(chanID string) (int, error) {
+	defer dbChannelPlayerCountTimer.UpdateSince(time.Now())
+
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	rkey := fmt.Sprintf("%splayers", gStatsKey)
+	if chanID != "" {
+		rkey = fmt.Sprintf("%splayers_%s", cStatsKey, chanID)
+	}
+	return redis.Int(conn.Do("PFCOUNT", rkey))
+}
+
+// incHourStats increments activity of the channel and global at specific hour of the day
+func (r RedisDB) incHourStats(chanID string, hour int) error {
+	defer dbIncHourStatsTimer.UpdateSince(time.Now())
+
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	conn.Send("HINCRBY", fmt.Sprintf("%shour_%s", cStatsKey, chanID), hour, 1)
+	conn.Send("HINCRBY", fmt.Sprintf("%shour", gStatsKey), hour, 1)
+
+	return conn.Flush()
+}
+
+// hourStats returns activity per hour of the day of a channel, empty chanID for global
+func (r RedisDB) hourStats(chanID string) (map[int]int, error) {
+	defer dbHourStatsTimer.UpdateSince(time.Now())
+
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	rkey := fmt.Sprintf("%shour", gStatsKey)
+	if chanID != "" {
+		rkey = fmt.Sprintf("%shour_%s", cStatsKey, chanID)
+	}
+	values, err := redis.IntMap(conn.Do("HGETALL", rkey))
+	if err != nil {
+		return nil, err
+	}
+
+	hours := make(map[int]int, len(values))
+	for k, v := range values {
+		hour, err := strconv.Atoi(k)
+		if err != nil {
+			continue
+		}
+		hours[hour] = v
+	}
+
+	return hours, nil
+}
+
 func (r *RedisDB) incRoundPlayed(chanID string) error {
 	return r.incChannelStats(chanID, "played")
 }
@@ -263,9 +734,12 @@ func (r RedisDB) saveScore(chanID, chanName string, scores Rank) error {
 
 	conn := r.pool.Get()
 	defer conn.Close()
+	now := time.Now().Unix()
 	for _, score := range scores {
 		conn.Send("HSET", cNameKey, chanID, chanName)
 		conn.Send("HSET", pNameKey, score.PlayerID, score.Name)
+		conn.Send("ZADD", pNameHistoryKey+string(score.PlayerID), now, score.Name)
+		conn.Send("ZREMRANGEBYRANK", pNameHistoryKey+string(score.PlayerID), 0, -(maxNameHistory + 1))
 		conn.Send("ZINCRBY", pRankKey, score.Score, score.PlayerID)
 		conn.Send("ZINCRBY", cRankKey+chanID, score.Score, score.PlayerID)
 	}
@@ -276,6 +750,60 @@ func (r RedisDB) ChannelRanking(chanID string, limit int) (ranking Rank, err err
 	return r.getRanking(cRankKey+chanID, limit-1)
 }
 
+// RankedChannels returns id of channels that have ranking, using SCAN so it does not block redis
+func (r RedisDB) RankedChannels() (chanIDs []string, err error) {
+	defer dbRankedChannelsTimer.UpdateSince(time.Now())
+
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	cursor := 0
+	for {
+		values, err := redis.Values(conn.Do("SCAN", cursor, "MATCH", cRankKey+"*", "COUNT", 1000))
+		if err != nil {
+			return nil, err
+		}
+		var keys []string
+		if _, err := redis.Scan(values, &cursor, &keys); err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			if chanID := strings.TrimPrefix(key, cRankKey); chanID != "" {
+				chanIDs = append(chanIDs, chanID)
+			}
+		}
+		if cursor == 0 {
+			return chanIDs, nil
+		}
+	}
+}
+
+// resetRankingScript subtracts exported score (ARGV pairs of member, score) from KEYS[1]
+// and removes members without score left, so points scored after the export are kept
+var resetRankingScript = redis.NewScript(1, `
+for i = 1, #ARGV, 2 do
+	redis.call('ZINCRBY', KEYS[1], -tonumber(ARGV[i+1]), ARGV[i])
+end
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', 0)
+return redis.call('ZCARD', KEYS[1])`)
+
+// ResetChannelRanking removes exported scores from the channel ranking
+func (r RedisDB) ResetChannelRanking(chanID string, exported Rank) error {
+	defer dbResetChannelRankingTimer.UpdateSince(time.Now())
+
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	args := make([]interface{}, 0, 1+2*len(exported))
+	args = append(args, cRankKey+chanID)
+	for _, ps := range exported {
+		args = append(args, ps.PlayerID, ps.Score)
+	}
+	_, err := resetRankingScript.Do(conn, args...)
+
+	return err
+}
+
 func (r RedisDB) playerRanking(limit int) (Rank, error) {
 	return r.getRanking(pRankKey, limit-1)
 }
@@ -285,7 +813,7 @@ func (r RedisDB) getRanking(key string, limit int) (ranking Rank, err error) {
 
 	conn := r.pool.Get()
 	defer conn.Close()
-	if limit <= 0 {
+	if limit < 0 {
 		limit = -1
 	}
 
@@ -331,6 +859,79 @@ func (r RedisDB) PlayerChannelScore(chanID string, playerID PlayerID) (PlayerSco
 	return r.getScore(cRankKey+chanID, playerID)
 }
 
+// playerNames returns names used by the player, most recent first
+func (r RedisDB) playerNames(playerID PlayerID) ([]string, error) {
+	defer dbPlayerNamesTimer.UpdateSince(time.Now())
+
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	return redis.Strings(conn.Do("ZREVRANGE", pNameHistoryKey+string(playerID), 0, -1))
+}
+
+// mergePlayerScript moves the player ARGV[1] into ARGV[2]. KEYS are ARGV[3] rankings, ARGV[4] pairs of
+// counters to add, the best score pair, the name history pair and the player name hash
+var mergePlayerScript = redis.NewScript(-1, `
+local from, to = ARGV[1], ARGV[2]
+local nRanks, nCounters = tonumber(ARGV[3]), tonumber(ARGV[4])
+for i = 1, nRanks do
+	local score = redis.call('ZSCORE', KEYS[i], from)
+	if score then
+		redis.call('ZINCRBY', KEYS[i], score, to)
+		redis.call('ZREM', KEYS[i], from)
+	end
+end
+local i = nRanks + 1
+for n = 1, nCounters do
+	local v = redis.call('GET', KEYS[i])
+	if v then
+		redis.call('INCRBY', KEYS[i+1], v)
+		redis.call('DEL', KEYS[i])
+	end
+	i = i + 2
+end
+local best = tonumber(redis.call('GET', KEYS[i]) or '0')
+if best > tonumber(redis.call('GET', KEYS[i+1]) or '0') then
+	redis.call('SET', KEYS[i+1], best)
+end
+redis.call('DEL', KEYS[i])
+redis.call('ZUNIONSTORE', KEYS[i+3], 2, KEYS[i+3], KEYS[i+2], 'AGGREGATE', 'MAX')
+redis.call('ZREMRANGEBYRANK', KEYS[i+3], 0, -(tonumber(ARGV[5]) + 1))
+redis.call('DEL', KEYS[i+2])
+redis.call('HDEL', KEYS[i+4], from)
+return 1`)
+
+// MergePlayer moves scores, stats and names of fromID to toID in all channels atomically
+func (r RedisDB) MergePlayer(fromID, toID PlayerID) error {
+	defer dbMergePlayerTimer.UpdateSince(time.Now())
+
+	chanIDs, err := r.RankedChannels()
+	if err != nil {
+		return err
+	}
+
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	keys := []interface{}{pRankKey}
+	for _, chanID := range chanIDs {
+		keys = append(keys, cRankKey+chanID)
+	}
+	for _, key := range pStatsSumKeys {
+		keys = append(keys, fmt.Sprintf("%s%s_%s", pStatsKey, key, fromID), fmt.Sprintf("%s%s_%s", pStatsKey, key, toID))
+	}
+	keys = append(keys,
+		fmt.Sprintf("%s%s_%s", pStatsKey, pStatsBestScore, fromID), fmt.Sprintf("%s%s_%s", pStatsKey, pStatsBestScore, toID),
+		pNameHistoryKey+string(fromID), pNameHistoryKey+string(toID),
+		pNameKey,
+	)
+	args := append([]interface{}{len(keys)}, keys...)
+	args = append(args, fromID, toID, len(chanIDs)+1, len(pStatsSumKeys), maxNameHistory)
+	_, err = mergePlayerScript.Do(conn, args...)
+
+	return err
+}
+
 func (r RedisDB) getScore(key string, playerID PlayerID) (ps PlayerScore, err error) {
 	defer dbGetScoreTimer.UpdateSince(time.Now())
 
@@ -351,39 +952,1755 @@ func (r RedisDB) getScore(key string, playerID PlayerID) (ps PlayerScore, err er
 	return ps, nil
 }
 
-// MemoryDB stores data in non persistence way
-type MemoryDB struct {
-	Seed   int64
-	played int
+// lease scripts compare the holder so an instance never extends or removes lease of another instance
+var (
+	acquireLeaseScript = redis.NewScript(1, `
+local holder = redis.call('GET', KEYS[1])
+if not holder or holder == ARGV[1] then
+	redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[2])
+	return ARGV[1]
+end
+return holder`)
+	renewLeaseScript = redis.NewScript(1, `
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 0`)
+	releaseLeaseScript = redis.NewScript(1, `
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0`)
+)
+
+// AcquireLease takes the lease if it is free or already held by owner and returns the current holder
+func (r RedisDB) AcquireLease(key, owner string, ttl time.Duration) (holder string, err error) {
+	defer dbAcquireLeaseTimer.UpdateSince(time.Now())
+
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	return redis.String(acquireLeaseScript.Do(conn, leaseKey+key, owner, int64(ttl/time.Millisecond)))
 }
 
-func (m *MemoryDB) Reset() error                                                      { return nil }
-func (m *MemoryDB) Init() (err error)                                                 { return nil }
-func (m *MemoryDB) ChannelRanking(chanID string, limit int) (ranking Rank, err error) { return nil, nil }
-func (m *MemoryDB) ChannelCount() (total int, err error)                              { return 0, nil }
-func (m *MemoryDB) Channels() (channels map[string]string, err error)                 { return nil, nil }
-func (m *MemoryDB) ChannelConfig(chanID, key, defaultValue string) (string, error)    { return "", nil }
-func (m *MemoryDB) GlobalConfig(key, defaultValue string) (string, error)             { return "", nil }
-func (m *MemoryDB) PlayerCount() (total int, err error)                               { return 0, nil }
-func (m *MemoryDB) incStats(key string) error                                         { return nil }
-func (m *MemoryDB) incChannelStats(chanID, key string) error                          { return nil }
-func (m *MemoryDB) incPlayerStats(playerID PlayerID, key string) error                { return nil }
-func (m *MemoryDB) stats(key string) (interface{}, error)                             { return nil, nil }
-func (m *MemoryDB) channelStats(chanID, key string) (interface{}, error)              { return nil, nil }
-func (m *MemoryDB) playerStats(playerID, key string) (interface{}, error)             { return nil, nil }
-func (m *MemoryDB) saveScore(chanID, chanName string, scores Rank) error              { return nil }
-func (m *MemoryDB) playerRanking(limit int) (Rank, error)                             { return nil, nil }
-func (m *MemoryDB) playerScore(playerID PlayerID) (ps PlayerScore, err error) {
-	return PlayerScore{}, nil
+// RenewLease extends the lease, returns false if the lease is expired or held by other owner
+func (r RedisDB) RenewLease(key, owner string, ttl time.Duration) (renewed bool, err error) {
+	defer dbRenewLeaseTimer.UpdateSince(time.Now())
+
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	return redis.Bool(renewLeaseScript.Do(conn, leaseKey+key, owner, int64(ttl/time.Millisecond)))
 }
-func (m *MemoryDB) PlayerChannelScore(chanID string, playerID PlayerID) (PlayerScore, error) {
-	return PlayerScore{}, nil
+
+func (r RedisDB) ReleaseLease(key, owner string) error {
+	defer dbReleaseLeaseTimer.UpdateSince(time.Now())
+
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	_, err := releaseLeaseScript.Do(conn, leaseKey+key, owner)
+	return err
 }
 
-func (m *MemoryDB) nextGame(chanID string) (seed int64, nextRound int, err error) {
-	return m.Seed, m.played + 1, nil
+// LeaseHolder returns the owner of the lease, empty if it is free
+func (r RedisDB) LeaseHolder(key string) (string, error) {
+	defer dbLeaseHolderTimer.UpdateSince(time.Now())
+
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	holder, err := redis.String(conn.Do("GET", leaseKey+key))
+	if err == redis.ErrNil {
+		return "", nil
+	}
+	return holder, err
 }
-func (m *MemoryDB) incRoundPlayed(chanID string) error {
-	m.played++
-	return nil
+
+// RegisterInstance marks the instance alive for ttl, it is renewed by calling it again
+func (r RedisDB) RegisterInstance(id string, ttl time.Duration) error {
+	defer dbRegisterInstanceTimer.UpdateSince(time.Now())
+
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	_, err := conn.Do("ZADD", instanceKey, time.Now().Add(ttl).UnixNano()/int64(time.Millisecond), id)
+	return err
+}
+
+func (r RedisDB) UnregisterInstance(id string) error {
+	defer dbUnregisterInstanceTimer.UpdateSince(time.Now())
+
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	_, err := conn.Do("ZREM", instanceKey, id)
+	return err
+}
+
+// Instances returns the instances alive, sorted by id
+func (r RedisDB) Instances() ([]string, error) {
+	defer dbInstancesTimer.UpdateSince(time.Now())
+
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	if _, err := conn.Do("ZREMRANGEBYSCORE", instanceKey, "-inf", now); err != nil {
+		return nil, err
+	}
+	ids, err := redis.Strings(conn.Do("ZRANGE", instanceKey, 0, -1))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(ids)
+
+	return ids, nil
+}
+
+// PushUpdate queues the update for the owner instance, the queue is dropped when it is not read within ttl
+func (r RedisDB) PushUpdate(owner string, update []byte, ttl time.Duration) error {
+	defer dbPushUpdateTimer.UpdateSince(time.Now())
+
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	key := updateKey + owner
+	conn.Send("MULTI")
+	conn.Send("RPUSH", key, update)
+	conn.Send("PEXPIRE", key, int64(ttl/time.Millisecond))
+	_, err := conn.Do("EXEC")
+	return err
+}
+
+// PopUpdate returns the next update queued for the owner, it waits up to timeout and returns nil if there is none
+func (r RedisDB) PopUpdate(owner string, timeout time.Duration) ([]byte, error) {
+	defer dbPopUpdateTimer.UpdateSince(time.Now())
+
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	// zero blocks forever, fractional timeout needs redis 6
+	if timeout < time.Millisecond {
+		timeout = time.Millisecond
+	}
+	values, err := redis.ByteSlices(conn.Do("BLPOP", updateKey+owner, timeout.Seconds()))
+	if err == redis.ErrNil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return values[1], nil
+}
+
+func (r RedisDB) SaveGameSnapshot(s GameSnapshot) error {
+	defer dbSaveGameSnapshotTimer.UpdateSince(time.Now())
+
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	_, err = conn.Do("SET", cSnapshotKey+s.ChanID, data, "PX", int64(SnapshotTTL/time.Millisecond))
+	return err
+}
+
+// GameSnapshot returns snapshot of the running game of the channel, nil if there is none
+func (r RedisDB) GameSnapshot(chanID string) (*GameSnapshot, error) {
+	defer dbGameSnapshotTimer.UpdateSince(time.Now())
+
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	data, err := redis.Bytes(conn.Do("GET", cSnapshotKey+chanID))
+	if err == redis.ErrNil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	s := &GameSnapshot{}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (r RedisDB) DeleteGameSnapshot(chanID string) error {
+	defer dbDeleteGameSnapshotTimer.UpdateSince(time.Now())
+
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	_, err := conn.Do("DEL", cSnapshotKey+chanID)
+	return err
+}
+
+// SnapshotChannels returns id of channels that have game snapshot, using SCAN so it does not block redis
+func (r RedisDB) SnapshotChannels() (chanIDs []string, err error) {
+	defer dbSnapshotChannelsTimer.UpdateSince(time.Now())
+
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	cursor := 0
+	for {
+		values, err := redis.Values(conn.Do("SCAN", cursor, "MATCH", cSnapshotKey+"*", "COUNT", 1000))
+		if err != nil {
+			return nil, err
+		}
+		var keys []string
+		if _, err := redis.Scan(values, &cursor, &keys); err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			if chanID := strings.TrimPrefix(key, cSnapshotKey); chanID != "" {
+				chanIDs = append(chanIDs, chanID)
+			}
+		}
+		if cursor == 0 {
+			return chanIDs, nil
+		}
+	}
+}
+
+// AddSchedule stores the schedule with a new ID
+func (r RedisDB) AddSchedule(s Schedule) (Schedule, error) {
+	defer dbScheduleTimer.UpdateSince(time.Now())
+
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	id, err := redis.Int64(conn.Do("INCR", scheduleIDKey))
+	if err != nil {
+		return s, err
+	}
+	s.ID = id
+	data, err := json.Marshal(s)
+	if err != nil {
+		return s, err
+	}
+	_, err = conn.Do("HSET", scheduleKey, id, data)
+
+	return s, err
+}
+
+// Schedules returns schedules of the channel ordered by ID, empty chanID returns all schedules
+func (r RedisDB) Schedules(chanID string) ([]Schedule, error) {
+	defer dbScheduleTimer.UpdateSince(time.Now())
+
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	values, err := redis.ByteSlices(conn.Do("HVALS", scheduleKey))
+	if err != nil {
+		return nil, err
+	}
+	var schedules []Schedule
+	for _, data := range values {
+		var s Schedule
+		if err := json.Unmarshal(data, &s); err != nil {
+			return nil, err
+		}
+		if chanID == "" || s.ChanID == chanID {
+			schedules = append(schedules, s)
+		}
+	}
+	sort.Slice(schedules, func(i, j int) bool { return schedules[i].ID < schedules[j].ID })
+
+	return schedules, nil
+}
+
+func (r RedisDB) RemoveSchedule(chanID string, id int64) (removed bool, err error) {
+	defer dbScheduleTimer.UpdateSince(time.Now())
+
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	data, err := redis.Bytes(conn.Do("HGET", scheduleKey, id))
+	if err == redis.ErrNil {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	var s Schedule
+	if err := json.Unmarshal(data, &s); err != nil {
+		return false, err
+	}
+	if s.ChanID != chanID {
+		return false, nil
+	}
+
+	return redis.Bool(conn.Do("HDEL", scheduleKey, id))
+}
+
+func tournamentTable(name, table string) string {
+	return name + "_" + table
+}
+
+func (r RedisDB) SaveTournament(t Tournament) error {
+	defer dbTournamentTimer.UpdateSince(time.Now())
+
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	data, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	conn.Send("MULTI")
+	conn.Send("HSET", tournamentKey, t.Name, data)
+	for _, chanID := range t.Channels {
+		conn.Send("SADD", cTournamentKey+chanID, t.Name)
+	}
+	_, err = conn.Do("EXEC")
+
+	return err
+}
+
+// Tournament returns the tournament by name, nil if there is none
+func (r RedisDB) Tournament(name string) (*Tournament, error) {
+	defer dbTournamentTimer.UpdateSince(time.Now())
+
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	data, err := redis.Bytes(conn.Do("HGET", tournamentKey, name))
+	if err == redis.ErrNil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	t := &Tournament{}
+	if err := json.Unmarshal(data, t); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// Tournaments returns all tournaments ordered by creation time
+func (r RedisDB) Tournaments() ([]Tournament, error) {
+	defer dbTournamentTimer.UpdateSince(time.Now())
+
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	values, err := redis.ByteSlices(conn.Do("HVALS", tournamentKey))
+	if err != nil {
+		return nil, err
+	}
+	tournaments := make([]Tournament, 0, len(values))
+	for _, data := range values {
+		var t Tournament
+		if err := json.Unmarshal(data, &t); err != nil {
+			return nil, err
+		}
+		tournaments = append(tournaments, t)
+	}
+	sort.Slice(tournaments, func(i, j int) bool { return tournaments[i].CreatedAt.Before(tournaments[j].CreatedAt) })
+
+	return tournaments, nil
+}
+
+// ChannelTournaments returns the tournaments the channel plays in ordered by creation time, using the
+// index of the channel instead of loading every tournament
+func (r RedisDB) ChannelTournaments(chanID string) ([]Tournament, error) {
+	defer dbTournamentTimer.UpdateSince(time.Now())
+
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	names, err := redis.Values(conn.Do("SMEMBERS", cTournamentKey+chanID))
+	if err != nil || len(names) == 0 {
+		return nil, err
+	}
+	values, err := redis.ByteSlices(conn.Do("HMGET", append([]interface{}{tournamentKey}, names...)...))
+	if err != nil {
+		return nil, err
+	}
+	var tournaments []Tournament
+	for _, data := range values {
+		if data == nil {
+			continue
+		}
+		var t Tournament
+		if err := json.Unmarshal(data, &t); err != nil {
+			return nil, err
+		}
+		tournaments = append(tournaments, t)
+	}
+	sort.Slice(tournaments, func(i, j int) bool { return tournaments[i].CreatedAt.Before(tournaments[j].CreatedAt) })
+
+	return tournaments, nil
+}
+
+// DeleteTournament removes the tournament and the standings of its tables
+func (r RedisDB) DeleteTournament(t Tournament) error {
+	defer dbTournamentTimer.UpdateSince(time.Now())
+
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	conn.Send("HDEL", tournamentKey, t.Name)
+	for _, chanID := range t.Channels {
+		conn.Send("SREM", cTournamentKey+chanID, t.Name)
+	}
+	for _, table := range append(t.Channels, FinalTable) {
+		conn.Send("DEL", tRankKey+tournamentTable(t.Name, table))
+	}
+	return conn.Flush()
+}
+
+func (r RedisDB) AddTournamentScore(name, table string, rank Rank) error {
+	defer dbTournamentTimer.UpdateSince(time.Now())
+
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	key := tRankKey + tournamentTable(name, table)
+	for _, score := range rank {
+		conn.Send("HSET", pNameKey, score.PlayerID, score.Name)
+		conn.Send("ZINCRBY", key, score.Score, score.PlayerID)
+	}
+	return conn.Flush()
+}
+
+func (r RedisDB) TournamentStandings(name, table string, limit int) (Rank, error) {
+	return r.getRanking(tRankKey+tournamentTable(name, table), limit-1)
+}
+
+func (r RedisDB) AddBroadcast(b Broadcast) (Broadcast, error) {
+	defer dbBroadcastTimer.UpdateSince(time.Now())
+
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	id, err := redis.Int64(conn.Do("INCR", broadcastIDKey))
+	if err != nil {
+		return b, err
+	}
+	b.ID = id
+	data, err := json.Marshal(b)
+	if err != nil {
+		return b, err
+	}
+	conn.Send("MULTI")
+	conn.Send("HSET", broadcastKey, id, data)
+	conn.Send("HSET", broadcastStatusKey, id, string(b.Status))
+	_, err = conn.Do("EXEC")
+
+	return b, err
+}
+
+// SaveBroadcast saves the progress of the broadcast, the status is kept. Use SetBroadcastStatus to change it
+func (r RedisDB) SaveBroadcast(b Broadcast) error {
+	defer dbBroadcastTimer.UpdateSince(time.Now())
+
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	data, err := json.Marshal(b)
+	if err != nil {
+		return err
+	}
+	_, err = conn.Do("HSET", broadcastKey, b.ID, data)
+
+	return err
+}
+
+// setStatusScript sets field ARGV[1] of hash KEYS[1] to ARGV[3] only when it is ARGV[2]
+var setStatusScript = redis.NewScript(1, `
+if redis.call('HGET', KEYS[1], ARGV[1]) == ARGV[2] then
+	redis.call('HSET', KEYS[1], ARGV[1], ARGV[3])
+	return 1
+end
+return 0`)
+
+// SetBroadcastStatus changes the status of the broadcast only when it is still from, so a broadcast cancelled
+// while it is sent is not set back to running or done
+func (r RedisDB) SetBroadcastStatus(id int64, from, to BroadcastStatus) (changed bool, err error) {
+	defer dbBroadcastTimer.UpdateSince(time.Now())
+
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	return redis.Bool(setStatusScript.Do(conn, broadcastStatusKey, id, string(from), string(to)))
+}
+
+// Broadcast returns the broadcast by ID, nil if there is none
+func (r RedisDB) Broadcast(id int64) (*Broadcast, error) {
+	defer dbBroadcastTimer.UpdateSince(time.Now())
+
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	data, err := redis.Bytes(conn.Do("HGET", broadcastKey, id))
+	if err == redis.ErrNil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var b Broadcast
+	if err := json.Unmarshal(data, &b); err != nil {
+		return nil, err
+	}
+	status, err := redis.String(conn.Do("HGET", broadcastStatusKey, id))
+	if err != nil && err != redis.ErrNil {
+		return nil, err
+	}
+	if status != "" {
+		b.Status = BroadcastStatus(status)
+	}
+	return &b, nil
+}
+
+// Broadcasts returns all broadcasts ordered by ID
+func (r RedisDB) Broadcasts() ([]Broadcast, error) {
+	defer dbBroadcastTimer.UpdateSince(time.Now())
+
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	values, err := redis.ByteSlices(conn.Do("HVALS", broadcastKey))
+	if err != nil {
+		return nil, err
+	}
+	statuses, err := redis.StringMap(conn.Do("HGETALL", broadcastStatusKey))
+	if err != nil {
+		return nil, err
+	}
+	var broadcasts []Broadcast
+	for _, data := range values {
+		var b Broadcast
+		if err := json.Unmarshal(data, &b); err != nil {
+			return nil, err
+		}
+		if status, ok := statuses[strconv.FormatInt(b.ID, 10)]; ok {
+			b.Status = BroadcastStatus(status)
+		}
+		broadcasts = append(broadcasts, b)
+	}
+	sort.Slice(broadcasts, func(i, j int) bool { return broadcasts[i].ID < broadcasts[j].ID })
+
+	return broadcasts, nil
+}
+
+func (r RedisDB) SetAdmin(userID string, role Role) error {
+	defer dbAdminTimer.UpdateSince(time.Now())
+
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	_, err := conn.Do("HSET", adminKey, userID, string(role))
+	return err
+}
+
+func (r RedisDB) RemoveAdmin(userID string) (removed bool, err error) {
+	defer dbAdminTimer.UpdateSince(time.Now())
+
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	n, err := redis.Int(conn.Do("HDEL", adminKey, userID))
+	return n > 0, err
+}
+
+// AdminRole returns the role of the user, empty if the user is not an admin
+func (r RedisDB) AdminRole(userID string) (Role, error) {
+	defer dbAdminTimer.UpdateSince(time.Now())
+
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	role, err := redis.String(conn.Do("HGET", adminKey, userID))
+	if err == redis.ErrNil {
+		return "", nil
+	}
+	return Role(role), err
+}
+
+// Admins returns the role of every admin by user ID
+func (r RedisDB) Admins() (map[string]Role, error) {
+	defer dbAdminTimer.UpdateSince(time.Now())
+
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	values, err := redis.StringMap(conn.Do("HGETALL", adminKey))
+	if err != nil {
+		return nil, err
+	}
+	admins := make(map[string]Role, len(values))
+	for userID, role := range values {
+		admins[userID] = Role(role)
+	}
+	return admins, nil
+}
+
+func banKey(chanID string) string {
+	if chanID == "" {
+		return gBanKey
+	}
+	return cBanKey + chanID
+}
+
+func (r RedisDB) AddBan(b Ban) error {
+	defer dbBanTimer.UpdateSince(time.Now())
+
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	data, err := json.Marshal(b)
+	if err != nil {
+		return err
+	}
+	_, err = conn.Do("HSET", banKey(b.ChanID), b.PlayerID, data)
+	return err
+}
+
+func (r RedisDB) RemoveBan(chanID string, playerID PlayerID) (removed bool, err error) {
+	defer dbBanTimer.UpdateSince(time.Now())
+
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	n, err := redis.Int(conn.Do("HDEL", banKey(chanID), playerID))
+	return n > 0, err
+}
+
+// Banned returns true if the player is banned from the channel or globally
+func (r RedisDB) Banned(chanID string, playerID PlayerID) (bool, error) {
+	defer dbBanTimer.UpdateSince(time.Now())
+
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	conn.Send("HEXISTS", gBanKey, playerID)
+	conn.Send("HEXISTS", banKey(chanID), playerID)
+	if err := conn.Flush(); err != nil {
+		return false, err
+	}
+	for i := 0; i < 2; i++ {
+		banned, err := redis.Bool(conn.Receive())
+		if err != nil || banned {
+			return banned, err
+		}
+	}
+	return false, nil
+}
+
+// Bans returns the bans of the channel ordered by player ID, empty chanID returns the global bans
+func (r RedisDB) Bans(chanID string) ([]Ban, error) {
+	defer dbBanTimer.UpdateSince(time.Now())
+
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	values, err := redis.ByteSlices(conn.Do("HVALS", banKey(chanID)))
+	if err != nil {
+		return nil, err
+	}
+	var bans []Ban
+	for _, data := range values {
+		var b Ban
+		if err := json.Unmarshal(data, &b); err != nil {
+			return nil, err
+		}
+		// kept when the channel is migrated
+		b.ChanID = chanID
+		bans = append(bans, b)
+	}
+	sort.Slice(bans, func(i, j int) bool { return bans[i].PlayerID < bans[j].PlayerID })
+
+	return bans, nil
+}
+
+// RemoveFromRanking removes the player from the channel ranking, empty chanID is the global ranking
+func (r RedisDB) RemoveFromRanking(chanID string, playerID PlayerID) error {
+	defer dbBanTimer.UpdateSince(time.Now())
+
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	key := pRankKey
+	if chanID != "" {
+		key = cRankKey + chanID
+	}
+	_, err := conn.Do("ZREM", key, playerID)
+	return err
+}
+
+// MemoryDB stores data in non persistence way
+type MemoryDB struct {
+	Seed   int64
+	played int
+
+	mu          sync.Mutex
+	chanNames   map[string]string
+	playerName  map[PlayerID]string
+	nameHistory map[PlayerID][]string // most recent first
+	playerRank  map[PlayerID]int
+	chanRank    map[string]map[PlayerID]int
+	config      map[string]map[string]string // chanID -> key -> value, empty chanID is global
+	counters    map[string]int               // stats counter, see memoryKey
+	players     map[string]map[PlayerID]bool // unique players per channel, empty chanID is global
+	hours       map[string]map[int]int       // activity per hour per channel, empty chanID is global
+	leases      map[string]memoryLease
+	updates     map[string][][]byte  // owner instance -> queued updates
+	instances   map[string]time.Time // instance -> expired at
+	snapshots   map[string]GameSnapshot
+	schedules   map[int64]Schedule
+	scheduleID  int64
+	tournaments map[string]Tournament
+	tRank       map[string]map[PlayerID]int // see tournamentTable
+	broadcasts  map[int64]Broadcast
+	broadcastID int64
+	admins      map[string]Role
+	bans        map[string]map[PlayerID]Ban // chanID -> player, empty chanID is global
+
+	// Clock is used for lease expiry, nil uses DefaultClock
+	Clock Clock
+}
+
+type memoryLease struct {
+	holder    string
+	expiredAt time.Time
+}
+
+// memoryKey builds counter key, kind is one of "g" (global), "c" (channel) or "p" (player)
+func memoryKey(kind, id, key string) string {
+	return kind + ":" + id + ":" + key
+}
+
+func (m *MemoryDB) lazyInit() {
+	if m.chanNames != nil {
+		return
+	}
+	m.chanNames = make(map[string]string)
+	m.playerName = make(map[PlayerID]string)
+	m.nameHistory = make(map[PlayerID][]string)
+	m.playerRank = make(map[PlayerID]int)
+	m.chanRank = make(map[string]map[PlayerID]int)
+	m.config = make(map[string]map[string]string)
+	m.counters = make(map[string]int)
+	m.players = make(map[string]map[PlayerID]bool)
+	m.hours = make(map[string]map[int]int)
+	m.leases = make(map[string]memoryLease)
+	m.updates = make(map[string][][]byte)
+	m.instances = make(map[string]time.Time)
+	m.snapshots = make(map[string]GameSnapshot)
+	m.schedules = make(map[int64]Schedule)
+	m.tournaments = make(map[string]Tournament)
+	m.tRank = make(map[string]map[PlayerID]int)
+	m.broadcasts = make(map[int64]Broadcast)
+	m.admins = make(map[string]Role)
+	m.bans = make(map[string]map[PlayerID]Ban)
+}
+
+func (m *MemoryDB) Reset() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.chanNames = nil
+	m.played = 0
+	m.lazyInit()
+	return nil
+}
+
+func (m *MemoryDB) Init() (err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.lazyInit()
+	return nil
+}
+
+func (m *MemoryDB) ChannelRanking(chanID string, limit int) (ranking Rank, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lazyInit()
+
+	return m.ranking(m.chanRank[chanID], limit), nil
+}
+
+func (m *MemoryDB) RankedChannels() (chanIDs []string, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lazyInit()
+
+	for chanID, scores := range m.chanRank {
+		if len(scores) > 0 {
+			chanIDs = append(chanIDs, chanID)
+		}
+	}
+	return chanIDs, nil
+}
+
+func (m *MemoryDB) ResetChannelRanking(chanID string, exported Rank) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lazyInit()
+
+	scores, ok := m.chanRank[chanID]
+	if !ok {
+		return nil
+	}
+	for _, ps := range exported {
+		if scores[ps.PlayerID] -= ps.Score; scores[ps.PlayerID] <= 0 {
+			delete(scores, ps.PlayerID)
+		}
+	}
+	return nil
+}
+
+func (m *MemoryDB) ChannelCount() (total int, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lazyInit()
+
+	return len(m.chanNames), nil
+}
+
+func (m *MemoryDB) Channels() (channels map[string]string, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lazyInit()
+
+	channels = make(map[string]string, len(m.chanNames))
+	for id, name := range m.chanNames {
+		channels[id] = name
+	}
+	return channels, nil
+}
+
+func (m *MemoryDB) ChannelConfig(chanID, key, defaultValue string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lazyInit()
+
+	if config := m.config[chanID][key]; config != "" {
+		return config, nil
+	}
+	return defaultValue, nil
+}
+
+func (m *MemoryDB) SetChannelConfig(chanID, key, value string) error {
+	if err := CheckConfig(key, value, false); err != nil {
+		return err
+	}
+	return m.setConfig(chanID, key, value)
+}
+
+func (m *MemoryDB) setConfig(chanID, key, value string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lazyInit()
+
+	if m.config[chanID] == nil {
+		m.config[chanID] = make(map[string]string)
+	}
+	m.config[chanID][key] = value
+	return nil
+}
+
+func (m *MemoryDB) DeleteChannelConfig(chanID, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lazyInit()
+
+	delete(m.config[chanID], key)
+	return nil
+}
+
+func (m *MemoryDB) GlobalConfig(key, defaultValue string) (string, error) {
+	return m.ChannelConfig("", key, defaultValue)
+}
+
+func (m *MemoryDB) SetGlobalConfig(key, value string) error {
+	if err := CheckConfig(key, value, true); err != nil {
+		return err
+	}
+	return m.setConfig("", key, value)
+}
+
+func (m *MemoryDB) DeleteGlobalConfig(key string) error {
+	return m.DeleteChannelConfig("", key)
+}
+
+func (m *MemoryDB) RemoveChannel(chanID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lazyInit()
+
+	delete(m.chanNames, chanID)
+	return nil
+}
+
+func (m *MemoryDB) MigrateChannel(fromID, toID string) error {
+	if fromID == toID {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lazyInit()
+
+	if scores, ok := m.chanRank[fromID]; ok {
+		if m.chanRank[toID] == nil {
+			m.chanRank[toID] = make(map[PlayerID]int)
+		}
+		for playerID, score := range scores {
+			m.chanRank[toID][playerID] += score
+		}
+		delete(m.chanRank, fromID)
+	}
+	if name, ok := m.chanNames[fromID]; ok {
+		if _, exists := m.chanNames[toID]; !exists {
+			m.chanNames[toID] = name
+		}
+		delete(m.chanNames, fromID)
+	}
+	if config, ok := m.config[fromID]; ok {
+		if m.config[toID] == nil {
+			m.config[toID] = make(map[string]string)
+		}
+		for key, value := range config {
+			if _, exists := m.config[toID][key]; !exists {
+				m.config[toID][key] = value
+			}
+		}
+		delete(m.config, fromID)
+	}
+	if players, ok := m.players[fromID]; ok {
+		if m.players[toID] == nil {
+			m.players[toID] = make(map[PlayerID]bool)
+		}
+		for playerID := range players {
+			m.players[toID][playerID] = true
+		}
+		delete(m.players, fromID)
+	}
+	if hours, ok := m.hours[fromID]; ok {
+		if m.hours[toID] == nil {
+			m.hours[toID] = make(map[int]int)
+		}
+		for hour, n := range hours {
+			m.hours[toID][hour] += n
+		}
+		delete(m.hours, fromID)
+	}
+	if bans, ok := m.bans[fromID]; ok {
+		if m.bans[toID] == nil {
+			m.bans[toID] = make(map[PlayerID]Ban)
+		}
+		for playerID, b := range bans {
+			if _, exists := m.bans[toID][playerID]; !exists {
+				b.ChanID = toID
+				m.bans[toID][playerID] = b
+			}
+		}
+		delete(m.bans, fromID)
+	}
+	for _, key := range cStatsKeys {
+		if v, ok := m.counters[memoryKey("c", fromID, key)]; ok {
+			m.counters[memoryKey("c", toID, key)] += v
+			delete(m.counters, memoryKey("c", fromID, key))
+		}
+	}
+	for id, s := range m.schedules {
+		if s.ChanID == fromID {
+			s.ChanID = toID
+			m.schedules[id] = s
+		}
+	}
+	if snapshot, ok := m.snapshots[fromID]; ok {
+		if _, exists := m.snapshots[toID]; !exists {
+			snapshot.ChanID = toID
+			m.snapshots[toID] = snapshot
+		}
+		delete(m.snapshots, fromID)
+	}
+	for name, t := range m.tournaments {
+		if !t.migrateChannel(fromID, toID) {
+			continue
+		}
+		m.tournaments[name] = t
+		from, to := tournamentTable(name, fromID), tournamentTable(name, toID)
+		if scores, ok := m.tRank[from]; ok {
+			if m.tRank[to] == nil {
+				m.tRank[to] = make(map[PlayerID]int)
+			}
+			for playerID, score := range scores {
+				m.tRank[to][playerID] += score
+			}
+			delete(m.tRank, from)
+		}
+	}
+
+	return nil
+}
+
+func (m *MemoryDB) PlayerCount() (total int, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lazyInit()
+
+	return len(m.playerName), nil
+}
+
+func (m *MemoryDB) incr(key string, n int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lazyInit()
+
+	m.counters[key] += n
+	return nil
+}
+
+func (m *MemoryDB) counter(key string) (interface{}, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lazyInit()
+
+	v, ok := m.counters[key]
+	if !ok {
+		return nil, nil
+	}
+	return int64(v), nil
+}
+
+func (m *MemoryDB) incStats(key string) error {
+	return m.incr(memoryKey("g", "", key), 1)
+}
+
+func (m *MemoryDB) incChannelStats(chanID, key string) error {
+	return m.incr(memoryKey("c", chanID, key), 1)
+}
+
+func (m *MemoryDB) incPlayerStats(playerID PlayerID, key string) error {
+	return m.incr(memoryKey("p", string(playerID), key), 1)
+}
+
+func (m *MemoryDB) incPlayerStatsBy(playerID PlayerID, key string, n int64) error {
+	return m.incr(memoryKey("p", string(playerID), key), int(n))
+}
+
+func (m *MemoryDB) maxPlayerStats(playerID PlayerID, key string, value int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lazyInit()
+
+	rkey := memoryKey("p", string(playerID), key)
+	if int(value) > m.counters[rkey] {
+		m.counters[rkey] = int(value)
+	}
+	return nil
+}
+
+func (m *MemoryDB) stats(key string) (interface{}, error) {
+	return m.counter(memoryKey("g", "", key))
+}
+
+func (m *MemoryDB) channelStats(chanID, key string) (interface{}, error) {
+	return m.counter(memoryKey("c", chanID, key))
+}
+
+func (m *MemoryDB) playerStats(playerID, key string) (interface{}, error) {
+	return m.counter(memoryKey("p", playerID, key))
+}
+
+func (m *MemoryDB) addChannelPlayers(chanID string, playerIDs ...PlayerID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lazyInit()
+
+	for _, id := range []string{chanID, ""} {
+		if m.players[id] == nil {
+			m.players[id] = make(map[PlayerID]bool)
+		}
+		for _, playerID := range playerIDs {
+			m.players[id][playerID] = true
+		}
+	}
+	return nil
+}
+
+func (m *MemoryDB) channelPlayerCount(chanID string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lazyInit()
+
+	return len(m.players[chanID]), nil
+}
+
+func (m *MemoryDB) incHourStats(chanID string, hour int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lazyInit()
+
+	for _, id := range []string{chanID, ""} {
+		if m.hours[id] == nil {
+			m.hours[id] = make(map[int]int)
+		}
+		m.hours[id][hour]++
+	}
+	return nil
+}
+
+func (m *MemoryDB) hourStats(chanID string) (map[int]int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lazyInit()
+
+	hours := make(map[int]int, len(m.hours[chanID]))
+	for hour, n := range m.hours[chanID] {
+		hours[hour] = n
+	}
+	return hours, nil
+}
+
+func (m *MemoryDB) saveScore(chanID, chanName string, scores Rank) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lazyInit()
+
+	for _, score := range scores {
+		m.chanNames[chanID] = chanName
+		m.playerName[score.PlayerID] = score.Name
+		m.nameHistory[score.PlayerID] = addNameHistory(m.nameHistory[score.PlayerID], score.Name)
+		m.playerRank[score.PlayerID] += score.Score
+		if m.chanRank[chanID] == nil {
+			m.chanRank[chanID] = make(map[PlayerID]int)
+		}
+		m.chanRank[chanID][score.PlayerID] += score.Score
+	}
+	return nil
+}
+
+func (m *MemoryDB) playerRanking(limit int) (Rank, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lazyInit()
+
+	return m.ranking(m.playerRank, limit), nil
+}
+
+// ranking sorts scores the same way as redis ZREVRANGE
+func (m *MemoryDB) ranking(scores map[PlayerID]int, limit int) Rank {
+	ranking := make(Rank, 0, len(scores))
+	for playerID, score := range scores {
+		ranking = append(ranking, PlayerScore{PlayerID: playerID, Name: m.playerName[playerID], Score: score})
+	}
+	sort.Slice(ranking, func(i, j int) bool {
+		if ranking[i].Score != ranking[j].Score {
+			return ranking[i].Score > ranking[j].Score
+		}
+		return ranking[i].PlayerID > ranking[j].PlayerID
+	})
+	if limit > 0 && len(ranking) > limit {
+		ranking = ranking[:limit]
+	}
+	for i := range ranking {
+		ranking[i].Position = i + 1
+	}
+
+	return ranking
+}
+
+func (m *MemoryDB) playerScore(playerID PlayerID) (ps PlayerScore, err error) {
+	return m.score(func() map[PlayerID]int { return m.playerRank }, playerID)
+}
+
+func (m *MemoryDB) PlayerChannelScore(chanID string, playerID PlayerID) (PlayerScore, error) {
+	return m.score(func() map[PlayerID]int { return m.chanRank[chanID] }, playerID)
+}
+
+// addNameHistory puts name in front of the history
+func addNameHistory(history []string, name string) []string {
+	names := []string{name}
+	for _, v := range history {
+		if v != name && len(names) < maxNameHistory {
+			names = append(names, v)
+		}
+	}
+	return names
+}
+
+func (m *MemoryDB) playerNames(playerID PlayerID) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lazyInit()
+
+	return append([]string(nil), m.nameHistory[playerID]...), nil
+}
+
+func (m *MemoryDB) MergePlayer(fromID, toID PlayerID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lazyInit()
+
+	for _, scores := range append([]map[PlayerID]int{m.playerRank}, chanRanks(m.chanRank)...) {
+		if score, ok := scores[fromID]; ok {
+			scores[toID] += score
+			delete(scores, fromID)
+		}
+	}
+
+	for _, key := range pStatsSumKeys {
+		if v, ok := m.counters[memoryKey("p", string(fromID), key)]; ok {
+			m.counters[memoryKey("p", string(toID), key)] += v
+			delete(m.counters, memoryKey("p", string(fromID), key))
+		}
+	}
+	fromBest, toBest := memoryKey("p", string(fromID), pStatsBestScore), memoryKey("p", string(toID), pStatsBestScore)
+	if m.counters[fromBest] > m.counters[toBest] {
+		m.counters[toBest] = m.counters[fromBest]
+	}
+	delete(m.counters, fromBest)
+
+	names := m.nameHistory[toID]
+	for _, name := range m.nameHistory[fromID] {
+		if !containsString(names, name) && len(names) < maxNameHistory {
+			names = append(names, name)
+		}
+	}
+	m.nameHistory[toID] = names
+	delete(m.nameHistory, fromID)
+	delete(m.playerName, fromID)
+
+	return nil
+}
+
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func chanRanks(ranks map[string]map[PlayerID]int) []map[PlayerID]int {
+	result := make([]map[PlayerID]int, 0, len(ranks))
+	for _, scores := range ranks {
+		result = append(result, scores)
+	}
+	return result
+}
+
+// score returns score of a player, position is 0 based same as redis ZREVRANK
+func (m *MemoryDB) score(scores func() map[PlayerID]int, playerID PlayerID) (PlayerScore, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lazyInit()
+
+	ps := PlayerScore{PlayerID: playerID, Name: m.playerName[playerID]}
+	for i, v := range m.ranking(scores(), 0) {
+		if v.PlayerID == playerID {
+			ps.Score = v.Score
+			ps.Position = i
+		}
+	}
+	return ps, nil
+}
+
+func (m *MemoryDB) nextGame(chanID string) (seed int64, nextRound int, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.Seed, m.played + 1, nil
+}
+func (m *MemoryDB) incRoundPlayed(chanID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.played++
+	return nil
+}
+
+func (m *MemoryDB) now() time.Time {
+	if m.Clock == nil {
+		return DefaultClock.Now()
+	}
+	return m.Clock.Now()
+}
+
+func (m *MemoryDB) AcquireLease(key, owner string, ttl time.Duration) (holder string, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lazyInit()
+
+	now := m.now()
+	if l, ok := m.leases[key]; ok && l.holder != owner && l.expiredAt.After(now) {
+		return l.holder, nil
+	}
+	m.leases[key] = memoryLease{holder: owner, expiredAt: now.Add(ttl)}
+	return owner, nil
+}
+
+func (m *MemoryDB) RenewLease(key, owner string, ttl time.Duration) (renewed bool, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lazyInit()
+
+	now := m.now()
+	l, ok := m.leases[key]
+	if !ok || l.holder != owner || !l.expiredAt.After(now) {
+		return false, nil
+	}
+	m.leases[key] = memoryLease{holder: owner, expiredAt: now.Add(ttl)}
+	return true, nil
+}
+
+func (m *MemoryDB) ReleaseLease(key, owner string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lazyInit()
+
+	if l, ok := m.leases[key]; ok && l.holder == owner {
+		delete(m.leases, key)
+	}
+	return nil
+}
+
+func (m *MemoryDB) LeaseHolder(key string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lazyInit()
+
+	if l, ok := m.leases[key]; ok && l.expiredAt.After(m.now()) {
+		return l.holder, nil
+	}
+	return "", nil
+}
+
+func (m *MemoryDB) RegisterInstance(id string, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lazyInit()
+
+	m.instances[id] = m.now().Add(ttl)
+	return nil
+}
+
+func (m *MemoryDB) UnregisterInstance(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lazyInit()
+
+	delete(m.instances, id)
+	return nil
+}
+
+func (m *MemoryDB) Instances() ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lazyInit()
+
+	var ids []string
+	now := m.now()
+	for id, expiredAt := range m.instances {
+		if !expiredAt.After(now) {
+			delete(m.instances, id)
+			continue
+		}
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	return ids, nil
+}
+
+func (m *MemoryDB) PushUpdate(owner string, update []byte, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lazyInit()
+
+	m.updates[owner] = append(m.updates[owner], update)
+	return nil
+}
+
+// PopUpdate polls the queue of the owner until timeout
+func (m *MemoryDB) PopUpdate(owner string, timeout time.Duration) ([]byte, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		m.mu.Lock()
+		m.lazyInit()
+		if queue := m.updates[owner]; len(queue) > 0 {
+			m.updates[owner] = queue[1:]
+			m.mu.Unlock()
+			return queue[0], nil
+		}
+		m.mu.Unlock()
+
+		if time.Now().After(deadline) {
+			return nil, nil
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func (m *MemoryDB) SaveGameSnapshot(s GameSnapshot) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lazyInit()
+
+	m.snapshots[s.ChanID] = s
+	return nil
+}
+
+func (m *MemoryDB) GameSnapshot(chanID string) (*GameSnapshot, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lazyInit()
+
+	s, ok := m.snapshots[chanID]
+	if !ok || m.now().Sub(s.SavedAt) > SnapshotTTL {
+		return nil, nil
+	}
+	return &s, nil
+}
+
+func (m *MemoryDB) DeleteGameSnapshot(chanID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lazyInit()
+
+	delete(m.snapshots, chanID)
+	return nil
+}
+
+func (m *MemoryDB) SnapshotChannels() ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lazyInit()
+
+	var chanIDs []string
+	for chanID, s := range m.snapshots {
+		if m.now().Sub(s.SavedAt) <= SnapshotTTL {
+			chanIDs = append(chanIDs, chanID)
+		}
+	}
+	sort.Strings(chanIDs)
+	return chanIDs, nil
+}
+
+func (m *MemoryDB) AddSchedule(s Schedule) (Schedule, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lazyInit()
+
+	m.scheduleID++
+	s.ID = m.scheduleID
+	m.schedules[s.ID] = s
+	return s, nil
+}
+
+func (m *MemoryDB) Schedules(chanID string) ([]Schedule, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lazyInit()
+
+	var schedules []Schedule
+	for _, s := range m.schedules {
+		if chanID == "" || s.ChanID == chanID {
+			schedules = append(schedules, s)
+		}
+	}
+	sort.Slice(schedules, func(i, j int) bool { return schedules[i].ID < schedules[j].ID })
+	return schedules, nil
+}
+
+func (m *MemoryDB) RemoveSchedule(chanID string, id int64) (removed bool, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lazyInit()
+
+	if s, ok := m.schedules[id]; !ok || s.ChanID != chanID {
+		return false, nil
+	}
+	delete(m.schedules, id)
+	return true, nil
+}
+
+func (m *MemoryDB) SaveTournament(t Tournament) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lazyInit()
+
+	// stored as json so the caller can't modify the stored maps and slices
+	data, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	var stored Tournament
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return err
+	}
+	m.tournaments[t.Name] = stored
+	return nil
+}
+
+func (m *MemoryDB) Tournament(name string) (*Tournament, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lazyInit()
+
+	t, ok := m.tournaments[name]
+	if !ok {
+		return nil, nil
+	}
+	return copyTournament(t)
+}
+
+func (m *MemoryDB) Tournaments() ([]Tournament, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lazyInit()
+
+	tournaments := make([]Tournament, 0, len(m.tournaments))
+	for _, t := range m.tournaments {
+		c, err := copyTournament(t)
+		if err != nil {
+			return nil, err
+		}
+		tournaments = append(tournaments, *c)
+	}
+	sort.Slice(tournaments, func(i, j int) bool { return tournaments[i].CreatedAt.Before(tournaments[j].CreatedAt) })
+	return tournaments, nil
+}
+
+func (m *MemoryDB) ChannelTournaments(chanID string) ([]Tournament, error) {
+	tournaments, err := m.Tournaments()
+	if err != nil {
+		return nil, err
+	}
+	var result []Tournament
+	for _, t := range tournaments {
+		if t.HasChannel(chanID) {
+			result = append(result, t)
+		}
+	}
+	return result, nil
+}
+
+func copyTournament(t Tournament) (*Tournament, error) {
+	data, err := json.Marshal(t)
+	if err != nil {
+		return nil, err
+	}
+	c := &Tournament{}
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (m *MemoryDB) DeleteTournament(t Tournament) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lazyInit()
+
+	delete(m.tournaments, t.Name)
+	for _, table := range append(t.Channels, FinalTable) {
+		delete(m.tRank, tournamentTable(t.Name, table))
+	}
+	return nil
+}
+
+func (m *MemoryDB) AddTournamentScore(name, table string, rank Rank) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lazyInit()
+
+	key := tournamentTable(name, table)
+	if m.tRank[key] == nil {
+		m.tRank[key] = make(map[PlayerID]int)
+	}
+	for _, score := range rank {
+		m.playerName[score.PlayerID] = score.Name
+		m.tRank[key][score.PlayerID] += score.Score
+	}
+	return nil
+}
+
+func (m *MemoryDB) TournamentStandings(name, table string, limit int) (Rank, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lazyInit()
+
+	return m.ranking(m.tRank[tournamentTable(name, table)], limit), nil
+}
+
+func (m *MemoryDB) AddBroadcast(b Broadcast) (Broadcast, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lazyInit()
+
+	m.broadcastID++
+	b.ID = m.broadcastID
+	m.broadcasts[b.ID] = copyBroadcast(b)
+	return b, nil
+}
+
+func (m *MemoryDB) SaveBroadcast(b Broadcast) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lazyInit()
+
+	if saved, ok := m.broadcasts[b.ID]; ok {
+		b.Status = saved.Status
+	}
+	m.broadcasts[b.ID] = copyBroadcast(b)
+	return nil
+}
+
+func (m *MemoryDB) SetBroadcastStatus(id int64, from, to BroadcastStatus) (changed bool, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lazyInit()
+
+	b, ok := m.broadcasts[id]
+	if !ok || b.Status != from {
+		return false, nil
+	}
+	b.Status = to
+	m.broadcasts[id] = b
+	return true, nil
+}
+
+func (m *MemoryDB) Broadcast(id int64) (*Broadcast, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lazyInit()
+
+	b, ok := m.broadcasts[id]
+	if !ok {
+		return nil, nil
+	}
+	b = copyBroadcast(b)
+	return &b, nil
+}
+
+func (m *MemoryDB) Broadcasts() ([]Broadcast, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lazyInit()
+
+	broadcasts := make([]Broadcast, 0, len(m.broadcasts))
+	for _, b := range m.broadcasts {
+		broadcasts = append(broadcasts, copyBroadcast(b))
+	}
+	sort.Slice(broadcasts, func(i, j int) bool { return broadcasts[i].ID < broadcasts[j].ID })
+	return broadcasts, nil
+}
+
+func (m *MemoryDB) SetAdmin(userID string, role Role) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lazyInit()
+
+	m.admins[userID] = role
+	return nil
+}
+
+func (m *MemoryDB) RemoveAdmin(userID string) (removed bool, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lazyInit()
+
+	_, removed = m.admins[userID]
+	delete(m.admins, userID)
+	return removed, nil
+}
+
+func (m *MemoryDB) AdminRole(userID string) (Role, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lazyInit()
+
+	return m.admins[userID], nil
+}
+
+func (m *MemoryDB) Admins() (map[string]Role, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lazyInit()
+
+	admins := make(map[string]Role, len(m.admins))
+	for userID, role := range m.admins {
+		admins[userID] = role
+	}
+	return admins, nil
+}
+
+func (m *MemoryDB) AddBan(b Ban) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lazyInit()
+
+	if m.bans[b.ChanID] == nil {
+		m.bans[b.ChanID] = make(map[PlayerID]Ban)
+	}
+	m.bans[b.ChanID][b.PlayerID] = b
+	return nil
+}
+
+func (m *MemoryDB) RemoveBan(chanID string, playerID PlayerID) (removed bool, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lazyInit()
+
+	_, removed = m.bans[chanID][playerID]
+	delete(m.bans[chanID], playerID)
+	return removed, nil
+}
+
+func (m *MemoryDB) Banned(chanID string, playerID PlayerID) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lazyInit()
+
+	_, global := m.bans[""][playerID]
+	_, channel := m.bans[chanID][playerID]
+	return global || channel, nil
+}
+
+func (m *MemoryDB) Bans(chanID string) ([]Ban, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lazyInit()
+
+	var bans []Ban
+	for _, b := range m.bans[chanID] {
+		bans = append(bans, b)
+	}
+	sort.Slice(bans, func(i, j int) bool { return bans[i].PlayerID < bans[j].PlayerID })
+	return bans, nil
+}
+
+func (m *MemoryDB) RemoveFromRanking(chanID string, playerID PlayerID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lazyInit()
+
+	if chanID == "" {
+		delete(m.playerRank, playerID)
+		return nil
+	}
+	delete(m.chanRank[chanID], playerID)
+	return nil
+}
+
+// copyBroadcast keeps the stored broadcast from being changed by the caller
+func copyBroadcast(b Broadcast) Broadcast {
+	b.Channels = append([]string(nil), b.Channels...)
+	return b
 }
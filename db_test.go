@@ -1,6 +1,12 @@
 package fam100
 
-import "testing"
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
 
 func TestSaveScore(t *testing.T) {
 	ranking := Rank{
@@ -88,3 +94,805 @@ func TestSaveScore(t *testing.T) {
 		t.Errorf("playerID, want %d got %d", want, got)
 	}
 }
+
+func TestMigrateChannel(t *testing.T) {
+	memoryDB := &MemoryDB{}
+	memoryDB.Init()
+	backends := map[string]db{"redis": DefaultDB, "memory": memoryDB}
+	for name, d := range backends {
+		testMigrateChannel(t, name, d)
+	}
+}
+
+func testMigrateChannel(t *testing.T, name string, d db) {
+	fromID, toID := "migrate_from", "migrate_to"
+	setConfig := func(chanID, key, value string) {
+		switch d := d.(type) {
+		case *RedisDB:
+			conn := d.pool.Get()
+			defer conn.Close()
+			if _, err := conn.Do("HSET", cConfigKey+chanID, key, value); err != nil {
+				t.Fatalf("%s: %s", name, err)
+			}
+		case *MemoryDB:
+			if d.config[chanID] == nil {
+				d.config[chanID] = make(map[string]string)
+			}
+			d.config[chanID][key] = value
+		}
+	}
+
+	// existing target is merged
+	if err := d.saveScore(fromID, "group", Rank{{PlayerID: "m1", Name: "M 1", Score: 10}, {PlayerID: "m2", Name: "M 2", Score: 5}}); err != nil {
+		t.Fatalf("%s: %s", name, err)
+	}
+	if err := d.saveScore(toID, "supergroup", Rank{{PlayerID: "m2", Name: "M 2", Score: 7}}); err != nil {
+		t.Fatalf("%s: %s", name, err)
+	}
+	setConfig(fromID, "motd", "hello")
+	setConfig(fromID, "questionLimit", "10")
+	setConfig(toID, "questionLimit", "20")
+	d.incChannelStats(fromID, cStatsGameStarted)
+	d.incChannelStats(fromID, cStatsGameStarted)
+	d.incChannelStats(toID, cStatsGameStarted)
+	d.addChannelPlayers(fromID, "m1", "m2")
+	d.addChannelPlayers(toID, "m2", "m3")
+	d.incHourStats(fromID, 20)
+	d.incHourStats(toID, 20)
+	schedule, err := d.AddSchedule(Schedule{ChanID: fromID, Spec: "0 20 * * 5"})
+	if err != nil {
+		t.Fatalf("%s: %s", name, err)
+	}
+	cup := Tournament{Name: "migrate_cup", FinalChanID: fromID, Channels: []string{fromID, "migrate_other"}, Played: map[string]int{fromID: 2}}
+	if err := d.SaveTournament(cup); err != nil {
+		t.Fatalf("%s: %s", name, err)
+	}
+	if err := d.AddTournamentScore(cup.Name, fromID, Rank{{PlayerID: "m1", Name: "M 1", Score: 10}}); err != nil {
+		t.Fatalf("%s: %s", name, err)
+	}
+	if err := d.SaveGameSnapshot(GameSnapshot{ID: 42, ChanID: fromID, Round: 2, Rounds: 3, SavedAt: time.Now()}); err != nil {
+		t.Fatalf("%s: %s", name, err)
+	}
+
+	if err := d.MigrateChannel(fromID, toID); err != nil {
+		t.Fatalf("%s: %s", name, err)
+	}
+
+	rank, err := d.ChannelRanking(toID, 10)
+	if err != nil {
+		t.Fatalf("%s: %s", name, err)
+	}
+	if want, got := 2, len(rank); want != got {
+		t.Fatalf("%s: len(rank) want %d got %d", name, want, got)
+	}
+	if want, got := PlayerID("m2"), rank[0].PlayerID; want != got {
+		t.Errorf("%s: rank[0] want %s got %s", name, want, got)
+	}
+	if want, got := 12, rank[0].Score; want != got {
+		t.Errorf("%s: rank[0] score want %d got %d", name, want, got)
+	}
+	if want, got := 10, rank[1].Score; want != got {
+		t.Errorf("%s: rank[1] score want %d got %d", name, want, got)
+	}
+	if rank, _ := d.ChannelRanking(fromID, 10); len(rank) != 0 {
+		t.Errorf("%s: old ranking should be removed, got %v", name, rank)
+	}
+
+	channels, err := d.Channels()
+	if err != nil {
+		t.Fatalf("%s: %s", name, err)
+	}
+	if _, ok := channels[fromID]; ok {
+		t.Errorf("%s: old channel name should be removed", name)
+	}
+	if want, got := "supergroup", channels[toID]; want != got {
+		t.Errorf("%s: channel name want %s got %s", name, want, got)
+	}
+
+	if motd, _ := d.ChannelConfig(toID, "motd", ""); motd != "hello" {
+		t.Errorf("%s: motd want hello got %s", name, motd)
+	}
+	if limit, _ := d.ChannelConfig(toID, "questionLimit", ""); limit != "20" {
+		t.Errorf("%s: questionLimit want 20 got %s", name, limit)
+	}
+	if motd, _ := d.ChannelConfig(fromID, "motd", ""); motd != "" {
+		t.Errorf("%s: old config should be removed, got %s", name, motd)
+	}
+
+	if v, _ := statsInt(d.channelStats(toID, cStatsGameStarted)); v != 3 {
+		t.Errorf("%s: gameStarted want 3 got %d", name, v)
+	}
+	if v, _ := statsInt(d.channelStats(fromID, cStatsGameStarted)); v != 0 {
+		t.Errorf("%s: old gameStarted want 0 got %d", name, v)
+	}
+	if n, _ := d.channelPlayerCount(toID); n != 3 {
+		t.Errorf("%s: players want 3 got %d", name, n)
+	}
+	if hours, _ := d.hourStats(toID); hours[20] != 2 {
+		t.Errorf("%s: hour stats want 2 got %d", name, hours[20])
+	}
+
+	if schedules, _ := d.Schedules(toID); len(schedules) != 1 || schedules[0].ID != schedule.ID {
+		t.Errorf("%s: schedules want %d got %v", name, schedule.ID, schedules)
+	}
+	if schedules, _ := d.Schedules(fromID); len(schedules) != 0 {
+		t.Errorf("%s: old schedules should be moved, got %v", name, schedules)
+	}
+	migrated, err := d.Tournament(cup.Name)
+	if err != nil {
+		t.Fatalf("%s: %s", name, err)
+	}
+	if migrated.FinalChanID != toID || !migrated.HasChannel(toID) || migrated.HasChannel(fromID) || migrated.Played[toID] != 2 {
+		t.Errorf("%s: tournament channel not migrated: %+v", name, migrated)
+	}
+	if standings, _ := d.TournamentStandings(cup.Name, toID, 10); len(standings) != 1 || standings[0].Score != 10 {
+		t.Errorf("%s: tournament standings want 10 got %v", name, standings)
+	}
+	d.DeleteTournament(*migrated)
+
+	snapshot, err := d.GameSnapshot(toID)
+	if err != nil {
+		t.Fatalf("%s: %s", name, err)
+	}
+	if snapshot == nil || snapshot.ID != 42 || snapshot.ChanID != toID || snapshot.Round != 2 {
+		t.Errorf("%s: game snapshot not migrated: %+v", name, snapshot)
+	}
+	if snapshot, _ := d.GameSnapshot(fromID); snapshot != nil {
+		t.Errorf("%s: old game snapshot should be removed, got %+v", name, snapshot)
+	}
+	d.DeleteGameSnapshot(toID)
+
+	// migrating a channel without any data is a no-op
+	if err := d.MigrateChannel("migrate_none", "migrate_none_to"); err != nil {
+		t.Fatalf("%s: %s", name, err)
+	}
+
+	// migrating a channel to itself keeps its data
+	if err := d.MigrateChannel(toID, toID); err != nil {
+		t.Fatalf("%s: %s", name, err)
+	}
+	if hours, _ := d.hourStats(toID); hours[20] != 2 {
+		t.Errorf("%s: hour stats after migrating to itself want 2 got %d", name, hours[20])
+	}
+}
+
+func TestMergePlayer(t *testing.T) {
+	memoryDB := &MemoryDB{}
+	memoryDB.Init()
+	backends := map[string]db{"redis": DefaultDB, "memory": memoryDB}
+	for name, d := range backends {
+		testMergePlayer(t, name, d)
+	}
+}
+
+func testMergePlayer(t *testing.T, name string, d db) {
+	var fromID, toID PlayerID = "merge_from", "merge_to"
+	if err := d.saveScore("merge1", "merge 1", Rank{{PlayerID: fromID, Name: "Old Name", Score: 10}, {PlayerID: toID, Name: "Main", Score: 3}}); err != nil {
+		t.Fatalf("%s: %s", name, err)
+	}
+	if err := d.saveScore("merge2", "merge 2", Rank{{PlayerID: fromID, Name: "Fake Leader", Score: 4}}); err != nil {
+		t.Fatalf("%s: %s", name, err)
+	}
+	d.incPlayerStats(fromID, pStatsGamePlayed)
+	d.incPlayerStats(toID, pStatsGamePlayed)
+	d.maxPlayerStats(fromID, pStatsBestScore, 10)
+	d.maxPlayerStats(toID, pStatsBestScore, 3)
+	// the ranking is kept after the channel leaves the channel list
+	if err := d.RemoveChannel("merge2"); err != nil {
+		t.Fatalf("%s: %s", name, err)
+	}
+
+	// name history, most recent first
+	names, err := d.playerNames(fromID)
+	if err != nil {
+		t.Fatalf("%s: %s", name, err)
+	}
+	if len(names) != 2 {
+		t.Fatalf("%s: names want 2 got %v", name, names)
+	}
+
+	if err := d.MergePlayer(fromID, toID); err != nil {
+		t.Fatalf("%s: %s", name, err)
+	}
+
+	for chanID, want := range map[string]int{"merge1": 13, "merge2": 4} {
+		ps, err := d.PlayerChannelScore(chanID, toID)
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if got := ps.Score; want != got {
+			t.Errorf("%s: %s score want %d got %d", name, chanID, want, got)
+		}
+		rank, _ := d.ChannelRanking(chanID, 10)
+		for _, ps := range rank {
+			if ps.PlayerID == fromID {
+				t.Errorf("%s: %s still has merged player", name, chanID)
+			}
+		}
+	}
+	ps, err := d.playerScore(toID)
+	if err != nil {
+		t.Fatalf("%s: %s", name, err)
+	}
+	if want, got := 17, ps.Score; want != got {
+		t.Errorf("%s: global score want %d got %d", name, want, got)
+	}
+	if want, got := "Main", ps.Name; want != got {
+		t.Errorf("%s: name want %s got %s", name, want, got)
+	}
+	if v, _ := statsInt(d.playerStats(string(toID), pStatsGamePlayed)); v != 2 {
+		t.Errorf("%s: gamePlayed want 2 got %d", name, v)
+	}
+	if v, _ := statsInt(d.playerStats(string(toID), pStatsBestScore)); v != 10 {
+		t.Errorf("%s: bestScore want 10 got %d", name, v)
+	}
+
+	names, err = d.playerNames(toID)
+	if err != nil {
+		t.Fatalf("%s: %s", name, err)
+	}
+	if want, got := 3, len(names); want != got {
+		t.Errorf("%s: names want %d got %v", name, want, names)
+	}
+}
+
+func TestResetChannelRanking(t *testing.T) {
+	memoryDB := &MemoryDB{}
+	memoryDB.Init()
+	backends := map[string]db{"redis": DefaultDB, "memory": memoryDB}
+	for name, d := range backends {
+		chanID := "reset_" + name
+		if err := d.saveScore(chanID, "reset", Rank{{PlayerID: "r1", Score: 10}, {PlayerID: "r2", Score: 5}}); err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		exported, err := d.ChannelRanking(chanID, 0)
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+
+		chanIDs, err := d.RankedChannels()
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		found := false
+		for _, id := range chanIDs {
+			found = found || id == chanID
+		}
+		if !found {
+			t.Errorf("%s: RankedChannels want %s in %v", name, chanID, chanIDs)
+		}
+
+		// score after export must be kept
+		if err := d.saveScore(chanID, "reset", Rank{{PlayerID: "r2", Score: 2}}); err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if err := d.ResetChannelRanking(chanID, exported); err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		rank, err := d.ChannelRanking(chanID, 0)
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if want, got := 1, len(rank); want != got {
+			t.Fatalf("%s: len(rank) want %d got %d", name, want, got)
+		}
+		if want, got := 2, rank[0].Score; want != got {
+			t.Errorf("%s: score want %d got %d", name, want, got)
+		}
+	}
+}
+
+func TestLease(t *testing.T) {
+	clock := NewFakeClock(time.Date(2016, 12, 1, 20, 0, 0, 0, time.UTC))
+	memoryDB := &MemoryDB{Clock: clock}
+	memoryDB.Init()
+	backends := map[string]db{"redis": DefaultDB, "memory": memoryDB}
+	for name, d := range backends {
+		expire := func(key string, ttl time.Duration) { clock.Advance(2 * ttl) }
+		if r, ok := d.(*RedisDB); ok {
+			// the lease must have ttl, expiry itself is done by redis so the key is removed
+			expire = func(key string, ttl time.Duration) {
+				conn := r.pool.Get()
+				defer conn.Close()
+				if pttl, err := redis.Int64(conn.Do("PTTL", leaseKey+key)); err != nil || pttl <= 0 || pttl > int64(ttl/time.Millisecond) {
+					t.Errorf("%s: lease ttl want (0, %d] got %d err %v", name, ttl/time.Millisecond, pttl, err)
+				}
+				conn.Do("DEL", leaseKey+key)
+			}
+		}
+		testLease(t, name, d, expire)
+	}
+}
+
+func testLease(t *testing.T, name string, d db, expire func(key string, ttl time.Duration)) {
+	key, ttl := "lease_chan", 100*time.Millisecond
+
+	holder, err := d.AcquireLease(key, "a", ttl)
+	if err != nil {
+		t.Fatalf("%s: %s", name, err)
+	}
+	if want, got := "a", holder; want != got {
+		t.Errorf("%s: holder want %s got %s", name, want, got)
+	}
+	if holder, err := d.LeaseHolder(key); err != nil || holder != "a" {
+		t.Errorf("%s: lease holder want a got %q, %v", name, holder, err)
+	}
+	// held by a until expired
+	if holder, _ := d.AcquireLease(key, "b", ttl); holder != "a" {
+		t.Errorf("%s: b should not acquire lease of a, holder %s", name, holder)
+	}
+	if renewed, _ := d.RenewLease(key, "b", ttl); renewed {
+		t.Errorf("%s: b should not renew lease of a", name)
+	}
+	if renewed, _ := d.RenewLease(key, "a", ttl); !renewed {
+		t.Errorf("%s: a should renew its lease", name)
+	}
+	d.ReleaseLease(key, "b")
+	if holder, _ := d.AcquireLease(key, "b", ttl); holder != "a" {
+		t.Errorf("%s: release by b should not remove lease of a, holder %s", name, holder)
+	}
+
+	// expired lease is taken over
+	expire(key, ttl)
+	if renewed, _ := d.RenewLease(key, "a", ttl); renewed {
+		t.Errorf("%s: expired lease should not be renewed", name)
+	}
+	if holder, _ := d.AcquireLease(key, "b", ttl); holder != "b" {
+		t.Errorf("%s: b should acquire expired lease, holder %s", name, holder)
+	}
+	if err := d.ReleaseLease(key, "b"); err != nil {
+		t.Fatalf("%s: %s", name, err)
+	}
+	if holder, _ := d.AcquireLease(key, "a", ttl); holder != "a" {
+		t.Errorf("%s: a should acquire released lease, holder %s", name, holder)
+	}
+	d.ReleaseLease(key, "a")
+	if holder, err := d.LeaseHolder(key); err != nil || holder != "" {
+		t.Errorf("%s: released lease holder want empty got %q, %v", name, holder, err)
+	}
+}
+
+func TestInstances(t *testing.T) {
+	memoryDB := &MemoryDB{}
+	memoryDB.Init()
+	backends := map[string]db{"redis": DefaultDB, "memory": memoryDB}
+	for name, d := range backends {
+		for _, id := range []string{"b", "a"} {
+			if err := d.RegisterInstance(id, time.Minute); err != nil {
+				t.Fatalf("%s: %s", name, err)
+			}
+		}
+		// an instance not registered again within ttl is dead
+		d.RegisterInstance("dead", -time.Millisecond)
+		if ids, err := d.Instances(); err != nil || strings.Join(ids, " ") != "a b" {
+			t.Errorf("%s: instances want [a b] got %v, %v", name, ids, err)
+		}
+		d.UnregisterInstance("a")
+		d.UnregisterInstance("b")
+		if ids, _ := d.Instances(); len(ids) != 0 {
+			t.Errorf("%s: want no instance got %v", name, ids)
+		}
+	}
+}
+
+func TestUpdates(t *testing.T) {
+	memoryDB := &MemoryDB{}
+	memoryDB.Init()
+	backends := map[string]db{"redis": DefaultDB, "memory": memoryDB}
+	for name, d := range backends {
+		for _, update := range []string{"first", "second"} {
+			if err := d.PushUpdate("a", []byte(update), time.Minute); err != nil {
+				t.Fatalf("%s: %s", name, err)
+			}
+		}
+		// queued per instance, in order
+		if got, err := d.PopUpdate("b", 10*time.Millisecond); err != nil || got != nil {
+			t.Errorf("%s: b want no update got %q, %v", name, got, err)
+		}
+		for _, want := range []string{"first", "second"} {
+			if got, err := d.PopUpdate("a", time.Second); err != nil || string(got) != want {
+				t.Errorf("%s: update want %q got %q, %v", name, want, got, err)
+			}
+		}
+		if got, _ := d.PopUpdate("a", 10*time.Millisecond); got != nil {
+			t.Errorf("%s: want empty queue got %q", name, got)
+		}
+	}
+}
+
+func TestGameSnapshot(t *testing.T) {
+	memoryDB := &MemoryDB{}
+	memoryDB.Init()
+	backends := map[string]db{"redis": DefaultDB, "memory": memoryDB}
+	for name, d := range backends {
+		chanID := "snapshot_chan"
+		if s, err := d.GameSnapshot(chanID); err != nil || s != nil {
+			t.Errorf("%s: snapshot want nil got %v err %v", name, s, err)
+		}
+		saved := GameSnapshot{ID: 10, ChanID: chanID, ChanName: "snapshot", Round: 2, Seed: 5, Rank: Rank{{PlayerID: "s1", Name: "S 1", Score: 30}}, Players: []Player{{ID: "s1", Name: "S 1"}}, SavedAt: time.Now()}
+		if err := d.SaveGameSnapshot(saved); err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		s, err := d.GameSnapshot(chanID)
+		if err != nil || s == nil {
+			t.Fatalf("%s: snapshot not found err %v", name, err)
+		}
+		if want, got := saved.Round, s.Round; want != got {
+			t.Errorf("%s: round want %d got %d", name, want, got)
+		}
+		if want, got := 30, s.Rank[0].Score; want != got {
+			t.Errorf("%s: score want %d got %d", name, want, got)
+		}
+		if want, got := "S 1", s.Players[0].Name; want != got {
+			t.Errorf("%s: player want %s got %s", name, want, got)
+		}
+		hasSnapshot := func() bool {
+			chanIDs, err := d.SnapshotChannels()
+			if err != nil {
+				t.Fatalf("%s: %s", name, err)
+			}
+			for _, id := range chanIDs {
+				if id == chanID {
+					return true
+				}
+			}
+			return false
+		}
+		if !hasSnapshot() {
+			t.Errorf("%s: snapshot channels should have %s", name, chanID)
+		}
+		if err := d.DeleteGameSnapshot(chanID); err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if s, _ := d.GameSnapshot(chanID); s != nil {
+			t.Errorf("%s: deleted snapshot should be nil", name)
+		}
+		if hasSnapshot() {
+			t.Errorf("%s: snapshot channels should not have deleted %s", name, chanID)
+		}
+	}
+}
+
+func TestSchedules(t *testing.T) {
+	memoryDB := &MemoryDB{}
+	memoryDB.Init()
+	backends := map[string]db{"redis": DefaultDB, "memory": memoryDB}
+	for name, d := range backends {
+		chanID := "schedule_chan"
+		a, err := d.AddSchedule(Schedule{ChanID: chanID, Spec: "0 20 * * 5", Rounds: 10})
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		b, err := d.AddSchedule(Schedule{ChanID: chanID, Spec: "0 12 * * *"})
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if a.ID == 0 || a.ID == b.ID {
+			t.Errorf("%s: expecting unique id got %d and %d", name, a.ID, b.ID)
+		}
+		if _, err := d.AddSchedule(Schedule{ChanID: "schedule_other", Spec: "0 12 * * *"}); err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+
+		schedules, err := d.Schedules(chanID)
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if want, got := 2, len(schedules); want != got {
+			t.Fatalf("%s: schedules want %d got %d", name, want, got)
+		}
+		all, err := d.Schedules("")
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if len(all) < 3 {
+			t.Errorf("%s: all schedules want at least 3 got %d", name, len(all))
+		}
+
+		// schedule of other channel can't be removed
+		if removed, _ := d.RemoveSchedule("schedule_other", a.ID); removed {
+			t.Errorf("%s: removed schedule of other channel", name)
+		}
+		if removed, err := d.RemoveSchedule(chanID, a.ID); err != nil || !removed {
+			t.Errorf("%s: remove want true got %t err %v", name, removed, err)
+		}
+		schedules, _ = d.Schedules(chanID)
+		if want, got := 1, len(schedules); want != got {
+			t.Fatalf("%s: schedules want %d got %d", name, want, got)
+		}
+		if want, got := b.Spec, schedules[0].Spec; want != got {
+			t.Errorf("%s: spec want %s got %s", name, want, got)
+		}
+		for _, s := range all {
+			d.RemoveSchedule(s.ChanID, s.ID)
+		}
+	}
+}
+
+func TestTournamentStandings(t *testing.T) {
+	memoryDB := &MemoryDB{}
+	memoryDB.Init()
+	backends := map[string]db{"redis": DefaultDB, "memory": memoryDB}
+	for name, d := range backends {
+		tour := Tournament{Name: "standings-cup", Stage: TournamentQualification, Channels: []string{"c1"}, Played: map[string]int{"c1": 1}}
+		if err := d.SaveTournament(tour); err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		saved, err := d.Tournament(tour.Name)
+		if err != nil || saved == nil {
+			t.Fatalf("%s: tournament not found err %v", name, err)
+		}
+		if want, got := 1, saved.Played["c1"]; want != got {
+			t.Errorf("%s: played want %d got %d", name, want, got)
+		}
+		if tournaments, err := d.ChannelTournaments("c1"); err != nil || len(tournaments) != 1 || tournaments[0].Name != tour.Name {
+			t.Errorf("%s: channel tournaments want %s got %v err %v", name, tour.Name, tournaments, err)
+		}
+		if tournaments, _ := d.ChannelTournaments("c2"); len(tournaments) != 0 {
+			t.Errorf("%s: c2 should not play %v", name, tournaments)
+		}
+
+		d.AddTournamentScore(tour.Name, "c1", Rank{{PlayerID: "t1", Name: "T 1", Score: 10}, {PlayerID: "t2", Name: "T 2", Score: 20}})
+		d.AddTournamentScore(tour.Name, "c1", Rank{{PlayerID: "t1", Name: "T 1", Score: 15}})
+		d.AddTournamentScore(tour.Name, FinalTable, Rank{{PlayerID: "t2", Name: "T 2", Score: 5}})
+		standings, err := d.TournamentStandings(tour.Name, "c1", 0)
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if want, got := 2, len(standings); want != got {
+			t.Fatalf("%s: standings want %d got %d", name, want, got)
+		}
+		if want, got := (PlayerScore{PlayerID: "t1", Name: "T 1", Score: 25, Position: 1}), standings[0]; want != got {
+			t.Errorf("%s: leader want %v got %v", name, want, got)
+		}
+		if standings, _ := d.TournamentStandings(tour.Name, "c1", 1); len(standings) != 1 {
+			t.Errorf("%s: limited standings want 1 got %d", name, len(standings))
+		}
+
+		if err := d.DeleteTournament(tour); err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if saved, _ := d.Tournament(tour.Name); saved != nil {
+			t.Errorf("%s: deleted tournament should be nil", name)
+		}
+		if tournaments, _ := d.ChannelTournaments("c1"); len(tournaments) != 0 {
+			t.Errorf("%s: deleted tournament should leave the channel index, got %v", name, tournaments)
+		}
+		for _, table := range []string{"c1", FinalTable} {
+			if standings, _ := d.TournamentStandings(tour.Name, table, 0); len(standings) != 0 {
+				t.Errorf("%s: standings of %s should be deleted", name, table)
+			}
+		}
+	}
+}
+
+func TestBroadcasts(t *testing.T) {
+	memoryDB := &MemoryDB{}
+	memoryDB.Init()
+	backends := map[string]db{"redis": DefaultDB, "memory": memoryDB}
+	for name, d := range backends {
+		a, err := d.AddBroadcast(Broadcast{Text: "a", Status: BroadcastRunning, Channels: []string{"-1", "-2"}})
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		b, err := d.AddBroadcast(Broadcast{Text: "b", Status: BroadcastRunning})
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if a.ID == 0 || a.ID == b.ID {
+			t.Errorf("%s: expecting unique id got %d and %d", name, a.ID, b.ID)
+		}
+
+		a.Next, a.Sent = 1, 1
+		if err := d.SaveBroadcast(a); err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		got, err := d.Broadcast(a.ID)
+		if err != nil || got == nil {
+			t.Fatalf("%s: broadcast %d not found, %v", name, a.ID, err)
+		}
+		if got.Next != 1 || got.Sent != 1 || len(got.Channels) != 2 {
+			t.Errorf("%s: unexpected broadcast %+v", name, got)
+		}
+		if got, err := d.Broadcast(-1); err != nil || got != nil {
+			t.Errorf("%s: want nil broadcast got %v, %v", name, got, err)
+		}
+
+		// the status only changes from the expected one, saving the progress keeps it
+		if changed, err := d.SetBroadcastStatus(a.ID, BroadcastRunning, BroadcastCancelled); err != nil || !changed {
+			t.Fatalf("%s: cancel want changed got %t, %v", name, changed, err)
+		}
+		if changed, _ := d.SetBroadcastStatus(a.ID, BroadcastRunning, BroadcastDone); changed {
+			t.Errorf("%s: cancelled broadcast should not be done", name)
+		}
+		a.Next = 2
+		if err := d.SaveBroadcast(a); err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if got, _ := d.Broadcast(a.ID); got.Status != BroadcastCancelled || got.Next != 2 {
+			t.Errorf("%s: want cancelled at 2 got %s at %d", name, got.Status, got.Next)
+		}
+
+		all, err := d.Broadcasts()
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if len(all) < 2 || all[len(all)-1].ID != b.ID {
+			t.Errorf("%s: expecting broadcasts ordered by id, got %d", name, len(all))
+		}
+
+		// removed channel is not listed anymore
+		if err := d.saveScore("broadcast_kicked", "Kicked", Rank{{PlayerID: "p", Name: "P", Score: 1}}); err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if err := d.RemoveChannel("broadcast_kicked"); err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		channels, err := d.Channels()
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if _, ok := channels["broadcast_kicked"]; ok {
+			t.Errorf("%s: removed channel is still listed", name)
+		}
+	}
+}
+
+func TestAdmins(t *testing.T) {
+	memoryDB := &MemoryDB{}
+	memoryDB.Init()
+	backends := map[string]db{"redis": DefaultDB, "memory": memoryDB}
+	for name, d := range backends {
+		if err := d.SetAdmin("admin_1", RoleOperator); err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if err := d.SetAdmin("admin_2", RoleEditor); err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if err := d.SetAdmin("admin_2", RoleModerator); err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if role, err := d.AdminRole("admin_2"); err != nil || role != RoleModerator {
+			t.Errorf("%s: role want %s got %s, %v", name, RoleModerator, role, err)
+		}
+		if role, err := d.AdminRole("admin_none"); err != nil || role != "" {
+			t.Errorf("%s: want no role got %s, %v", name, role, err)
+		}
+
+		removed, err := d.RemoveAdmin("admin_1")
+		if err != nil || !removed {
+			t.Errorf("%s: want removed got %t, %v", name, removed, err)
+		}
+		if removed, _ := d.RemoveAdmin("admin_1"); removed {
+			t.Errorf("%s: admin removed twice", name)
+		}
+		admins, err := d.Admins()
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if _, ok := admins["admin_1"]; ok || admins["admin_2"] != RoleModerator {
+			t.Errorf("%s: unexpected admins %v", name, admins)
+		}
+
+		// deleted config falls back to the default
+		if err := d.SetChannelConfig("admin_chan", "motd", "hello"); err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if err := d.DeleteChannelConfig("admin_chan", "motd"); err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if motd, err := d.ChannelConfig("admin_chan", "motd", "default"); err != nil || motd != "default" {
+			t.Errorf("%s: motd want default got %q, %v", name, motd, err)
+		}
+	}
+}
+
+func TestRoleIncludes(t *testing.T) {
+	tests := []struct {
+		role, other Role
+		want        bool
+	}{
+		{RoleOperator, RoleEditor, true},
+		{RoleModerator, RoleModerator, true},
+		{RoleModerator, RoleOperator, false},
+		{RoleEditor, RoleModerator, false},
+		{Role(""), RoleEditor, false},
+		{Role("root"), Role("root"), false},
+	}
+	for _, tt := range tests {
+		if got := tt.role.Includes(tt.other); got != tt.want {
+			t.Errorf("%q includes %q want %t got %t", tt.role, tt.other, tt.want, got)
+		}
+	}
+	if _, err := ParseRole("root"); err == nil {
+		t.Error("expecting error for unknown role")
+	}
+}
+
+func TestConfig(t *testing.T) {
+	memoryDB := &MemoryDB{}
+	memoryDB.Init()
+	backends := map[string]db{"redis": DefaultDB, "memory": memoryDB}
+	for name, d := range backends {
+		if err := d.SetChannelConfig("config_chan", "questionLimit", "many"); err == nil {
+			t.Errorf("%s: expecting error for invalid questionLimit", name)
+		}
+		if err := d.SetChannelConfig("config_chan", "color", "red"); err == nil {
+			t.Errorf("%s: expecting error for unknown key", name)
+		}
+		if err := d.SetGlobalConfig("disabled", "maintenance"); err == nil {
+			t.Errorf("%s: expecting error for channel only key", name)
+		}
+
+		if err := d.SetGlobalConfig("motd", "hello"); err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if motd, err := d.GlobalConfig("motd", ""); err != nil || motd != "hello" {
+			t.Errorf("%s: global motd want hello got %q, %v", name, motd, err)
+		}
+		if motd, _ := d.ChannelConfig("config_chan", "motd", ""); motd != "" {
+			t.Errorf("%s: global config is not a channel config, got %q", name, motd)
+		}
+		if err := d.DeleteGlobalConfig("motd"); err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if motd, err := d.GlobalConfig("motd", "default"); err != nil || motd != "default" {
+			t.Errorf("%s: global motd want default got %q, %v", name, motd, err)
+		}
+	}
+}
+
+func TestBans(t *testing.T) {
+	memoryDB := &MemoryDB{}
+	memoryDB.Init()
+	backends := map[string]db{"redis": DefaultDB, "memory": memoryDB}
+	for name, d := range backends {
+		if err := d.AddBan(Ban{PlayerID: "ban_p1", ChanID: "ban_chan", Reason: "spam"}); err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if err := d.AddBan(Ban{PlayerID: "ban_p2"}); err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		tests := []struct {
+			chanID   string
+			playerID PlayerID
+			banned   bool
+		}{
+			{"ban_chan", "ban_p1", true},
+			{"ban_other", "ban_p1", false},
+			{"ban_other", "ban_p2", true},
+			{"ban_chan", "ban_p3", false},
+		}
+		for _, tt := range tests {
+			if banned, err := d.Banned(tt.chanID, tt.playerID); err != nil || banned != tt.banned {
+				t.Errorf("%s: %s banned in %s want %t got %t, %v", name, tt.playerID, tt.chanID, tt.banned, banned, err)
+			}
+		}
+
+		// bans follow the migrated channel
+		if err := d.MigrateChannel("ban_chan", "ban_chan_new"); err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		bans, err := d.Bans("ban_chan_new")
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if len(bans) != 1 || bans[0].PlayerID != "ban_p1" || bans[0].Reason != "spam" || bans[0].ChanID != "ban_chan_new" {
+			t.Errorf("%s: unexpected bans %+v", name, bans)
+		}
+		if banned, _ := d.Banned("ban_chan", "ban_p1"); banned {
+			t.Errorf("%s: ban is still in the old channel", name)
+		}
+
+		removed, err := d.RemoveBan("", "ban_p2")
+		if err != nil || !removed {
+			t.Errorf("%s: want removed got %t, %v", name, removed, err)
+		}
+		if removed, _ := d.RemoveBan("", "ban_p2"); removed {
+			t.Errorf("%s: ban removed twice", name)
+		}
+		if banned, _ := d.Banned("ban_other", "ban_p2"); banned {
+			t.Errorf("%s: ban_p2 is still banned", name)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package fam100
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/uber-go/zap"
+)
+
+// EventType is the type of game event
+type EventType string
+
+// Available event type
+const (
+	EventGameCreated    EventType = "gameCreated"
+	EventPlayerJoined   EventType = "playerJoined"
+	EventGameStarted    EventType = "gameStarted"
+	EventQuestionAsked  EventType = "questionAsked"
+	EventAnswerAccepted EventType = "answerAccepted"
+	EventRoundEnded     EventType = "roundEnded"
+	EventGameFinished   EventType = "gameFinished"
+	EventGameCancelled  EventType = "gameCancelled"
+	EventGameResumed    EventType = "gameResumed"
+	EventGameStopped    EventType = "gameStopped"
+)
+
+// Event is a structured record of the game lifecycle
+type Event struct {
+	Time       time.Time `json:"time"`
+	Type       EventType `json:"type"`
+	ChanID     string    `json:"chanID"`
+	GameID     int64     `json:"gameID"`
+	Round      int       `json:"round,omitempty"`
+	PlayerID   PlayerID  `json:"playerID,omitempty"`
+	PlayerName string    `json:"playerName,omitempty"`
+	QuestionID int       `json:"questionID,omitempty"`
+	Question   string    `json:"question,omitempty"`
+	Text       string    `json:"text,omitempty"`   // text sent by the player
+	Answer     string    `json:"answer,omitempty"` // matched answer of the question
+	Score      int       `json:"score,omitempty"`
+	LatencyMs  int64     `json:"latencyMs,omitempty"` // since the question is asked
+	State      State     `json:"state,omitempty"`
+	Rank       Rank      `json:"rank,omitempty"`
+}
+
+// EventLog stores game events
+type EventLog interface {
+	Log(e Event) error
+}
+
+// DefaultEventLog receives all game events, discarded by default
+var DefaultEventLog EventLog = nopEventLog{}
+
+type nopEventLog struct{}
+
+func (nopEventLog) Log(e Event) error { return nil }
+
+// LogEvent sends event to the DefaultEventLog, failure is only logged so it never stops the game
+func LogEvent(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	if err := DefaultEventLog.Log(e); err != nil {
+		log.Error("logging event failed", zap.String("type", string(e.Type)), zap.String("chanID", e.ChanID), zap.Int64("gameID", e.GameID), zap.Error(err))
+	}
+}
+
+// FileEventLog appends events to a file as JSON lines
+type FileEventLog struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// NewFileEventLog opens or creates the event log file
+func NewFileEventLog(path string) (*FileEventLog, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileEventLog{file: file}, nil
+}
+
+// Log writes the event as a single line
+func (f *FileEventLog) Log(e Event) error {
+	defer eventLogTimer.UpdateSince(time.Now())
+
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, err = f.file.Write(b)
+
+	return err
+}
+
+// Close the underlying file
+func (f *FileEventLog) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.file.Close()
+}
+
+// ReadEvents reads JSON lines events, filter returns true for the events to keep
+func ReadEvents(r io.Reader, filter func(e Event) bool) ([]Event, error) {
+	var events []Event
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var e Event
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return events, fmt.Errorf("line %d: %s", line, err)
+		}
+		if filter == nil || filter(e) {
+			events = append(events, e)
+		}
+	}
+
+	return events, scanner.Err()
+}
@@ -0,0 +1,132 @@
+package fam100
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+type memoryEventLog struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+func (m *memoryEventLog) Log(e Event) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.events = append(m.events, e)
+	return nil
+}
+
+func TestFileEventLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "eventlog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "events.log")
+
+	el, err := NewFileEventLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	el.Log(Event{Type: EventGameCreated, ChanID: "1", GameID: 10})
+	el.Log(Event{Type: EventGameCreated, ChanID: "2", GameID: 20})
+	el.Log(Event{Type: EventAnswerAccepted, ChanID: "1", GameID: 10, PlayerID: "p1", Score: 30, LatencyMs: 1500})
+	if err := el.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	events, err := ReadEvents(file, func(e Event) bool { return e.GameID == 10 })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 2, len(events); want != got {
+		t.Fatalf("events want %d got %d", want, got)
+	}
+	if want, got := EventAnswerAccepted, events[1].Type; want != got {
+		t.Errorf("type want %s got %s", want, got)
+	}
+	if want, got := 30, events[1].Score; want != got {
+		t.Errorf("score want %d got %d", want, got)
+	}
+	if want, got := int64(1500), events[1].LatencyMs; want != got {
+		t.Errorf("latency want %d got %d", want, got)
+	}
+}
+
+func TestGameEvents(t *testing.T) {
+	el := &memoryEventLog{}
+	oEventLog, oRoundPerGame := DefaultEventLog, RoundPerGame
+	defer func() {
+		DefaultEventLog, RoundPerGame = oEventLog, oRoundPerGame
+	}()
+	DefaultEventLog, RoundPerGame = el, 1
+
+	in, out := make(chan Message), make(chan Message, 100)
+	g, err := NewGame("eventChan", "Event Chan", in, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.Start()
+
+	// answer every question once round is started
+	timeout := time.After(5 * time.Second)
+	for done := false; !done; {
+		select {
+		case msg := <-out:
+			switch m := msg.(type) {
+			case StateMessage:
+				if m.State == RoundStarted {
+					for i, ans := range g.CurrentQuestion().Answers {
+						p := Player{ID: PlayerID(fmt.Sprintf("p%d", i%2)), Name: "player"}
+						in <- TextMessage{ChanID: "eventChan", Player: p, Text: ans.Text[0], ReceivedAt: time.Now()}
+					}
+				}
+				done = m.State == Finished
+			}
+		case <-timeout:
+			t.Fatal("timeout waiting game to finish")
+		}
+	}
+
+	el.mu.Lock()
+	defer el.mu.Unlock()
+	types := make(map[EventType]int)
+	answered := make(map[PlayerID]int)
+	var final Rank
+	for _, e := range el.events {
+		if e.GameID != g.ID {
+			t.Errorf("event %s gameID want %d got %d", e.Type, g.ID, e.GameID)
+		}
+		types[e.Type]++
+		switch e.Type {
+		case EventAnswerAccepted:
+			answered[e.PlayerID] += e.Score
+		case EventGameFinished:
+			final = e.Rank
+		}
+	}
+	for _, typ := range []EventType{EventGameCreated, EventGameStarted, EventQuestionAsked, EventRoundEnded, EventGameFinished} {
+		if want, got := 1, types[typ]; want != got {
+			t.Errorf("%s events want %d got %d", typ, want, got)
+		}
+	}
+	if want, got := len(g.CurrentQuestion().Answers), types[EventAnswerAccepted]; want != got {
+		t.Errorf("answer events want %d got %d", want, got)
+	}
+	for _, ps := range final {
+		if want, got := ps.Score, answered[ps.PlayerID]; want != got {
+			t.Errorf("player %s score want %d got %d", ps.PlayerID, want, got)
+		}
+	}
+}
@@ -1,9 +1,12 @@
 package fam100
 
 import (
+	"errors"
 	"math/rand"
 	"sort"
 	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/patrickmn/go-cache"
@@ -52,6 +55,7 @@ type StateMessage struct {
 	GameID    int64
 	ChanID    string
 	Round     int
+	Rounds    int // rounds of the game
 	State     State
 	RoundText QNAMessage //question and answer
 }
@@ -83,10 +87,11 @@ type roundAnswers struct {
 	Highlight  bool
 }
 type RankMessage struct {
-	ChanID string
-	Round  int
-	Rank   Rank
-	Final  bool
+	ChanID  string
+	Round   int
+	Rank    Rank
+	Final   bool
+	Stopped bool // final rank of a game ended by Stop before every round was played
 }
 
 // PlayerID is the player ID type
@@ -109,8 +114,13 @@ const (
 	RoundStarted  State = "roundStarted"
 	RoundTimeout  State = "RoundTimeout"
 	RoundFinished State = "roundFinished"
+	Cancelled     State = "cancelled"
+	Stopped       State = "stopped" // round ended by Stop
 )
 
+// errGameStopped is returned by startRound when the game is stopped in the middle of the round
+var errGameStopped = errors.New("game stopped")
+
 // Game can consists of multiple round
 // each round user will be asked question and gain points
 type Game struct {
@@ -123,59 +133,304 @@ type Game struct {
 	seed             int64
 	rank             Rank
 	currentRound     *round
+	rounds           int  // rounds of this game
+	roundPlayed      int  // finished rounds of this game
+	resumed          bool // resumed from snapshot of another instance
+
+	stop     chan struct{} // closed by Stop or Abort
+	stopOnce sync.Once
+	done     chan struct{} // closed when the game started by Start has ended
+	aborted  int32         // set to 1 before stop is closed, accessed atomically
+
+	statusMu sync.RWMutex
+	status   GameStatus
+
+	clock Clock
+	rand  *rand.Rand // nil uses the global source
 
 	In  chan Message
 	Out chan Message
 }
 
+// GameOption configures a game created by NewGame
+type GameOption func(g *Game)
+
+// WithClock sets the source of time of the game, used to simulate rounds without waiting
+func WithClock(c Clock) GameOption {
+	return func(g *Game) { g.clock = c }
+}
+
+// WithRounds sets number of rounds of the game, non positive n uses RoundPerGame
+func WithRounds(n int) GameOption {
+	return func(g *Game) {
+		if n > 0 {
+			g.rounds = n
+		}
+	}
+}
+
+// WithRand sets the random source of game and round ID, r is only used by the game goroutine
+func WithRand(r *rand.Rand) GameOption {
+	return func(g *Game) { g.rand = r }
+}
+
+// GameStatus is a snapshot of the game that is safe to read from other goroutine
+type GameStatus struct {
+	ID           int64     `json:"id"`
+	ChanID       string    `json:"chanID"`
+	State        State     `json:"state"`
+	Round        int       `json:"round"`
+	QuestionID   int       `json:"questionID,omitempty"`
+	QuestionText string    `json:"question,omitempty"`
+	RoundEndAt   time.Time `json:"roundEndAt,omitempty"`
+}
+
+// GameSnapshot is the persisted state of a running game. It is saved after every round
+// so another instance can resume the game from the next round
+type GameSnapshot struct {
+	ID               int64     `json:"id"`
+	ChanID           string    `json:"chanID"`
+	ChanName         string    `json:"chanName"`
+	Round            int       `json:"round"` // finished rounds
+	Rounds           int       `json:"rounds"`
+	TotalRoundPlayed int       `json:"totalRoundPlayed"`
+	Seed             int64     `json:"seed"`
+	Rank             Rank      `json:"rank"`
+	Players          []Player  `json:"players"`
+	SavedAt          time.Time `json:"savedAt"`
+}
+
 // NewGame create a new round
-func NewGame(chanID, chanName string, in, out chan Message) (r *Game, err error) {
+func NewGame(chanID, chanName string, in, out chan Message, options ...GameOption) (r *Game, err error) {
 	seed, totalRoundPlayed, err := DefaultDB.nextGame(chanID)
 	if err != nil {
 		return nil, err
 	}
 
-	return &Game{
-		ID:               int64(rand.Int31()),
+	g := &Game{
 		ChanID:           chanID,
 		ChanName:         chanName,
 		State:            Created,
 		players:          make(map[PlayerID]Player),
 		seed:             seed,
 		TotalRoundPlayed: totalRoundPlayed,
+		rounds:           RoundPerGame,
+		clock:            DefaultClock,
+		stop:             make(chan struct{}),
+		done:             make(chan struct{}),
 		In:               in,
 		Out:              out,
-	}, err
+	}
+	for _, option := range options {
+		option(g)
+	}
+	g.ID = int64(g.int31())
+	g.status = GameStatus{ID: g.ID, ChanID: chanID, State: Created}
+	g.logEvent(Event{Type: EventGameCreated, ChanID: chanID, GameID: g.ID})
+
+	return g, err
+}
+
+// ResumeGame creates game from the snapshot, Start continues with the round after the snapshot
+func ResumeGame(s GameSnapshot, in, out chan Message, options ...GameOption) *Game {
+	g := &Game{
+		ID:               s.ID,
+		ChanID:           s.ChanID,
+		ChanName:         s.ChanName,
+		State:            Created,
+		players:          make(map[PlayerID]Player),
+		seed:             s.Seed,
+		TotalRoundPlayed: s.TotalRoundPlayed,
+		rank:             s.Rank,
+		rounds:           s.Rounds,
+		roundPlayed:      s.Round,
+		resumed:          true,
+		clock:            DefaultClock,
+		stop:             make(chan struct{}),
+		done:             make(chan struct{}),
+		In:               in,
+		Out:              out,
+	}
+	if g.rounds <= 0 {
+		g.rounds = RoundPerGame
+	}
+	for _, p := range s.Players {
+		g.players[p.ID] = p
+	}
+	for _, option := range options {
+		option(g)
+	}
+	g.status = GameStatus{ID: g.ID, ChanID: g.ChanID, State: Created, Round: g.roundPlayed}
+
+	return g
+}
+
+// snapshot returns persisted state of the game, only called from the game goroutine
+func (g *Game) snapshot() GameSnapshot {
+	s := GameSnapshot{
+		ID:               g.ID,
+		ChanID:           g.ChanID,
+		ChanName:         g.ChanName,
+		Round:            g.roundPlayed,
+		Rounds:           g.rounds,
+		TotalRoundPlayed: g.TotalRoundPlayed,
+		Seed:             g.seed,
+		Rank:             g.rank,
+		SavedAt:          g.clock.Now(),
+	}
+	for _, p := range g.players {
+		s.Players = append(s.Players, p)
+	}
+
+	return s
+}
+
+func (g *Game) saveSnapshot() {
+	if err := DefaultDB.SaveGameSnapshot(g.snapshot()); err != nil {
+		log.Error("saving game snapshot failed", zap.String("chanID", g.ChanID), zap.Int64("gameID", g.ID), zap.Error(err))
+	}
+}
+
+func (g *Game) int31() int32 {
+	if g.rand == nil {
+		return rand.Int31()
+	}
+	return g.rand.Int31()
+}
+
+// logEvent logs the event with the time of the game clock
+func (g *Game) logEvent(e Event) {
+	e.Time = g.clock.Now()
+	LogEvent(e)
+}
+
+// Status returns snapshot of the game
+func (g *Game) Status() GameStatus {
+	g.statusMu.RLock()
+	defer g.statusMu.RUnlock()
+
+	return g.status
+}
+
+func (g *Game) updateStatus(update func(s *GameStatus)) {
+	g.statusMu.Lock()
+	defer g.statusMu.Unlock()
+
+	update(&g.status)
 }
 
 // Start the game
 func (g *Game) Start() {
 	g.State = Started
-	log.Info("Game started",
-		zap.String("chanID", g.ChanID),
-		zap.Int64("gameID", g.ID),
-		zap.Int64("seed", g.seed),
-		zap.Int("totalRoundPlayed", g.TotalRoundPlayed))
+	g.updateStatus(func(s *GameStatus) { s.State = Started })
+	if g.resumed {
+		log.Info("Game resumed", zap.String("chanID", g.ChanID), zap.Int64("gameID", g.ID), zap.Int("roundPlayed", g.roundPlayed))
+		g.logEvent(Event{Type: EventGameResumed, ChanID: g.ChanID, GameID: g.ID, Round: g.roundPlayed})
+	} else {
+		log.Info("Game started",
+			zap.String("chanID", g.ChanID),
+			zap.Int64("gameID", g.ID),
+			zap.Int64("seed", g.seed),
+			zap.Int("totalRoundPlayed", g.TotalRoundPlayed))
+		recordChannelStats(g.ChanID, cStatsGameStarted)
+		g.logEvent(Event{Type: EventGameStarted, ChanID: g.ChanID, GameID: g.ID})
+		if err := DefaultDB.incHourStats(g.ChanID, g.clock.Now().Hour()); err != nil {
+			log.Error("failed to record hour stats", zap.String("chanID", g.ChanID), zap.Error(err))
+		}
+	}
 
 	go func() {
+		defer close(g.done)
+		g.saveSnapshot()
 		g.Out <- StateMessage{ChanID: g.ChanID, State: Started, GameID: g.ID}
-		for i := 1; i <= RoundPerGame; i++ {
+		for i := g.roundPlayed + 1; i <= g.rounds && !g.stopped(); i++ {
 			err := g.startRound(i)
+			if err == errGameStopped {
+				break
+			}
 			if err != nil {
 				log.Error("starting round failed", zap.String("chanID", g.ChanID), zap.Error(err))
 			}
-			final := i == RoundPerGame
+			g.roundPlayed = i
+			final := i == g.rounds
+			if !final {
+				g.saveSnapshot()
+			}
 			g.Out <- RankMessage{ChanID: g.ChanID, Round: i, Rank: g.rank, Final: final}
 			if !final {
-				time.Sleep(DelayBetweenRound)
+				select {
+				case <-g.clock.After(DelayBetweenRound):
+				case <-g.stop:
+				}
 			}
 		}
+		if g.isAborted() {
+			// continued by another instance from the snapshot
+			log.Info("Game aborted", zap.String("chanID", g.ChanID), zap.Int64("gameID", g.ID), zap.Int("roundPlayed", g.roundPlayed))
+			return
+		}
+		if g.roundPlayed < g.rounds {
+			g.logEvent(Event{Type: EventGameStopped, ChanID: g.ChanID, GameID: g.ID, Round: g.roundPlayed})
+			log.Info("Game stopped", zap.String("chanID", g.ChanID), zap.Int64("gameID", g.ID), zap.Int("roundPlayed", g.roundPlayed))
+			g.Out <- RankMessage{ChanID: g.ChanID, Round: g.roundPlayed, Rank: g.rank, Final: true, Stopped: true}
+		}
 		g.State = Finished
+		g.updateStatus(func(s *GameStatus) { *s = GameStatus{ID: s.ID, ChanID: s.ChanID, State: Finished, Round: s.Round} })
+		recordGameStats(g.players, g.rank)
+		g.logEvent(Event{Type: EventGameFinished, ChanID: g.ChanID, GameID: g.ID, Rank: g.rank})
+		if err := DefaultDB.DeleteGameSnapshot(g.ChanID); err != nil {
+			log.Error("deleting game snapshot failed", zap.String("chanID", g.ChanID), zap.Error(err))
+		}
 		g.Out <- StateMessage{ChanID: g.ChanID, State: Finished, GameID: g.ID}
 		log.Info("Game finished", zap.String("chanID", g.ChanID), zap.Int64("gameID", g.ID))
 	}()
 }
 
+// Stop ends the running game after the current round is scored with the points so far, the final
+// RankMessage and Finished state are sent as when the game ends normally. Safe to call from any goroutine
+func (g *Game) Stop() {
+	g.stopOnce.Do(func() { close(g.stop) })
+}
+
+// Abort ends the running game without sending anything and keeps the snapshot, used when the game
+// is continued by another instance
+func (g *Game) Abort() {
+	g.stopOnce.Do(func() {
+		atomic.StoreInt32(&g.aborted, 1)
+		close(g.stop)
+	})
+}
+
+// Done returns a channel closed once the game started by Start has ended, finished, stopped or aborted
+func (g *Game) Done() <-chan struct{} {
+	return g.done
+}
+
+func (g *Game) isAborted() bool {
+	return atomic.LoadInt32(&g.aborted) == 1
+}
+
+func (g *Game) stopped() bool {
+	select {
+	case <-g.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// Cancel the game that never started because there were not enough players
+func (g *Game) Cancel() {
+	if g.State != Created {
+		return
+	}
+	g.State = Cancelled
+	g.updateStatus(func(s *GameStatus) { s.State = Cancelled })
+	recordChannelStats(g.ChanID, cStatsGameCancelled)
+	g.logEvent(Event{Type: EventGameCancelled, ChanID: g.ChanID, GameID: g.ID})
+	log.Info("Game cancelled", zap.String("chanID", g.ChanID), zap.Int64("gameID", g.ID))
+}
+
 func (g *Game) startRound(currentRound int) error {
 	g.TotalRoundPlayed++
 	if err := DefaultDB.incRoundPlayed(g.ChanID); err != nil {
@@ -189,19 +444,25 @@ func (g *Game) startRound(currentRound int) error {
 		}
 	}
 
-	r, err := newRound(g.seed, g.TotalRoundPlayed, g.players, questionLimit)
+	r, err := newRound(int64(g.int31()), g.seed, g.TotalRoundPlayed, g.players, questionLimit, g.clock)
 	if err != nil {
 		return err
 	}
 
 	g.currentRound = r
+	r.number = currentRound
 	r.state = RoundStarted
-	timeUp := time.After(RoundDuration)
-	timeLeftTick := time.NewTicker(tickDuration)
-	displayAnswerTick := time.NewTicker(tickDuration)
+	g.updateStatus(func(s *GameStatus) {
+		s.State, s.Round = RoundStarted, currentRound
+		s.QuestionID, s.QuestionText, s.RoundEndAt = r.q.ID, r.q.Text, r.endAt
+	})
+	timeUp := g.clock.After(RoundDuration)
+	timeLeftTick := g.clock.NewTicker(tickDuration)
+	displayAnswerTick := g.clock.NewTicker(tickDuration)
 
 	// print question
-	g.Out <- StateMessage{ChanID: g.ChanID, State: RoundStarted, Round: currentRound, RoundText: r.questionText(g.ChanID, false), GameID: g.ID}
+	g.Out <- StateMessage{ChanID: g.ChanID, State: RoundStarted, Round: currentRound, Rounds: g.rounds, RoundText: r.questionText(g.ChanID, false), GameID: g.ID}
+	g.logEvent(Event{Type: EventQuestionAsked, ChanID: g.ChanID, GameID: g.ID, Round: currentRound, QuestionID: r.q.ID, Question: r.q.Text})
 	log.Info("Round Started", zap.String("chanID", g.ChanID), zap.Int64("gameID", g.ID), zap.Int64("roundID", r.id), zap.Int("questionID", r.q.ID), zap.Int("questionLimit", questionLimit))
 
 	for {
@@ -227,7 +488,9 @@ func (g *Game) startRound(currentRound int) error {
 				displayAnswerTick.Stop()
 				g.showAnswer(r)
 				r.state = RoundFinished
-				g.updateRanking(r.ranking())
+				g.updateStatus(func(s *GameStatus) { s.State = RoundFinished })
+				g.updateRanking(r, RoundFinished)
+				recordChannelStats(g.ChanID, cStatsRoundFinished)
 				g.Out <- StateMessage{ChanID: g.ChanID, State: RoundFinished, Round: currentRound, GameID: g.ID}
 				log.Info("Round finished", zap.String("chanID", g.ChanID), zap.Int64("gameID", g.ID), zap.Int64("roundID", r.id), zap.Bool("timeout", false))
 				gameFinishedTimer.UpdateSince(started)
@@ -237,20 +500,30 @@ func (g *Game) startRound(currentRound int) error {
 			gameMsgProcessTimer.UpdateSince(started)
 			gameServiceTimer.UpdateSince(msg.ReceivedAt)
 
-		case <-timeLeftTick.C: // inform time left
+		case <-timeLeftTick.C(): // inform time left
 			select {
 			case g.Out <- TickMessage{ChanID: g.ChanID, TimeLeft: r.timeLeft()}:
 			default:
 			}
 
-		case <-displayAnswerTick.C: // show correct answer (at most once every 10s)
+		case <-displayAnswerTick.C(): // show correct answer (at most once every 10s)
 			g.showAnswer(r)
 
+		case <-g.stop:
+			timeLeftTick.Stop()
+			displayAnswerTick.Stop()
+			if !g.isAborted() {
+				g.updateRanking(r, Stopped)
+			}
+			return errGameStopped
+
 		case <-timeUp: // time is up
 			timeLeftTick.Stop()
 			displayAnswerTick.Stop()
 			g.State = RoundFinished
-			g.updateRanking(r.ranking())
+			g.updateStatus(func(s *GameStatus) { s.State = RoundTimeout })
+			g.updateRanking(r, RoundTimeout)
+			recordChannelStats(g.ChanID, cStatsRoundTimeout)
 			g.Out <- StateMessage{ChanID: g.ChanID, State: RoundTimeout, Round: currentRound, GameID: g.ID}
 			log.Info("Round finished", zap.String("chanID", g.ChanID), zap.Int64("gameID", g.ID), zap.Int64("roundID", r.id), zap.Bool("timeout", true))
 			showUnAnswered := true
@@ -286,12 +559,34 @@ func (g *Game) handleMessage(msg TextMessage, r *round) (handled bool) {
 		zap.Int64("gameID", g.ID),
 		zap.Int64("roundID", r.id))
 
+	answeredAt := msg.ReceivedAt
+	if answeredAt.IsZero() {
+		answeredAt = g.clock.Now()
+	}
+	recordAnswerStats(msg.Player.ID, idx, answeredAt.Sub(r.startedAt))
+	g.logEvent(Event{
+		Type:       EventAnswerAccepted,
+		ChanID:     g.ChanID,
+		GameID:     g.ID,
+		Round:      r.number,
+		PlayerID:   msg.Player.ID,
+		PlayerName: msg.Player.Name,
+		QuestionID: r.q.ID,
+		Text:       answer,
+		Answer:     r.q.Answers[idx].String(),
+		Score:      r.q.Answers[idx].Score,
+		LatencyMs:  int64(answeredAt.Sub(r.startedAt) / time.Millisecond),
+	})
+
 	return false
 }
 
-func (g *Game) updateRanking(r Rank) {
-	g.rank = g.rank.Add(r)
-	DefaultDB.saveScore(g.ChanID, g.ChanName, r)
+func (g *Game) updateRanking(r *round, state State) {
+	rank := DefaultNameSanitizer.Rank(withoutBanned(g.ChanID, r.ranking()))
+	g.rank = g.rank.Add(rank)
+	DefaultDB.saveScore(g.ChanID, DefaultNameSanitizer.Channel(g.ChanID, g.ChanName), rank)
+	recordRoundStats(g.ChanID, r.active)
+	g.logEvent(Event{Type: EventRoundEnded, ChanID: g.ChanID, GameID: g.ID, Round: r.number, QuestionID: r.q.ID, State: state, Rank: rank})
 }
 
 func (g *Game) CurrentQuestion() Question {
@@ -325,34 +620,42 @@ func (g *Game) showAnswer(r *round) {
 // round represents with one question
 type round struct {
 	id        int64
+	number    int // round number in the game, starts from 1
 	q         Question
 	state     State
 	correct   []PlayerID // correct answer answered by a player, "" means not answered
 	players   map[PlayerID]Player
+	active    map[PlayerID]bool // players who answered in this round
 	highlight map[int]bool
 
-	endAt time.Time
+	startedAt time.Time
+	endAt     time.Time
+	clock     Clock
 }
 
-func newRound(seed int64, totalRoundPlayed int, players map[PlayerID]Player, questionLimit int) (*round, error) {
+func newRound(id, seed int64, totalRoundPlayed int, players map[PlayerID]Player, questionLimit int, clock Clock) (*round, error) {
 	q, err := NextQuestion(seed, totalRoundPlayed, questionLimit)
 	if err != nil {
 		return nil, err
 	}
 
+	now := clock.Now()
 	return &round{
-		id:        int64(rand.Int31()),
+		id:        id,
 		q:         q,
 		correct:   make([]PlayerID, len(q.Answers)),
 		state:     Created,
 		players:   players,
+		active:    make(map[PlayerID]bool),
 		highlight: make(map[int]bool),
-		endAt:     time.Now().Add(RoundDuration).Round(time.Second),
+		startedAt: now,
+		endAt:     now.Add(RoundDuration).Round(time.Second),
+		clock:     clock,
 	}, nil
 }
 
 func (r *round) timeLeft() time.Duration {
-	return r.endAt.Sub(time.Now().Round(time.Second))
+	return r.endAt.Sub(r.clock.Now().Round(time.Second))
 }
 
 // questionText construct QNAMessage which contains questions, answers and score
@@ -366,7 +669,7 @@ func (r *round) questionText(gameID string, showUnAnswered bool) QNAMessage {
 		}
 		if pID := r.correct[i]; pID != "" {
 			ra.Answered = true
-			ra.PlayerName = r.players[pID].Name
+			ra.PlayerName = DefaultNameSanitizer.Player(pID, r.players[pID].Name)
 		}
 		if r.highlight[i] {
 			ra.Highlight = true
@@ -437,6 +740,7 @@ func (r *round) answer(p Player, text string) (correct, answered bool, index int
 	if _, ok := r.players[p.ID]; !ok {
 		r.players[p.ID] = p
 	}
+	r.active[p.ID] = true
 	if correct, _, i := r.q.checkAnswer(text); correct {
 		if r.correct[i] != "" {
 			// already answered
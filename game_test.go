@@ -4,6 +4,7 @@ import (
 	"math/rand"
 	"os"
 	"testing"
+	"time"
 )
 
 func TestMain(m *testing.M) {
@@ -19,7 +20,7 @@ func TestMain(m *testing.M) {
 
 func TestQuestionString(t *testing.T) {
 	var seed, totalRoundPlayed = 7, 0
-	r, err := newRound(int64(seed), totalRoundPlayed, make(map[PlayerID]Player), 10)
+	r, err := newRound(1, int64(seed), totalRoundPlayed, make(map[PlayerID]Player), 10, DefaultClock)
 	if err != nil {
 		t.Error(err)
 	}
@@ -47,3 +48,161 @@ func TestQuestionString(t *testing.T) {
 		}
 	*/
 }
+
+func TestResumeGame(t *testing.T) {
+	oDB, oRoundPerGame, oDelay := DefaultDB, RoundPerGame, DelayBetweenRound
+	defer func() {
+		DefaultDB, RoundPerGame, DelayBetweenRound = oDB, oRoundPerGame, oDelay
+	}()
+	DefaultDB, RoundPerGame, DelayBetweenRound = &MemoryDB{}, 3, 0
+
+	p1, p2 := Player{ID: "r1", Name: "R 1"}, Player{ID: "r2", Name: "R 2"}
+	snapshot := GameSnapshot{ID: 99, ChanID: "resume", ChanName: "Resume", Round: 2, Rank: Rank{{PlayerID: p1.ID, Name: p1.Name, Score: 50}, {PlayerID: p2.ID, Name: p2.Name, Score: 20}}, Players: []Player{p1, p2}}
+	in, out := make(chan Message), make(chan Message, 100)
+	g := ResumeGame(snapshot, in, out)
+	g.Start()
+
+	timeout := time.After(5 * time.Second)
+	var rounds []int
+	var final Rank
+	var answered int
+	for done := false; !done; {
+		select {
+		case msg := <-out:
+			switch m := msg.(type) {
+			case StateMessage:
+				if m.State == RoundStarted {
+					rounds = append(rounds, m.Round)
+					if s, _ := DefaultDB.GameSnapshot("resume"); s == nil || s.Round != 2 {
+						t.Errorf("snapshot of the resumed game should be saved, got %v", s)
+					}
+					for _, ans := range g.CurrentQuestion().Answers {
+						answered += ans.Score
+						in <- TextMessage{ChanID: "resume", Player: p2, Text: ans.Text[0], ReceivedAt: time.Now()}
+					}
+				}
+				done = m.State == Finished
+			case RankMessage:
+				if m.Final {
+					final = m.Rank
+				}
+			}
+		case <-timeout:
+			t.Fatal("timeout waiting game to finish")
+		}
+	}
+
+	if want, got := []int{3}, rounds; len(got) != 1 || want[0] != got[0] {
+		t.Errorf("rounds want %v got %v", want, got)
+	}
+	scores := make(map[PlayerID]int)
+	for _, ps := range final {
+		scores[ps.PlayerID] = ps.Score
+	}
+	if want, got := 50, scores[p1.ID]; want != got {
+		t.Errorf("%s score want %d got %d", p1.ID, want, got)
+	}
+	if want, got := 20+answered, scores[p2.ID]; want != got {
+		t.Errorf("%s score want %d got %d", p2.ID, want, got)
+	}
+	if s, _ := DefaultDB.GameSnapshot("resume"); s != nil {
+		t.Errorf("snapshot should be deleted after game finished, got %v", s)
+	}
+}
+
+func TestStopGame(t *testing.T) {
+	oDB, oRoundPerGame, oDelay := DefaultDB, RoundPerGame, DelayBetweenRound
+	defer func() {
+		DefaultDB, RoundPerGame, DelayBetweenRound = oDB, oRoundPerGame, oDelay
+	}()
+	DefaultDB, RoundPerGame, DelayBetweenRound = &MemoryDB{}, 3, 0
+
+	p1 := Player{ID: "s1", Name: "S 1"}
+	in, out := make(chan Message), make(chan Message, 100)
+	g, err := NewGame("stop", "Stop", in, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.Start()
+
+	timeout := time.After(5 * time.Second)
+	var rounds []int
+	var final Rank
+	var stopped bool
+	var answered int
+	for done := false; !done; {
+		select {
+		case msg := <-out:
+			switch m := msg.(type) {
+			case StateMessage:
+				if m.State == RoundStarted {
+					rounds = append(rounds, m.Round)
+					ans := g.CurrentQuestion().Answers[0]
+					answered = ans.Score
+					in <- TextMessage{ChanID: "stop", Player: p1, Text: ans.Text[0], ReceivedAt: time.Now()}
+					g.Stop()
+					g.Stop()
+				}
+				done = m.State == Finished
+			case RankMessage:
+				if m.Final {
+					final, stopped = m.Rank, m.Stopped
+				}
+			}
+		case <-timeout:
+			t.Fatal("timeout waiting game to stop")
+		}
+	}
+
+	if want, got := 1, len(rounds); want != got {
+		t.Errorf("rounds want %d got %v", want, rounds)
+	}
+	if len(final) != 1 || final[0].PlayerID != p1.ID || final[0].Score != answered {
+		t.Errorf("final rank want %s with %d got %v", p1.ID, answered, final)
+	}
+	if !stopped {
+		t.Errorf("final rank of a stopped game should be marked stopped")
+	}
+	if s, _ := DefaultDB.GameSnapshot("stop"); s != nil {
+		t.Errorf("snapshot should be deleted after game stopped, got %v", s)
+	}
+}
+
+func TestAbortGame(t *testing.T) {
+	oDB, oRoundPerGame := DefaultDB, RoundPerGame
+	defer func() {
+		DefaultDB, RoundPerGame = oDB, oRoundPerGame
+	}()
+	DefaultDB, RoundPerGame = &MemoryDB{}, 3
+
+	in, out := make(chan Message), make(chan Message, 100)
+	g, err := NewGame("abort", "Abort", in, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.Start()
+
+	timeout := time.After(5 * time.Second)
+	for started := false; !started; {
+		select {
+		case msg := <-out:
+			if m, ok := msg.(StateMessage); ok && m.State == RoundStarted {
+				started = true
+			}
+		case <-timeout:
+			t.Fatal("timeout waiting round to start")
+		}
+	}
+	g.Abort()
+	g.Stop()
+
+	// nothing is sent and the snapshot is kept for the instance continuing the game
+	select {
+	case msg := <-out:
+		t.Fatalf("aborted game should not send message, got %#v", msg)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if s, _ := DefaultDB.GameSnapshot("abort"); s == nil {
+		t.Error("snapshot should be kept after game aborted")
+	}
+}
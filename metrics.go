@@ -11,19 +11,51 @@ var (
 	playerActive        = metrics.NewRegisteredGauge("player.active", metrics.DefaultRegistry)
 
 	// db metrics
-	dbChannelCountTimer    = metrics.NewRegisteredTimer("db.channelCount.ns", metrics.DefaultRegistry)
-	dbChannelsTimer        = metrics.NewRegisteredTimer("db.channels.ns", metrics.DefaultRegistry)
-	dbChannelConfigTimer   = metrics.NewRegisteredTimer("db.channelConfig.ns", metrics.DefaultRegistry)
-	dbGlobalConfigTimer    = metrics.NewRegisteredTimer("db.globalConfig.ns", metrics.DefaultRegistry)
-	dbPlayerCountTimer     = metrics.NewRegisteredTimer("db.playerCount.ns", metrics.DefaultRegistry)
-	dbNextGameTimer        = metrics.NewRegisteredTimer("db.nextGame.ns", metrics.DefaultRegistry)
-	dbIncStatsTimer        = metrics.NewRegisteredTimer("db.incStats.ns", metrics.DefaultRegistry)
-	dbIncChannelStatsTimer = metrics.NewRegisteredTimer("db.incChannelStats.ns", metrics.DefaultRegistry)
-	dbIncPlayerStatsTimer  = metrics.NewRegisteredTimer("db.incPlayerStats.ns", metrics.DefaultRegistry)
-	dbStatsTimer           = metrics.NewRegisteredTimer("db.stats.ns", metrics.DefaultRegistry)
-	dbChannelStatsTimer    = metrics.NewRegisteredTimer("db.channelStats.ns", metrics.DefaultRegistry)
-	dbPlayerStatsTimer     = metrics.NewRegisteredTimer("db.playerStats.ns", metrics.DefaultRegistry)
-	dbSaveScoreTimer       = metrics.NewRegisteredTimer("db.saveScore.ns", metrics.DefaultRegistry)
-	dbGetRankingTimer      = metrics.NewRegisteredTimer("db.getRanking.ns", metrics.DefaultRegistry)
-	dbGetScoreTimer        = metrics.NewRegisteredTimer("db.getScore.ns", metrics.DefaultRegistry)
+	dbChannelCountTimer        = metrics.NewRegisteredTimer("db.channelCount.ns", metrics.DefaultRegistry)
+	dbChannelsTimer            = metrics.NewRegisteredTimer("db.channels.ns", metrics.DefaultRegistry)
+	dbChannelConfigTimer       = metrics.NewRegisteredTimer("db.channelConfig.ns", metrics.DefaultRegistry)
+	eventLogTimer              = metrics.NewRegisteredTimer("eventLog.log.ns", metrics.DefaultRegistry)
+	dbSetChannelConfigTimer    = metrics.NewRegisteredTimer("db.setChannelConfig.ns", metrics.DefaultRegistry)
+	dbGlobalConfigTimer        = metrics.NewRegisteredTimer("db.globalConfig.ns", metrics.DefaultRegistry)
+	dbSetGlobalConfigTimer     = metrics.NewRegisteredTimer("db.setGlobalConfig.ns", metrics.DefaultRegistry)
+	dbMigrateChannelTimer      = metrics.NewRegisteredTimer("db.migrateChannel.ns", metrics.DefaultRegistry)
+	dbPlayerCountTimer         = metrics.NewRegisteredTimer("db.playerCount.ns", metrics.DefaultRegistry)
+	dbNextGameTimer            = metrics.NewRegisteredTimer("db.nextGame.ns", metrics.DefaultRegistry)
+	dbIncStatsTimer            = metrics.NewRegisteredTimer("db.incStats.ns", metrics.DefaultRegistry)
+	dbIncChannelStatsTimer     = metrics.NewRegisteredTimer("db.incChannelStats.ns", metrics.DefaultRegistry)
+	dbIncPlayerStatsTimer      = metrics.NewRegisteredTimer("db.incPlayerStats.ns", metrics.DefaultRegistry)
+	dbMaxPlayerStatsTimer      = metrics.NewRegisteredTimer("db.maxPlayerStats.ns", metrics.DefaultRegistry)
+	dbStatsTimer               = metrics.NewRegisteredTimer("db.stats.ns", metrics.DefaultRegistry)
+	dbChannelStatsTimer        = metrics.NewRegisteredTimer("db.channelStats.ns", metrics.DefaultRegistry)
+	dbPlayerStatsTimer         = metrics.NewRegisteredTimer("db.playerStats.ns", metrics.DefaultRegistry)
+	dbAddChannelPlayersTimer   = metrics.NewRegisteredTimer("db.addChannelPlayers.ns", metrics.DefaultRegistry)
+	dbChannelPlayerCountTimer  = metrics.NewRegisteredTimer("db.channelPlayerCount.ns", metrics.DefaultRegistry)
+	dbIncHourStatsTimer        = metrics.NewRegisteredTimer("db.incHourStats.ns", metrics.DefaultRegistry)
+	dbHourStatsTimer           = metrics.NewRegisteredTimer("db.hourStats.ns", metrics.DefaultRegistry)
+	dbSaveScoreTimer           = metrics.NewRegisteredTimer("db.saveScore.ns", metrics.DefaultRegistry)
+	dbRankedChannelsTimer      = metrics.NewRegisteredTimer("db.rankedChannels.ns", metrics.DefaultRegistry)
+	dbResetChannelRankingTimer = metrics.NewRegisteredTimer("db.resetChannelRanking.ns", metrics.DefaultRegistry)
+	dbGetRankingTimer          = metrics.NewRegisteredTimer("db.getRanking.ns", metrics.DefaultRegistry)
+	dbGetScoreTimer            = metrics.NewRegisteredTimer("db.getScore.ns", metrics.DefaultRegistry)
+	dbPlayerNamesTimer         = metrics.NewRegisteredTimer("db.playerNames.ns", metrics.DefaultRegistry)
+	dbMergePlayerTimer         = metrics.NewRegisteredTimer("db.mergePlayer.ns", metrics.DefaultRegistry)
+	dbAcquireLeaseTimer        = metrics.NewRegisteredTimer("db.acquireLease.ns", metrics.DefaultRegistry)
+	dbRenewLeaseTimer          = metrics.NewRegisteredTimer("db.renewLease.ns", metrics.DefaultRegistry)
+	dbReleaseLeaseTimer        = metrics.NewRegisteredTimer("db.releaseLease.ns", metrics.DefaultRegistry)
+	dbLeaseHolderTimer         = metrics.NewRegisteredTimer("db.leaseHolder.ns", metrics.DefaultRegistry)
+	dbRegisterInstanceTimer    = metrics.NewRegisteredTimer("db.registerInstance.ns", metrics.DefaultRegistry)
+	dbUnregisterInstanceTimer  = metrics.NewRegisteredTimer("db.unregisterInstance.ns", metrics.DefaultRegistry)
+	dbInstancesTimer           = metrics.NewRegisteredTimer("db.instances.ns", metrics.DefaultRegistry)
+	dbPushUpdateTimer          = metrics.NewRegisteredTimer("db.pushUpdate.ns", metrics.DefaultRegistry)
+	dbPopUpdateTimer           = metrics.NewRegisteredTimer("db.popUpdate.ns", metrics.DefaultRegistry)
+	dbSaveGameSnapshotTimer    = metrics.NewRegisteredTimer("db.saveGameSnapshot.ns", metrics.DefaultRegistry)
+	dbGameSnapshotTimer        = metrics.NewRegisteredTimer("db.gameSnapshot.ns", metrics.DefaultRegistry)
+	dbDeleteGameSnapshotTimer  = metrics.NewRegisteredTimer("db.deleteGameSnapshot.ns", metrics.DefaultRegistry)
+	dbSnapshotChannelsTimer    = metrics.NewRegisteredTimer("db.snapshotChannels.ns", metrics.DefaultRegistry)
+	dbScheduleTimer            = metrics.NewRegisteredTimer("db.schedule.ns", metrics.DefaultRegistry)
+	dbTournamentTimer          = metrics.NewRegisteredTimer("db.tournament.ns", metrics.DefaultRegistry)
+	dbBroadcastTimer           = metrics.NewRegisteredTimer("db.broadcast.ns", metrics.DefaultRegistry)
+	dbRemoveChannelTimer       = metrics.NewRegisteredTimer("db.removeChannel.ns", metrics.DefaultRegistry)
+	dbAdminTimer               = metrics.NewRegisteredTimer("db.admin.ns", metrics.DefaultRegistry)
+	dbBanTimer                 = metrics.NewRegisteredTimer("db.ban.ns", metrics.DefaultRegistry)
 )
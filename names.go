@@ -0,0 +1,144 @@
+package fam100
+
+import (
+	"bufio"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// DefaultNameMaxLength is the length of the names shown in the rankings, in characters
+const DefaultNameMaxLength = 32
+
+// DefaultNameSanitizer cleans the names saved with the scores and shown in the rankings
+var DefaultNameSanitizer = NewNameSanitizer(DefaultNameMaxLength, nil)
+
+// maxCombiningMarks is the number of combining marks kept on a character, more is used to draw over
+// the other lines of the ranking
+const maxCombiningMarks = 2
+
+// leetReplacer maps the characters used to write around the word list
+var leetReplacer = strings.NewReplacer("0", "o", "1", "i", "3", "e", "4", "a", "5", "s", "7", "t", "@", "a", "$", "s")
+
+// NameSanitizer cleans player and channel names coming from telegram before they are shown publicly.
+// Invisible and direction changing characters are removed and long names are cut. A name containing a
+// word of the list is replaced by an alias generated from the ID, the same ID always gets the same alias
+type NameSanitizer struct {
+	MaxLength int // in characters, 0 is unlimited
+	words     []string
+}
+
+// NewNameSanitizer creates a sanitizer replacing the names containing one of the words, a word can be
+// several words separated by space
+func NewNameSanitizer(maxLength int, words []string) *NameSanitizer {
+	s := &NameSanitizer{MaxLength: maxLength}
+	for _, word := range words {
+		if w := normalizeName(word); w != "" {
+			s.words = append(s.words, w)
+		}
+	}
+	return s
+}
+
+// LoadNameWords reads the word list of the sanitizer, one word per line. Empty lines and lines starting
+// with # are skipped
+func LoadNameWords(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var words []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		words = append(words, line)
+	}
+	return words, scanner.Err()
+}
+
+// Player returns the name of the player to show
+func (s *NameSanitizer) Player(id PlayerID, name string) string {
+	if clean, ok := s.clean(name); ok {
+		return clean
+	}
+	return fmt.Sprintf(T("Pemain %04d"), crc32.ChecksumIEEE([]byte(id))%10000)
+}
+
+// Channel returns the name of the channel to show
+func (s *NameSanitizer) Channel(chanID, name string) string {
+	if clean, ok := s.clean(name); ok {
+		return clean
+	}
+	return fmt.Sprintf(T("Grup %04d"), crc32.ChecksumIEEE([]byte(chanID))%10000)
+}
+
+// Rank returns a copy of the rank with the player names sanitized
+func (s *NameSanitizer) Rank(rank Rank) Rank {
+	if rank == nil {
+		return nil
+	}
+	sanitized := make(Rank, len(rank))
+	for i, ps := range rank {
+		ps.Name = s.Player(ps.PlayerID, ps.Name)
+		sanitized[i] = ps
+	}
+	return sanitized
+}
+
+// clean returns the name without the hidden characters, false if the name can not be shown
+func (s *NameSanitizer) clean(name string) (string, bool) {
+	var b strings.Builder
+	marks, space := 0, false
+	for _, r := range name {
+		switch {
+		case unicode.Is(unicode.Cf, r) || unicode.IsControl(r) && !unicode.IsSpace(r):
+			// zero width, direction override and other invisible characters
+			continue
+		case unicode.IsSpace(r):
+			space = b.Len() > 0
+			continue
+		case unicode.Is(unicode.Mn, r):
+			if marks++; marks > maxCombiningMarks {
+				continue
+			}
+		default:
+			marks = 0
+		}
+		if space {
+			b.WriteRune(' ')
+			space = false
+		}
+		b.WriteRune(r)
+	}
+	clean := b.String()
+	if clean == "" {
+		return "", false
+	}
+
+	normalized := " " + normalizeName(clean) + " "
+	for _, word := range s.words {
+		if strings.Contains(normalized, " "+word+" ") {
+			return "", false
+		}
+	}
+
+	if runes := []rune(clean); s.MaxLength > 0 && len(runes) > s.MaxLength {
+		clean = strings.TrimSpace(string(runes[:s.MaxLength-1])) + "…"
+	}
+	return clean, true
+}
+
+// normalizeName returns the lower case words of the name separated by a space, used to match the word list
+func normalizeName(name string) string {
+	name = leetReplacer.Replace(strings.ToLower(name))
+	return strings.Join(strings.FieldsFunc(name, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}), " ")
+}
@@ -0,0 +1,67 @@
+package fam100
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestNameSanitizer(t *testing.T) {
+	s := NewNameSanitizer(12, []string{"jelek", "anak nakal"})
+	alias := s.Player("p1", "")
+	if !strings.HasPrefix(alias, "Pemain ") || alias != s.Player("p1", "\u200b") {
+		t.Errorf("alias should be generated from the ID, got %q", alias)
+	}
+
+	tests := []struct {
+		name, want string
+	}{
+		{"Budi", "Budi"},
+		{"  Budi \n\t Santoso ", "Budi Santoso"},
+		{"Bu\u200bdi\u202e", "Budi"},
+		{"\u202eBudi\u2066", "Budi"},
+		{"Muhammad Abdullah", "Muhammad Ab…"},
+		{"e\u0301\u0301\u0301\u0301", "e\u0301\u0301"},
+		{"Si J3LEK", alias},
+		{"si_jelek_99", alias},
+		{"anak-nakal", alias},
+		{"Jelekson", "Jelekson"},
+		{"\u200b\u200d", alias},
+	}
+	for _, tt := range tests {
+		if got := s.Player("p1", tt.name); got != tt.want {
+			t.Errorf("%q want %q got %q", tt.name, tt.want, got)
+		}
+		if got := s.Player("p1", s.Player("p1", tt.name)); got != tt.want {
+			t.Errorf("%q sanitized twice want %q got %q", tt.name, tt.want, got)
+		}
+	}
+	if got := s.Channel("-1", "Grup Jelek"); !strings.HasPrefix(got, "Grup ") || got == "Grup Jelek" {
+		t.Errorf("channel alias expected got %q", got)
+	}
+
+	rank := Rank{{PlayerID: "p1", Name: "jelek"}, {PlayerID: "p2", Name: "Budi"}}
+	sanitized := s.Rank(rank)
+	if rank[0].Name != "jelek" || sanitized[0].Name != alias || sanitized[1].Name != "Budi" {
+		t.Errorf("unexpected rank %v, original %v", sanitized, rank)
+	}
+}
+
+func TestLoadNameWords(t *testing.T) {
+	file, err := ioutil.TempFile("", "words")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString("# comment\njelek\n\n  anak nakal \n")
+	file.Close()
+
+	words, err := LoadNameWords(file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := "jelek|anak nakal", strings.Join(words, "|"); want != got {
+		t.Errorf("words want %s got %s", want, got)
+	}
+}
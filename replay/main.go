@@ -0,0 +1,167 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/yulrizka/fam100"
+)
+
+var (
+	eventFile = "events.log"
+	gameID    int64
+	chanID    = ""
+)
+
+// replayResult is the reconstructed game from the event log
+type replayResult struct {
+	GameID   int64
+	ChanID   string
+	Timeline []string
+	Scores   fam100.Rank // reconstructed from accepted answers
+	Final    fam100.Rank // final rank logged by the game, nil if the game did not finish
+}
+
+// Match returns true if the reconstructed scores match the logged final rank
+func (r replayResult) Match() bool {
+	if r.Final == nil || len(r.Final) != len(r.Scores) {
+		return false
+	}
+	scores := make(map[fam100.PlayerID]int)
+	for _, ps := range r.Scores {
+		scores[ps.PlayerID] = ps.Score
+	}
+	for _, ps := range r.Final {
+		if score, ok := scores[ps.PlayerID]; !ok || score != ps.Score {
+			return false
+		}
+	}
+
+	return true
+}
+
+func main() {
+	log.SetFlags(log.LstdFlags | log.Lshortfile)
+	flag.StringVar(&eventFile, "file", "events.log", "event log file written by -eventLog")
+	flag.Int64Var(&gameID, "game", 0, "game id to replay")
+	flag.StringVar(&chanID, "chan", "", "list games of the channel when game is not set")
+	flag.Parse()
+
+	file, err := os.Open(eventFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer file.Close()
+
+	if gameID == 0 {
+		if err := listGames(os.Stdout, file, chanID); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	events, err := fam100.ReadEvents(file, func(e fam100.Event) bool { return e.GameID == gameID })
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(events) == 0 {
+		log.Fatalf("no event found for game %d", gameID)
+	}
+	result := replay(events)
+	printResult(os.Stdout, result)
+	if !result.Match() {
+		os.Exit(1)
+	}
+}
+
+// listGames prints created games, optionally only of a channel
+func listGames(w io.Writer, r io.Reader, chanID string) error {
+	events, err := fam100.ReadEvents(r, func(e fam100.Event) bool {
+		return e.Type == fam100.EventGameCreated && (chanID == "" || e.ChanID == chanID)
+	})
+	if err != nil {
+		return err
+	}
+	for _, e := range events {
+		fmt.Fprintf(w, "%s chanID:%s game:%d\n", e.Time.Format(time.RFC3339), e.ChanID, e.GameID)
+	}
+
+	return nil
+}
+
+// replay builds timeline and scores of a single game events
+func replay(events []fam100.Event) replayResult {
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Time.Before(events[j].Time) })
+
+	result := replayResult{GameID: events[0].GameID, ChanID: events[0].ChanID}
+	start := events[0].Time
+	scores := make(map[fam100.PlayerID]fam100.PlayerScore)
+	for _, e := range events {
+		var line string
+		switch e.Type {
+		case fam100.EventGameCreated:
+			line = "game created"
+		case fam100.EventPlayerJoined:
+			line = fmt.Sprintf("%s (%s) joined", e.PlayerName, e.PlayerID)
+		case fam100.EventGameStarted:
+			line = "game started"
+		case fam100.EventQuestionAsked:
+			line = fmt.Sprintf("round %d question [%d] %s?", e.Round, e.QuestionID, e.Question)
+		case fam100.EventAnswerAccepted:
+			line = fmt.Sprintf("round %d %s (%s) answered %q as %q +%d in %dms", e.Round, e.PlayerName, e.PlayerID, e.Text, e.Answer, e.Score, e.LatencyMs)
+			ps := scores[e.PlayerID]
+			ps.PlayerID, ps.Name = e.PlayerID, e.PlayerName
+			ps.Score += e.Score
+			scores[e.PlayerID] = ps
+		case fam100.EventRoundEnded:
+			line = fmt.Sprintf("round %d ended (%s)", e.Round, e.State)
+		case fam100.EventGameFinished:
+			line = "game finished"
+			result.Final = e.Rank
+		case fam100.EventGameCancelled:
+			line = "game cancelled"
+		default:
+			line = string(e.Type)
+		}
+		result.Timeline = append(result.Timeline, fmt.Sprintf("+%-8s %s", e.Time.Sub(start).Truncate(time.Millisecond), line))
+	}
+
+	for _, ps := range scores {
+		result.Scores = append(result.Scores, ps)
+	}
+	sort.Sort(result.Scores)
+	for i := range result.Scores {
+		result.Scores[i].Position = i + 1
+	}
+
+	return result
+}
+
+func printResult(w io.Writer, r replayResult) {
+	fmt.Fprintf(w, "game %d chanID:%s\n\n", r.GameID, r.ChanID)
+	for _, line := range r.Timeline {
+		fmt.Fprintln(w, line)
+	}
+
+	fmt.Fprintln(w, "\nscores from answers:")
+	for _, ps := range r.Scores {
+		fmt.Fprintf(w, "%d. %s (%s) %d\n", ps.Position, ps.Name, ps.PlayerID, ps.Score)
+	}
+
+	switch {
+	case r.Final == nil:
+		fmt.Fprintln(w, "\ngame did not finish, no final rank logged")
+	case r.Match():
+		fmt.Fprintln(w, "\nfinal rank matches the answers")
+	default:
+		fmt.Fprintln(w, "\nMISMATCH final rank logged by the game:")
+		for _, ps := range r.Final {
+			fmt.Fprintf(w, "%d. %s (%s) %d\n", ps.Position, ps.Name, ps.PlayerID, ps.Score)
+		}
+	}
+}
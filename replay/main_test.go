@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yulrizka/fam100"
+)
+
+func testEvents() []fam100.Event {
+	start := time.Date(2016, 12, 1, 20, 0, 0, 0, time.UTC)
+	at := func(sec int) time.Time { return start.Add(time.Duration(sec) * time.Second) }
+
+	return []fam100.Event{
+		{Time: at(0), Type: fam100.EventGameCreated, ChanID: "-1", GameID: 7},
+		{Time: at(1), Type: fam100.EventPlayerJoined, ChanID: "-1", GameID: 7, PlayerID: "a", PlayerName: "A"},
+		{Time: at(2), Type: fam100.EventGameStarted, ChanID: "-1", GameID: 7},
+		{Time: at(3), Type: fam100.EventQuestionAsked, ChanID: "-1", GameID: 7, Round: 1, QuestionID: 9, Question: "Buah"},
+		{Time: at(5), Type: fam100.EventAnswerAccepted, ChanID: "-1", GameID: 7, Round: 1, PlayerID: "a", PlayerName: "A", Text: "apel", Answer: "apel", Score: 30},
+		{Time: at(4), Type: fam100.EventAnswerAccepted, ChanID: "-1", GameID: 7, Round: 1, PlayerID: "b", PlayerName: "B", Text: "jeruk", Answer: "jeruk", Score: 20},
+		{Time: at(6), Type: fam100.EventAnswerAccepted, ChanID: "-1", GameID: 7, Round: 1, PlayerID: "b", PlayerName: "B", Text: "mangga", Answer: "mangga", Score: 15},
+		{Time: at(7), Type: fam100.EventRoundEnded, ChanID: "-1", GameID: 7, Round: 1, State: fam100.RoundFinished},
+		{Time: at(8), Type: fam100.EventGameFinished, ChanID: "-1", GameID: 7, Rank: fam100.Rank{
+			{PlayerID: "b", Name: "B", Score: 35, Position: 1},
+			{PlayerID: "a", Name: "A", Score: 30, Position: 2},
+		}},
+	}
+}
+
+func TestReplay(t *testing.T) {
+	result := replay(testEvents())
+
+	if want, got := 9, len(result.Timeline); want != got {
+		t.Fatalf("timeline want %d got %d", want, got)
+	}
+	if !strings.Contains(result.Timeline[4], "B (b) answered \"jeruk\"") {
+		t.Errorf("timeline should be sorted by time, got %s", result.Timeline[4])
+	}
+	if want, got := fam100.PlayerID("b"), result.Scores[0].PlayerID; want != got {
+		t.Errorf("scores[0] want %s got %s", want, got)
+	}
+	if want, got := 35, result.Scores[0].Score; want != got {
+		t.Errorf("scores[0] score want %d got %d", want, got)
+	}
+	if !result.Match() {
+		t.Errorf("scores should match final rank")
+	}
+
+	// tampered final rank
+	result.Final[1].Score = 40
+	if result.Match() {
+		t.Errorf("scores should not match tampered final rank")
+	}
+	var buf bytes.Buffer
+	printResult(&buf, result)
+	if !strings.Contains(buf.String(), "MISMATCH") {
+		t.Errorf("mismatch should be reported, got %s", buf.String())
+	}
+
+	// unfinished game
+	events := testEvents()
+	result = replay(events[:len(events)-1])
+	if result.Match() {
+		t.Errorf("unfinished game should not match")
+	}
+}
+
+func TestListGames(t *testing.T) {
+	input := `{"type":"gameCreated","chanID":"1","gameID":1,"time":"2016-12-01T20:00:00Z"}
+{"type":"gameStarted","chanID":"1","gameID":1,"time":"2016-12-01T20:00:01Z"}
+{"type":"gameCreated","chanID":"2","gameID":2,"time":"2016-12-01T20:00:02Z"}
+`
+	var buf bytes.Buffer
+	if err := listGames(&buf, strings.NewReader(input), "2"); err != nil {
+		t.Fatal(err)
+	}
+	if want, got := "2016-12-01T20:00:02Z chanID:2 game:2\n", buf.String(); want != got {
+		t.Errorf("want %q got %q", want, got)
+	}
+}
@@ -0,0 +1,129 @@
+package fam100
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule is a recurring game of a channel
+type Schedule struct {
+	ID        int64     `json:"id"`
+	ChanID    string    `json:"chanID"`
+	Spec      string    `json:"spec"`   // cron spec, see ParseCron
+	Rounds    int       `json:"rounds"` // 0 uses RoundPerGame
+	CreatedBy string    `json:"createdBy"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Cron is a parsed cron spec, each field is bit set of the allowed values
+type Cron struct {
+	minute, hour, dom, month, dow uint64
+	anyDom, anyDow                bool
+}
+
+type cronField struct {
+	name     string
+	min, max int
+}
+
+var cronFields = []cronField{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 7}, // 0 and 7 are sunday
+}
+
+// ParseCron parses 5 fields cron spec "minute hour day-of-month month day-of-week". A field is "*",
+// a number, a range "1-5", a step "*/15" or "0-30/10" or a comma separated list of those.
+// As in cron, when both day of month and day of week are set the day matches either of them
+func ParseCron(spec string) (c Cron, err error) {
+	fields := strings.Fields(spec)
+	if len(fields) != len(cronFields) {
+		return c, fmt.Errorf("cron spec must have %d fields: minute hour day-of-month month day-of-week", len(cronFields))
+	}
+	values := make([]uint64, len(fields))
+	for i, f := range fields {
+		if values[i], err = parseCronField(f, cronFields[i]); err != nil {
+			return c, err
+		}
+	}
+	c = Cron{minute: values[0], hour: values[1], dom: values[2], month: values[3], dow: values[4]}
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+	c.anyDom, c.anyDow = fields[2] == "*", fields[4] == "*"
+
+	return c, nil
+}
+
+func parseCronField(s string, f cronField) (bits uint64, err error) {
+	for _, part := range strings.Split(s, ",") {
+		rng, step := part, 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			rng = part[:i]
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid %s step %q", f.name, part)
+			}
+		}
+		lo, hi := f.min, f.max
+		switch {
+		case rng == "*":
+		case strings.Contains(rng, "-"):
+			bounds := strings.SplitN(rng, "-", 2)
+			if lo, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, fmt.Errorf("invalid %s %q", f.name, part)
+			}
+			if hi, err = strconv.Atoi(bounds[1]); err != nil {
+				return 0, fmt.Errorf("invalid %s %q", f.name, part)
+			}
+		default:
+			if lo, err = strconv.Atoi(rng); err != nil {
+				return 0, fmt.Errorf("invalid %s %q", f.name, part)
+			}
+			hi = lo
+		}
+		if lo < f.min || hi > f.max || lo > hi {
+			return 0, fmt.Errorf("%s %q out of range %d-%d", f.name, part, f.min, f.max)
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+
+	return bits, nil
+}
+
+func (c Cron) dayMatch(t time.Time) bool {
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+	if c.anyDom || c.anyDow {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// Next returns the first time after t matching the spec in the location of t, zero if there is none
+func (c Cron) Next(t time.Time) time.Time {
+	loc := t.Location()
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case c.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+		case !c.dayMatch(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+		case c.hour&(1<<uint(t.Hour())) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+		case c.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+
+	return time.Time{}
+}
@@ -0,0 +1,44 @@
+package fam100
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronNext(t *testing.T) {
+	// friday
+	now := time.Date(2016, 11, 25, 19, 40, 30, 0, time.UTC)
+	tests := []struct {
+		spec string
+		next time.Time
+	}{
+		{"0 20 * * 5", time.Date(2016, 11, 25, 20, 0, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2016, 11, 25, 19, 45, 0, 0, time.UTC)},
+		{"0 9 * * 1-5", time.Date(2016, 11, 28, 9, 0, 0, 0, time.UTC)},
+		{"30 8 * * 7", time.Date(2016, 11, 27, 8, 30, 0, 0, time.UTC)},
+		{"0 0 1 * *", time.Date(2016, 12, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 12 29 2 *", time.Date(2020, 2, 29, 12, 0, 0, 0, time.UTC)},
+		{"0 12 1 * 6", time.Date(2016, 11, 26, 12, 0, 0, 0, time.UTC)}, // day of month or day of week
+		{"0,30 10,22 * * *", time.Date(2016, 11, 25, 22, 0, 0, 0, time.UTC)},
+		{"0 12 31 2 *", time.Time{}},
+	}
+	for _, tt := range tests {
+		c, err := ParseCron(tt.spec)
+		if err != nil {
+			t.Errorf("%s: %s", tt.spec, err)
+			continue
+		}
+		if want, got := tt.next, c.Next(now); !want.Equal(got) {
+			t.Errorf("%s: next want %s got %s", tt.spec, want, got)
+		}
+	}
+}
+
+func TestParseCronInvalid(t *testing.T) {
+	specs := []string{"", "0 20 * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* * * 13 *", "* * * * 8", "5-1 * * * *", "*/0 * * * *", "a * * * *"}
+	for _, spec := range specs {
+		if _, err := ParseCron(spec); err == nil {
+			t.Errorf("%q: expecting error", spec)
+		}
+	}
+}
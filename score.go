@@ -11,6 +11,11 @@ type PlayerScore struct {
 
 type Rank []PlayerScore
 
+// PlayerRanking returns ranking of players across all channels
+func PlayerRanking(limit int) (Rank, error) {
+	return DefaultDB.playerRanking(limit)
+}
+
 func (r Rank) Len() int           { return len(r) }
 func (r Rank) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
 func (r Rank) Less(i, j int) bool { return r[i].Score > r[j].Score }
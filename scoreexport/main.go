@@ -4,136 +4,200 @@ import (
 	"encoding/json"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
-	"path"
-	"strings"
+	"path/filepath"
+	"reflect"
 	"time"
 
-	"github.com/garyburd/redigo/redis"
 	"github.com/yulrizka/fam100"
 )
 
 var (
 	outdir       = "scores"
 	reset        = false
+	verify       = false
 	overrideWeek = -1
+	redisPrefix  = "fam100"
 )
 
+// rankingStore is the part of the fam100 db needed to export the scores
+type rankingStore interface {
+	Channels() (channels map[string]string, err error)
+	RankedChannels() (chanIDs []string, err error)
+	ChannelRanking(chanID string, limit int) (ranking fam100.Rank, err error)
+	ResetChannelRanking(chanID string, exported fam100.Rank) error
+}
+
 type scoreFile struct {
 	ChanID      string                 `json:"chanID"`
+	ChanName    string                 `json:"chanName"`
 	LastUpdated string                 `json:"lastUpdated"`
 	Total       fam100.Rank            `json:"total"`
 	Rank        map[string]fam100.Rank `json:"rank"`
 }
 
+// scoreFileName returns archive path of a channel
+func scoreFileName(chanID string) string {
+	return filepath.Join(outdir, fam100.ChannelFileName(chanID)) + ".json"
+}
+
+// write the score file to a temporary file and rename it so readers never see a partial file
 func (sf scoreFile) write() error {
-	fileName := path.Join(outdir, sf.ChanID) + ".json"
-	fileName = strings.Replace(fileName, "-", "!", -1)
-	file, err := os.OpenFile(fileName, os.O_CREATE|os.O_TRUNC|os.O_RDWR, 0600)
+	fileName := scoreFileName(sf.ChanID)
+	file, err := ioutil.TempFile(outdir, ".tmp-score-")
 	if err != nil {
-		log.Fatal(err)
+		return err
+	}
+	defer os.Remove(file.Name()) // no-op after successful rename
+
+	if err := json.NewEncoder(file).Encode(sf); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
 	}
-	enc := json.NewEncoder(file)
-	enc.Encode(sf)
 	if err := file.Close(); err != nil {
-		log.Fatal(err)
+		return err
 	}
 
-	return nil
+	return os.Rename(file.Name(), fileName)
 }
 
 func main() {
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
 	flag.StringVar(&outdir, "outdir", "scores", "output directory results")
 	flag.BoolVar(&reset, "reset", false, "reset current week score")
+	flag.BoolVar(&verify, "verify", false, "dry run, verify archives and report what would be exported without writing or resetting")
 	flag.IntVar(&overrideWeek, "week", -1, "override week")
+	flag.StringVar(&redisPrefix, "prefix", "fam100", "redis key prefix")
+	flag.Parse()
 
 	if outdir == "" {
 		log.Fatal("outdir cannot be empty")
 	}
-	flag.Parse()
 	year, week := time.Now().ISOWeek()
 	if overrideWeek > 0 {
 		week = overrideWeek
 	}
 	currentWeekKey := fmt.Sprintf("%d-%d", year, week)
-	lastUpdated := time.Now().Format(time.RFC3339)
 
-	// get all score from redis
-	conn, err := redis.Dial("tcp", ":6379")
-	if err != nil {
+	fam100.SetRedisPrefix(redisPrefix)
+	if err := fam100.DefaultDB.Init(); err != nil {
+		log.Fatal(err)
+	}
+	if err := os.MkdirAll(outdir, 0744); err != nil {
 		log.Fatal(err)
 	}
 
-	keys, err := redis.ByteSlices(conn.Do("keys", "fam100_chan_rank_*"))
+	exported, failed, err := export(fam100.DefaultDB, currentWeekKey, time.Now())
 	if err != nil {
 		log.Fatal(err)
 	}
+	log.Printf("week %s, exported %d channels, failed %d channels", currentWeekKey, exported, failed)
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
 
-	if err := fam100.DefaultDB.Init(); err != nil {
-		log.Fatal(err)
+// export writes current week ranking of every channel into its archive. Channel is only reset after
+// its archive is written and verified. Failed channels are skipped and counted.
+func export(store rankingStore, weekKey string, now time.Time) (exported, failed int, err error) {
+	chanIDs, err := store.RankedChannels()
+	if err != nil {
+		return 0, 0, err
 	}
-	if err := os.MkdirAll(outdir, 0744); err != nil {
-		log.Fatal(err)
+	names, err := store.Channels()
+	if err != nil {
+		return 0, 0, err
 	}
+	lastUpdated := now.Format(time.RFC3339)
 
-	for _, key := range keys {
-		chanID := strings.TrimPrefix(string(key), "fam100_chan_rank_")
-		if chanID == "" {
-			log.Printf("WARNING empty key, %s skipping\n", key)
+	for _, chanID := range chanIDs {
+		if err := exportChannel(store, chanID, names[chanID], weekKey, lastUpdated); err != nil {
+			log.Printf("ERROR chanID:%s, %s", chanID, err)
+			failed++
 			continue
 		}
+		exported++
+	}
 
-		// read total
-		sf, err := readFile(chanID)
-		if err != nil {
-			log.Printf("ERROR, failed loading file, chanID %s", chanID)
-			continue
-		}
+	return exported, failed, nil
+}
 
-		// subtract total from current week
-		total := sf.Total
-		currentWeek := sf.Rank[currentWeekKey]
-		total = total.Subtract(currentWeek)
+func exportChannel(store rankingStore, chanID, chanName, weekKey, lastUpdated string) error {
+	// read total
+	sf, err := readFile(chanID)
+	if err != nil {
+		return fmt.Errorf("failed loading archive: %s", err)
+	}
+
+	// subtract total from current week
+	total := sf.Total.Subtract(sf.Rank[weekKey])
+
+	// update total from new currentWeek data
+	currentWeek, err := store.ChannelRanking(chanID, 0)
+	if err != nil {
+		return fmt.Errorf("failed loading ranking: %s", err)
+	}
+	total = total.Add(currentWeek)
 
-		// update total from new currentWeek data
-		currentWeek, err = fam100.DefaultDB.ChannelRanking(chanID, 0)
+	if verify {
+		log.Printf("chanID:%s, week %s has %d players, total %d players", chanID, weekKey, len(currentWeek), len(total))
+		return nil
+	}
+
+	// write total & current week
+	sf.ChanID = chanID
+	if chanName != "" {
+		sf.ChanName = chanName
+	}
+	sf.Total = total
+	sf.Rank[weekKey] = currentWeek
+	sf.LastUpdated = lastUpdated
+	if err := sf.write(); err != nil {
+		return fmt.Errorf("failed writing archive: %s", err)
+	}
+
+	// reset current week data if reset flag is true and the archive is written correctly
+	if reset {
+		written, err := readFile(chanID)
 		if err != nil {
-			log.Fatal(err)
+			return fmt.Errorf("failed verifying archive: %s", err)
 		}
-		total = total.Add(currentWeek)
-
-		// write total & current week
-		sf.ChanID = chanID
-		sf.Total = total
-		sf.Rank[currentWeekKey] = currentWeek
-		sf.LastUpdated = lastUpdated
-		if err := sf.write(); err != nil {
-			log.Fatal(err)
+		if !reflect.DeepEqual(written.Rank[weekKey], currentWeek) {
+			return fmt.Errorf("archive week %s does not match the ranking, not resetting", weekKey)
 		}
-
-		// reset current week data if reset flag is true
-		if reset {
-			if _, err := conn.Do("DEL", key); err != nil {
-				log.Panic(err)
-			}
+		if err := store.ResetChannelRanking(chanID, currentWeek); err != nil {
+			return fmt.Errorf("failed resetting ranking: %s", err)
 		}
 	}
+
+	return nil
 }
 
+// readFile reads the archive of a channel, missing archive returns an empty one
 func readFile(chanID string) (scoreFile, error) {
-	fileName := path.Join(outdir, chanID) + ".json"
-	fileName = strings.Replace(fileName, "-", "!", -1)
-	file, err := os.Open(fileName)
+	file, err := os.Open(scoreFileName(chanID))
+	if os.IsNotExist(err) {
+		log.Printf("WARNING: chanID:%s,  %s, creating new file", chanID, err)
+		return scoreFile{ChanID: chanID, Rank: make(map[string]fam100.Rank)}, nil
+	}
 	if err != nil {
-		log.Printf("WARNING: chanID:%s,  %s, crating new file", chanID, err)
-		return scoreFile{Rank: make(map[string]fam100.Rank)}, nil
+		return scoreFile{}, err
 	}
+	defer file.Close()
 
-	dec := json.NewDecoder(file)
 	var sf scoreFile
-	err = dec.Decode(&sf)
+	if err := json.NewDecoder(file).Decode(&sf); err != nil {
+		return scoreFile{}, fmt.Errorf("decoding %s failed: %s", file.Name(), err)
+	}
+	if sf.Rank == nil {
+		sf.Rank = make(map[string]fam100.Rank)
+	}
 
-	return sf, err
+	return sf, nil
 }
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/yulrizka/fam100"
+)
+
+// memoryStore is a local stand-in for the redis ranking
+type memoryStore struct {
+	ranks    map[string]fam100.Rank
+	resetErr error
+}
+
+func (m *memoryStore) Channels() (map[string]string, error) {
+	channels := make(map[string]string)
+	for chanID := range m.ranks {
+		channels[chanID] = "channel " + chanID
+	}
+	return channels, nil
+}
+
+func (m *memoryStore) RankedChannels() ([]string, error) {
+	var chanIDs []string
+	for chanID := range m.ranks {
+		chanIDs = append(chanIDs, chanID)
+	}
+	return chanIDs, nil
+}
+
+func (m *memoryStore) ChannelRanking(chanID string, limit int) (fam100.Rank, error) {
+	return m.ranks[chanID], nil
+}
+
+func (m *memoryStore) ResetChannelRanking(chanID string, exported fam100.Rank) error {
+	if m.resetErr != nil {
+		return m.resetErr
+	}
+	m.ranks[chanID] = m.ranks[chanID].Subtract(exported)
+	return nil
+}
+
+func setup(t *testing.T) func() {
+	dir, err := ioutil.TempDir("", "scoreexport")
+	if err != nil {
+		t.Fatal(err)
+	}
+	oOutdir, oReset, oVerify := outdir, reset, verify
+	outdir = dir
+
+	return func() {
+		outdir, reset, verify = oOutdir, oReset, oVerify
+		os.RemoveAll(dir)
+	}
+}
+
+func TestExport(t *testing.T) {
+	defer setup(t)()
+	reset = true
+
+	store := &memoryStore{ranks: map[string]fam100.Rank{
+		"-100": {{PlayerID: "a", Name: "A", Score: 10, Position: 1}, {PlayerID: "b", Name: "B", Score: 5, Position: 2}},
+	}}
+	now := time.Date(2016, 12, 1, 0, 0, 0, 0, time.UTC)
+	exported, failed, err := export(store, "2016-48", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 1, exported; want != got {
+		t.Errorf("exported want %d got %d", want, got)
+	}
+	if want, got := 0, failed; want != got {
+		t.Errorf("failed want %d got %d", want, got)
+	}
+	if _, err := os.Stat(outdir + "/!100.json"); err != nil {
+		t.Errorf("archive file name: %s", err)
+	}
+
+	sf, err := readFile("-100")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := "channel -100", sf.ChanName; want != got {
+		t.Errorf("chanName want %s got %s", want, got)
+	}
+	if want, got := 2, len(sf.Rank["2016-48"]); want != got {
+		t.Errorf("week rank want %d got %d", want, got)
+	}
+	if want, got := 15, sf.Total[0].Score+sf.Total[1].Score; want != got {
+		t.Errorf("total want %d got %d", want, got)
+	}
+	for _, ps := range store.ranks["-100"] {
+		if ps.Score != 0 {
+			t.Errorf("ranking should be reset, got %v", store.ranks["-100"])
+		}
+	}
+
+	// next week adds up to the total
+	store.ranks["-100"] = fam100.Rank{{PlayerID: "b", Name: "B", Score: 7, Position: 1}}
+	if _, _, err := export(store, "2016-49", now); err != nil {
+		t.Fatal(err)
+	}
+	sf, err = readFile("-100")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 2, len(sf.Rank); want != got {
+		t.Errorf("weeks want %d got %d", want, got)
+	}
+	if want, got := fam100.PlayerID("b"), sf.Total[0].PlayerID; want != got {
+		t.Errorf("total[0] want %s got %s", want, got)
+	}
+	if want, got := 12, sf.Total[0].Score; want != got {
+		t.Errorf("total[0] score want %d got %d", want, got)
+	}
+}
+
+func TestExportVerify(t *testing.T) {
+	defer setup(t)()
+	reset, verify = true, true
+
+	rank := fam100.Rank{{PlayerID: "a", Name: "A", Score: 10, Position: 1}}
+	store := &memoryStore{ranks: map[string]fam100.Rank{"1": rank}}
+	exported, _, err := export(store, "2016-48", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 1, exported; want != got {
+		t.Errorf("exported want %d got %d", want, got)
+	}
+	if _, err := os.Stat(scoreFileName("1")); !os.IsNotExist(err) {
+		t.Errorf("verify should not write archive, got %v", err)
+	}
+	if want, got := 10, store.ranks["1"][0].Score; want != got {
+		t.Errorf("verify should not reset, score want %d got %d", want, got)
+	}
+}
+
+func TestExportCorruptedArchive(t *testing.T) {
+	defer setup(t)()
+	reset = true
+
+	if err := ioutil.WriteFile(scoreFileName("1"), []byte("{corrupted"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	rank := fam100.Rank{{PlayerID: "a", Name: "A", Score: 10, Position: 1}}
+	store := &memoryStore{ranks: map[string]fam100.Rank{"1": rank}, resetErr: errors.New("should not reset")}
+	exported, failed, err := export(store, "2016-48", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 0, exported; want != got {
+		t.Errorf("exported want %d got %d", want, got)
+	}
+	if want, got := 1, failed; want != got {
+		t.Errorf("failed want %d got %d", want, got)
+	}
+
+	// corrupted archive is left untouched
+	b, err := ioutil.ReadFile(scoreFileName("1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := "{corrupted", string(b); want != got {
+		t.Errorf("archive want %q got %q", want, got)
+	}
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/yulrizka/fam100"
+)
+
+var (
+	indir         = "scores"
+	outdir        = "site"
+	nameMaxLength = fam100.DefaultNameMaxLength
+	nameWordsPath = ""
+)
+
+// scoreFile is the channel archive written by scoreexport
+type scoreFile struct {
+	ChanID      string                 `json:"chanID"`
+	ChanName    string                 `json:"chanName"`
+	LastUpdated string                 `json:"lastUpdated"`
+	Total       fam100.Rank            `json:"total"`
+	Rank        map[string]fam100.Rank `json:"rank"`
+}
+
+// channelSummary is an entry of the global index
+type channelSummary struct {
+	ChanID      string `json:"chanID"`
+	ChanName    string `json:"chanName"`
+	Page        string `json:"page"`
+	LastUpdated string `json:"lastUpdated"`
+	Players     int    `json:"players"`
+	Points      int    `json:"points"`
+}
+
+// week is the ranking of a channel in a week
+type week struct {
+	Key    string
+	Rank   fam100.Rank
+	Points int
+}
+
+type channelPage struct {
+	channelSummary
+	Total     fam100.Rank
+	Weeks     []week // newest first
+	Sparkline string // svg polyline points of weekly points, oldest first
+}
+
+func main() {
+	log.SetFlags(log.LstdFlags | log.Lshortfile)
+	flag.StringVar(&indir, "indir", "scores", "directory of scoreexport archives")
+	flag.StringVar(&outdir, "outdir", "site", "output directory of the generated site")
+	flag.IntVar(&nameMaxLength, "nameMaxLength", nameMaxLength, "length of player and channel names, 0 is unlimited")
+	flag.StringVar(&nameWordsPath, "nameWords", "", "file of words, one per line, a name containing one is shown as an alias. empty to disable")
+	flag.Parse()
+
+	if indir == "" || outdir == "" {
+		log.Fatal("indir and outdir cannot be empty")
+	}
+	var words []string
+	if nameWordsPath != "" {
+		var err error
+		if words, err = fam100.LoadNameWords(nameWordsPath); err != nil {
+			log.Fatal(err)
+		}
+	}
+	fam100.DefaultNameSanitizer = fam100.NewNameSanitizer(nameMaxLength, words)
+	n, err := generate(indir, outdir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("generated %d channel pages in %s", n, outdir)
+}
+
+// generate writes channel pages and the global index of all archives in dir
+func generate(dir, out string) (int, error) {
+	if err := os.MkdirAll(out, 0755); err != nil {
+		return 0, err
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return 0, err
+	}
+
+	var index []channelSummary
+	for _, fileName := range files {
+		sf, err := readFile(fileName)
+		if err != nil {
+			log.Printf("ERROR skipping %s, %s", fileName, err)
+			continue
+		}
+		page := newChannelPage(sf)
+		if err := writeChannel(out, page, sf); err != nil {
+			return len(index), err
+		}
+		index = append(index, page.channelSummary)
+	}
+
+	sort.Slice(index, func(i, j int) bool {
+		if index[i].Points != index[j].Points {
+			return index[i].Points > index[j].Points
+		}
+		return index[i].ChanID < index[j].ChanID
+	})
+	if err := writeJSON(filepath.Join(out, "index.json"), index); err != nil {
+		return len(index), err
+	}
+	if err := writeTemplate(filepath.Join(out, "index.html"), indexTemplate, index); err != nil {
+		return len(index), err
+	}
+
+	return len(index), nil
+}
+
+func readFile(fileName string) (scoreFile, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return scoreFile{}, err
+	}
+	defer file.Close()
+
+	var sf scoreFile
+	if err := json.NewDecoder(file).Decode(&sf); err != nil {
+		return scoreFile{}, fmt.Errorf("decoding failed: %s", err)
+	}
+	if sf.ChanID == "" {
+		return scoreFile{}, fmt.Errorf("missing chanID")
+	}
+
+	// archives keep the names from before the sanitizer was configured
+	s := fam100.DefaultNameSanitizer
+	if sf.ChanName != "" {
+		sf.ChanName = s.Channel(sf.ChanID, sf.ChanName)
+	}
+	sf.Total = s.Rank(sf.Total)
+	for key, rank := range sf.Rank {
+		sf.Rank[key] = s.Rank(rank)
+	}
+
+	return sf, nil
+}
+
+func newChannelPage(sf scoreFile) channelPage {
+	name := sf.ChanName
+	if name == "" {
+		name = sf.ChanID
+	}
+	p := channelPage{
+		channelSummary: channelSummary{
+			ChanID:      sf.ChanID,
+			ChanName:    name,
+			Page:        fam100.ChannelFileName(sf.ChanID) + ".html",
+			LastUpdated: sf.LastUpdated,
+			Players:     len(sf.Total),
+			Points:      points(sf.Total),
+		},
+		Total: sf.Total,
+	}
+
+	for key, rank := range sf.Rank {
+		p.Weeks = append(p.Weeks, week{Key: key, Rank: rank, Points: points(rank)})
+	}
+	sort.Slice(p.Weeks, func(i, j int) bool { return weekLess(p.Weeks[j].Key, p.Weeks[i].Key) })
+
+	values := make([]int, len(p.Weeks))
+	for i, w := range p.Weeks {
+		values[len(values)-1-i] = w.Points
+	}
+	p.Sparkline = sparkline(values, 120, 24)
+
+	return p
+}
+
+func points(rank fam100.Rank) (total int) {
+	for _, ps := range rank {
+		total += ps.Score
+	}
+	return total
+}
+
+// weekLess compares week keys in "year-week" format, week is not zero padded
+func weekLess(a, b string) bool {
+	var ay, aw, by, bw int
+	fmt.Sscanf(a, "%d-%d", &ay, &aw)
+	fmt.Sscanf(b, "%d-%d", &by, &bw)
+	if ay != by {
+		return ay < by
+	}
+	return aw < bw
+}
+
+// sparkline returns svg polyline points scaled into width x height
+func sparkline(values []int, width, height int) string {
+	if len(values) == 0 {
+		return ""
+	}
+	max := 0
+	for _, v := range values {
+		if v > max {
+			max = v
+		}
+	}
+	step := 0.0
+	if len(values) > 1 {
+		step = float64(width) / float64(len(values)-1)
+	}
+
+	points := make([]string, len(values))
+	for i, v := range values {
+		y := float64(height)
+		if max > 0 {
+			y = float64(height) - float64(v)*float64(height)/float64(max)
+		}
+		points[i] = fmt.Sprintf("%.1f,%.1f", float64(i)*step, y)
+	}
+
+	return strings.Join(points, " ")
+}
+
+func writeChannel(out string, p channelPage, sf scoreFile) error {
+	base := filepath.Join(out, fam100.ChannelFileName(sf.ChanID))
+	if err := writeJSON(base+".json", sf); err != nil {
+		return err
+	}
+	return writeTemplate(base+".html", channelTemplate, p)
+}
+
+func writeJSON(fileName string, v interface{}) error {
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(v); err != nil {
+		return err
+	}
+	return writeFile(fileName, buf.Bytes())
+}
+
+func writeTemplate(fileName string, t *template.Template, data interface{}) error {
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return err
+	}
+	return writeFile(fileName, buf.Bytes())
+}
+
+// writeFile writes to a temporary file and rename it so the web server never serves a partial file
+func writeFile(fileName string, data []byte) error {
+	file, err := ioutil.TempFile(filepath.Dir(fileName), ".tmp-site-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name()) // no-op after successful rename
+
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(file.Name(), 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(file.Name(), fileName)
+}
+
+const style = `<style>
+body { font-family: sans-serif; max-width: 40em; margin: 1em auto; }
+table { border-collapse: collapse; width: 100%; }
+td, th { padding: 0.2em 0.5em; text-align: left; border-bottom: 1px solid #ddd; }
+.tabs a { margin-right: 0.5em; }
+.week { display: none; }
+.week.active { display: block; }
+polyline { fill: none; stroke: #36c; stroke-width: 1.5; }
+</style>`
+
+var indexTemplate = template.Must(template.New("index").Funcs(template.FuncMap{
+	"inc": func(i int) int { return i + 1 },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Fam100 Score</title>` + style + `</head>
+<body>
+<h1>Fam100 Score</h1>
+<table>
+<tr><th>#</th><th>Channel</th><th>Pemain</th><th>Score</th><th>Update</th></tr>
+{{range $i, $c := .}}<tr><td>{{$i | inc}}</td><td><a href="{{$c.Page}}">{{$c.ChanName}}</a></td><td>{{$c.Players}}</td><td>{{$c.Points}}</td><td>{{$c.LastUpdated}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+var channelTemplate = template.Must(template.New("channel").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>{{.ChanName}} - Fam100 Score</title>` + style + `</head>
+<body>
+<p><a href="index.html">&laquo; semua channel</a></p>
+<h1>{{.ChanName}}</h1>
+<p>update: {{.LastUpdated}}
+{{if .Sparkline}}<svg width="120" height="24" viewBox="-1 -1 122 26"><polyline points="{{.Sparkline}}"/></svg>{{end}}</p>
+<div class="tabs">
+<a href="#total" data-tab="total">Total</a>
+{{range .Weeks}}<a href="#w{{.Key}}" data-tab="w{{.Key}}">{{.Key}}</a>
+{{end}}</div>
+<div id="total" class="week active">{{template "rank" .Total}}</div>
+{{range .Weeks}}<div id="w{{.Key}}" class="week">{{template "rank" .Rank}}</div>
+{{end}}
+<script>
+function show() {
+	var id = location.hash.substring(1) || "total";
+	var weeks = document.getElementsByClassName("week");
+	for (var i = 0; i < weeks.length; i++) {
+		weeks[i].className = weeks[i].id === id ? "week active" : "week";
+	}
+}
+window.onhashchange = show;
+show();
+</script>
+</body>
+</html>
+{{define "rank"}}<table>
+<tr><th>#</th><th>Nama</th><th>Score</th></tr>
+{{range .}}<tr><td>{{.Position}}</td><td>{{.Name}}</td><td>{{.Score}}</td></tr>
+{{end}}</table>{{end}}
+`))
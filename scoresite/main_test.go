@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/yulrizka/fam100"
+)
+
+func TestGenerate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "scoresite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	in, out := filepath.Join(dir, "scores"), filepath.Join(dir, "site")
+	if err := os.MkdirAll(in, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	archives := []scoreFile{
+		{
+			ChanID:   "-100",
+			ChanName: "Group <b>",
+			Total:    fam100.Rank{{PlayerID: "a", Name: "<script>", Score: 20, Position: 1}},
+			Rank: map[string]fam100.Rank{
+				"2016-9":  {{PlayerID: "a", Name: "A", Score: 5, Position: 1}},
+				"2016-10": {{PlayerID: "a", Name: "A", Score: 15, Position: 1}},
+			},
+		},
+		{
+			ChanID: "200",
+			Total:  fam100.Rank{{PlayerID: "b", Name: "B", Score: 40, Position: 1}},
+			Rank:   map[string]fam100.Rank{"2016-10": {{PlayerID: "b", Name: "B", Score: 40, Position: 1}}},
+		},
+	}
+	for _, sf := range archives {
+		b, _ := json.Marshal(sf)
+		if err := ioutil.WriteFile(filepath.Join(in, fam100.ChannelFileName(sf.ChanID)+".json"), b, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(in, "broken.json"), []byte("{"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := generate(in, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 2, n; want != got {
+		t.Errorf("generated want %d got %d", want, got)
+	}
+
+	// index sorted by points
+	var index []channelSummary
+	b, err := ioutil.ReadFile(filepath.Join(out, "index.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(b, &index); err != nil {
+		t.Fatal(err)
+	}
+	if want, got := "200", index[0].ChanID; want != got {
+		t.Errorf("index[0] want %s got %s", want, got)
+	}
+	if want, got := "200", index[0].ChanName; want != got {
+		t.Errorf("channel without name should use id, want %s got %s", want, got)
+	}
+	if want, got := "!100.html", index[1].Page; want != got {
+		t.Errorf("page want %s got %s", want, got)
+	}
+
+	b, err = ioutil.ReadFile(filepath.Join(out, "!100.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	page := string(b)
+	if strings.Contains(page, "<script><") || strings.Contains(page, "Group <b>") {
+		t.Errorf("names should be escaped")
+	}
+	if i, j := strings.Index(page, `href="#w2016-10"`), strings.Index(page, `href="#w2016-9"`); i < 0 || j < 0 || i > j {
+		t.Errorf("newest week tab should be first")
+	}
+	if !strings.Contains(page, `points="0.0,16.0 120.0,0.0"`) {
+		t.Errorf("sparkline not found in page")
+	}
+	if _, err := os.Stat(filepath.Join(out, "!100.json")); err != nil {
+		t.Error(err)
+	}
+	if _, err := os.Stat(filepath.Join(out, "index.html")); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,225 @@
+package fam100
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+)
+
+// simAnswer is a scripted answer, index is the answer index of the round question
+type simAnswer struct {
+	player PlayerID
+	index  int
+}
+
+// simRound returns the scripted answers of a round question, round not answered completely ends with timeout
+type simRound func(q Question) []simAnswer
+
+// simResult is what the scripted players observe from a game
+type simResult struct {
+	expected   map[PlayerID]int // score calculated from the script
+	final      Rank
+	ticks      [][]time.Duration // time left of tick messages per round
+	timeouts   []bool
+	unrevealed []bool // timeout round shows unanswered answers
+	state      State
+}
+
+// simulate drives a game with a fake clock and scripted players, it never waits for the wall clock
+func simulate(chanID string, seed int64, script []simRound) (res simResult, err error) {
+	clock := NewFakeClock(time.Date(2016, 12, 1, 20, 0, 0, 0, time.UTC))
+	in, out := make(chan Message), make(chan Message, 100)
+	g, err := NewGame(chanID, "sim "+chanID, in, out, WithClock(clock), WithRand(rand.New(rand.NewSource(seed))))
+	if err != nil {
+		return res, err
+	}
+
+	timeout := time.After(10 * time.Second) // wall clock guard against deadlock
+	next := func() (Message, error) {
+		select {
+		case msg := <-out:
+			return msg, nil
+		case <-timeout:
+			return nil, fmt.Errorf("chanID:%s timeout waiting message", chanID)
+		}
+	}
+	// nextState skips message until state message of the given state
+	nextState := func(state State) error {
+		for {
+			msg, err := next()
+			if err != nil {
+				return err
+			}
+			if m, ok := msg.(StateMessage); ok && m.State == state {
+				return nil
+			}
+		}
+	}
+
+	res.expected = make(map[PlayerID]int)
+	g.Start()
+	for i, sr := range script {
+		if err := nextState(RoundStarted); err != nil {
+			return res, err
+		}
+		q := g.CurrentQuestion()
+		answered := make(map[int]bool)
+		for _, a := range sr(q) {
+			in <- TextMessage{ChanID: chanID, Player: Player{ID: a.player, Name: string(a.player)}, Text: q.Answers[a.index].Text[0], ReceivedAt: clock.Now()}
+			if !answered[a.index] {
+				answered[a.index] = true
+				res.expected[a.player] += q.Answers[a.index].Score
+			}
+		}
+
+		var ticks []time.Duration
+		timedOut := len(answered) < len(q.Answers)
+		if !timedOut {
+			if err := nextState(RoundFinished); err != nil {
+				return res, err
+			}
+		} else {
+			// advance one tick at a time until the round times out
+			timeLeft, timeoutSeen := RoundDuration, false
+			for !timeoutSeen {
+				if timeLeft > 0 {
+					clock.Advance(tickDuration)
+				}
+				msg, err := next()
+				for ; err == nil; msg, err = next() {
+					if tick, ok := msg.(TickMessage); ok {
+						ticks = append(ticks, tick.TimeLeft)
+						timeLeft = tick.TimeLeft
+						break
+					}
+					if m, ok := msg.(StateMessage); ok && m.State == RoundTimeout {
+						timeoutSeen = true
+						break
+					}
+				}
+				if err != nil {
+					return res, err
+				}
+			}
+			msg, err := next()
+			if err != nil {
+				return res, err
+			}
+			qna, ok := msg.(QNAMessage)
+			res.unrevealed = append(res.unrevealed, ok && qna.ShowUnanswered)
+		}
+		res.ticks = append(res.ticks, ticks)
+		res.timeouts = append(res.timeouts, timedOut)
+
+		for {
+			msg, err := next()
+			if err != nil {
+				return res, err
+			}
+			if m, ok := msg.(RankMessage); ok {
+				if m.Round != i+1 {
+					return res, fmt.Errorf("rank round want %d got %d", i+1, m.Round)
+				}
+				if m.Final {
+					res.final = m.Rank
+				}
+				break
+			}
+		}
+	}
+	if err := nextState(Finished); err != nil {
+		return res, err
+	}
+	res.state = g.Status().State
+
+	return res, nil
+}
+
+func TestSimulation(t *testing.T) {
+	oDB, oRoundPerGame, oDelay := DefaultDB, RoundPerGame, DelayBetweenRound
+	defer func() {
+		DefaultDB, RoundPerGame, DelayBetweenRound = oDB, oRoundPerGame, oDelay
+	}()
+	DefaultDB, RoundPerGame, DelayBetweenRound = &MemoryDB{}, 3, 0
+
+	nGames := 200
+	started := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < nGames; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			a, b := PlayerID(fmt.Sprintf("a%d", i)), PlayerID(fmt.Sprintf("b%d", i))
+			script := []simRound{
+				// answered completely, a answers the even answers
+				func(q Question) (answers []simAnswer) {
+					for j := range q.Answers {
+						if j%2 == 0 {
+							answers = append(answers, simAnswer{a, j})
+						} else {
+							answers = append(answers, simAnswer{b, j})
+						}
+					}
+					return answers
+				},
+				// timeout after 2 answers
+				func(q Question) []simAnswer {
+					return []simAnswer{{b, 0}, {a, 1}}
+				},
+				// b's duplicate answer doesn't count, b answers the rest
+				func(q Question) []simAnswer {
+					answers := []simAnswer{{a, 0}, {b, 0}}
+					for j := 1; j < len(q.Answers); j++ {
+						answers = append(answers, simAnswer{b, j})
+					}
+					return answers
+				},
+			}
+			chanID := fmt.Sprintf("sim%d", i)
+			res, err := simulate(chanID, int64(i), script)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			if want, got := Finished, res.state; want != got {
+				t.Errorf("%s state want %s got %s", chanID, want, got)
+			}
+			if want, got := len(res.expected), len(res.final); want != got {
+				t.Errorf("%s final rank want %d players got %d", chanID, want, got)
+			}
+			for _, ps := range res.final {
+				if want, got := res.expected[ps.PlayerID], ps.Score; want != got {
+					t.Errorf("%s player %s score want %d got %d", chanID, ps.PlayerID, want, got)
+				}
+			}
+			for round, timedOut := range res.timeouts {
+				if !timedOut {
+					if len(res.ticks[round]) != 0 {
+						t.Errorf("%s round %d should not tick, got %v", chanID, round+1, res.ticks[round])
+					}
+					continue
+				}
+				// ticks every 10s counting down, the last tick may race with the timeout
+				ticks := res.ticks[round]
+				if n := int(RoundDuration / tickDuration); len(ticks) < n-1 || len(ticks) > n {
+					t.Errorf("%s round %d ticks want %d got %v", chanID, round+1, n, ticks)
+				}
+				for j, timeLeft := range ticks {
+					if want, got := RoundDuration-time.Duration(j+1)*tickDuration, timeLeft; want != got {
+						t.Errorf("%s round %d tick %d time left want %s got %s", chanID, round+1, j, want, got)
+					}
+				}
+			}
+			for _, shown := range res.unrevealed {
+				if !shown {
+					t.Errorf("%s timeout should reveal unanswered answers", chanID)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	t.Logf("simulated %d games of %d rounds in %s", nGames, RoundPerGame, time.Since(started))
+}
@@ -0,0 +1,225 @@
+package fam100
+
+import (
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/uber-go/zap"
+)
+
+// player statistic keys
+const (
+	pStatsGamePlayed     = "gamePlayed"
+	pStatsGameWon        = "gameWon"
+	pStatsRoundPlayed    = "roundPlayed"
+	pStatsAnswerCorrect  = "answerCorrect"
+	pStatsAnswerTop      = "answerTop"
+	pStatsAnswerDuration = "answerDurationMs"
+	pStatsBestScore      = "bestScore"
+)
+
+// pStatsSumKeys are player stats that are summed when merging players, bestScore takes the maximum
+var pStatsSumKeys = []string{pStatsGamePlayed, pStatsGameWon, pStatsRoundPlayed, pStatsAnswerCorrect, pStatsAnswerTop, pStatsAnswerDuration}
+
+// channel statistic keys, also recorded globally
+const (
+	cStatsGameStarted   = "gameStarted"
+	cStatsGameCancelled = "gameCancelled"
+	cStatsRoundFinished = "roundFinished"
+	cStatsRoundTimeout  = "roundTimeout"
+)
+
+// cStatsKeys are all channel counter stats, "played" is the total round played
+var cStatsKeys = []string{"played", cStatsGameStarted, cStatsGameCancelled, cStatsRoundFinished, cStatsRoundTimeout}
+
+// ChannelStats is the activity statistic of a channel or of all channels
+type ChannelStats struct {
+	ChanID        string // empty for global stats
+	GameStarted   int
+	GameCancelled int // cancelled because of not enough players
+	RoundFinished int // all answers found
+	RoundTimeout  int
+	Players       int // approximate unique players
+	ActiveHour    int // hour of the day with the most game started, -1 if unknown
+}
+
+// GetChannelStats returns activity statistic of a channel
+func GetChannelStats(chanID string) (ChannelStats, error) {
+	return getChannelStats(chanID, func(key string) (interface{}, error) {
+		return DefaultDB.channelStats(chanID, key)
+	})
+}
+
+// GetGlobalStats returns activity statistic of all channels
+func GetGlobalStats() (ChannelStats, error) {
+	return getChannelStats("", DefaultDB.stats)
+}
+
+func getChannelStats(chanID string, get func(key string) (interface{}, error)) (s ChannelStats, err error) {
+	s.ChanID = chanID
+	fields := []struct {
+		key string
+		val *int
+	}{
+		{cStatsGameStarted, &s.GameStarted},
+		{cStatsGameCancelled, &s.GameCancelled},
+		{cStatsRoundFinished, &s.RoundFinished},
+		{cStatsRoundTimeout, &s.RoundTimeout},
+	}
+	for _, f := range fields {
+		if *f.val, err = statsInt(get(f.key)); err != nil {
+			return s, err
+		}
+	}
+
+	if s.Players, err = DefaultDB.channelPlayerCount(chanID); err != nil {
+		return s, err
+	}
+
+	hours, err := DefaultDB.hourStats(chanID)
+	if err != nil {
+		return s, err
+	}
+	s.ActiveHour = -1
+	max := 0
+	for hour, n := range hours {
+		if n > max || (n == max && hour < s.ActiveHour) {
+			max, s.ActiveHour = n, hour
+		}
+	}
+
+	return s, nil
+}
+
+// PlayerStats is the profile of a player accumulated across all channels
+type PlayerStats struct {
+	PlayerScore
+	GamePlayed    int
+	GameWon       int
+	RoundPlayed   int
+	AnswerCorrect int
+	AnswerTop     int // number of #1 answer found
+	BestScore     int // highest score in a single game
+	AnswerTime    time.Duration
+	FormerNames   []string // previous names, most recent first
+}
+
+// GetPlayerStats returns the global score and statistic of a player
+func GetPlayerStats(playerID PlayerID) (s PlayerStats, err error) {
+	s.PlayerScore, err = DefaultDB.playerScore(playerID)
+	if err != nil && err != redis.ErrNil {
+		return s, err
+	}
+	s.PlayerID = playerID
+
+	names, err := DefaultDB.playerNames(playerID)
+	if err != nil {
+		return s, err
+	}
+	for _, name := range names {
+		if name != s.Name {
+			s.FormerNames = append(s.FormerNames, name)
+		}
+	}
+
+	fields := []struct {
+		key string
+		val *int
+	}{
+		{pStatsGamePlayed, &s.GamePlayed},
+		{pStatsGameWon, &s.GameWon},
+		{pStatsRoundPlayed, &s.RoundPlayed},
+		{pStatsAnswerCorrect, &s.AnswerCorrect},
+		{pStatsAnswerTop, &s.AnswerTop},
+		{pStatsBestScore, &s.BestScore},
+	}
+	for _, f := range fields {
+		if *f.val, err = statsInt(DefaultDB.playerStats(string(playerID), f.key)); err != nil {
+			return s, err
+		}
+	}
+
+	duration, err := statsInt(DefaultDB.playerStats(string(playerID), pStatsAnswerDuration))
+	if err != nil {
+		return s, err
+	}
+	if s.AnswerCorrect > 0 {
+		s.AnswerTime = time.Duration(duration/s.AnswerCorrect) * time.Millisecond
+	}
+
+	return s, nil
+}
+
+// statsInt converts stats reply into int, missing stats is 0
+func statsInt(reply interface{}, err error) (int, error) {
+	if reply == nil && err == nil {
+		return 0, nil
+	}
+	v, err := redis.Int(reply, err)
+	if err == redis.ErrNil {
+		return 0, nil
+	}
+
+	return v, err
+}
+
+// recordAnswerStats records statistic of a correct answer
+func recordAnswerStats(playerID PlayerID, answerIndex int, elapsed time.Duration) {
+	if err := DefaultDB.incPlayerStats(playerID, pStatsAnswerCorrect); err != nil {
+		log.Error("failed to record answer stats", zap.String("playerID", string(playerID)), zap.Error(err))
+	}
+	if answerIndex == 0 {
+		if err := DefaultDB.incPlayerStats(playerID, pStatsAnswerTop); err != nil {
+			log.Error("failed to record answer stats", zap.String("playerID", string(playerID)), zap.Error(err))
+		}
+	}
+	if elapsed < 0 {
+		elapsed = 0
+	}
+	if err := DefaultDB.incPlayerStatsBy(playerID, pStatsAnswerDuration, int64(elapsed/time.Millisecond)); err != nil {
+		log.Error("failed to record answer stats", zap.String("playerID", string(playerID)), zap.Error(err))
+	}
+}
+
+// recordRoundStats records statistic of every player that took part in a round
+func recordRoundStats(chanID string, players map[PlayerID]bool) {
+	playerIDs := make([]PlayerID, 0, len(players))
+	for playerID := range players {
+		if err := DefaultDB.incPlayerStats(playerID, pStatsRoundPlayed); err != nil {
+			log.Error("failed to record round stats", zap.String("playerID", string(playerID)), zap.Error(err))
+		}
+		playerIDs = append(playerIDs, playerID)
+	}
+	if err := DefaultDB.addChannelPlayers(chanID, playerIDs...); err != nil {
+		log.Error("failed to record round stats", zap.String("chanID", chanID), zap.Error(err))
+	}
+}
+
+// recordChannelStats increments statistic of a channel and the global one
+func recordChannelStats(chanID, key string) {
+	if err := DefaultDB.incChannelStats(chanID, key); err != nil {
+		log.Error("failed to record channel stats", zap.String("chanID", chanID), zap.String("key", key), zap.Error(err))
+	}
+	if err := DefaultDB.incStats(key); err != nil {
+		log.Error("failed to record global stats", zap.String("key", key), zap.Error(err))
+	}
+}
+
+// recordGameStats records statistic of every player in a finished game
+func recordGameStats(players map[PlayerID]Player, rank Rank) {
+	for playerID := range players {
+		if err := DefaultDB.incPlayerStats(playerID, pStatsGamePlayed); err != nil {
+			log.Error("failed to record game stats", zap.String("playerID", string(playerID)), zap.Error(err))
+		}
+	}
+	for _, ps := range rank {
+		if err := DefaultDB.maxPlayerStats(ps.PlayerID, pStatsBestScore, int64(ps.Score)); err != nil {
+			log.Error("failed to record game stats", zap.String("playerID", string(ps.PlayerID)), zap.Error(err))
+		}
+	}
+	if len(rank) > 0 && rank[0].Score > 0 {
+		if err := DefaultDB.incPlayerStats(rank[0].PlayerID, pStatsGameWon); err != nil {
+			log.Error("failed to record game stats", zap.String("playerID", string(rank[0].PlayerID)), zap.Error(err))
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package fam100
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPlayerStats(t *testing.T) {
+	var pid PlayerID = "stats1"
+	players := map[PlayerID]Player{
+		pid:      {ID: pid, Name: "Stats 1"},
+		"stats2": {ID: "stats2", Name: "Stats 2"},
+	}
+
+	recordAnswerStats(pid, 0, 2*time.Second)
+	recordAnswerStats(pid, 3, 4*time.Second)
+	recordRoundStats("statsChan", map[PlayerID]bool{pid: true, "stats2": true})
+	recordGameStats(players, Rank{{PlayerID: pid, Score: 30}, {PlayerID: "stats2", Score: 10}})
+	recordGameStats(players, Rank{{PlayerID: "stats2", Score: 20}, {PlayerID: pid, Score: 15}})
+
+	s, err := GetPlayerStats(pid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := pid, s.PlayerID; want != got {
+		t.Errorf("playerID, want %s got %s", want, got)
+	}
+	if want, got := 2, s.GamePlayed; want != got {
+		t.Errorf("gamePlayed, want %d got %d", want, got)
+	}
+	if want, got := 1, s.GameWon; want != got {
+		t.Errorf("gameWon, want %d got %d", want, got)
+	}
+	if want, got := 1, s.RoundPlayed; want != got {
+		t.Errorf("roundPlayed, want %d got %d", want, got)
+	}
+	if want, got := 2, s.AnswerCorrect; want != got {
+		t.Errorf("answerCorrect, want %d got %d", want, got)
+	}
+	if want, got := 1, s.AnswerTop; want != got {
+		t.Errorf("answerTop, want %d got %d", want, got)
+	}
+	if want, got := 30, s.BestScore; want != got {
+		t.Errorf("bestScore, want %d got %d", want, got)
+	}
+	if want, got := 3*time.Second, s.AnswerTime; want != got {
+		t.Errorf("answerTime, want %s got %s", want, got)
+	}
+
+	// player without any stats
+	s, err = GetPlayerStats("unknown")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 0, s.GamePlayed; want != got {
+		t.Errorf("gamePlayed, want %d got %d", want, got)
+	}
+}
+
+func TestChannelStats(t *testing.T) {
+	chanID := "statsChan2"
+	recordChannelStats(chanID, cStatsGameStarted)
+	recordChannelStats(chanID, cStatsGameStarted)
+	recordChannelStats(chanID, cStatsGameCancelled)
+	recordChannelStats(chanID, cStatsRoundFinished)
+	recordChannelStats(chanID, cStatsRoundTimeout)
+	recordChannelStats(chanID, cStatsRoundTimeout)
+	recordRoundStats(chanID, map[PlayerID]bool{"c1": true, "c2": true})
+	recordRoundStats(chanID, map[PlayerID]bool{"c2": true, "c3": true})
+	for _, hour := range []int{20, 21, 21, 3} {
+		if err := DefaultDB.incHourStats(chanID, hour); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s, err := GetChannelStats(chanID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 2, s.GameStarted; want != got {
+		t.Errorf("gameStarted, want %d got %d", want, got)
+	}
+	if want, got := 1, s.GameCancelled; want != got {
+		t.Errorf("gameCancelled, want %d got %d", want, got)
+	}
+	if want, got := 1, s.RoundFinished; want != got {
+		t.Errorf("roundFinished, want %d got %d", want, got)
+	}
+	if want, got := 2, s.RoundTimeout; want != got {
+		t.Errorf("roundTimeout, want %d got %d", want, got)
+	}
+	if want, got := 3, s.Players; want != got {
+		t.Errorf("players, want %d got %d", want, got)
+	}
+	if want, got := 21, s.ActiveHour; want != got {
+		t.Errorf("activeHour, want %d got %d", want, got)
+	}
+
+	// global stats includes the channel stats
+	g, err := GetGlobalStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.GameStarted < s.GameStarted {
+		t.Errorf("global gameStarted, want >= %d got %d", s.GameStarted, g.GameStarted)
+	}
+	if g.Players < s.Players {
+		t.Errorf("global players, want >= %d got %d", s.Players, g.Players)
+	}
+
+	// channel without any stats
+	s, err = GetChannelStats("statsUnknown")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := -1, s.ActiveHour; want != got {
+		t.Errorf("activeHour, want %d got %d", want, got)
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/patrickmn/go-cache"
+	"github.com/uber-go/zap"
+	"github.com/yulrizka/bot"
+	"github.com/yulrizka/fam100"
+)
+
+// roleCache caches the admin role of the users sending private messages, a role changed on other instance
+// takes effect once the entry expires
+var roleCache = cache.New(time.Minute, 10*time.Minute)
+
+// adminRole returns the role of the user, the admin from the -admin flag is always an operator
+func adminRole(userID string) fam100.Role {
+	if adminID != "" && userID == adminID {
+		return fam100.RoleOperator
+	}
+	if role, ok := roleCache.Get(userID); ok {
+		return role.(fam100.Role)
+	}
+	role, err := fam100.DefaultDB.AdminRole(userID)
+	if err != nil {
+		log.Error("loading admin role failed", zap.String("userID", userID), zap.Error(err))
+		return ""
+	}
+	roleCache.Set(userID, role, cache.DefaultExpiration)
+
+	return role
+}
+
+// hasRole returns true if the user is an admin with the permission of the role
+func hasRole(userID string, role fam100.Role) bool {
+	return adminRole(userID).Includes(role)
+}
+
+// cmdAdmin handles "/admin list", "/admin grant [userID] [role]" and "/admin revoke [userID]" for operators
+func (b *fam100Bot) cmdAdmin(msg *bot.Message) bool {
+	defer cmdAdminTimer.UpdateSince(time.Now())
+
+	reply := func(text string) {
+		b.out <- bot.Message{Chat: bot.Chat{ID: msg.Chat.ID}, Text: text, Format: bot.HTML}
+	}
+	usage := fmt.Sprintf("usage:\n/admin list\n/admin grant [userID] [role]\n/admin revoke [userID]\nroles: %v", fam100.Roles)
+
+	fields := strings.Fields(msg.Text)
+	switch {
+	case len(fields) == 2 && fields[1] == "list":
+		admins, err := fam100.DefaultDB.Admins()
+		if err != nil {
+			reply(escape("admins failed. " + err.Error()))
+			return true
+		}
+		var ids []string
+		for id := range admins {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+
+		var text bytes.Buffer
+		if adminID != "" {
+			fmt.Fprintf(&text, "%s <b>%s</b> (-admin flag)\n", escape(adminID), fam100.RoleOperator)
+		}
+		for _, id := range ids {
+			fmt.Fprintf(&text, "%s <b>%s</b>\n", escape(id), escape(string(admins[id])))
+		}
+		if text.Len() == 0 {
+			text.WriteString("no admin")
+		}
+		reply(text.String())
+
+	case len(fields) == 4 && fields[1] == "grant":
+		role, err := fam100.ParseRole(fields[3])
+		if err != nil {
+			reply(escape(err.Error()))
+			return true
+		}
+		if err := fam100.DefaultDB.SetAdmin(fields[2], role); err != nil {
+			log.Error("granting admin role failed", zap.String("userID", fields[2]), zap.Error(err))
+			reply(escape("grant failed. " + err.Error()))
+			return true
+		}
+		roleCache.Delete(fields[2])
+		commandAdminCount.Inc(1)
+		log.Info("admin role granted", zap.String("userID", fields[2]), zap.String("role", string(role)), zap.String("by", msg.From.ID))
+		reply(fmt.Sprintf("%s is now <b>%s</b>", escape(fields[2]), role))
+
+	case len(fields) == 3 && fields[1] == "revoke":
+		if fields[2] == adminID {
+			reply("admin from the -admin flag can not be revoked")
+			return true
+		}
+		removed, err := fam100.DefaultDB.RemoveAdmin(fields[2])
+		if err != nil {
+			log.Error("revoking admin role failed", zap.String("userID", fields[2]), zap.Error(err))
+			reply(escape("revoke failed. " + err.Error()))
+			return true
+		}
+		roleCache.Delete(fields[2])
+		if !removed {
+			reply(fmt.Sprintf("%s is not an admin", escape(fields[2])))
+			return true
+		}
+		commandAdminCount.Inc(1)
+		log.Info("admin role revoked", zap.String("userID", fields[2]), zap.String("by", msg.From.ID))
+		reply(fmt.Sprintf("%s is not an admin anymore", escape(fields[2])))
+
+	default:
+		reply(escape(usage))
+	}
+
+	return true
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/uber-go/zap"
+	"github.com/yulrizka/bot"
+	"github.com/yulrizka/fam100"
+)
+
+func TestAdmin(t *testing.T) {
+	oDB, oAdminID := fam100.DefaultDB, adminID
+	defer func() {
+		fam100.DefaultDB, adminID = oDB, oAdminID
+		roleCache.Flush()
+	}()
+	fam100.DefaultDB, adminID = &fam100.MemoryDB{}, "admin"
+	roleCache.Flush()
+	log = logger{zap.New(zap.NewJSONEncoder(), zap.FatalLevel+1)}
+	fam100.SetLogger(log)
+
+	b := &fam100Bot{}
+	out := make(chan bot.Message, 100)
+	in, err := b.Init(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.start()
+	defer b.stop()
+
+	send := func(userID, text string) {
+		in <- &bot.Message{From: bot.User{ID: userID}, Chat: bot.Chat{ID: userID, Type: bot.Private}, Text: text, Date: time.Now()}
+	}
+	// reply returns the next message to the chat, commands without permission are ignored
+	reply := func(chatID string) string {
+		timeout := time.After(5 * time.Second)
+		for {
+			select {
+			case msg := <-out:
+				if msg.Chat.ID == chatID {
+					return msg.Text
+				}
+			case <-timeout:
+				t.Fatalf("timeout waiting message to %s", chatID)
+			}
+		}
+	}
+	expect := func(chatID, want string) {
+		if got := reply(chatID); !strings.Contains(got, want) {
+			t.Errorf("reply want %q got %q", want, got)
+		}
+	}
+
+	send("admin", "/admin grant editor editor")
+	expect("admin", "editor is now <b>editor</b>")
+	send("admin", "/admin grant mod moderator")
+	expect("admin", "mod is now <b>moderator</b>")
+	send("admin", "/admin grant mod root")
+	expect("admin", "unknown role")
+	send("admin", "/admin list")
+	expect("admin", "mod <b>moderator</b>")
+
+	// editor changes content only
+	send("editor", "/admin grant editor operator")
+	send("editor", "/broadcast hello")
+	send("editor", "/config set -1 disabled maintenance")
+	expect("editor", "needs the <b>moderator</b> role")
+	send("editor", "/config set -1 motd selamat datang")
+	expect("editor", "motd of -1 set to selamat datang")
+	if motd, _ := fam100.DefaultDB.ChannelConfig("-1", "motd", ""); motd != "selamat datang" {
+		t.Errorf("motd want %q got %q", "selamat datang", motd)
+	}
+	if role, _ := fam100.DefaultDB.AdminRole("editor"); role != fam100.RoleEditor {
+		t.Errorf("editor granted itself %s", role)
+	}
+	send("editor", "/config set -1 questionLimit many")
+	expect("editor", "questionLimit must be a positive number")
+	if _, ok := roleCache.Get("editor"); !ok {
+		t.Errorf("editor role should be cached")
+	}
+
+	send("mod", "/config set -1 disabled maintenance")
+	expect("mod", "disabled of -1 set to maintenance")
+	send("mod", "/config unset -1 disabled")
+	expect("mod", "disabled of -1 reset to the default")
+	if disabled, _ := fam100.DefaultDB.ChannelConfig("-1", "disabled", ""); disabled != "" {
+		t.Errorf("disabled want empty got %q", disabled)
+	}
+	send("mod", "/config set -1 color red")
+	expect("mod", "unknown config key")
+
+	send("admin", "/admin revoke mod")
+	expect("admin", "mod is not an admin anymore")
+	send("admin", "/admin revoke admin")
+	expect("admin", "can not be revoked")
+	send("mod", "/config set -1 disabled maintenance")
+	send("admin", "/admin revoke mod")
+	expect("admin", "mod is not an admin")
+
+	send("admin", "/merge p1 p1")
+	expect("admin", "usage")
+	send("admin", "/merge ghost p1")
+	expect("admin", "unknown player ghost")
+	select {
+	case msg := <-out:
+		t.Errorf("unexpected message to %s: %s", msg.Chat.ID, msg.Text)
+	default:
+	}
+}
+
+type slowMemberClient struct {
+	release chan struct{}
+}
+
+func (c slowMemberClient) Member(chatID, userID string) (*bot.TChatMember, error) {
+	<-c.release
+	return &bot.TChatMember{Status: "administrator"}, nil
+}
+
+func TestChatAdminLookupDoesNotBlockShard(t *testing.T) {
+	oDB, oMinQuorum := fam100.DefaultDB, minQuorum
+	defer func() {
+		fam100.DefaultDB, minQuorum = oDB, oMinQuorum
+		chatAdminCache.Flush()
+	}()
+	fam100.DefaultDB, minQuorum = &fam100.MemoryDB{}, 3
+	chatAdminCache.Flush()
+	log = logger{zap.New(zap.NewJSONEncoder(), zap.FatalLevel+1)}
+	fam100.SetLogger(log)
+
+	client := slowMemberClient{release: make(chan struct{})}
+	b := &fam100Bot{client: client}
+	out := make(chan bot.Message, 100)
+	in, err := b.Init(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.start()
+	defer b.stop()
+
+	chat := bot.Chat{ID: "chatAdminChan", Type: bot.Group}
+	in <- &bot.Message{From: bot.User{ID: "owner"}, Chat: chat, Text: "/stats", Date: time.Now()}
+	// the shard keeps serving the channel while the lookup is pending
+	in <- &bot.Message{From: bot.User{ID: "p1", FirstName: "P1"}, Chat: chat, Text: "/join", Date: time.Now()}
+	waitText(t, out, "Lobby fam100")
+
+	close(client.release)
+	waitText(t, out, "Statistik channel")
+	if admin, ok := chatAdminCache.Get(chat.ID + ":owner"); !ok || !admin.(bool) {
+		t.Errorf("chat admin lookup should be cached")
+	}
+}
+
+type failingMemberClient struct{}
+
+func (failingMemberClient) Member(chatID, userID string) (*bot.TChatMember, error) {
+	return nil, errors.New("telegram is down")
+}
+
+func TestChatAdminLookupFailed(t *testing.T) {
+	oDB := fam100.DefaultDB
+	defer func() {
+		fam100.DefaultDB = oDB
+		chatAdminCache.Flush()
+	}()
+	fam100.DefaultDB = &fam100.MemoryDB{}
+	chatAdminCache.Flush()
+	log = logger{zap.New(zap.NewJSONEncoder(), zap.FatalLevel+1)}
+	fam100.SetLogger(log)
+
+	b := &fam100Bot{client: failingMemberClient{}}
+	out := make(chan bot.Message, 100)
+	in, err := b.Init(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.start()
+	defer b.stop()
+
+	chat := bot.Chat{ID: "chatAdminFailChan", Type: bot.Group}
+	in <- &bot.Message{From: bot.User{ID: "owner"}, Chat: chat, Text: "/stats", Date: time.Now()}
+	if want, got := chat.ID, waitText(t, out, "Gagal memeriksa admin group").Chat.ID; want != got {
+		t.Errorf("reply want %s got %s", want, got)
+	}
+	if _, ok := chatAdminCache.Get(chat.ID + ":owner"); ok {
+		t.Errorf("failed lookup should not be cached")
+	}
+}
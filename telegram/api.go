@@ -0,0 +1,221 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/uber-go/zap"
+	"github.com/yulrizka/fam100"
+)
+
+var (
+	apiAddr    = ""
+	apiTimeout = 5 * time.Second
+
+	errAPIBusy = errors.New("bot is busy, try again later")
+)
+
+// gameStatus is an active game of a channel
+type gameStatus struct {
+	fam100.GameStatus
+	Players  int `json:"players"`
+	TimeLeft int `json:"timeLeft"` // seconds left of the current round
+}
+
+type apiError struct {
+	Error string `json:"error"`
+}
+
+// newAPIHandler returns http handler of the JSON api, every request must have the token
+// as bearer authorization header
+func newAPIHandler(b *fam100Bot, token string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer apiRequestTimer.UpdateSince(time.Now())
+
+		auth := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if token == "" || subtle.ConstantTimeCompare([]byte(auth), []byte(token)) != 1 {
+			apiUnauthorizedCount.Inc(1)
+			writeAPIError(w, http.StatusUnauthorized, errors.New("invalid token"))
+			return
+		}
+
+		if !strings.HasPrefix(r.URL.Path, "/api/") {
+			writeAPIError(w, http.StatusNotFound, errors.New("not found"))
+			return
+		}
+		path := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/"), "/"), "/")
+		switch {
+		case r.Method == "GET" && len(path) == 1 && path[0] == "ranking":
+			apiRanking(w, r)
+		case r.Method == "GET" && len(path) == 1 && path[0] == "games":
+			apiGames(b, w, r)
+		case r.Method == "GET" && len(path) == 2 && path[0] == "players":
+			apiPlayer(w, r, path[1])
+		case r.Method == "GET" && len(path) == 3 && path[0] == "channels" && path[2] == "ranking":
+			apiChannelRanking(w, r, path[1])
+		case r.Method == "GET" && len(path) == 3 && path[0] == "channels" && path[2] == "config":
+			apiChannelConfig(w, r, path[1])
+		case r.Method == "PUT" && len(path) == 4 && path[0] == "channels" && path[2] == "config":
+			apiSetChannelConfig(w, r, path[1], path[3])
+		default:
+			writeAPIError(w, http.StatusNotFound, errors.New("not found"))
+		}
+	})
+}
+
+// activeGames returns snapshot of the games, the channels are owned by the shard handleInbox so it is read from there
+func (b *fam100Bot) activeGames() ([]gameStatus, error) {
+	var mu sync.Mutex
+	games := make([]gameStatus, 0, b.gameCount())
+	err := b.callShards(func(s *fam100Bot) {
+		now := time.Now()
+		for chanID, ch := range s.channels {
+			gs := gameStatus{GameStatus: ch.game.Status(), Players: len(ch.quorumPlayer)}
+			gs.ChanID = chanID
+			if gs.State == fam100.RoundStarted && gs.RoundEndAt.After(now) {
+				gs.TimeLeft = int(gs.RoundEndAt.Sub(now).Seconds())
+			}
+			mu.Lock()
+			games = append(games, gs)
+			mu.Unlock()
+		}
+	}, apiTimeout)
+	if err != nil {
+		return nil, err
+	}
+	mu.Lock()
+	defer mu.Unlock()
+
+	return games, nil
+}
+
+func apiGames(b *fam100Bot, w http.ResponseWriter, r *http.Request) {
+	games, err := b.activeGames()
+	if err != nil {
+		writeAPIError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, games)
+}
+
+func apiRanking(w http.ResponseWriter, r *http.Request) {
+	limit, err := queryLimit(r)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err)
+		return
+	}
+	rank, err := fam100.PlayerRanking(limit)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, rank)
+}
+
+func apiChannelRanking(w http.ResponseWriter, r *http.Request, chanID string) {
+	limit, err := queryLimit(r)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err)
+		return
+	}
+	rank, err := fam100.DefaultDB.ChannelRanking(chanID, limit)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, rank)
+}
+
+func apiPlayer(w http.ResponseWriter, r *http.Request, playerID string) {
+	stats, err := fam100.GetPlayerStats(fam100.PlayerID(playerID))
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if stats.Name == "" {
+		writeAPIError(w, http.StatusNotFound, errors.New("player not found"))
+		return
+	}
+	writeJSON(w, http.StatusOK, stats)
+}
+
+func apiChannelConfig(w http.ResponseWriter, r *http.Request, chanID string) {
+	config := make(map[string]string)
+	for _, key := range fam100.ConfigKeys {
+		value, err := fam100.DefaultDB.ChannelConfig(chanID, key.Name, "")
+		if err != nil {
+			writeAPIError(w, http.StatusInternalServerError, err)
+			return
+		}
+		config[key.Name] = value
+	}
+	writeJSON(w, http.StatusOK, config)
+}
+
+// apiSetChannelConfig sets a channel configuration from body {"value": "..."}, empty value resets to the default
+func apiSetChannelConfig(w http.ResponseWriter, r *http.Request, chanID, key string) {
+	if _, ok := fam100.LookupConfigKey(key); !ok {
+		writeAPIError(w, http.StatusNotFound, errors.New("unknown config key"))
+		return
+	}
+	var body struct {
+		Value string `json:"value"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeAPIError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := fam100.CheckConfig(key, body.Value, false); err != nil {
+		writeAPIError(w, http.StatusBadRequest, err)
+		return
+	}
+	var err error
+	if body.Value == "" {
+		err = fam100.DefaultDB.DeleteChannelConfig(chanID, key)
+	} else {
+		err = fam100.DefaultDB.SetChannelConfig(chanID, key, body.Value)
+	}
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err)
+		return
+	}
+	log.Info("channel config updated from api", zap.String("chanID", chanID), zap.String("key", key), zap.String("value", body.Value))
+	writeJSON(w, http.StatusOK, map[string]string{key: body.Value})
+}
+
+func queryLimit(r *http.Request) (int, error) {
+	limitStr := r.URL.Query().Get("limit")
+	if limitStr == "" {
+		return 20, nil
+	}
+	limit, err := strconv.Atoi(limitStr)
+	if err != nil || limit < 0 {
+		return 0, errors.New("invalid limit")
+	}
+	return limit, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Error("writing api response failed", zap.Error(err))
+	}
+}
+
+// writeAPIError writes the error message of client errors, server errors are logged and only the status text
+// is returned so db errors are not leaked
+func writeAPIError(w http.ResponseWriter, status int, err error) {
+	if status >= http.StatusInternalServerError {
+		log.Error("api request failed", zap.Int("status", status), zap.Error(err))
+		writeJSON(w, status, apiError{Error: http.StatusText(status)})
+		return
+	}
+	writeJSON(w, status, apiError{Error: err.Error()})
+}
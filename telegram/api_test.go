@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/uber-go/zap"
+	"github.com/yulrizka/bot"
+	"github.com/yulrizka/fam100"
+)
+
+func apiRequest(t *testing.T, server *httptest.Server, method, path, token, body string, v interface{}) int {
+	req, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if v != nil {
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatalf("%s %s decoding response: %s", method, path, err)
+		}
+	}
+
+	return resp.StatusCode
+}
+
+func TestAPI(t *testing.T) {
+	log = logger{zap.New(zap.NewJSONEncoder(), zap.ErrorLevel)}
+	fam100.SetLogger(log)
+
+	b := fam100Bot{}
+	in, err := b.Init(make(chan bot.Message, 10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.start()
+	defer b.stop()
+
+	server := httptest.NewServer(newAPIHandler(&b, "secret"))
+	defer server.Close()
+
+	// token is required
+	if want, got := http.StatusUnauthorized, apiRequest(t, server, "GET", "/api/games", "", "", nil); want != got {
+		t.Errorf("without token want %d got %d", want, got)
+	}
+	if want, got := http.StatusUnauthorized, apiRequest(t, server, "GET", "/api/games", "wrong", "", nil); want != got {
+		t.Errorf("wrong token want %d got %d", want, got)
+	}
+
+	// active games
+	in <- &bot.Message{
+		From: bot.User{ID: "apiPlayer1", FirstName: "Player 1"},
+		Chat: bot.Chat{ID: "apiChan1", Type: bot.Group},
+		Text: "/join",
+	}
+	var games []gameStatus
+	for i := 0; i < 10 && len(games) == 0; i++ {
+		if want, got := http.StatusOK, apiRequest(t, server, "GET", "/api/games", "secret", "", &games); want != got {
+			t.Fatalf("games want %d got %d", want, got)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if want, got := 1, len(games); want != got {
+		t.Fatalf("games want %d got %d", want, got)
+	}
+	if want, got := "apiChan1", games[0].ChanID; want != got {
+		t.Errorf("chanID want %s got %s", want, got)
+	}
+	if want, got := fam100.Created, games[0].State; want != got {
+		t.Errorf("state want %s got %s", want, got)
+	}
+	if want, got := 1, games[0].Players; want != got {
+		t.Errorf("players want %d got %d", want, got)
+	}
+
+	// channel config
+	if want, got := http.StatusOK, apiRequest(t, server, "PUT", "/api/channels/apiChan1/config/motd", "secret", `{"value": "hello"}`, nil); want != got {
+		t.Errorf("set config want %d got %d", want, got)
+	}
+	if want, got := http.StatusNotFound, apiRequest(t, server, "PUT", "/api/channels/apiChan1/config/unknown", "secret", `{"value": "1"}`, nil); want != got {
+		t.Errorf("unknown config want %d got %d", want, got)
+	}
+	if want, got := http.StatusBadRequest, apiRequest(t, server, "PUT", "/api/channels/apiChan1/config/questionLimit", "secret", `{"value": "x"}`, nil); want != got {
+		t.Errorf("invalid questionLimit want %d got %d", want, got)
+	}
+	var config map[string]string
+	if want, got := http.StatusOK, apiRequest(t, server, "GET", "/api/channels/apiChan1/config", "secret", "", &config); want != got {
+		t.Errorf("get config want %d got %d", want, got)
+	}
+	if want, got := "hello", config["motd"]; want != got {
+		t.Errorf("motd want %s got %s", want, got)
+	}
+	// empty value resets to the default
+	if want, got := http.StatusOK, apiRequest(t, server, "PUT", "/api/channels/apiChan1/config/motd", "secret", `{"value": ""}`, nil); want != got {
+		t.Errorf("reset config want %d got %d", want, got)
+	}
+	if motd, _ := fam100.DefaultDB.ChannelConfig("apiChan1", "motd", "default"); motd != "default" {
+		t.Errorf("motd should be reset, got %q", motd)
+	}
+
+	// ranking
+	var rank fam100.Rank
+	if want, got := http.StatusOK, apiRequest(t, server, "GET", "/api/ranking?limit=5", "secret", "", &rank); want != got {
+		t.Errorf("ranking want %d got %d", want, got)
+	}
+	if want, got := http.StatusOK, apiRequest(t, server, "GET", "/api/channels/apiChan1/ranking", "secret", "", &rank); want != got {
+		t.Errorf("channel ranking want %d got %d", want, got)
+	}
+	if want, got := http.StatusBadRequest, apiRequest(t, server, "GET", "/api/ranking?limit=x", "secret", "", nil); want != got {
+		t.Errorf("invalid limit want %d got %d", want, got)
+	}
+	if want, got := http.StatusNotFound, apiRequest(t, server, "GET", "/api/players/apiUnknown", "secret", "", nil); want != got {
+		t.Errorf("unknown player want %d got %d", want, got)
+	}
+	if want, got := http.StatusNotFound, apiRequest(t, server, "GET", "/ranking", "secret", "", nil); want != got {
+		t.Errorf("path without /api/ want %d got %d", want, got)
+	}
+}
+
+func TestAPIServerErrorNotLeaked(t *testing.T) {
+	log = logger{zap.New(zap.NewJSONEncoder(), zap.FatalLevel+1)}
+
+	w := httptest.NewRecorder()
+	writeAPIError(w, http.StatusInternalServerError, errors.New("dial tcp 10.0.0.1:6379: connection refused"))
+	var resp apiError
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if want, got := http.StatusText(http.StatusInternalServerError), resp.Error; want != got {
+		t.Errorf("error want %q got %q", want, got)
+	}
+}
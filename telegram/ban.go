@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/patrickmn/go-cache"
+	"github.com/uber-go/zap"
+	"github.com/yulrizka/bot"
+	"github.com/yulrizka/fam100"
+)
+
+// banCache caches the ban lookup of players answering in a game, a ban from other instance takes effect
+// once the entry expires
+var banCache = cache.New(time.Minute, 10*time.Minute)
+
+// banLobbyTimeout is the time a global ban waits for every shard to remove the player from the lobbies
+var banLobbyTimeout = 5 * time.Second
+
+// isBanned returns true if the player is banned from the channel or globally
+func isBanned(chanID, playerID string) bool {
+	key := chanID + ":" + playerID
+	if banned, ok := banCache.Get(key); ok {
+		return banned.(bool)
+	}
+	banned, err := fam100.DefaultDB.Banned(chanID, fam100.PlayerID(playerID))
+	if err != nil {
+		log.Error("loading ban failed", zap.String("chanID", chanID), zap.String("playerID", playerID), zap.Error(err))
+		return false
+	}
+	banCache.Set(key, banned, cache.DefaultExpiration)
+
+	return banned
+}
+
+// forgetBan removes the cached ban lookup, a global ban clears every channel
+func forgetBan(chanID, playerID string) {
+	if chanID == "" {
+		banCache.Flush()
+		return
+	}
+	banCache.Delete(chanID + ":" + playerID)
+}
+
+// cmdBan handles "/ban", "/unban" and "/bans". In a group a chat admin manages the bans of the group,
+// the player is given by ID or by replying to the player's message. In private chat a moderator manages
+// the bans of any channel or the global bans
+func (b *fam100Bot) cmdBan(msg *bot.Message) bool {
+	defer cmdBanTimer.UpdateSince(time.Now())
+
+	if msg.Chat.Type == bot.Private {
+		return b.cmdBanPrivate(msg)
+	}
+
+	chanID := msg.Chat.ID
+	if !b.isChatAdmin(chanID, msg.From.ID, msg) {
+		return true
+	}
+	reply := func(text string) {
+		b.out <- bot.Message{Chat: bot.Chat{ID: chanID}, Text: text, Format: bot.HTML, DiscardAfter: time.Now().Add(5 * time.Second)}
+	}
+
+	cmd, rest := splitField(msg.Text)
+	cmd = commandName(cmd, b.name)
+	if cmd == "/bans" {
+		b.replyBans(chanID, reply)
+		return true
+	}
+
+	var playerID, name string
+	if msg.ReplyTo != nil && msg.ReplyTo.From.ID != "" {
+		playerID, name = msg.ReplyTo.From.ID, msg.ReplyTo.From.FullName()
+	} else {
+		playerID, rest = splitField(rest)
+	}
+	if playerID == "" {
+		reply(escape(fmt.Sprintf(fam100.T("Balas pesan pemain dengan %s atau %s [playerID]"), cmd, cmd)))
+		return true
+	}
+	shown := name
+	if shown == "" {
+		shown = playerID
+	}
+
+	if cmd == "/unban" {
+		b.unban(chanID, playerID, msg.From.ID, reply,
+			fmt.Sprintf(fam100.T("%s boleh bermain lagi"), escape(shown)),
+			fmt.Sprintf(fam100.T("%s tidak diblokir"), escape(shown)))
+		return true
+	}
+	ban := fam100.Ban{PlayerID: fam100.PlayerID(playerID), Name: name, ChanID: chanID, Reason: rest, BannedBy: msg.From.ID}
+	b.ban(ban, reply, fmt.Sprintf(fam100.T("%s diblokir dari permainan di grup ini"), escape(shown)))
+
+	return true
+}
+
+// cmdBanPrivate handles "/ban global|chanID playerID [reason]", "/unban global|chanID playerID" and
+// "/bans global|chanID" for moderators
+func (b *fam100Bot) cmdBanPrivate(msg *bot.Message) bool {
+	if !hasRole(msg.From.ID, fam100.RoleModerator) {
+		return true
+	}
+	reply := func(text string) {
+		b.out <- bot.Message{Chat: bot.Chat{ID: msg.Chat.ID}, Text: text, Format: bot.HTML}
+	}
+	usage := "usage:\n/ban global|chanID playerID [reason]\n/unban global|chanID playerID\n/bans global|chanID"
+
+	cmd, rest := splitField(msg.Text)
+	cmd = commandName(cmd, b.name)
+	chanID, rest := splitField(rest)
+	playerID, reason := splitField(rest)
+	if chanID == "" || (cmd != "/bans" && playerID == "") || (cmd != "/ban" && reason != "") {
+		reply(escape(usage))
+		return true
+	}
+	scope := chanID
+	if chanID == "global" {
+		chanID = ""
+	}
+
+	switch cmd {
+	case "/bans":
+		b.replyBans(chanID, reply)
+	case "/unban":
+		b.unban(chanID, playerID, msg.From.ID, reply,
+			fmt.Sprintf("%s unbanned from %s", escape(playerID), escape(scope)),
+			fmt.Sprintf("%s is not banned from %s", escape(playerID), escape(scope)))
+	default:
+		ban := fam100.Ban{PlayerID: fam100.PlayerID(playerID), ChanID: chanID, Reason: reason, BannedBy: msg.From.ID}
+		b.ban(ban, reply, fmt.Sprintf("%s banned from %s", escape(playerID), escape(scope)))
+	}
+
+	return true
+}
+
+// ban stores the ban outside of the shard, a global ban scans every ranking. The player is then removed from
+// the lobby by the shard owning the channel, or by every shard for a global ban
+func (b *fam100Bot) ban(ban fam100.Ban, reply func(string), done string) {
+	playerID := string(ban.PlayerID)
+	go func() {
+		if err := fam100.BanPlayer(ban); err != nil {
+			log.Error("banning player failed", zap.String("chanID", ban.ChanID), zap.String("playerID", playerID), zap.Error(err))
+			reply(escape("ban failed. " + err.Error()))
+			return
+		}
+		forgetBan(ban.ChanID, playerID)
+		commandBanCount.Inc(1)
+		log.Info("player banned", zap.String("chanID", ban.ChanID), zap.String("playerID", playerID), zap.String("by", ban.BannedBy))
+
+		if ban.Global() {
+			err := b.root.callShards(func(s *fam100Bot) {
+				for _, ch := range s.channels {
+					s.removeBanned(ch, playerID)
+				}
+			}, banLobbyTimeout)
+			if err != nil {
+				log.Error("removing banned player from lobbies failed", zap.String("playerID", playerID), zap.Error(err))
+			}
+		} else {
+			s := b.root.shard(ban.ChanID)
+			removed := func() {
+				if ch, ok := s.channels[ban.ChanID]; ok {
+					s.removeBanned(ch, playerID)
+				}
+			}
+			select {
+			case s.call <- removed:
+			case <-b.quit:
+				return
+			}
+		}
+		reply(done)
+	}()
+}
+
+// removeBanned removes the player waiting for the game of the channel
+func (b *fam100Bot) removeBanned(ch *channel, playerID string) {
+	if ch.started || !ch.quorumPlayer[playerID] {
+		return
+	}
+	if ch.lobby != nil {
+		b.leave(ch, playerID)
+	} else {
+		delete(ch.quorumPlayer, playerID)
+		delete(ch.players, playerID)
+	}
+}
+
+func (b *fam100Bot) unban(chanID, playerID, by string, reply func(string), done, notBanned string) {
+	removed, err := fam100.DefaultDB.RemoveBan(chanID, fam100.PlayerID(playerID))
+	if err != nil {
+		log.Error("unbanning player failed", zap.String("chanID", chanID), zap.String("playerID", playerID), zap.Error(err))
+		reply(escape("unban failed. " + err.Error()))
+		return
+	}
+	if !removed {
+		reply(notBanned)
+		return
+	}
+	forgetBan(chanID, playerID)
+	commandBanCount.Inc(1)
+	log.Info("player unbanned", zap.String("chanID", chanID), zap.String("playerID", playerID), zap.String("by", by))
+	reply(done)
+}
+
+func (b *fam100Bot) replyBans(chanID string, reply func(string)) {
+	bans, err := fam100.DefaultDB.Bans(chanID)
+	if err != nil {
+		log.Error("loading bans failed", zap.String("chanID", chanID), zap.Error(err))
+		reply(escape("bans failed. " + err.Error()))
+		return
+	}
+	if len(bans) == 0 {
+		reply(fam100.T("Tidak ada pemain yang diblokir"))
+		return
+	}
+	var text bytes.Buffer
+	text.WriteString(fam100.T("<b>Pemain yang diblokir</b>\n"))
+	for _, ban := range bans {
+		name := ban.Name
+		if name == "" {
+			name = string(ban.PlayerID)
+		}
+		fmt.Fprintf(&text, "%s (%s)", escape(name), escape(string(ban.PlayerID)))
+		if ban.Reason != "" {
+			fmt.Fprintf(&text, ": %s", escape(ban.Reason))
+		}
+		text.WriteString("\n")
+	}
+	reply(text.String())
+}
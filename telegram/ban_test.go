@@ -0,0 +1,112 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/uber-go/zap"
+	"github.com/yulrizka/bot"
+	"github.com/yulrizka/fam100"
+)
+
+func TestBan(t *testing.T) {
+	oShardCount, oMinQuorum, oDB, oAdminID := shardCount, minQuorum, fam100.DefaultDB, adminID
+	defer func() {
+		shardCount, minQuorum, fam100.DefaultDB, adminID = oShardCount, oMinQuorum, oDB, oAdminID
+		banCache.Flush()
+	}()
+	shardCount, minQuorum, fam100.DefaultDB, adminID = 4, 3, &fam100.MemoryDB{}, "admin"
+	banCache.Flush()
+	log = logger{zap.New(zap.NewJSONEncoder(), zap.FatalLevel+1)}
+	fam100.SetLogger(log)
+
+	b := &fam100Bot{}
+	out := make(chan bot.Message, 100)
+	in, err := b.Init(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.start()
+	defer b.stop()
+
+	chat := bot.Chat{ID: "banChan", Type: bot.Group}
+	admin, p1, p2 := bot.User{ID: "admin", FirstName: "Admin"}, bot.User{ID: "p1", FirstName: "P1"}, bot.User{ID: "p2", FirstName: "P2"}
+	send := func(from bot.User, text string, replyTo *bot.Message) {
+		in <- &bot.Message{From: from, Chat: chat, Text: text, ReplyTo: replyTo, Date: time.Now()}
+	}
+	private := func(text string) {
+		in <- &bot.Message{From: admin, Chat: bot.Chat{ID: "admin", Type: bot.Private}, Text: text, Date: time.Now()}
+	}
+	joined := func(playerID string) bool {
+		s := b.shard(chat.ID)
+		result := make(chan bool)
+		s.call <- func() {
+			ch, ok := s.channels[chat.ID]
+			result <- ok && ch.quorumPlayer[playerID]
+		}
+		return <-result
+	}
+
+	// non admin can not ban
+	send(p1, "/ban p2", nil)
+	send(admin, "/ban p2 spam jawaban", nil)
+	if msg := waitText(t, out, "diblokir"); msg.Text != "p2 diblokir dari permainan di grup ini" {
+		t.Errorf("unexpected reply %q", msg.Text)
+	}
+	send(p2, "/join", nil)
+	send(p1, "/join", nil)
+	waitText(t, out, "Lobby fam100")
+	if joined("p2") || !joined("p1") {
+		t.Errorf("banned player should not join")
+	}
+
+	// ban by replying to the player removes the player from the lobby
+	send(admin, "/ban kasar", &bot.Message{From: p1, Chat: chat, Text: "hello"})
+	waitText(t, out, "P1 diblokir dari permainan di grup ini")
+	if joined("p1") {
+		t.Errorf("banned player should leave the lobby")
+	}
+
+	send(admin, "/bans", nil)
+	msg := waitText(t, out, "Pemain yang diblokir")
+	for _, want := range []string{"P1 (p1): kasar\n", "p2 (p2): spam jawaban\n"} {
+		if !strings.Contains(msg.Text, want) {
+			t.Errorf("bans want %q got %q", want, msg.Text)
+		}
+	}
+	send(admin, "/unban p2", nil)
+	waitText(t, out, "p2 boleh bermain lagi")
+	send(admin, "/unban p2", nil)
+	waitText(t, out, "p2 tidak diblokir")
+	if isBanned(chat.ID, "p2") {
+		t.Errorf("p2 should not be banned")
+	}
+
+	// global ban from private chat removes the player from the lobby owned by other shard
+	send(p2, "/join", nil)
+	send(bot.User{ID: "p3", FirstName: "P3"}, "/join", nil)
+	waitText(t, out, "Lobby fam100")
+	private("/ban global p3 nama kasar")
+	waitText(t, out, "p3 banned from global")
+	if !isBanned("otherChan", "p3") {
+		t.Errorf("p3 should be banned from every channel")
+	}
+	if joined("p3") || !joined("p2") {
+		t.Errorf("globally banned player should leave the lobby")
+	}
+	private("/bans global")
+	waitText(t, out, "p3 (p3): nama kasar")
+	private("/unban global p3")
+	waitText(t, out, "p3 unbanned from global")
+	if isBanned("otherChan", "p3") {
+		t.Errorf("p3 should not be banned")
+	}
+	private("/ban banChan p2")
+	waitText(t, out, "p2 banned from banChan")
+	if joined("p2") {
+		t.Errorf("banned player should leave the lobby")
+	}
+	private("/ban")
+	waitText(t, out, "usage:")
+}
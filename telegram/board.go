@@ -0,0 +1,104 @@
+package main
+
+import (
+	"github.com/yulrizka/bot"
+	"github.com/yulrizka/fam100"
+)
+
+// board is the question message of the current round, edited in place as answers are revealed
+type board struct {
+	chanID     string
+	round      int
+	questionID int
+	header     string // round number shown above the question
+	id         string // message ID, empty until the message is sent
+	pending    string // text edited before the message was sent
+}
+
+// boardSent is the message ID of a board returned by the outbox, it changes when an edit failed
+// and the board was posted again
+type boardSent struct {
+	board *board
+	id    string
+}
+
+// boardMigrated moves the board to the new ID of a migrated channel, it is sent through the game outbox
+// after the messages of the old ID
+type boardMigrated struct {
+	from, to string
+}
+
+// postBoard sends the board of a new round, must be called from handleOutbox which owns the boards
+func (b *fam100Bot) postBoard(header string, qna fam100.QNAMessage) {
+	bd := &board{chanID: qna.ChanID, round: qna.Round, questionID: qna.QuestionID, header: header}
+	b.boards[qna.ChanID] = bd
+	msg := b.boardMessage(bd, header+formatRoundText(qna))
+	msg.Priority = bot.PriorityHigh
+	b.out <- msg
+}
+
+// updateBoard edits the board with the answers so far, returns false if the round has no board
+func (b *fam100Bot) updateBoard(qna fam100.QNAMessage) bool {
+	bd, ok := b.boards[qna.ChanID]
+	if !ok || bd.round != qna.Round || bd.questionID != qna.QuestionID {
+		return false
+	}
+	text := bd.header + formatRoundText(qna)
+	if bd.id == "" {
+		bd.pending = text
+		return true
+	}
+	b.out <- b.boardMessage(bd, text)
+
+	return true
+}
+
+// setBoardID stores the message ID of the board and sends the edit waiting for it
+func (b *fam100Bot) setBoardID(s boardSent) {
+	bd := s.board
+	if b.boards[bd.chanID] != bd {
+		// round is over
+		return
+	}
+	bd.id = s.id
+	if bd.pending != "" {
+		text := bd.pending
+		bd.pending = ""
+		b.out <- b.boardMessage(bd, text)
+	}
+}
+
+// migrateBoard moves the board of the migrated channel, the edits to the old message fail and the board is
+// posted again in the new chat
+func (b *fam100Bot) migrateBoard(m boardMigrated) {
+	bd, ok := b.boards[m.from]
+	if !ok {
+		return
+	}
+	delete(b.boards, m.from)
+	bd.chanID = m.to
+	b.boards[m.to] = bd
+}
+
+func (b *fam100Bot) boardMessage(bd *board, text string) bot.Message {
+	editID := bd.id
+	msg := bot.Message{Chat: bot.Chat{ID: bd.chanID}, Text: text, Format: bot.HTML, Retry: 3, EditID: editID}
+	if editID != "" {
+		// queued edit is replaced by the next one when the chat is rate limited
+		msg.Priority = bot.PriorityLow
+	}
+	msg.OnSent = func(id string) {
+		if id == "" || id == editID {
+			return
+		}
+		// called by the outbox worker, the board is owned by handleOutbox
+		go func() {
+			select {
+			case b.boardSent <- boardSent{board: bd, id: id}:
+			case <-b.quit:
+			}
+		}()
+	}
+
+	return msg
+}
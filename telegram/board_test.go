@@ -0,0 +1,83 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/uber-go/zap"
+	"github.com/yulrizka/bot"
+	"github.com/yulrizka/fam100"
+)
+
+func TestBoard(t *testing.T) {
+	log = logger{zap.New(zap.NewJSONEncoder(), zap.FatalLevel+1)}
+
+	b := &fam100Bot{}
+	out := make(chan bot.Message, 100)
+	if _, err := b.Init(out); err != nil {
+		t.Fatal(err)
+	}
+	b.start()
+	defer b.stop()
+
+	chanID := "boardChan"
+	s := b.shard(chanID)
+	qna := func(text string) fam100.QNAMessage {
+		return fam100.QNAMessage{ChanID: chanID, Round: 1, QuestionID: 7, QuestionText: text}
+	}
+
+	s.gameOut <- fam100.StateMessage{ChanID: chanID, State: fam100.RoundStarted, Round: 1, Rounds: 3, RoundText: qna("first")}
+	msg := waitText(t, out, "Ronde 1 dari 3")
+	if msg.EditID != "" || msg.OnSent == nil {
+		t.Fatalf("board should be sent as new message, edit %q", msg.EditID)
+	}
+	if want, got := bot.PriorityHigh, msg.Priority; want != got {
+		t.Errorf("board priority want %d got %d", want, got)
+	}
+
+	// answer before the board is sent is edited once the ID is known
+	s.gameOut <- qna("second")
+	s.gameOut <- qna("third")
+	select {
+	case msg := <-out:
+		t.Fatalf("unexpected message before board is sent %q", msg.Text)
+	case <-time.After(50 * time.Millisecond):
+	}
+	msg.OnSent("10")
+	msg = waitText(t, out, "third")
+	if want, got := "10", msg.EditID; want != got {
+		t.Errorf("edit id want %s got %s", want, got)
+	}
+	if want, got := bot.PriorityLow, msg.Priority; want != got {
+		t.Errorf("edit priority want %d got %d", want, got)
+	}
+
+	// edit failed and the board was posted again
+	msg.OnSent("11")
+	waitFor(t, "edit of reposted board", func() bool {
+		s.gameOut <- qna("fourth")
+		msg = waitText(t, out, "fourth")
+		return msg.EditID == "11"
+	})
+
+	// answers of other round are posted as new message
+	other := qna("other round")
+	other.Round = 2
+	s.gameOut <- other
+	if msg := waitText(t, out, "other round"); msg.EditID != "" {
+		t.Errorf("message of other round should not edit the board, got %s", msg.EditID)
+	}
+
+	// the board follows the migrated channel
+	s.gameOut <- boardMigrated{from: chanID, to: "boardChanNew"}
+	moved := qna("migrated")
+	moved.ChanID = "boardChanNew"
+	s.gameOut <- moved
+	msg = waitText(t, out, "migrated")
+	if want, got := "boardChanNew", msg.Chat.ID; want != got {
+		t.Errorf("board chat want %s got %s", want, got)
+	}
+	if want, got := "11", msg.EditID; want != got {
+		t.Errorf("migrated board edit id want %s got %s", want, got)
+	}
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/uber-go/zap"
+	"github.com/yulrizka/bot"
+	"github.com/yulrizka/fam100"
+)
+
+var (
+	broadcastInterval    = 10 * time.Second // how often running broadcasts are checked, eg: after restart
+	broadcastSendTimeout = time.Minute      // message without result from the outbox is counted as failed
+	broadcastReportEvery = 30 * time.Second
+	broadcastStatusLimit = 5
+
+	errBroadcastTimeout = errors.New("no result from the outbox")
+)
+
+// broadcaster sends the running broadcasts one channel at a time. The progress is saved before every
+// channel so a broadcast is resumed after restart without sending twice. With several instances a
+// broadcast is sent by the instance holding its lease
+type broadcaster struct {
+	bot  *fam100Bot
+	wake chan struct{}
+}
+
+func newBroadcaster(b *fam100Bot) *broadcaster {
+	return &broadcaster{bot: b, wake: make(chan struct{}, 1)}
+}
+
+func (s *broadcaster) run() {
+	ticker := time.NewTicker(broadcastInterval)
+	defer ticker.Stop()
+	for {
+		s.runPending()
+		select {
+		case <-s.bot.quit:
+			return
+		case <-ticker.C:
+		case <-s.wake:
+		}
+	}
+}
+
+// notify starts the new broadcast without waiting for the next tick
+func (s *broadcaster) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *broadcaster) runPending() {
+	broadcasts, err := fam100.DefaultDB.Broadcasts()
+	if err != nil {
+		log.Error("loading broadcasts failed", zap.Error(err))
+		return
+	}
+	for _, bc := range broadcasts {
+		if bc.Status != fam100.BroadcastRunning || !s.lease(bc.ID, false) {
+			continue
+		}
+		s.send(bc)
+		if s.bot.cluster != nil {
+			if err := fam100.DefaultDB.ReleaseLease(broadcastLease(bc.ID), s.bot.cluster.id); err != nil {
+				log.Error("releasing broadcast lease failed", zap.Int64("broadcastID", bc.ID), zap.Error(err))
+			}
+		}
+	}
+}
+
+func broadcastLease(id int64) string {
+	return fmt.Sprintf("broadcast_%d", id)
+}
+
+// lease returns true if this instance sends the broadcast
+func (s *broadcaster) lease(id int64, renew bool) bool {
+	c := s.bot.cluster
+	if c == nil {
+		return true
+	}
+	if renew {
+		renewed, err := fam100.DefaultDB.RenewLease(broadcastLease(id), c.id, c.ttl)
+		if err != nil {
+			log.Error("renewing broadcast lease failed", zap.Int64("broadcastID", id), zap.Error(err))
+		}
+		return renewed
+	}
+	holder, err := fam100.DefaultDB.AcquireLease(broadcastLease(id), c.id, c.ttl)
+	if err != nil {
+		log.Error("acquiring broadcast lease failed", zap.Int64("broadcastID", id), zap.Error(err))
+		return false
+	}
+	return holder == c.id
+}
+
+// send sends the broadcast until every channel is done, it is cancelled or the bot stops
+func (s *broadcaster) send(bc fam100.Broadcast) {
+	lastReport := time.Now()
+	for !bc.Done() {
+		select {
+		case <-s.bot.quit:
+			return
+		default:
+		}
+
+		// cancelled by the admin, possibly on other instance
+		current, err := fam100.DefaultDB.Broadcast(bc.ID)
+		if err != nil {
+			log.Error("loading broadcast failed", zap.Int64("broadcastID", bc.ID), zap.Error(err))
+			return
+		}
+		if current == nil {
+			return
+		}
+		if current.Status != fam100.BroadcastRunning {
+			// the result of the last channel is already saved, the status is not changed by SaveBroadcast
+			return
+		}
+		if !s.lease(bc.ID, true) {
+			return
+		}
+
+		chanID := bc.Channels[bc.Next]
+		bc.Next++
+		if err := fam100.DefaultDB.SaveBroadcast(bc); err != nil {
+			log.Error("saving broadcast failed", zap.Int64("broadcastID", bc.ID), zap.Error(err))
+			return
+		}
+
+		err = s.sendMessage(chanID, bc.Text)
+		switch {
+		case err == nil:
+			bc.Sent++
+			broadcastSentCount.Inc(1)
+		case isKicked(err):
+			bc.Removed++
+			broadcastFailedCount.Inc(1)
+			log.Info("channel removed, bot was kicked", zap.String("chanID", chanID), zap.Error(err))
+			if err := fam100.DefaultDB.RemoveChannel(chanID); err != nil {
+				log.Error("removing channel failed", zap.String("chanID", chanID), zap.Error(err))
+			}
+		default:
+			bc.Failed++
+			broadcastFailedCount.Inc(1)
+			log.Warn("broadcast message failed", zap.Int64("broadcastID", bc.ID), zap.String("chanID", chanID), zap.Error(err))
+		}
+
+		// the progress never changes the status, a cancel while sending is kept
+		if err := fam100.DefaultDB.SaveBroadcast(bc); err != nil {
+			log.Error("saving broadcast failed", zap.Int64("broadcastID", bc.ID), zap.Error(err))
+		}
+		if bc.Done() {
+			done, err := fam100.DefaultDB.SetBroadcastStatus(bc.ID, fam100.BroadcastRunning, fam100.BroadcastDone)
+			if err != nil {
+				log.Error("saving broadcast status failed", zap.Int64("broadcastID", bc.ID), zap.Error(err))
+			}
+			if done {
+				bc.Status = fam100.BroadcastDone
+			}
+		}
+		if bc.Done() || time.Since(lastReport) >= broadcastReportEvery {
+			lastReport = time.Now()
+			s.bot.out <- bot.Message{Chat: bot.Chat{ID: bc.CreatedBy}, Text: formatBroadcastText(bc), Format: bot.HTML}
+		}
+	}
+}
+
+// sendMessage sends the broadcast to a channel and waits for the result from the outbox
+func (s *broadcaster) sendMessage(chanID, text string) error {
+	result := make(chan error, 1)
+	done := func(err error) {
+		select {
+		case result <- err:
+		default:
+		}
+	}
+	s.bot.out <- bot.Message{
+		Chat:     bot.Chat{ID: chanID},
+		Text:     text,
+		Format:   bot.Text,
+		Priority: bot.PriorityBulk,
+		OnSent:   func(string) { done(nil) },
+		OnFailed: done,
+	}
+
+	select {
+	case err := <-result:
+		return err
+	case <-time.After(broadcastSendTimeout):
+		return errBroadcastTimeout
+	case <-s.bot.quit:
+		return errBroadcastTimeout
+	}
+}
+
+// isKicked returns true if telegram refused the message because the bot is not in the chat anymore
+func isKicked(err error) bool {
+	terr, ok := err.(bot.TError)
+	return ok && terr.ErrorCode == 403
+}
+
+// cmdBroadcast handles /broadcast for the bot admin. It creates a broadcast job to the channels matching
+// the options, shows the progress of the broadcasts or cancels one
+func (b *fam100Bot) cmdBroadcast(msg *bot.Message) bool {
+	reply := func(text string) {
+		b.out <- bot.Message{Chat: bot.Chat{ID: msg.Chat.ID}, Text: text, Format: bot.HTML}
+	}
+	usage := "usage:\n/broadcast [name=regexp] [lang=id] [games=n] [dryrun] message\n/broadcast status\n/broadcast cancel [id]"
+
+	fields := strings.SplitN(msg.Text, " ", 2)
+	if len(fields) < 2 || strings.TrimSpace(fields[1]) == "" {
+		reply(escape(usage))
+		return true
+	}
+	rest := strings.TrimSpace(fields[1])
+
+	switch args := strings.Fields(rest); {
+	case args[0] == "status" && len(args) == 1:
+		broadcasts, err := fam100.DefaultDB.Broadcasts()
+		if err != nil {
+			reply(escape("broadcasts failed. " + err.Error()))
+			return true
+		}
+		if len(broadcasts) == 0 {
+			reply("no broadcast")
+			return true
+		}
+		if len(broadcasts) > broadcastStatusLimit {
+			broadcasts = broadcasts[len(broadcasts)-broadcastStatusLimit:]
+		}
+		var text bytes.Buffer
+		for _, bc := range broadcasts {
+			text.WriteString(formatBroadcastText(bc) + "\n")
+		}
+		reply(text.String())
+		return true
+
+	case args[0] == "cancel" && len(args) == 2:
+		id, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			reply(escape(usage))
+			return true
+		}
+		bc, err := fam100.CancelBroadcast(id)
+		if err != nil {
+			reply(escape(err.Error()))
+			return true
+		}
+		log.Info("broadcast cancelled", zap.Int64("broadcastID", id))
+		reply(formatBroadcastText(bc))
+		return true
+	}
+
+	var target fam100.BroadcastTarget
+	dryRun := false
+	for {
+		parts := strings.SplitN(rest, " ", 2)
+		option := parts[0]
+		switch {
+		case option == "dryrun":
+			dryRun = true
+		case strings.HasPrefix(option, "name="):
+			target.Name = strings.TrimPrefix(option, "name=")
+		case strings.HasPrefix(option, "lang="):
+			target.Lang = strings.TrimPrefix(option, "lang=")
+		case strings.HasPrefix(option, "games="):
+			games, err := strconv.Atoi(strings.TrimPrefix(option, "games="))
+			if err != nil || games < 0 {
+				reply(escape(usage))
+				return true
+			}
+			target.MinGames = games
+		default:
+			option = ""
+		}
+		if option == "" {
+			break
+		}
+		rest = ""
+		if len(parts) == 2 {
+			rest = strings.TrimSpace(parts[1])
+		}
+	}
+
+	if !dryRun && rest == "" {
+		reply(escape(usage))
+		return true
+	}
+
+	// selecting the channels reads the config and stats of every channel, it must not block the shard
+	chatID := msg.Chat.ID
+	go func() {
+		if dryRun {
+			channels, err := fam100.BroadcastChannels(target)
+			if err != nil {
+				reply(escape(err.Error()))
+				return
+			}
+			reply(fmt.Sprintf("dry run: broadcast to %d channels", len(channels)))
+			return
+		}
+
+		bc, err := fam100.NewBroadcast(chatID, rest, target)
+		if err != nil {
+			reply(escape(err.Error()))
+			return
+		}
+		broadcastCount.Inc(1)
+		log.Info("broadcast created", zap.Int64("broadcastID", bc.ID), zap.Int("channels", len(bc.Channels)))
+		reply(formatBroadcastText(bc))
+		b.broadcaster.notify()
+	}()
+
+	return true
+}
+
+func formatBroadcastText(bc fam100.Broadcast) string {
+	return fmt.Sprintf("<b>broadcast #%d</b> %s: %d of %d channels, sent %d, failed %d, removed %d",
+		bc.ID, bc.Status, bc.Next, len(bc.Channels), bc.Sent, bc.Failed, bc.Removed)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/uber-go/zap"
+	"github.com/yulrizka/bot"
+	"github.com/yulrizka/fam100"
+)
+
+func TestBroadcast(t *testing.T) {
+	oDB, oAdminID, oInterval := fam100.DefaultDB, adminID, broadcastInterval
+	defer func() {
+		fam100.DefaultDB, adminID, broadcastInterval = oDB, oAdminID, oInterval
+	}()
+	fam100.DefaultDB, adminID, broadcastInterval = &fam100.MemoryDB{}, "admin", 10*time.Millisecond
+	log = logger{zap.New(zap.NewJSONEncoder(), zap.FatalLevel+1)}
+	fam100.SetLogger(log)
+
+	// interrupted by restart after the first channel
+	running, err := fam100.DefaultDB.AddBroadcast(fam100.Broadcast{
+		CreatedBy: "admin",
+		Text:      "hello",
+		Status:    fam100.BroadcastRunning,
+		Channels:  []string{"-1", "-2", "-3", "-4"},
+		Next:      1,
+		Sent:      1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := &fam100Bot{}
+	out := make(chan bot.Message, 100)
+	in, err := b.Init(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.start()
+	defer b.stop()
+
+	waitChat := func(chatID string) bot.Message {
+		timeout := time.After(5 * time.Second)
+		for {
+			select {
+			case msg := <-out:
+				if msg.Chat.ID == chatID {
+					return msg
+				}
+			case <-timeout:
+				t.Fatalf("timeout waiting message to %s", chatID)
+			}
+		}
+	}
+	admin := func(text string) {
+		in <- &bot.Message{From: bot.User{ID: "admin"}, Chat: bot.Chat{ID: "admin", Type: bot.Private}, Text: text, Date: time.Now()}
+	}
+
+	msg := waitChat("-2")
+	if want, got := bot.PriorityBulk, msg.Priority; want != got {
+		t.Errorf("priority want %d got %d", want, got)
+	}
+	msg.OnSent("1")
+	waitChat("-3").OnFailed(bot.TError{ErrorCode: 403, Description: "Forbidden: bot was kicked from the group chat"})
+	waitChat("-4").OnFailed(errors.New("timeout"))
+	report := waitChat("admin").Text
+	if want := "done: 4 of 4 channels, sent 2, failed 1, removed 1"; !strings.Contains(report, want) {
+		t.Errorf("report want %q got %q", want, report)
+	}
+	bc, _ := fam100.DefaultDB.Broadcast(running.ID)
+	if bc.Status != fam100.BroadcastDone || bc.Next != 4 {
+		t.Errorf("unexpected broadcast %+v", bc)
+	}
+
+	admin("/broadcast")
+	waitText(t, out, "usage:")
+	admin("/broadcast dryrun name=^Kuis hello")
+	waitText(t, out, "dry run: broadcast to 0 channels")
+	admin("/broadcast status")
+	waitText(t, out, "broadcast #1</b> done")
+
+	// cancelled while sending
+	second, err := fam100.DefaultDB.AddBroadcast(fam100.Broadcast{
+		CreatedBy: "admin",
+		Text:      "second",
+		Status:    fam100.BroadcastRunning,
+		Channels:  []string{"-5", "-6"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg = waitChat("-5")
+	admin("/broadcast cancel 2")
+	waitText(t, out, "broadcast #2</b> cancelled")
+	msg.OnSent("2")
+	select {
+	case msg := <-out:
+		t.Errorf("unexpected message to %s after cancel: %s", msg.Chat.ID, msg.Text)
+	case <-time.After(100 * time.Millisecond):
+	}
+	bc, _ = fam100.DefaultDB.Broadcast(second.ID)
+	if bc.Status != fam100.BroadcastCancelled || bc.Next != 1 || bc.Sent != 1 {
+		t.Errorf("unexpected broadcast %+v", bc)
+	}
+}
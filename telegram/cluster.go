@@ -0,0 +1,531 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/uber-go/zap"
+	"github.com/yulrizka/bot"
+	"github.com/yulrizka/fam100"
+)
+
+var (
+	instanceID = ""
+	leaseTTL   = 15 * time.Second
+	receiver   = true // candidate to receive the updates, the instance holding the receiver lease receives them
+
+	// maxForwardHops stops an update moving between instances while the lease changes hands
+	maxForwardHops = 2
+)
+
+// cluster tracks the channels owned by this instance when several instances share the channels. Only the
+// instance holding the receiver lease receives the updates from telegram, an update of a channel owned by
+// other instance is forwarded to the queue of that instance in the db. A free channel is given to the instance
+// picked by hashing the channel ID over the instances alive. Ownership is a lease in the db renewed by the
+// heartbeat, the lease of a dead instance expires and the channel is taken over, together with its game
+// snapshot, by the instance it is picked for
+type cluster struct {
+	id  string
+	ttl time.Duration
+
+	mu      sync.Mutex
+	owned   map[string]bool       // channels leased by this instance
+	idle    map[string]time.Time  // owned channels without game since, released after ttl
+	others  map[string]otherLease // channels owned by other instance
+	resume  map[string]bool       // acquired channels that may have game snapshot to resume
+	members []string              // instances alive, refreshed by the heartbeat
+
+	receiving bool // holds the receiver lease
+}
+
+// otherLease is the holder of a channel owned by other instance, cached until the time
+type otherLease struct {
+	holder string
+	until  time.Time
+}
+
+// forwardedUpdate is an update received by other instance, only one of the update fields is set
+type forwardedUpdate struct {
+	Hops     int                         `json:"hops"`
+	Message  *bot.Message                `json:"message,omitempty"`
+	Callback *bot.CallbackQuery          `json:"callback,omitempty"`
+	Migrated *bot.ChannelMigratedMessage `json:"migrated,omitempty"`
+}
+
+func newCluster(id string, ttl time.Duration) *cluster {
+	return &cluster{
+		id:      id,
+		ttl:     ttl,
+		owned:   make(map[string]bool),
+		idle:    make(map[string]time.Time),
+		others:  make(map[string]otherLease),
+		resume:  make(map[string]bool),
+		members: []string{id},
+	}
+}
+
+// receiverLease is held by the instance receiving the updates from telegram
+const receiverLease = "receiver"
+
+func leaseName(chanID string) string {
+	return "chan_" + chanID
+}
+
+// owns returns true if the channel is owned by this instance, acquiring the lease if it is free and the
+// channel is picked for this instance
+func (c *cluster) owns(chanID string) bool {
+	return c.owner(chanID, false) == c.id
+}
+
+// owner returns the instance owning the channel. A free channel is acquired when it is picked for this
+// instance, otherwise the picked instance is returned and acquires it once the update is forwarded there.
+// claim acquires the free channel regardless of the pick and skips the cached holder, used for forwarded
+// updates. Empty when the lease can not be loaded
+func (c *cluster) owner(chanID string, claim bool) string {
+	c.mu.Lock()
+	if c.owned[chanID] {
+		c.mu.Unlock()
+		return c.id
+	}
+	if other, ok := c.others[chanID]; ok && !claim && time.Now().Before(other.until) {
+		c.mu.Unlock()
+		return other.holder
+	}
+	picked := c.pick(chanID)
+	c.mu.Unlock()
+
+	// the db is called without the lock so the heartbeat and the shards are not blocked by it
+	var holder string
+	var err error
+	if !claim && picked != c.id {
+		if holder, err = fam100.DefaultDB.LeaseHolder(leaseName(chanID)); err == nil && holder == "" {
+			return picked
+		}
+	} else {
+		holder, err = fam100.DefaultDB.AcquireLease(leaseName(chanID), c.id, c.ttl)
+	}
+	if err != nil {
+		log.Error("loading channel lease failed", zap.String("chanID", chanID), zap.Error(err))
+		return ""
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if holder != c.id {
+		// check again before the lease of the holder could expire
+		c.others[chanID] = otherLease{holder: holder, until: time.Now().Add(c.ttl / 3)}
+		return holder
+	}
+	delete(c.others, chanID)
+	if !c.owned[chanID] {
+		c.owned[chanID] = true
+		c.idle[chanID] = time.Now()
+		c.resume[chanID] = true
+		clusterAcquiredCount.Inc(1)
+		log.Info("channel lease acquired", zap.String("chanID", chanID), zap.String("instance", c.id))
+	}
+
+	return c.id
+}
+
+// pick returns the instance a free channel is given to, every instance picks the same one for the same
+// members and only the channels of a dead instance move. Must be called with c.mu held
+func (c *cluster) pick(chanID string) string {
+	var picked string
+	var max uint32
+	for _, id := range c.members {
+		h := fnv.New32a()
+		h.Write([]byte(id + ":" + chanID))
+		if sum := h.Sum32(); picked == "" || sum > max {
+			picked, max = id, sum
+		}
+	}
+	return picked
+}
+
+// register marks this instance alive and loads the other instances
+func (c *cluster) register() {
+	if err := fam100.DefaultDB.RegisterInstance(c.id, c.ttl); err != nil {
+		log.Error("registering instance failed", zap.String("instance", c.id), zap.Error(err))
+		return
+	}
+	members, err := fam100.DefaultDB.Instances()
+	if err != nil {
+		log.Error("loading instances failed", zap.Error(err))
+		return
+	}
+	c.mu.Lock()
+	c.members = members
+	c.mu.Unlock()
+	instanceTotal.Update(int64(len(members)))
+}
+
+// forward queues the update for the instance owning its channel, the queue of a dead instance expires
+// together with its leases
+func (c *cluster) forward(owner string, u forwardedUpdate) {
+	data, err := json.Marshal(u)
+	if err == nil {
+		err = fam100.DefaultDB.PushUpdate(owner, data, c.ttl)
+	}
+	if err != nil {
+		log.Error("forwarding update failed", zap.String("instance", owner), zap.Error(err))
+		return
+	}
+	clusterForwardCount.Inc(1)
+}
+
+// setActive marks whether the channel has a running game, idle channel lease is released by the heartbeat
+func (c *cluster) setActive(chanID string, active bool) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.owned[chanID] {
+		return
+	}
+	if active {
+		delete(c.idle, chanID)
+	} else {
+		c.idle[chanID] = time.Now()
+	}
+}
+
+// takeResume returns true once after the channel is acquired
+func (c *cluster) takeResume(chanID string) bool {
+	if c == nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ok := c.resume[chanID]
+	delete(c.resume, chanID)
+
+	return ok
+}
+
+// migrate moves the lease to the new channel ID
+func (c *cluster) migrate(fromID, toID string) {
+	if c == nil {
+		return
+	}
+	if _, err := fam100.DefaultDB.AcquireLease(leaseName(toID), c.id, c.ttl); err != nil {
+		log.Error("acquiring migrated channel lease failed", zap.String("chanID", toID), zap.Error(err))
+	}
+	c.mu.Lock()
+	c.owned[toID] = true
+	if since, ok := c.idle[fromID]; ok {
+		c.idle[toID] = since
+	}
+	c.forget(fromID)
+	c.mu.Unlock()
+
+	c.release(fromID)
+}
+
+// forget stops tracking the channel as owned, must be called with c.mu held. The lease is released by
+// release after c.mu is unlocked
+func (c *cluster) forget(chanID string) {
+	delete(c.owned, chanID)
+	delete(c.idle, chanID)
+	delete(c.resume, chanID)
+}
+
+// release removes the lease of the channel in the db, must be called without c.mu held
+func (c *cluster) release(chanIDs ...string) {
+	for _, chanID := range chanIDs {
+		if err := fam100.DefaultDB.ReleaseLease(leaseName(chanID), c.id); err != nil {
+			log.Error("releasing channel lease failed", zap.String("chanID", chanID), zap.Error(err))
+		}
+	}
+}
+
+// heartbeat renews the leases of the owned channels and releases idle ones. lost is called for channel
+// taken over by other instance, eg: this instance was not able to renew in time
+func (c *cluster) heartbeat(lost func(chanID string)) {
+	c.register()
+
+	c.mu.Lock()
+	chanIDs := make([]string, 0, len(c.owned))
+	var idle []string
+	for chanID := range c.owned {
+		if since, ok := c.idle[chanID]; ok && time.Since(since) > c.ttl {
+			c.forget(chanID)
+			idle = append(idle, chanID)
+			continue
+		}
+		chanIDs = append(chanIDs, chanID)
+	}
+	for chanID, other := range c.others {
+		if time.Now().After(other.until) {
+			delete(c.others, chanID)
+		}
+	}
+	c.mu.Unlock()
+	c.release(idle...)
+
+	for _, chanID := range chanIDs {
+		renewed, err := fam100.DefaultDB.RenewLease(leaseName(chanID), c.id, c.ttl)
+		if err != nil {
+			log.Error("renewing channel lease failed", zap.String("chanID", chanID), zap.Error(err))
+			continue
+		}
+		if renewed {
+			continue
+		}
+		c.mu.Lock()
+		delete(c.owned, chanID)
+		delete(c.idle, chanID)
+		c.mu.Unlock()
+		clusterLostCount.Inc(1)
+		log.Warn("channel lease lost", zap.String("chanID", chanID), zap.String("instance", c.id))
+		lost(chanID)
+	}
+	clusterOwnedTotal.Update(int64(c.ownedCount()))
+}
+
+func (c *cluster) isReceiving() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.receiving
+}
+
+func (c *cluster) ownedCount() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.owned)
+}
+
+// orphans acquires the channels with a game snapshot whose owner is gone and that are picked for this
+// instance, the games are resumed without waiting for an update of the channel
+func (c *cluster) orphans() []string {
+	chanIDs, err := fam100.DefaultDB.SnapshotChannels()
+	if err != nil {
+		log.Error("loading game snapshots failed", zap.Error(err))
+		return nil
+	}
+	var acquired []string
+	for _, chanID := range chanIDs {
+		c.mu.Lock()
+		owned := c.owned[chanID]
+		c.mu.Unlock()
+		if !owned && c.owns(chanID) {
+			acquired = append(acquired, chanID)
+		}
+	}
+	return acquired
+}
+
+// releaseAll gives up all leases so other instances take over immediately, used on shutdown. Free channels
+// are not picked for this instance anymore
+func (c *cluster) releaseAll() {
+	c.mu.Lock()
+	chanIDs := make([]string, 0, len(c.owned))
+	for chanID := range c.owned {
+		c.forget(chanID)
+		chanIDs = append(chanIDs, chanID)
+	}
+	c.receiving = false
+	c.mu.Unlock()
+
+	c.release(chanIDs...)
+	if err := fam100.DefaultDB.ReleaseLease(receiverLease, c.id); err != nil {
+		log.Error("releasing receiver lease failed", zap.String("instance", c.id), zap.Error(err))
+	}
+	if err := fam100.DefaultDB.UnregisterInstance(c.id); err != nil {
+		log.Error("unregistering instance failed", zap.String("instance", c.id), zap.Error(err))
+	}
+}
+
+// waitReceiver blocks until this instance holds the receiver lease and keeps renewing it until quit is
+// closed, false when quit is closed before. An instance waiting takes over once the lease of the receiver
+// expires, eg: the receiver died
+func (c *cluster) waitReceiver(quit chan struct{}) bool {
+	ticker := time.NewTicker(c.ttl / 3)
+	defer ticker.Stop()
+	for {
+		holder, err := fam100.DefaultDB.AcquireLease(receiverLease, c.id, c.ttl)
+		if err != nil {
+			log.Error("acquiring receiver lease failed", zap.String("instance", c.id), zap.Error(err))
+		}
+		if holder == c.id {
+			break
+		}
+		select {
+		case <-quit:
+			return false
+		case <-ticker.C:
+		}
+	}
+	c.mu.Lock()
+	c.receiving = true
+	c.mu.Unlock()
+	log.Info("receiving updates from telegram", zap.String("instance", c.id))
+	go c.renewReceiver(quit)
+
+	return true
+}
+
+// renewReceiver keeps the receiver lease until it is released by releaseAll. Once it is lost other instance
+// receives the updates and this one exits, telegram does not deliver the updates to two receivers
+func (c *cluster) renewReceiver(quit chan struct{}) {
+	ticker := time.NewTicker(c.ttl / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-quit:
+			return
+		case <-ticker.C:
+		}
+		if !c.isReceiving() {
+			return
+		}
+		renewed, err := fam100.DefaultDB.RenewLease(receiverLease, c.id, c.ttl)
+		if err != nil {
+			log.Error("renewing receiver lease failed", zap.String("instance", c.id), zap.Error(err))
+			continue
+		}
+		if !renewed && c.isReceiving() {
+			log.Fatal("receiver lease lost", zap.String("instance", c.id))
+		}
+	}
+}
+
+// newForwardedUpdate wraps the update received from telegram, false for the update that is not forwarded
+func newForwardedUpdate(rawMsg interface{}) (forwardedUpdate, bool) {
+	switch msg := rawMsg.(type) {
+	case *bot.Message:
+		return forwardedUpdate{Message: msg}, true
+	case *bot.CallbackQuery:
+		return forwardedUpdate{Callback: msg}, true
+	case *bot.ChannelMigratedMessage:
+		return forwardedUpdate{Migrated: msg}, true
+	}
+	return forwardedUpdate{}, false
+}
+
+// update returns the update to dispatch to the shard
+func (u forwardedUpdate) update() interface{} {
+	switch {
+	case u.Message != nil:
+		return u.Message
+	case u.Callback != nil:
+		return u.Callback
+	case u.Migrated != nil:
+		return u.Migrated
+	}
+	return nil
+}
+
+// receiveForwarded handles the updates forwarded by other instances until the bot is stopped. The lease is
+// checked again in the db, the update is forwarded again if the channel changed hands in the meantime
+func (b *fam100Bot) receiveForwarded() {
+	for {
+		select {
+		case <-b.quit:
+			return
+		default:
+		}
+		data, err := fam100.DefaultDB.PopUpdate(b.cluster.id, time.Second)
+		if err != nil {
+			log.Error("receiving forwarded update failed", zap.Error(err))
+			time.Sleep(time.Second)
+			continue
+		}
+		if data == nil {
+			continue
+		}
+		var u forwardedUpdate
+		if err := json.Unmarshal(data, &u); err != nil || u.update() == nil {
+			log.Error("invalid forwarded update", zap.String("update", string(data)), zap.Error(err))
+			continue
+		}
+		rawMsg := u.update()
+		chanID := updateChanID(rawMsg)
+		owner := b.cluster.owner(chanID, true)
+		switch {
+		case owner == b.cluster.id:
+			clusterReceiveCount.Inc(1)
+			b.dispatch(chanID, rawMsg)
+		case owner != "" && u.Hops < maxForwardHops:
+			u.Hops++
+			b.cluster.forward(owner, u)
+		default:
+			clusterDroppedCount.Inc(1)
+			log.Warn("forwarded update dropped", zap.String("chanID", chanID), zap.String("owner", owner), zap.Int("hops", u.Hops))
+		}
+	}
+}
+
+// runHeartbeat renews the leases until the bot is stopped
+func (b *fam100Bot) runHeartbeat() {
+	b.cluster.register()
+	ticker := time.NewTicker(b.cluster.ttl / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-b.quit:
+			return
+		case <-ticker.C:
+			b.cluster.heartbeat(func(chanID string) {
+				// the new owner continues the game from its snapshot, stop handling it here
+				s := b.shard(chanID)
+				b.callShard(s, func() {
+					if ch, ok := s.channels[chanID]; ok {
+						if ch.started {
+							ch.game.Abort()
+						} else {
+							ch.game.Cancel()
+						}
+						delete(s.channels, chanID)
+					}
+				})
+			})
+			for _, chanID := range b.cluster.orphans() {
+				chanID, s := chanID, b.shard(chanID)
+				b.callShard(s, func() { s.resumeGame(chanID) })
+			}
+		}
+	}
+}
+
+// resumeGame continues the game of a channel taken over from another instance
+func (b *fam100Bot) resumeGame(chanID string) {
+	if !b.cluster.takeResume(chanID) {
+		return
+	}
+	if _, ok := b.channels[chanID]; ok {
+		return
+	}
+	snapshot, err := fam100.DefaultDB.GameSnapshot(chanID)
+	if err != nil {
+		log.Error("loading game snapshot failed", zap.String("chanID", chanID), zap.Error(err))
+		return
+	}
+	if snapshot == nil {
+		return
+	}
+
+	gameIn := make(chan fam100.Message, gameInBufferSize)
+	game := fam100.ResumeGame(*snapshot, gameIn, b.gameOut)
+	ch := &channel{ID: chanID, game: game, quorumPlayer: make(map[string]bool), players: make(map[string]string)}
+	for _, p := range snapshot.Players {
+		ch.quorumPlayer[string(p.ID)] = true
+		ch.players[string(p.ID)] = p.Name
+	}
+	b.channels[chanID] = ch
+	b.cluster.setActive(chanID, true)
+	b.startGame(ch)
+	gameResumedCount.Inc(1)
+
+	text := fmt.Sprintf(fam100.T("Game (id: %d) dilanjutkan setelah ronde %d"), snapshot.ID, snapshot.Round)
+	b.out <- bot.Message{Chat: bot.Chat{ID: chanID}, Text: text, Format: bot.HTML}
+	log.Info("Game resumed from snapshot", zap.String("chanID", chanID), zap.Int64("gameID", snapshot.ID), zap.Int("round", snapshot.Round))
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/uber-go/zap"
+	"github.com/yulrizka/bot"
+	"github.com/yulrizka/fam100"
+)
+
+func TestClusterFailover(t *testing.T) {
+	oShardCount, oMinQuorum, oDB, oLobbyGrace := shardCount, minQuorum, fam100.DefaultDB, lobbyGrace
+	defer func() {
+		shardCount, minQuorum, fam100.DefaultDB, lobbyGrace = oShardCount, oMinQuorum, oDB, oLobbyGrace
+	}()
+	shardCount, minQuorum, fam100.DefaultDB, lobbyGrace = 2, 2, &fam100.MemoryDB{}, 0
+	log = logger{zap.New(zap.NewJSONEncoder(), zap.FatalLevel+1)}
+	fam100.SetLogger(log)
+
+	ttl := 150 * time.Millisecond
+	newInstance := func(id string) (*fam100Bot, chan interface{}, chan bot.Message) {
+		b := &fam100Bot{cluster: newCluster(id, ttl)}
+		out := make(chan bot.Message, 100)
+		in, err := b.Init(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b.start()
+		return b, in, out
+	}
+	// a receives every update and forwards the ones of the channels given to b
+	a, aIn, aOut := newInstance("a")
+	defer a.stop()
+	b, _, _ := newInstance("b")
+	members := func(c *cluster) int {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return len(c.members)
+	}
+	waitFor(t, "instances registered", func() bool { return members(a.cluster) == 2 && members(b.cluster) == 2 })
+	var chanID string
+	for i := 0; chanID == ""; i++ {
+		id := fmt.Sprintf("clusterChan%d", i)
+		a.cluster.mu.Lock()
+		if a.cluster.pick(id) == "b" {
+			chanID = id
+		}
+		a.cluster.mu.Unlock()
+	}
+
+	for _, id := range []string{"c1", "c2"} {
+		aIn <- &bot.Message{From: bot.User{ID: id, FirstName: id}, Chat: bot.Chat{ID: chanID, Type: bot.Group}, Text: "/join", Date: time.Now()}
+	}
+	waitFor(t, "game on instance b", func() bool { return b.gameCount() == 1 })
+	waitFor(t, "snapshot saved", func() bool {
+		s, _ := fam100.DefaultDB.GameSnapshot(chanID)
+		return s != nil
+	})
+	if want, got := 0, a.gameCount(); want != got {
+		t.Fatalf("instance a games want %d got %d", want, got)
+	}
+
+	// heartbeat keeps the lease longer than the ttl
+	time.Sleep(2 * ttl)
+	if holder, _ := fam100.DefaultDB.LeaseHolder(leaseName(chanID)); holder != "b" {
+		t.Fatalf("lease should be renewed by b, holder %s", holder)
+	}
+
+	// b dies without releasing, a takes over after the lease expired and continues the game without waiting
+	// for an update of the channel
+	b.stop()
+	waitFor(t, "game resumed on instance a", func() bool { return a.gameCount() == 1 })
+	if holder, _ := fam100.DefaultDB.LeaseHolder(leaseName(chanID)); holder != "a" {
+		t.Fatalf("lease should be taken over by a, holder %s", holder)
+	}
+
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case msg := <-aOut:
+			if strings.Contains(msg.Text, "dilanjutkan") {
+				return
+			}
+		case <-timeout:
+			t.Fatal("timeout waiting resumed message")
+		}
+	}
+}
+
+func TestClusterReceiverElection(t *testing.T) {
+	oDB := fam100.DefaultDB
+	defer func() { fam100.DefaultDB = oDB }()
+	fam100.DefaultDB = &fam100.MemoryDB{}
+	log = logger{zap.New(zap.NewJSONEncoder(), zap.FatalLevel+1)}
+	fam100.SetLogger(log)
+
+	ttl := 150 * time.Millisecond
+	a, b := newCluster("a", ttl), newCluster("b", ttl)
+	aQuit, bQuit := make(chan struct{}), make(chan struct{})
+	defer close(aQuit)
+	defer close(bQuit)
+	if !a.waitReceiver(aQuit) {
+		t.Fatal("a should be the receiver")
+	}
+	elected := make(chan bool, 1)
+	go func() { elected <- b.waitReceiver(bQuit) }()
+
+	// the lease of a is renewed, b keeps waiting
+	time.Sleep(2 * ttl)
+	select {
+	case <-elected:
+		t.Fatal("b should wait while a is the receiver")
+	default:
+	}
+
+	// a shuts down, b takes over
+	a.releaseAll()
+	select {
+	case ok := <-elected:
+		if !ok {
+			t.Fatal("b should be the receiver")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting b to be elected")
+	}
+	if holder, _ := fam100.DefaultDB.LeaseHolder(receiverLease); holder != "b" {
+		t.Errorf("receiver lease holder want b got %s", holder)
+	}
+}
@@ -4,10 +4,12 @@ import (
 	"bufio"
 	"bytes"
 	"fmt"
+	"net/url"
 	"regexp"
 	"strings"
 	"time"
 
+	"github.com/patrickmn/go-cache"
 	"github.com/uber-go/zap"
 	"github.com/yulrizka/bot"
 	"github.com/yulrizka/fam100"
@@ -18,6 +20,9 @@ var cmdRateDelay = 30 * time.Second
 
 var lastCmdRequest = make(map[string]time.Time)
 
+// chatAdminCache caches chat administrator lookup
+var chatAdminCache = cache.New(5*time.Minute, 10*time.Minute)
+
 // handleJoin handles "/join". Create game and start it if quorum
 func (b *fam100Bot) cmdJoin(msg *bot.Message) bool {
 	defer cmdJoinTimer.UpdateSince(time.Now())
@@ -25,10 +30,19 @@ func (b *fam100Bot) cmdJoin(msg *bot.Message) bool {
 	if b.handleDisabled(msg) {
 		return true
 	}
+	if isBanned(msg.Chat.ID, msg.From.ID) {
+		// no reply, a banned player should not be able to make the bot spam the group
+		return true
+	}
 
 	commandJoinCount.Inc(1)
 	chanID := msg.Chat.ID
 	chanName := msg.Chat.Title
+	if ok, t := isFinalist(chanID, msg.From.ID); !ok {
+		text := fmt.Sprintf(fam100.T("Hanya finalis turnamen %s yang bisa /join"), t.Name)
+		b.out <- bot.Message{Chat: bot.Chat{ID: chanID}, Text: text, Format: bot.HTML, DiscardAfter: time.Now().Add(5 * time.Second)}
+		return true
+	}
 	ch, ok := b.channels[chanID]
 	if !ok {
 		playerJoinedCount.Inc(1)
@@ -43,14 +57,16 @@ func (b *fam100Bot) cmdJoin(msg *bot.Message) bool {
 			return true
 		}
 
-		ch := &channel{ID: chanID, game: game, quorumPlayer: quorumPlayer, players: players}
+		ch := &channel{ID: chanID, game: game, quorumPlayer: quorumPlayer, players: players, initiator: msg.From.ID, joined: []string{msg.From.ID}}
 		b.channels[chanID] = ch
-		if len(ch.quorumPlayer) == minQuorum {
-			ch.game.Start()
+		b.cluster.setActive(chanID, true)
+		logJoinEvent(ch, msg)
+		b.postLobby(ch)
+		if len(ch.quorumPlayer) >= minQuorum {
+			b.quorumReached(ch)
 			return true
 		}
 		ch.startQuorumTimer(quorumWait, b.out)
-		ch.startQuorumNotifyTimer(5*time.Second, b.out)
 		log.Info("User joined", zap.String("playerID", msg.From.ID), zap.String("chanID", chanID))
 		return true
 	}
@@ -61,25 +77,108 @@ func (b *fam100Bot) cmdJoin(msg *bot.Message) bool {
 
 	// new player joined
 	playerJoinedCount.Inc(1)
-	ch.cancelTimer()
+	if ch.scheduled {
+		// scheduled game starts when the join window is closed
+		ch.quorumPlayer[msg.From.ID] = true
+		ch.players[msg.From.ID] = msg.From.FullName()
+		if ch.game.ChanName == "" {
+			ch.game.ChanName = chanName
+		}
+		logJoinEvent(ch, msg)
+		return true
+	}
 	ch.quorumPlayer[msg.From.ID] = true
 	ch.players[msg.From.ID] = msg.From.FullName()
-	if len(ch.quorumPlayer) == minQuorum {
-		if ch.cancelNotifyTimer != nil {
-			ch.cancelNotifyTimer()
-		}
-		ch.game.Start()
+	ch.joined = append(ch.joined, msg.From.ID)
+	logJoinEvent(ch, msg)
+	if len(ch.quorumPlayer) >= minQuorum {
+		b.quorumReached(ch)
 		return true
 	}
-	ch.startQuorumTimer(quorumWait, b.out)
-	if ch.cancelNotifyTimer == nil {
-		ch.startQuorumNotifyTimer(5*time.Second, b.out)
+	if ch.cancelTimer != nil {
+		ch.cancelTimer()
 	}
+	ch.startQuorumTimer(quorumWait, b.out)
+	b.updateLobby(ch)
 	log.Info("User joined", zap.String("playerID", msg.From.ID), zap.String("chanID", chanID))
 
 	return true
 }
 
+// cmdLeave handles "/leave" removes the player from the game that has not started
+func (b *fam100Bot) cmdLeave(msg *bot.Message) bool {
+	defer cmdLeaveTimer.UpdateSince(time.Now())
+
+	ch, ok := b.channels[msg.Chat.ID]
+	if !ok || ch.started || !ch.quorumPlayer[msg.From.ID] {
+		return true
+	}
+	commandLeaveCount.Inc(1)
+	if ch.lobby != nil {
+		b.leave(ch, msg.From.ID)
+		return true
+	}
+
+	// scheduled game has no lobby, it's started or cancelled when the join window is closed
+	delete(ch.quorumPlayer, msg.From.ID)
+	delete(ch.players, msg.From.ID)
+	text := fmt.Sprintf(fam100.T("%s keluar dari permainan"), escape(msg.From.FullName()))
+	b.out <- bot.Message{Chat: bot.Chat{ID: msg.Chat.ID}, Text: text, Format: bot.HTML, DiscardAfter: time.Now().Add(5 * time.Second)}
+
+	return true
+}
+
+// cmdStop handles "/stop" for chat admin, a running game ends with the score so far
+func (b *fam100Bot) cmdStop(msg *bot.Message) bool {
+	defer cmdStopTimer.UpdateSince(time.Now())
+
+	chanID := msg.Chat.ID
+	ch, ok := b.channels[chanID]
+	if !ok || !b.isChatAdmin(chanID, msg.From.ID, msg) {
+		return true
+	}
+	commandStopCount.Inc(1)
+	text := fmt.Sprintf(fam100.T("Permainan dihentikan oleh %s"), escape(msg.From.FullName()))
+	log.Info("Game stopped", zap.String("chanID", chanID), zap.String("userID", msg.From.ID), zap.Bool("started", ch.started))
+	switch {
+	case ch.started:
+		// the game sends the final score and the channel is freed once it is finished
+		ch.game.Stop()
+	case ch.lobby != nil:
+		b.cancelLobby(ch, text)
+		return true
+	default:
+		if ch.cancelTimer != nil {
+			ch.cancelTimer()
+		}
+		ch.game.Cancel()
+		delete(b.channels, chanID)
+		b.cluster.setActive(chanID, false)
+	}
+	b.out <- bot.Message{Chat: bot.Chat{ID: chanID}, Text: text, Format: bot.HTML}
+
+	return true
+}
+
+// commandName returns the command without the bot name, eg: "/schedule@fam100bot list" -> "/schedule"
+func commandName(text, botName string) string {
+	fields := strings.Fields(text)
+	if len(fields) == 0 {
+		return ""
+	}
+	return strings.TrimSuffix(fields[0], "@"+botName)
+}
+
+func logJoinEvent(ch *channel, msg *bot.Message) {
+	fam100.LogEvent(fam100.Event{
+		Type:       fam100.EventPlayerJoined,
+		ChanID:     ch.ID,
+		GameID:     ch.game.ID,
+		PlayerID:   fam100.PlayerID(msg.From.ID),
+		PlayerName: msg.From.FullName(),
+	})
+}
+
 func (b *fam100Bot) cmdHelp(msg *bot.Message) bool {
 	defer cmdHelpTimer.UpdateSince(time.Now())
 
@@ -117,12 +216,130 @@ func (b *fam100Bot) cmdScore(msg *bot.Message) bool {
 	}
 
 	text := "<b>Top Score:</b>\n" + formatRankText(rank)
-	text += fmt.Sprintf("\n<a href=\"http://labs.yulrizka.com/fam100/scores.html?c=%s\">Full Score</a>", chanID)
+	if link := channelScoreURL(chanID); link != "" {
+		text += fmt.Sprintf("\n<a href=\"%s\">Full Score</a>", link)
+	}
 	b.out <- bot.Message{Chat: bot.Chat{ID: chanID}, Text: text, Format: bot.HTML, DiscardAfter: time.Now().Add(20 * time.Second)}
 
 	return true
 }
 
+// cmdMe handles "/me" reply privately with player profile across all channels
+func (b *fam100Bot) cmdMe(msg *bot.Message) bool {
+	defer cmdMeTimer.UpdateSince(time.Now())
+
+	if rateLimited("me", msg.From.ID, cmdRateDelay) {
+		return true
+	}
+
+	commandMeCount.Inc(1)
+	playerID := fam100.PlayerID(msg.From.ID)
+	stats, err := fam100.GetPlayerStats(playerID)
+	if err != nil {
+		log.Error("getting player stats failed", zap.String("playerID", msg.From.ID), zap.Error(err))
+		return true
+	}
+	if stats.Name == "" {
+		stats.Name = msg.From.FullName()
+	}
+
+	text := formatPlayerStatsText(stats)
+	b.out <- bot.Message{Chat: bot.Chat{ID: msg.From.ID}, Text: text, Format: bot.HTML}
+
+	return true
+}
+
+// cmdStats handles "/stats" show channel statistic to chat admin. In private chat with bot admin,
+// "/stats" shows global statistic and "/stats [chanID]" shows statistic of the channel
+func (b *fam100Bot) cmdStats(msg *bot.Message) bool {
+	defer cmdStatsTimer.UpdateSince(time.Now())
+
+	var stats fam100.ChannelStats
+	var err error
+	if msg.Chat.Type == bot.Private {
+		if !hasRole(msg.From.ID, fam100.RoleModerator) {
+			return true
+		}
+		if fields := strings.Fields(msg.Text); len(fields) > 1 {
+			stats, err = fam100.GetChannelStats(fields[1])
+		} else {
+			stats, err = fam100.GetGlobalStats()
+		}
+	} else {
+		if !b.isChatAdmin(msg.Chat.ID, msg.From.ID, msg) || rateLimited("stats", msg.Chat.ID, cmdRateDelay) {
+			return true
+		}
+		stats, err = fam100.GetChannelStats(msg.Chat.ID)
+	}
+	commandStatsCount.Inc(1)
+	if err != nil {
+		log.Error("getting stats failed", zap.String("chanID", msg.Chat.ID), zap.Error(err))
+		b.out <- bot.Message{Chat: bot.Chat{ID: msg.Chat.ID}, Text: "stats failed. " + err.Error(), Format: bot.Text}
+		return true
+	}
+
+	text := formatChannelStatsText(stats)
+	if stats.ChanID == "" {
+		channelCount, _ := fam100.DefaultDB.ChannelCount()
+		text += fmt.Sprintf(fam100.T("Jumlah channel: %d\nGame aktif: %d\n"), channelCount, b.gameCount())
+	}
+	b.out <- bot.Message{Chat: bot.Chat{ID: msg.Chat.ID}, Text: text, Format: bot.HTML}
+
+	return true
+}
+
+// isChatAdmin returns true if user is the administrator of the chat or a bot moderator. The telegram lookup
+// does not block the shard, on a cache miss it returns false and retry is handled again by the shard once
+// the lookup is cached. A failed lookup is told to the chat instead
+func (b *fam100Bot) isChatAdmin(chatID, userID string, retry interface{}) bool {
+	admin, _ := b.chatAdmin(chatID, userID, retry)
+	return admin
+}
+
+// chatAdmin is isChatAdmin with ok false while the lookup is pending
+func (b *fam100Bot) chatAdmin(chatID, userID string, retry interface{}) (admin, ok bool) {
+	if hasRole(userID, fam100.RoleModerator) {
+		return true, true
+	}
+	if b.client == nil {
+		return false, true
+	}
+
+	key := chatID + ":" + userID
+	if admin, ok := chatAdminCache.Get(key); ok {
+		return admin.(bool), true
+	}
+	go func() {
+		member, err := b.client.Member(chatID, userID)
+		if err != nil {
+			log.Error("getting chat member failed", zap.String("chanID", chatID), zap.String("userID", userID), zap.Error(err))
+			// not cached, the next command looks it up again
+			text := fam100.T("Gagal memeriksa admin group, coba lagi nanti")
+			select {
+			case b.out <- bot.Message{Chat: bot.Chat{ID: chatID}, Text: text, Format: bot.HTML, DiscardAfter: time.Now().Add(5 * time.Second)}:
+			case <-b.quit:
+			}
+			return
+		}
+		admin := member.Status == "creator" || member.Status == "administrator"
+		chatAdminCache.Set(key, admin, cache.DefaultExpiration)
+		select {
+		case b.in <- retry:
+		case <-b.quit:
+		}
+	}()
+
+	return false, false
+}
+
+// channelScoreURL returns the page of the channel on the score site, empty if it's not configured
+func channelScoreURL(chanID string) string {
+	if scoreURL == "" {
+		return ""
+	}
+	return strings.TrimSuffix(scoreURL, "/") + "/" + url.PathEscape(fam100.ChannelFileName(chanID)) + ".html"
+}
+
 func (b *fam100Bot) handleDisabled(msg *bot.Message) bool {
 	chanID := msg.Chat.ID
 	disabledMsg, _ := fam100.DefaultDB.ChannelConfig(chanID, "disabled", "")
@@ -168,13 +385,14 @@ func formatRankText(rank fam100.Rank) string {
 	fmt.Fprintf(w, "\n")
 	lastPos := 0
 	if len(rank) == 0 {
-		fmt.Fprintf(w, fam100.T("Tidak ada\n"))
+		fmt.Fprint(w, fam100.T("Tidak ada\n"))
 	} else {
 		for _, ps := range rank {
 			if lastPos != 0 && lastPos+1 != ps.Position {
 				fmt.Fprintf(w, "...\n")
 			}
-			fmt.Fprintf(w, "%d. (%2d) %s\n", ps.Position, ps.Score, ps.Name)
+			// names saved before the sanitizer was configured
+			fmt.Fprintf(w, "%d. (%2d) %s\n", ps.Position, ps.Score, fam100.DefaultNameSanitizer.Player(ps.PlayerID, ps.Name))
 			lastPos = ps.Position
 		}
 	}
@@ -183,6 +401,63 @@ func formatRankText(rank fam100.Rank) string {
 	return escape(b.String())
 }
 
+func formatPlayerStatsText(s fam100.PlayerStats) string {
+	var b bytes.Buffer
+	w := bufio.NewWriter(&b)
+
+	name := fam100.DefaultNameSanitizer.Player(s.PlayerID, s.Name)
+	fmt.Fprintf(w, "<b>%s</b>\n", escape(name))
+	// former names are shown like the current one, replaced names end up as the same alias
+	var former []string
+	seen := map[string]bool{name: true}
+	for _, n := range s.FormerNames {
+		if n = fam100.DefaultNameSanitizer.Player(s.PlayerID, n); !seen[n] {
+			seen[n] = true
+			former = append(former, n)
+		}
+	}
+	if len(former) > 0 {
+		fmt.Fprintf(w, fam100.T("<i>sebelumnya dikenal sebagai %s</i>\n"), escape(strings.Join(former, ", ")))
+	}
+	if s.Score > 0 {
+		fmt.Fprintf(w, fam100.T("Total score: %d (peringkat %d)\n"), s.Score, s.Position+1)
+	} else {
+		fmt.Fprint(w, fam100.T("Total score: 0\n"))
+	}
+	fmt.Fprintf(w, fam100.T("Game dimainkan: %d\n"), s.GamePlayed)
+	fmt.Fprintf(w, fam100.T("Game dimenangkan: %d\n"), s.GameWon)
+	fmt.Fprintf(w, fam100.T("Ronde dimainkan: %d\n"), s.RoundPlayed)
+	fmt.Fprintf(w, fam100.T("Jawaban benar: %d\n"), s.AnswerCorrect)
+	fmt.Fprintf(w, fam100.T("Jawaban #1: %d\n"), s.AnswerTop)
+	fmt.Fprintf(w, fam100.T("Rata-rata waktu menjawab: %s\n"), s.AnswerTime)
+	fmt.Fprintf(w, fam100.T("Score terbaik dalam 1 game: %d\n"), s.BestScore)
+	w.Flush()
+
+	return b.String()
+}
+
+func formatChannelStatsText(s fam100.ChannelStats) string {
+	var b bytes.Buffer
+	w := bufio.NewWriter(&b)
+
+	if s.ChanID == "" {
+		fmt.Fprint(w, fam100.T("<b>Statistik global</b>\n"))
+	} else {
+		fmt.Fprint(w, fam100.T("<b>Statistik channel</b>\n"))
+	}
+	fmt.Fprintf(w, fam100.T("Game dimulai: %d\n"), s.GameStarted)
+	fmt.Fprintf(w, fam100.T("Game batal (pemain kurang): %d\n"), s.GameCancelled)
+	fmt.Fprintf(w, fam100.T("Ronde selesai: %d\n"), s.RoundFinished)
+	fmt.Fprintf(w, fam100.T("Ronde waktu habis: %d\n"), s.RoundTimeout)
+	fmt.Fprintf(w, fam100.T("Jumlah pemain: %d\n"), s.Players)
+	if s.ActiveHour >= 0 {
+		fmt.Fprintf(w, fam100.T("Jam paling aktif: %02d:00\n"), s.ActiveHour)
+	}
+	w.Flush()
+
+	return b.String()
+}
+
 func escape(s string) string {
 	s = strings.Replace(s, "&", "&amp;", -1)
 	s = strings.Replace(s, "<", "&lt;", -1)
@@ -253,26 +528,39 @@ func (b *fam100Bot) cmdChannels(msg *bot.Message) bool {
 	return true
 }
 
-// cmdBroadcast handles /broadcast [msg]. Broadcast message to all channels
-func (b *fam100Bot) cmdBroadcast(msg *bot.Message) bool {
-	fields := strings.SplitN(msg.Text, " ", 2)
-	if len(fields) < 2 || fields[1] == "" {
-		b.out <- bot.Message{Chat: bot.Chat{ID: msg.Chat.ID}, Text: "usage: `/broadcast [message]`", Format: bot.Markdown}
+// cmdMerge handles /merge [fromPlayerID] [toPlayerID]. Moves all scores of a player to another player
+func (b *fam100Bot) cmdMerge(msg *bot.Message) bool {
+	fields := strings.Fields(msg.Text)
+	if len(fields) != 3 || fields[1] == fields[2] {
+		b.out <- bot.Message{Chat: bot.Chat{ID: msg.Chat.ID}, Text: "usage: `/merge [fromPlayerID] [toPlayerID]`", Format: bot.Markdown}
 		return true
 	}
+	fromID, toID := fam100.PlayerID(fields[1]), fam100.PlayerID(fields[2])
+	for _, playerID := range []fam100.PlayerID{fromID, toID} {
+		stats, err := fam100.GetPlayerStats(playerID)
+		if err != nil {
+			log.Error("loading player failed", zap.String("playerID", string(playerID)), zap.Error(err))
+			b.out <- bot.Message{Chat: bot.Chat{ID: msg.Chat.ID}, Text: "merge failed. " + err.Error(), Format: bot.Text}
+			return true
+		}
+		if stats.Name == "" && stats.Score == 0 && stats.GamePlayed == 0 {
+			b.out <- bot.Message{Chat: bot.Chat{ID: msg.Chat.ID}, Text: fmt.Sprintf("unknown player %s", playerID), Format: bot.Text}
+			return true
+		}
+	}
 
-	channels, err := fam100.DefaultDB.Channels()
-	if err != nil {
-		b.out <- bot.Message{Chat: bot.Chat{ID: msg.Chat.ID}, Text: "channels failed. " + err.Error(), Format: bot.Markdown}
+	if err := fam100.DefaultDB.MergePlayer(fromID, toID); err != nil {
+		log.Error("merging player failed", zap.String("from", fields[1]), zap.String("to", fields[2]), zap.Error(err))
+		b.out <- bot.Message{Chat: bot.Chat{ID: msg.Chat.ID}, Text: "merge failed. " + err.Error(), Format: bot.Text}
+		return true
 	}
+	log.Info("Player merged", zap.String("from", fields[1]), zap.String("to", fields[2]), zap.String("by", msg.From.ID))
 
-	go func() {
-		text := fields[1]
-		for id := range channels {
-			b.out <- bot.Message{Chat: bot.Chat{ID: id}, Text: text, Format: bot.Text}
-			time.Sleep(1 * time.Second)
-		}
-	}()
+	text := fmt.Sprintf("player %s merged into %s", fromID, toID)
+	if stats, err := fam100.GetPlayerStats(toID); err == nil {
+		text += fmt.Sprintf(", total score %d", stats.Score)
+	}
+	b.out <- bot.Message{Chat: bot.Chat{ID: msg.Chat.ID}, Text: text, Format: bot.Text}
 
 	return true
 }
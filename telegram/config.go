@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/uber-go/zap"
+	"github.com/yulrizka/bot"
+	"github.com/yulrizka/fam100"
+)
+
+// cmdConfig handles "/config get|set|unset [chanID] [key] [value]" for admins. Without chanID the global
+// config is used, "get" without key shows every config. The role needed to change a key is in fam100.ConfigKeys
+func (b *fam100Bot) cmdConfig(msg *bot.Message) bool {
+	defer cmdConfigTimer.UpdateSince(time.Now())
+
+	reply := func(text string) {
+		b.out <- bot.Message{Chat: bot.Chat{ID: msg.Chat.ID}, Text: text, Format: bot.HTML}
+	}
+	usage := fmt.Sprintf("usage:\n/config get [chanID] [key]\n/config set [chanID] key value\n/config unset [chanID] key\nkeys: %v",
+		fam100.ConfigKeyNames())
+
+	_, rest := splitField(msg.Text)
+	action, rest := splitField(rest)
+	chanID, name, value := "", "", ""
+	if name, rest = splitField(rest); name != "" {
+		if _, ok := fam100.LookupConfigKey(name); !ok {
+			// not a key, it is the channel
+			chanID = name
+			name, rest = splitField(rest)
+		}
+	}
+	if action == "set" {
+		value = rest
+	}
+
+	scope := "global"
+	if chanID != "" {
+		scope = chanID
+	}
+	switch {
+	case action == "get" && rest == "":
+		text, err := formatConfigText(chanID, name)
+		if err != nil {
+			reply(escape(err.Error()))
+			return true
+		}
+		reply(text)
+		return true
+	case action == "set" && name != "" && value != "":
+	case action == "unset" && name != "" && rest == "":
+	default:
+		reply(escape(usage))
+		return true
+	}
+
+	if err := fam100.CheckConfig(name, value, chanID == ""); err != nil {
+		reply(escape(err.Error()))
+		return true
+	}
+	key, _ := fam100.LookupConfigKey(name)
+	if !hasRole(msg.From.ID, key.Role) {
+		reply(fmt.Sprintf("changing %s needs the <b>%s</b> role", escape(name), key.Role))
+		return true
+	}
+
+	var err error
+	switch {
+	case chanID == "" && action == "set":
+		err = fam100.DefaultDB.SetGlobalConfig(name, value)
+	case chanID == "":
+		err = fam100.DefaultDB.DeleteGlobalConfig(name)
+	case action == "set":
+		err = fam100.DefaultDB.SetChannelConfig(chanID, name, value)
+	default:
+		err = fam100.DefaultDB.DeleteChannelConfig(chanID, name)
+	}
+	if err != nil {
+		log.Error("changing config failed", zap.String("chanID", chanID), zap.String("key", name), zap.Error(err))
+		reply(escape("config failed. " + err.Error()))
+		return true
+	}
+	commandConfigCount.Inc(1)
+	log.Info("config updated", zap.String("chanID", chanID), zap.String("key", name), zap.String("value", value), zap.String("by", msg.From.ID))
+
+	if action == "unset" {
+		reply(fmt.Sprintf("%s of %s reset to the default", escape(name), escape(scope)))
+		return true
+	}
+	reply(fmt.Sprintf("%s of %s set to %s", escape(name), escape(scope), escape(value)))
+
+	return true
+}
+
+// formatConfigText shows the config of the channel, or the global config when chanID is empty.
+// A channel config that is not set shows the global value if there is one
+func formatConfigText(chanID, name string) (string, error) {
+	if _, ok := fam100.LookupConfigKey(name); name != "" && !ok {
+		return "", fam100.CheckConfig(name, "", false)
+	}
+	var text bytes.Buffer
+	if chanID == "" {
+		text.WriteString("<b>global config</b>\n")
+	} else {
+		fmt.Fprintf(&text, "<b>config of %s</b>\n", escape(chanID))
+	}
+	for _, key := range fam100.ConfigKeys {
+		if (name != "" && key.Name != name) || (chanID == "" && !key.Global) {
+			continue
+		}
+		var value, source string
+		var err error
+		if chanID != "" {
+			value, err = fam100.DefaultDB.ChannelConfig(chanID, key.Name, "")
+		}
+		if err == nil && value == "" && key.Global {
+			value, err = fam100.DefaultDB.GlobalConfig(key.Name, "")
+			if chanID != "" {
+				source = " (global)"
+			}
+		}
+		if err != nil {
+			return "", err
+		}
+		if value == "" {
+			value, source = "-", " (default)"
+		}
+		fmt.Fprintf(&text, "%s [%s]: %s%s\n", key.Name, key.Type, escape(value), source)
+	}
+
+	return text.String(), nil
+}
+
+// splitField returns the first word of s and the rest without the leading spaces
+func splitField(s string) (field, rest string) {
+	s = strings.TrimSpace(s)
+	if i := strings.IndexAny(s, " \n"); i >= 0 {
+		return s[:i], strings.TrimSpace(s[i+1:])
+	}
+	return s, ""
+}
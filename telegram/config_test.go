@@ -0,0 +1,64 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/uber-go/zap"
+	"github.com/yulrizka/bot"
+	"github.com/yulrizka/fam100"
+)
+
+func TestConfig(t *testing.T) {
+	oDB, oAdminID := fam100.DefaultDB, adminID
+	defer func() {
+		fam100.DefaultDB, adminID = oDB, oAdminID
+	}()
+	fam100.DefaultDB, adminID = &fam100.MemoryDB{}, "admin"
+	log = logger{zap.New(zap.NewJSONEncoder(), zap.FatalLevel+1)}
+	fam100.SetLogger(log)
+
+	b := &fam100Bot{}
+	out := make(chan bot.Message, 100)
+	in, err := b.Init(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.start()
+	defer b.stop()
+
+	admin := func(text string) {
+		in <- &bot.Message{From: bot.User{ID: "admin"}, Chat: bot.Chat{ID: "admin", Type: bot.Private}, Text: text, Date: time.Now()}
+	}
+
+	admin("/config")
+	waitText(t, out, "usage:")
+	admin("/config set motd selamat\ndatang")
+	waitText(t, out, "motd of global set to selamat\ndatang")
+	if motd, _ := fam100.DefaultDB.GlobalConfig("motd", ""); motd != "selamat\ndatang" {
+		t.Errorf("global motd want %q got %q", "selamat\ndatang", motd)
+	}
+	admin("/config set disabled maintenance")
+	waitText(t, out, "disabled can not be set globally")
+	admin("/config set -1 questionLimit 50")
+	waitText(t, out, "questionLimit of -1 set to 50")
+
+	admin("/config get -1")
+	msg := waitText(t, out, "<b>config of -1</b>")
+	for _, want := range []string{"questionLimit [int]: 50\n", "motd [string]: selamat\ndatang (global)\n", "disabled [string]: - (default)\n"} {
+		if !strings.Contains(msg.Text, want) {
+			t.Errorf("config want %q got %q", want, msg.Text)
+		}
+	}
+	admin("/config get -1 color")
+	waitText(t, out, "unknown config key")
+
+	admin("/config unset motd")
+	waitText(t, out, "motd of global reset to the default")
+	admin("/config get")
+	msg = waitText(t, out, "<b>global config</b>")
+	if want := "motd [string]: - (default)\n"; msg.Text != "<b>global config</b>\n"+want {
+		t.Errorf("global config want %q got %q", want, msg.Text)
+	}
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/yulrizka/bot"
+	"github.com/yulrizka/fam100"
+)
+
+// loadConfig configures the load generator
+type loadConfig struct {
+	Channels    int
+	Players     int           // players per channel
+	AnswerRate  float64       // answers per player per second
+	CorrectRate float64       // probability an answer is correct
+	Duration    time.Duration // how long messages are generated
+}
+
+// loadReport is the result of a load test
+type loadReport struct {
+	Config       loadConfig
+	Elapsed      time.Duration
+	Sent         int64 // messages accepted by the plugin inbox
+	Dropped      int64 // messages dropped because the plugin inbox is full
+	Received     int64 // messages sent by the plugin
+	GameStarted  int64
+	GameFinished int64
+	LatencyP50   time.Duration // from transport receiving a message until the game processed it
+	LatencyP99   time.Duration
+	HandleP99    time.Duration // handleInbox processing time of a message
+}
+
+var questionIDRe = regexp.MustCompile(`\[id: (\d+)\]`)
+
+// loadChannel is the state of a simulated chat seen by the fake transport
+type loadChannel struct {
+	mu      sync.Mutex
+	id      string
+	answers []string // answers of the current question, empty when no round is running
+	joined  int
+}
+
+// runLoadTest plugs a fake transport into the plugin and generates chat traffic from many channels
+func runLoadTest(cfg loadConfig) loadReport {
+	var sent, dropped, received int64
+	startedCount, finishedCount := gameStartedCount.Count(), gameFinishedCount.Count()
+
+	// simulated players join at once, start without waiting for more players
+	oLobbyGrace := lobbyGrace
+	lobbyGrace = 0
+	defer func() { lobbyGrace = oLobbyGrace }()
+
+	b := &fam100Bot{}
+	out := make(chan bot.Message, telegramInBufferSize)
+	in, _ := b.Init(out)
+	b.start()
+	defer b.stop()
+
+	channels := make(map[string]*loadChannel, cfg.Channels)
+	chanList := make([]*loadChannel, cfg.Channels)
+	for i := range chanList {
+		ch := &loadChannel{id: fmt.Sprintf("-%d", 1000000+i)}
+		chanList[i] = ch
+		channels[ch.id] = ch
+	}
+
+	// fake transport receiving messages sent by the plugin
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		var lastID int64
+		for {
+			select {
+			case <-done:
+				return
+			case msg := <-out:
+				atomic.AddInt64(&received, 1)
+				// telegram returns the ID of a new message, edited message keeps its ID
+				if msg.OnSent != nil {
+					id := msg.EditID
+					if id == "" {
+						lastID++
+						id = strconv.FormatInt(lastID, 10)
+					}
+					msg.OnSent(id)
+				}
+				ch, ok := channels[msg.Chat.ID]
+				if !ok {
+					continue
+				}
+				// round text has the question id, the players answer from the question db
+				if m := questionIDRe.FindStringSubmatch(msg.Text); m != nil {
+					q, err := fam100.GetQuestion(m[1])
+					if err != nil {
+						continue
+					}
+					answers := make([]string, len(q.Answers))
+					for i, a := range q.Answers {
+						answers[i] = a.Text[0]
+					}
+					ch.mu.Lock()
+					ch.answers = answers
+					ch.mu.Unlock()
+				}
+				if strings.Contains(msg.Text, fam100.T("Game selesai")) {
+					ch.mu.Lock()
+					ch.answers, ch.joined = nil, 0
+					ch.mu.Unlock()
+				}
+			}
+		}
+	}()
+
+	// generators, each owns part of the channels and sends on every tick
+	tick := 100 * time.Millisecond
+	perTick := cfg.AnswerRate * float64(cfg.Players) * tick.Seconds()
+	workers := runtime.NumCPU()
+	started := time.Now()
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			rnd := rand.New(rand.NewSource(int64(w)))
+			ticker := time.NewTicker(tick)
+			defer ticker.Stop()
+			for now := range ticker.C {
+				if now.Sub(started) > cfg.Duration {
+					return
+				}
+				for i := w; i < len(chanList); i += workers {
+					ch := chanList[i]
+					n := int(perTick)
+					if rnd.Float64() < perTick-float64(n) {
+						n++
+					}
+					for j := 0; j < n; j++ {
+						msg := ch.nextMessage(rnd, cfg)
+						select {
+						case in <- msg:
+							atomic.AddInt64(&sent, 1)
+						default:
+							atomic.AddInt64(&dropped, 1)
+						}
+					}
+				}
+			}
+		}(w)
+	}
+
+	time.Sleep(cfg.Duration + tick)
+	close(done)
+	wg.Wait()
+
+	latency := fam100Timer("game.latency.ns")
+	return loadReport{
+		Config:       cfg,
+		Elapsed:      time.Since(started),
+		Sent:         sent,
+		Dropped:      dropped,
+		Received:     received,
+		GameStarted:  gameStartedCount.Count() - startedCount,
+		GameFinished: gameFinishedCount.Count() - finishedCount,
+		LatencyP50:   time.Duration(latency.Percentile(0.5)),
+		LatencyP99:   time.Duration(latency.Percentile(0.99)),
+		HandleP99:    time.Duration(mainHandleMessageTimer.Percentile(0.99)),
+	}
+}
+
+// nextMessage returns join message until the channel has enough players, answer otherwise
+func (ch *loadChannel) nextMessage(rnd *rand.Rand, cfg loadConfig) *bot.Message {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+
+	msg := &bot.Message{
+		Chat:       bot.Chat{ID: ch.id, Type: bot.Group, Title: "load " + ch.id},
+		Date:       time.Now(),
+		ReceivedAt: time.Now(),
+	}
+	player := rnd.Intn(cfg.Players)
+	if ch.answers == nil && ch.joined < cfg.Players {
+		player = ch.joined
+		ch.joined++
+	}
+	msg.From = bot.User{ID: ch.id + "_" + strconv.Itoa(player), FirstName: "Player " + strconv.Itoa(player)}
+
+	switch {
+	case ch.answers == nil:
+		msg.Text = "/join"
+	case rnd.Float64() < cfg.CorrectRate:
+		msg.Text = ch.answers[rnd.Intn(len(ch.answers))]
+	default:
+		msg.Text = "salah " + strconv.Itoa(rnd.Int())
+	}
+
+	return msg
+}
+
+// fam100Timer returns timer registered by the fam100 package
+func fam100Timer(name string) metrics.Timer {
+	if t, ok := metrics.DefaultRegistry.Get(name).(metrics.Timer); ok {
+		return t.Snapshot()
+	}
+	return metrics.NilTimer{}
+}
+
+func (r loadReport) write(w io.Writer) {
+	secs := r.Elapsed.Seconds()
+	fmt.Fprintf(w, "channels: %d, players: %d, answer rate: %.2f/s, correct rate: %.2f, duration: %s\n",
+		r.Config.Channels, r.Config.Players, r.Config.AnswerRate, r.Config.CorrectRate, r.Config.Duration)
+	fmt.Fprintf(w, "inbound:  %d messages (%.0f/s), dropped %d (%.2f%%)\n", r.Sent, float64(r.Sent)/secs, r.Dropped, percent(r.Dropped, r.Sent+r.Dropped))
+	fmt.Fprintf(w, "outbound: %d messages (%.0f/s)\n", r.Received, float64(r.Received)/secs)
+	fmt.Fprintf(w, "games:    %d started, %d finished\n", r.GameStarted, r.GameFinished)
+	fmt.Fprintf(w, "latency:  p50 %s, p99 %s, handleInbox p99 %s\n", r.LatencyP50, r.LatencyP99, r.HandleP99)
+}
+
+func percent(n, total int64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(n) * 100 / float64(total)
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/uber-go/zap"
+	"github.com/yulrizka/fam100"
+)
+
+func TestLoadTest(t *testing.T) {
+	oDB, oMinQuorum := fam100.DefaultDB, minQuorum
+	defer func() {
+		fam100.DefaultDB, minQuorum = oDB, oMinQuorum
+	}()
+	fam100.DefaultDB, minQuorum = &fam100.MemoryDB{}, 3
+	log = logger{zap.New(zap.NewJSONEncoder(), zap.FatalLevel+1)}
+	fam100.SetLogger(log)
+
+	report := runLoadTest(loadConfig{Channels: 50, Players: 3, AnswerRate: 5, CorrectRate: 0.5, Duration: 500 * time.Millisecond})
+	if report.Sent == 0 {
+		t.Errorf("should send messages")
+	}
+	if report.Received == 0 {
+		t.Errorf("should receive messages")
+	}
+	if want, got := int64(50), report.GameStarted; want != got {
+		t.Errorf("game started want %d got %d", want, got)
+	}
+	if report.LatencyP99 <= 0 {
+		t.Errorf("latency should be measured, got %s", report.LatencyP99)
+	}
+
+	var buf bytes.Buffer
+	report.write(&buf)
+	if !strings.Contains(buf.String(), "channels: 50, players: 3") {
+		t.Errorf("unexpected report %s", buf.String())
+	}
+}
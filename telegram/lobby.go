@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/uber-go/zap"
+	"github.com/yulrizka/bot"
+	"github.com/yulrizka/fam100"
+)
+
+// lobbyGrace is the time players can still join after quorum is met, the initiator can start earlier.
+// Zero starts the game as soon as quorum is met
+var lobbyGrace time.Duration
+
+// callback data of the lobby buttons
+const (
+	lobbyJoin   = "lobby:join"
+	lobbyLeave  = "lobby:leave"
+	lobbyStart  = "lobby:start"
+	lobbyCancel = "lobby:cancel"
+)
+
+// lobby is the message of a game waiting for players, edited in place as players join and leave
+type lobby struct {
+	id      string // message ID, empty until the message is sent
+	pending bool   // changed before the message was sent, edited once the ID is known
+	closed  string // final text once the game is started or cancelled, shown without buttons
+}
+
+// callbackAnswerer is the chat client that answers inline keyboard buttons
+type callbackAnswerer interface {
+	AnswerCallbackQuery(id, text string) error
+}
+
+// postLobby sends the lobby message of a new game
+func (b *fam100Bot) postLobby(ch *channel) {
+	l := &lobby{}
+	ch.lobby = l
+	msg := ch.lobbyMessage()
+	msg.OnSent = func(id string) {
+		if id == "" {
+			return
+		}
+		b.callChannel(ch, func(s *fam100Bot) {
+			l.id = id
+			if l.pending {
+				l.pending = false
+				s.out <- ch.lobbyMessage()
+			}
+		})
+	}
+	b.out <- msg
+}
+
+// updateLobby edits the lobby message with the current players
+func (b *fam100Bot) updateLobby(ch *channel) {
+	if ch.lobby == nil {
+		return
+	}
+	if ch.lobby.id == "" {
+		ch.lobby.pending = true
+		return
+	}
+	b.out <- ch.lobbyMessage()
+}
+
+// closeLobby removes the buttons of the lobby and shows the text instead
+func (b *fam100Bot) closeLobby(ch *channel, text string) {
+	if ch.lobby == nil || ch.lobby.closed != "" {
+		return
+	}
+	ch.lobby.closed = text
+	b.updateLobby(ch)
+}
+
+func (c *channel) lobbyMessage() bot.Message {
+	msg := bot.Message{Chat: bot.Chat{ID: c.ID}, Format: bot.HTML, Retry: 3}
+	if c.lobby.id != "" {
+		msg.EditID = c.lobby.id
+	}
+	if c.lobby.closed != "" {
+		msg.Text = c.lobby.closed
+		return msg
+	}
+
+	var text bytes.Buffer
+	fmt.Fprintf(&text, fam100.T("<b>Lobby fam100</b>\nPemain (%d): %s\n"), len(c.joined), escape(c.playerNames()))
+	quorum := len(c.quorumPlayer) >= minQuorum
+	if quorum {
+		fmt.Fprintf(&text, fam100.T("Game dimulai dalam %s, %s bisa mulai sekarang"), lobbyGrace, escape(c.players[c.initiator]))
+	} else {
+		fmt.Fprintf(&text, fam100.T("Butuh %d orang lagi, tekan Join atau ketik /join"), minQuorum-len(c.quorumPlayer))
+	}
+	msg.Text = text.String()
+
+	msg.Keyboard = [][]bot.InlineButton{{{Text: "Join", Data: lobbyJoin}, {Text: "Leave", Data: lobbyLeave}}}
+	if quorum {
+		msg.Keyboard = append(msg.Keyboard, []bot.InlineButton{{Text: "Start now", Data: lobbyStart}})
+	}
+	msg.Keyboard = append(msg.Keyboard, []bot.InlineButton{{Text: "Cancel", Data: lobbyCancel}})
+
+	return msg
+}
+
+// playerNames returns names of the players in the order they joined
+func (c *channel) playerNames() string {
+	names := make([]string, 0, len(c.joined))
+	for _, id := range c.joined {
+		names = append(names, c.players[id])
+	}
+	return strings.Join(names, ", ")
+}
+
+// quorumReached starts the game after the grace period, players can still join until then
+func (b *fam100Bot) quorumReached(ch *channel) {
+	if ch.cancelTimer != nil {
+		ch.cancelTimer()
+	}
+	if lobbyGrace <= 0 {
+		b.startLobbyGame(ch)
+		return
+	}
+	if ch.graceTimer == nil {
+		start := func(s *fam100Bot) {
+			if s.channels[ch.ID] == ch && !ch.started {
+				s.startLobbyGame(ch)
+			}
+		}
+		ch.graceTimer = time.AfterFunc(lobbyGrace, func() { b.callChannel(ch, start) })
+	}
+	b.updateLobby(ch)
+}
+
+// startLobbyGame closes the lobby and starts the game
+func (b *fam100Bot) startLobbyGame(ch *channel) {
+	if ch.graceTimer != nil {
+		ch.graceTimer.Stop()
+		ch.graceTimer = nil
+	}
+	b.startGame(ch)
+	b.closeLobby(ch, fmt.Sprintf(fam100.T("<b>Lobby fam100</b>\nGame dimulai! Pemain: %s"), escape(ch.playerNames())))
+}
+
+// cancelLobby removes the game that has not started
+func (b *fam100Bot) cancelLobby(ch *channel, text string) {
+	if ch.cancelTimer != nil {
+		ch.cancelTimer()
+	}
+	if ch.graceTimer != nil {
+		ch.graceTimer.Stop()
+		ch.graceTimer = nil
+	}
+	ch.game.Cancel()
+	delete(b.channels, ch.ID)
+	b.cluster.setActive(ch.ID, false)
+	b.closeLobby(ch, text)
+}
+
+// leave removes the player from the game that has not started
+func (b *fam100Bot) leave(ch *channel, playerID string) {
+	delete(ch.quorumPlayer, playerID)
+	delete(ch.players, playerID)
+	for i, id := range ch.joined {
+		if id == playerID {
+			ch.joined = append(ch.joined[:i], ch.joined[i+1:]...)
+			break
+		}
+	}
+	if len(ch.joined) == 0 {
+		b.cancelLobby(ch, fam100.T("Permainan dibatalkan, semua pemain keluar"))
+		return
+	}
+	if ch.initiator == playerID {
+		ch.initiator = ch.joined[0]
+	}
+	if len(ch.quorumPlayer) < minQuorum && ch.graceTimer != nil {
+		// not enough players anymore, wait for quorum again
+		ch.graceTimer.Stop()
+		ch.graceTimer = nil
+		ch.startQuorumTimer(quorumWait, b.out)
+	}
+	b.updateLobby(ch)
+}
+
+// handleCallback handles the lobby buttons
+func (b *fam100Bot) handleCallback(q *bot.CallbackQuery) {
+	defer mainHandleCallbackTimer.UpdateSince(time.Now())
+	callbackQueryCount.Inc(1)
+
+	answer, pending := "", false
+	defer func() {
+		if !pending {
+			b.answerCallback(q.ID, answer)
+		}
+	}()
+
+	if q.Message == nil {
+		return
+	}
+	chanID := q.Message.Chat.ID
+	ch, ok := b.channels[chanID]
+	if !ok || ch.lobby == nil || ch.lobby.id != q.Message.ID || ch.lobby.closed != "" {
+		answer = fam100.T("Permainan sudah tidak aktif")
+		return
+	}
+
+	userID := q.From.ID
+	switch q.Data {
+	case lobbyJoin:
+		if ch.quorumPlayer[userID] {
+			answer = fam100.T("Kamu sudah join")
+			return
+		}
+		if isBanned(chanID, userID) {
+			answer = fam100.T("Kamu diblokir dari permainan di grup ini")
+			return
+		}
+		b.cmdJoin(&bot.Message{From: q.From, Chat: q.Message.Chat, Text: "/join", ReceivedAt: q.ReceivedAt})
+
+	case lobbyLeave:
+		if !ch.quorumPlayer[userID] {
+			answer = fam100.T("Kamu belum join")
+			return
+		}
+		b.leave(ch, userID)
+
+	case lobbyStart:
+		if userID != ch.initiator {
+			answer = fmt.Sprintf(fam100.T("Hanya %s yang bisa mulai"), ch.players[ch.initiator])
+			return
+		}
+		if len(ch.quorumPlayer) < minQuorum {
+			answer = fmt.Sprintf(fam100.T("Butuh %d orang lagi"), minQuorum-len(ch.quorumPlayer))
+			return
+		}
+		b.startLobbyGame(ch)
+
+	case lobbyCancel:
+		if userID != ch.initiator {
+			admin, ok := b.chatAdmin(chanID, userID, q)
+			if !ok {
+				// answered when the callback is handled again after the lookup
+				pending = true
+				return
+			}
+			if !admin {
+				answer = fam100.T("Hanya yang memulai atau admin yang bisa membatalkan")
+				return
+			}
+		}
+		b.cancelLobby(ch, fmt.Sprintf(fam100.T("Permainan dibatalkan oleh %s"), escape(q.From.FullName())))
+		log.Info("Game cancelled", zap.String("chanID", chanID), zap.String("userID", userID))
+
+	default:
+		answer = fam100.T("Permainan sudah tidak aktif")
+	}
+}
+
+// answerCallback stops the progress of the button without blocking the shard
+func (b *fam100Bot) answerCallback(id, text string) {
+	answerer, ok := b.client.(callbackAnswerer)
+	if !ok {
+		return
+	}
+	go func() {
+		if err := answerer.AnswerCallbackQuery(id, text); err != nil {
+			log.Error("answering callback query failed", zap.Error(err))
+		}
+	}()
+}
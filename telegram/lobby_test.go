@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/uber-go/zap"
+	"github.com/yulrizka/bot"
+	"github.com/yulrizka/fam100"
+)
+
+func keyboardData(msg bot.Message) []string {
+	var data []string
+	for _, row := range msg.Keyboard {
+		for _, button := range row {
+			data = append(data, button.Data)
+		}
+	}
+	return data
+}
+
+// waitLobbySent simulates telegram returning the ID of the sent lobby message
+func waitLobbySent(t *testing.T, b *fam100Bot, msg bot.Message, id string) {
+	msg.OnSent(id)
+	s := b.shard(msg.Chat.ID)
+	waitFor(t, "lobby message ID", func() bool {
+		sent := make(chan bool)
+		s.call <- func() {
+			ch, ok := s.channels[msg.Chat.ID]
+			sent <- ok && ch.lobby.id == id
+		}
+		return <-sent
+	})
+}
+
+func TestLobby(t *testing.T) {
+	oMinQuorum, oLobbyGrace, oDB := minQuorum, lobbyGrace, fam100.DefaultDB
+	defer func() {
+		minQuorum, lobbyGrace, fam100.DefaultDB = oMinQuorum, oLobbyGrace, oDB
+	}()
+	minQuorum, lobbyGrace, fam100.DefaultDB = 2, time.Hour, &fam100.MemoryDB{}
+	log = logger{zap.New(zap.NewJSONEncoder(), zap.FatalLevel+1)}
+	fam100.SetLogger(log)
+
+	b := &fam100Bot{}
+	out := make(chan bot.Message, 100)
+	in, err := b.Init(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.start()
+	defer b.stop()
+
+	chat := bot.Chat{ID: "lobbyChan", Type: bot.Group}
+	p1, p2 := bot.User{ID: "p1", FirstName: "P1"}, bot.User{ID: "p2", FirstName: "P2"}
+	press := func(from bot.User, messageID, data string) {
+		in <- &bot.CallbackQuery{ID: "q", From: from, Message: &bot.Message{ID: messageID, Chat: chat}, Data: data}
+	}
+
+	in <- &bot.Message{From: p1, Chat: chat, Text: "/join", Date: time.Now()}
+	msg := waitText(t, out, "Lobby fam100")
+	if msg.EditID != "" || msg.OnSent == nil {
+		t.Fatalf("lobby should be sent as new message, edit %q", msg.EditID)
+	}
+	if want, got := "lobby:join lobby:leave lobby:cancel", strings.Join(keyboardData(msg), " "); want != got {
+		t.Errorf("keyboard want %s got %s", want, got)
+	}
+	waitLobbySent(t, b, msg, "55")
+
+	// quorum shows start button for the initiator
+	press(p2, "55", lobbyJoin)
+	msg = waitText(t, out, "P1 bisa mulai sekarang")
+	if want, got := "55", msg.EditID; want != got {
+		t.Errorf("edit id want %s got %s", want, got)
+	}
+	if want, got := "lobby:join lobby:leave lobby:start lobby:cancel", strings.Join(keyboardData(msg), " "); want != got {
+		t.Errorf("keyboard want %s got %s", want, got)
+	}
+
+	// buttons of other message are ignored, only the initiator starts
+	press(p1, "54", lobbyStart)
+	press(p2, "55", lobbyStart)
+	press(p2, "55", lobbyLeave)
+	msg = waitText(t, out, "Butuh 1 orang lagi")
+	if !strings.Contains(msg.Text, "Pemain (1): P1") {
+		t.Errorf("unexpected lobby %s", msg.Text)
+	}
+
+	in <- &bot.Message{From: p2, Chat: chat, Text: "/join", Date: time.Now()}
+	waitText(t, out, "Pemain (2): P1, P2")
+	press(p1, "55", lobbyStart)
+	msg = waitText(t, out, "Game dimulai! Pemain: P1, P2")
+	if len(msg.Keyboard) != 0 {
+		t.Errorf("started lobby should not have buttons, got %v", msg.Keyboard)
+	}
+	waitText(t, out, "Ronde 1 dari")
+}
+
+func TestLobbyCancel(t *testing.T) {
+	oMinQuorum, oDB := minQuorum, fam100.DefaultDB
+	defer func() {
+		minQuorum, fam100.DefaultDB = oMinQuorum, oDB
+	}()
+	minQuorum, fam100.DefaultDB = 3, &fam100.MemoryDB{}
+	log = logger{zap.New(zap.NewJSONEncoder(), zap.FatalLevel+1)}
+	fam100.SetLogger(log)
+
+	b := &fam100Bot{}
+	out := make(chan bot.Message, 100)
+	in, err := b.Init(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.start()
+	defer b.stop()
+
+	chat := bot.Chat{ID: "cancelChan", Type: bot.Group}
+	p1, p2 := bot.User{ID: "p1", FirstName: "P1"}, bot.User{ID: "p2", FirstName: "P2"}
+	press := func(from bot.User, data string) {
+		in <- &bot.CallbackQuery{ID: "q", From: from, Message: &bot.Message{ID: "7", Chat: chat}, Data: data}
+	}
+
+	in <- &bot.Message{From: p1, Chat: chat, Text: "/join", Date: time.Now()}
+	waitLobbySent(t, b, waitText(t, out, "Lobby fam100"), "7")
+	press(p2, lobbyJoin)
+	waitText(t, out, "Pemain (2)")
+
+	// only the initiator or chat admin cancels
+	press(p2, lobbyCancel)
+	press(p1, lobbyCancel)
+	if want, got := "7", waitText(t, out, "Permainan dibatalkan oleh P1").EditID; want != got {
+		t.Errorf("edit id want %s got %s", want, got)
+	}
+	waitFor(t, "game removed", func() bool { return b.gameCount() == 0 })
+}
+
+func TestLeaveStop(t *testing.T) {
+	oMinQuorum, oLobbyGrace, oDB, oAdminID := minQuorum, lobbyGrace, fam100.DefaultDB, adminID
+	defer func() {
+		minQuorum, lobbyGrace, fam100.DefaultDB, adminID = oMinQuorum, oLobbyGrace, oDB, oAdminID
+	}()
+	minQuorum, lobbyGrace, fam100.DefaultDB, adminID = 3, 0, &fam100.MemoryDB{}, "admin"
+	log = logger{zap.New(zap.NewJSONEncoder(), zap.FatalLevel+1)}
+	fam100.SetLogger(log)
+
+	b := &fam100Bot{}
+	out := make(chan bot.Message, 100)
+	in, err := b.Init(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.start()
+	defer b.stop()
+
+	chat := bot.Chat{ID: "stopChan", Type: bot.Group}
+	p1, p2 := bot.User{ID: "p1", FirstName: "P1"}, bot.User{ID: "p2", FirstName: "P2"}
+	admin := bot.User{ID: "admin", FirstName: "Admin"}
+	send := func(from bot.User, text string) {
+		in <- &bot.Message{From: from, Chat: chat, Text: text, Date: time.Now()}
+	}
+
+	send(p1, "/join")
+	waitLobbySent(t, b, waitText(t, out, "Lobby fam100"), "9")
+	send(p2, "/join")
+	waitText(t, out, "Pemain (2): P1, P2")
+	send(p2, "/leave")
+	waitText(t, out, "Pemain (1): P1")
+
+	// only chat admin stops the game
+	send(p1, "/stop")
+	send(p2, "/join")
+	send(admin, "/join")
+	waitText(t, out, "Game dimulai! Pemain: P1, P2, Admin")
+	waitText(t, out, "Ronde 1 dari")
+
+	send(admin, "/stop")
+	waitText(t, out, "Permainan dihentikan oleh Admin")
+	waitText(t, out, "Final score")
+	waitFor(t, "game removed", func() bool { return b.gameCount() == 0 })
+
+	// a new game can be played after stop
+	send(p1, "/join")
+	waitText(t, out, "Lobby fam100")
+}
+
+func TestUpdateCallbackQuery(t *testing.T) {
+	raw := `{"id": "q1", "data": "lobby:join", "from": {"id": 7, "first_name": "Foo"},
+		"message": {"message_id": 55, "date": 1480000000, "chat": {"id": -100, "type": "group"}}}`
+	msg := bot.UpdateMessage(bot.TUpdate{UpdateID: 1, CallbackQuery: json.RawMessage(raw)}, time.Now())
+	q, ok := msg.(*bot.CallbackQuery)
+	if !ok {
+		t.Fatalf("expecting *bot.CallbackQuery got %T", msg)
+	}
+	if want, got := "lobby:join", q.Data; want != got {
+		t.Errorf("data want %s got %s", want, got)
+	}
+	if want, got := "7", q.From.ID; want != got {
+		t.Errorf("from want %s got %s", want, got)
+	}
+	if q.Message == nil || q.Message.ID != "55" || q.Message.Chat.ID != "-100" {
+		t.Errorf("unexpected message %+v", q.Message)
+	}
+
+	if msg := bot.UpdateMessage(bot.TUpdate{UpdateID: 2}, time.Now()); msg != nil {
+		t.Errorf("update without message should be nil, got %v", msg)
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"runtime"
 	"sort"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -41,6 +42,13 @@ var (
 	plugin               = fam100Bot{}
 	outboxWorker         = 0
 	profile              = false
+	scoreURL             = "http://labs.yulrizka.com/fam100"
+	nameMaxLength        = fam100.DefaultNameMaxLength
+	nameWordsPath        = ""
+	eventLogPath         = ""
+	loadTest             = false
+	loadRedis            = false
+	loadCfg              = loadConfig{}
 )
 
 // compiled time information
@@ -66,14 +74,36 @@ func init() {
 func main() {
 	flag.StringVar(&adminID, "admin", "", "admin id")
 	flag.IntVar(&minQuorum, "quorum", 3, "minimal channel quorum")
+	flag.DurationVar(&lobbyGrace, "lobbyGrace", lobbyGrace, "time players can still join after quorum is met, 0 starts immediately")
 	flag.StringVar(&graphiteURL, "graphite", "", "graphite url, empty to disable")
 	flag.StringVar(&graphiteWebURL, "graphiteWeb", "", "graphite web url, empty to disable")
+	flag.StringVar(&prometheusAddr, "prometheus", "", "listen address of prometheus /metrics endpoint, empty to disable")
 	flag.IntVar(&roundDuration, "roundDuration", 90, "round duration in second")
 	flag.IntVar(&defaultQuestionLimit, "questionLimit", -1, "set default question limit")
 	flag.IntVar(&blockProfileRate, "blockProfile", 0, "enable go routine blockProfile for profiling rate set to 1000000000 for sampling every sec")
 	flag.IntVar(&httpTimeout, "httpTimeout", 10, "http timeout in Second")
 	flag.IntVar(&outboxWorker, "outboxWorker", 0, "telegram outbox sender worker")
+	flag.StringVar(&instanceID, "instance", "", "unique id of this instance to run multiple instances sharing the channels, empty for single instance")
+	flag.DurationVar(&leaseTTL, "leaseTTL", 15*time.Second, "channel ownership lease of an instance, renewed every third of it")
+	flag.BoolVar(&receiver, "receiver", receiver, "candidate to receive updates from telegram, with several instances the one holding the receiver lease receives and forwards them to the instance owning the channel")
+	flag.IntVar(&shardCount, "shards", runtime.NumCPU(), "number of workers handling the channels, channels are assigned by chat ID")
 	flag.BoolVar(&profile, "profile", false, "open go http profiler endpoint")
+	flag.StringVar(&apiAddr, "api", "", "listen address of the JSON api, token is read from API_TOKEN. empty to disable")
+	flag.StringVar(&webhookURL, "webhook", "", "public https url receiving updates from telegram, secret is read from WEBHOOK_SECRET. empty to poll updates")
+	flag.StringVar(&webhookAddr, "webhookAddr", ":8443", "listen address of the webhook")
+	flag.StringVar(&webhookCert, "webhookCert", "", "TLS certificate file of the webhook listener, empty when TLS is terminated by a reverse proxy")
+	flag.StringVar(&webhookKey, "webhookKey", "", "TLS key file of the webhook listener")
+	flag.StringVar(&eventLogPath, "eventLog", "", "file to append game events as JSON lines, empty to disable")
+	flag.StringVar(&scoreURL, "scoreURL", scoreURL, "base url of the score site generated by scoresite, empty to disable the link")
+	flag.IntVar(&nameMaxLength, "nameMaxLength", nameMaxLength, "length of player and channel names shown in the rankings, 0 is unlimited")
+	flag.StringVar(&nameWordsPath, "nameWords", "", "file of words, one per line, a name containing one is shown as an alias. empty to disable")
+	flag.BoolVar(&loadTest, "loadtest", false, "run load test with a fake telegram transport instead of connecting to telegram")
+	flag.BoolVar(&loadRedis, "loadRedis", false, "use redis in load test, memory db is used by default")
+	flag.IntVar(&loadCfg.Channels, "loadChannels", 1000, "load test: number of channels")
+	flag.IntVar(&loadCfg.Players, "loadPlayers", 3, "load test: players per channel")
+	flag.Float64Var(&loadCfg.AnswerRate, "loadAnswerRate", 0.5, "load test: answers per player per second")
+	flag.Float64Var(&loadCfg.CorrectRate, "loadCorrectRate", 0.3, "load test: probability of a correct answer")
+	flag.DurationVar(&loadCfg.Duration, "loadDuration", time.Minute, "load test: duration")
 	logLevel := zap.LevelFlag("v", zap.InfoLevel, "log level: all, debug, info, warn, error, panic, fatal, none")
 	flag.Parse()
 
@@ -93,6 +123,9 @@ func main() {
 		signal.Notify(sigchan, os.Interrupt, syscall.SIGTERM)
 
 		<-sigchan
+		if plugin.cluster != nil {
+			plugin.cluster.releaseAll()
+		}
 		postEvent("fam100 shutdown", "shutdown", fmt.Sprintf("shutdown version:%s buildtime:%s", VERSION, BUILDTIME))
 		log.Info("STOPED", zap.String("version", VERSION), zap.String("buildtime", BUILDTIME))
 		os.Exit(0)
@@ -106,7 +139,7 @@ func main() {
 	postEvent("startup", "startup", fmt.Sprintf("startup version:%s buildtime:%s", VERSION, BUILDTIME))
 
 	key := os.Getenv("TELEGRAM_KEY")
-	if key == "" {
+	if key == "" && !loadTest {
 		log.Fatal("TELEGRAM_KEY can not be empty")
 	}
 	http.DefaultClient.Timeout = time.Duration(httpTimeout) * time.Second
@@ -128,6 +161,15 @@ func main() {
 	if outboxWorker > 0 {
 		bot.OutboxWorker = outboxWorker
 	}
+	var nameWords []string
+	if nameWordsPath != "" {
+		var err error
+		if nameWords, err = fam100.LoadNameWords(nameWordsPath); err != nil {
+			log.Fatal("Failed loading name words", zap.String("path", nameWordsPath), zap.Error(err))
+		}
+		log.Info("Name words loaded", zap.Int("nWords", len(nameWords)))
+	}
+	fam100.DefaultNameSanitizer = fam100.NewNameSanitizer(nameMaxLength, nameWords)
 	log.Info("Question limit ", zap.Int("fam100.DefaultQuestionLimit", fam100.DefaultQuestionLimit))
 
 	defer func() {
@@ -138,36 +180,138 @@ func main() {
 		fam100.DefaultQuestionDB.Close()
 	}()
 
+	if loadTest && !loadRedis {
+		fam100.DefaultDB = &fam100.MemoryDB{}
+	}
 	if err := fam100.DefaultDB.Init(); err != nil {
 		log.Fatal("Failed loading DB", zap.Error(err))
 	}
+	if eventLogPath != "" {
+		eventLog, err := fam100.NewFileEventLog(eventLogPath)
+		if err != nil {
+			log.Fatal("Failed opening event log", zap.String("path", eventLogPath), zap.Error(err))
+		}
+		defer eventLog.Close()
+		fam100.DefaultEventLog = eventLog
+	}
 	startedAt = time.Now()
+	if loadTest {
+		if loadCfg.Players < minQuorum {
+			log.Fatal("loadPlayers must be at least quorum", zap.Int("quorum", minQuorum))
+		}
+		runLoadTest(loadCfg).write(os.Stdout)
+		return
+	}
 	telegram, err := bot.NewTelegram(key)
 	if err != nil {
 		log.Fatal("telegram failed", zap.Error(err))
 	}
 	plugin.name = telegram.Username()
+	plugin.client = telegram
+	if instanceID != "" {
+		plugin.cluster = newCluster(instanceID, leaseTTL)
+		log.Info("cluster mode", zap.String("instance", instanceID), zap.Duration("leaseTTL", leaseTTL))
+	}
 	log.Info("Bot started", zap.String("name", plugin.name))
 
 	if err := telegram.AddPlugin(&plugin); err != nil {
 		log.Fatal("Failed AddPlugin", zap.Error(err))
 	}
-	initMetrics(plugin)
+	initMetrics(&plugin)
 	plugin.start()
 
+	if apiAddr != "" {
+		token := os.Getenv("API_TOKEN")
+		if token == "" {
+			log.Fatal("API_TOKEN can not be empty when api is enabled")
+		}
+		go func() {
+			log.Info("api listener", zap.String("addr", apiAddr))
+			log.Error("api listener stopped", zap.Error(http.ListenAndServe(apiAddr, newAPIHandler(&plugin, token))))
+		}()
+	}
+
+	if plugin.cluster != nil {
+		// only the outbox is started, the updates are forwarded by the instance holding the receiver lease.
+		// A receiver candidate takes the lease over once it is free
+		log.Info("receiving forwarded updates", zap.String("instance", instanceID), zap.Bool("receiverCandidate", receiver))
+		if receiver {
+			go func() {
+				if !plugin.cluster.waitReceiver(plugin.quit) {
+					return
+				}
+				if webhookURL != "" {
+					listenWebhook(telegram, plugin.in)
+					return
+				}
+				deleteWebhook(telegram)
+				telegram.StartPolling()
+			}()
+		}
+		telegram.StartWebhook()
+		return
+	}
+	if webhookURL != "" {
+		listenWebhook(telegram, plugin.in)
+		telegram.StartWebhook()
+		return
+	}
+	deleteWebhook(telegram)
 	telegram.Start()
 }
 
+// deleteWebhook removes the webhook, updates can not be polled while webhook is set
+func deleteWebhook(telegram *bot.Telegram) {
+	if err := telegram.DeleteWebhook(); err != nil {
+		log.Error("deleting webhook failed", zap.Error(err))
+	}
+}
+
 type fam100Bot struct {
 	// channel to communicate with telegram
 	in       chan interface{}
 	out      chan bot.Message
 	channels map[string]*channel
 	name     string
+	client   chatClient
 
 	// channel to communicate with game
 	gameOut chan fam100.Message
 	quit    chan struct{}
+
+	// round board of the channels, owned by handleOutbox
+	boards    map[string]*board
+	boardSent chan boardSent
+
+	// call runs function on the handleInbox goroutine which owns the channels
+	call chan func()
+
+	// channels are sharded by chat ID, each shard is a fam100Bot with its own handleInbox and handleOutbox
+	shards   []*fam100Bot
+	root     *fam100Bot // router of the shard, nil for the router itself
+	shardID  int
+	timeout  chan string // quorum timeout of the shard channels
+	finished chan string // finished game of the shard channels
+	games    int64       // number of channels, read atomically outside of handleInbox
+	metrics  *shardMetrics
+
+	// migrating allows one channel migration at a time, the shard of the old ID waits for the shard of the new ID
+	migrating sync.Mutex
+
+	// cluster is the channel ownership when running multiple instances, nil for single instance
+	cluster *cluster
+
+	scheduler   *scheduler
+	broadcaster *broadcaster
+
+	// goroutines started by start and the games started by the shards, waited by stop
+	workers sync.WaitGroup
+	running sync.WaitGroup
+}
+
+// chatClient is the chat API used by the plugin other than sending messages
+type chatClient interface {
+	Member(chatID, userID string) (*bot.TChatMember, error)
 }
 
 func (b *fam100Bot) Name() string {
@@ -177,25 +321,69 @@ func (b *fam100Bot) Name() string {
 func (b *fam100Bot) Init(out chan bot.Message) (in chan interface{}, err error) {
 	b.in = make(chan interface{}, telegramInBufferSize)
 	b.out = out
-	b.gameOut = make(chan fam100.Message, gameOutBufferSize)
-	b.channels = make(map[string]*channel)
 	b.quit = make(chan struct{})
+	n := shardCount
+	if n < 1 {
+		n = 1
+	}
+	b.shards = make([]*fam100Bot, n)
+	for i := range b.shards {
+		b.shards[i] = b.newShard(i)
+	}
+	b.scheduler = newScheduler(b, fam100.DefaultClock)
+	b.broadcaster = newBroadcaster(b)
 
 	return b.in, nil
 }
 
 func (b *fam100Bot) start() {
-	go b.handleOutbox()
-	go b.handleInbox()
+	for _, s := range b.shards {
+		b.goWorker(s.handleOutbox)
+		b.goWorker(s.handleInbox)
+	}
+	b.goWorker(b.route)
+	b.goWorker(b.scheduler.run)
+	b.goWorker(b.broadcaster.run)
+	if b.cluster != nil {
+		b.goWorker(b.runHeartbeat)
+		b.goWorker(b.receiveForwarded)
+	}
 }
 
+func (b *fam100Bot) goWorker(f func()) {
+	b.workers.Add(1)
+	go func() {
+		defer b.workers.Done()
+		f()
+	}()
+}
+
+// stop ends the goroutines started by start and aborts the running games. It returns once all of them
+// are done, after that nothing of the bot uses the db or the settings anymore
 func (b *fam100Bot) stop() {
 	close(b.quit)
+	b.workers.Wait()
+	// the shards are stopped, their channels can be read here
+	for _, s := range b.shards {
+		for _, ch := range s.channels {
+			if ch.cancelTimer != nil {
+				ch.cancelTimer()
+			}
+			if ch.graceTimer != nil {
+				ch.graceTimer.Stop()
+			}
+			if ch.started {
+				ch.game.Abort()
+			}
+		}
+	}
+	b.running.Wait()
 }
 
-// handleInbox handles incomming chat message
+// handleInbox handles incomming chat message of the shard channels
 func (b *fam100Bot) handleInbox() {
 	for {
+		b.setGames()
 		select {
 		case <-b.quit:
 			return
@@ -206,10 +394,14 @@ func (b *fam100Bot) handleInbox() {
 			}
 			messageIncomingCount.Inc(1)
 			switch msg := rawMsg.(type) {
-			case *bot.ChannelMigratedMessage:
-				b.handleChannelMigration(msg)
+			case channelMigration:
+				b.handleChannelMigration(msg.msg)
+				close(msg.done)
 				mainHandleMigrationTimer.UpdateSince(start)
 				continue
+			case *bot.CallbackQuery:
+				b.handleCallback(msg)
+				continue
 			case *bot.Message:
 				if msg.Date.Before(startedAt) {
 					// ignore message that is received before the process started
@@ -218,29 +410,66 @@ func (b *fam100Bot) handleInbox() {
 				}
 				log.Debug("handleInbox got message", zap.Object("msg", msg))
 				msgType := msg.Chat.Type
+				if c, ok := messageChatCount[msgType]; ok {
+					c.Inc(1)
+				}
 				if msgType == bot.Private {
 					messagePrivateCount.Inc(1)
 					log.Debug("Got private message", zap.Object("msg", msg))
-					if msg.From.ID == adminID {
+					if msg.Text == "/me" || msg.Text == "/me@"+b.name {
+						if b.cmdMe(msg) {
+							mainHandleMeTimer.UpdateSince(start)
+							mainHandleMessageTimer.UpdateSince(start)
+							continue
+						}
+					}
+					if role := adminRole(msg.From.ID); role != "" {
 						switch {
-						case strings.HasPrefix(msg.Text, "/say"):
+						case strings.HasPrefix(msg.Text, "/stats") && role.Includes(fam100.RoleModerator):
+							if b.cmdStats(msg) {
+								mainHandleStatsTimer.UpdateSince(start)
+								mainHandleMessageTimer.UpdateSince(start)
+								continue
+							}
+						case strings.HasPrefix(msg.Text, "/say") && role.Includes(fam100.RoleOperator):
 							if b.cmdSay(msg) {
 								mainHandleSayTimer.UpdateSince(start)
 								mainHandleMessageTimer.UpdateSince(start)
 								continue
 							}
-						case strings.HasPrefix(msg.Text, "/channels"):
+						case strings.HasPrefix(msg.Text, "/channels") && role.Includes(fam100.RoleModerator):
 							if b.cmdChannels(msg) {
 								mainHandleChannelsTimer.UpdateSince(start)
 								mainHandleMessageTimer.UpdateSince(start)
 								continue
 							}
-						case strings.HasPrefix(msg.Text, "/broadcast"):
+						case strings.HasPrefix(msg.Text, "/merge") && role.Includes(fam100.RoleOperator):
+							if b.cmdMerge(msg) {
+								mainHandleMergeTimer.UpdateSince(start)
+								mainHandleMessageTimer.UpdateSince(start)
+								continue
+							}
+						case strings.HasPrefix(msg.Text, "/broadcast") && role.Includes(fam100.RoleOperator):
 							if b.cmdBroadcast(msg) {
 								mainHandleBrodcastTimer.UpdateSince(start)
 								mainHandleMessageTimer.UpdateSince(start)
 								continue
 							}
+						case strings.HasPrefix(msg.Text, "/admin") && role.Includes(fam100.RoleOperator):
+							if b.cmdAdmin(msg) {
+								mainHandleMessageTimer.UpdateSince(start)
+								continue
+							}
+						case strings.HasPrefix(msg.Text, "/config"):
+							if b.cmdConfig(msg) {
+								mainHandleMessageTimer.UpdateSince(start)
+								continue
+							}
+						case strings.HasPrefix(msg.Text, "/ban") || strings.HasPrefix(msg.Text, "/unban"):
+							if b.cmdBan(msg) {
+								mainHandleMessageTimer.UpdateSince(start)
+								continue
+							}
 						}
 					}
 					mainHandlePrivateChatTimer.UpdateSince(start)
@@ -248,7 +477,27 @@ func (b *fam100Bot) handleInbox() {
 					continue
 				}
 
+				// channel taken over from other instance continues its game
+				b.resumeGame(msg.Chat.ID)
+
 				// ## Handle Commands ##
+				switch commandName(msg.Text, b.name) {
+				case "/schedule":
+					if b.cmdSchedule(msg) {
+						mainHandleMessageTimer.UpdateSince(start)
+						continue
+					}
+				case "/tournament":
+					if b.cmdTournament(msg) {
+						mainHandleMessageTimer.UpdateSince(start)
+						continue
+					}
+				case "/ban", "/unban", "/bans":
+					if b.cmdBan(msg) {
+						mainHandleMessageTimer.UpdateSince(start)
+						continue
+					}
+				}
 				switch msg.Text {
 				case "/join", "/join@" + b.name:
 					if b.cmdJoin(msg) {
@@ -256,12 +505,34 @@ func (b *fam100Bot) handleInbox() {
 						mainHandleMessageTimer.UpdateSince(start)
 						continue
 					}
+				case "/leave", "/leave@" + b.name:
+					if b.cmdLeave(msg) {
+						mainHandleMessageTimer.UpdateSince(start)
+						continue
+					}
+				case "/stop", "/stop@" + b.name:
+					if b.cmdStop(msg) {
+						mainHandleMessageTimer.UpdateSince(start)
+						continue
+					}
 				case "/score", "/score@" + b.name:
 					if b.cmdScore(msg) {
 						mainHandleScoreTimer.UpdateSince(start)
 						mainHandleMessageTimer.UpdateSince(start)
 						continue
 					}
+				case "/me", "/me@" + b.name:
+					if b.cmdMe(msg) {
+						mainHandleMeTimer.UpdateSince(start)
+						mainHandleMessageTimer.UpdateSince(start)
+						continue
+					}
+				case "/stats", "/stats@" + b.name:
+					if b.cmdStats(msg) {
+						mainHandleStatsTimer.UpdateSince(start)
+						mainHandleMessageTimer.UpdateSince(start)
+						continue
+					}
 				case "/help", "/help@" + b.name:
 					continue
 					/*
@@ -280,12 +551,17 @@ func (b *fam100Bot) handleInbox() {
 					mainHandleMessageTimer.UpdateSince(start)
 					continue
 				}
-				if len(ch.quorumPlayer) < minQuorum {
+				if !ch.started {
 					// ignore message if no game started or it's not quorum yet
 					mainHandleMinQuorumTimer.UpdateSince(start)
 					mainHandleMessageTimer.UpdateSince(start)
 					continue
 				}
+				if isBanned(chanID, msg.From.ID) {
+					bannedMessageCount.Inc(1)
+					mainHandleMessageTimer.UpdateSince(start)
+					continue
+				}
 
 				// pass message to the fam100 game package
 				gameMsg := fam100.TextMessage{
@@ -295,22 +571,44 @@ func (b *fam100Bot) handleInbox() {
 				}
 
 				startSendingAt := time.Now()
-				ch.game.In <- gameMsg
+				select {
+				case ch.game.In <- gameMsg:
+				default:
+					// game is not keeping up, drop instead of blocking other channels of the shard
+					gameInDroppedCount.Inc(1)
+					b.metrics.gameDropped.Inc(1)
+					log.Warn("game inbox is full, message dropped", zap.String("chanID", chanID))
+					mainHandleMessageTimer.UpdateSince(start)
+					continue
+				}
 				mainSendToGameTimer.UpdateSince(startSendingAt)
 
 				log.Debug("sent to game", zap.String("chanID", chanID), zap.Object("msg", msg))
 				mainHandleMessageTimer.UpdateSince(start)
 			}
 
-		case chanID := <-timeoutChan:
+		case chanID := <-b.timeout:
 			// chan failed to get quorum
+			text := fam100.T("Permainan dibatalkan, jumlah pemain tidak cukup  😞")
+			if ch, ok := b.channels[chanID]; ok && ch.lobby != nil {
+				b.cancelLobby(ch, text)
+				log.Info("Quorum timeout", zap.String("chanID", chanID))
+				continue
+			}
+			if ch, ok := b.channels[chanID]; ok {
+				ch.game.Cancel()
+			}
 			delete(b.channels, chanID)
-			text := fmt.Sprintf(fam100.T("Permainan dibatalkan, jumlah pemain tidak cukup  😞"))
+			b.cluster.setActive(chanID, false)
 			b.out <- bot.Message{Chat: bot.Chat{ID: chanID}, Text: text, Format: bot.Markdown, DiscardAfter: time.Now().Add(5 * time.Second)}
 			log.Info("Quorum timeout", zap.String("chanID", chanID))
 
-		case chanID := <-finishedChan:
+		case chanID := <-b.finished:
 			delete(b.channels, chanID)
+			b.cluster.setActive(chanID, false)
+
+		case fn := <-b.call:
+			fn()
 		}
 	}
 }
@@ -318,26 +616,50 @@ func (b *fam100Bot) handleInbox() {
 // handleChannelMigration handles if channel is migrated from group -> supergroup (telegram specific)
 func (b *fam100Bot) handleChannelMigration(msg *bot.ChannelMigratedMessage) bool {
 	channelMigratedCount.Inc(1)
-	chanID := msg.FromID
+	chanID, newID := msg.FromID, msg.ToID
 	if ch, exists := b.channels[chanID]; exists {
-		// TODO migrate channel score
-		newID := msg.ToID
 		ch.ID = newID
 		ch.game.ChanID = newID
 		delete(b.channels, chanID)
-		b.channels[newID] = ch
-		log.Info("Channel migrated", zap.String("from", chanID), zap.String("to", newID))
+		// the board is owned by the outbox of the game, the move is ordered with the game messages
+		select {
+		case ch.game.Out <- boardMigrated{from: chanID, to: newID}:
+		case <-b.quit:
+		}
+		b.handOver(ch)
 	}
+	b.cluster.migrate(chanID, newID)
+	if err := fam100.DefaultDB.MigrateChannel(chanID, newID); err != nil {
+		log.Error("migrating channel data failed", zap.String("from", chanID), zap.String("to", newID), zap.Error(err))
+	}
+	log.Info("Channel migrated", zap.String("from", chanID), zap.String("to", newID))
 
 	return true
 }
 
+// handOver moves the channel to the shard owning its ID, it returns once the shard has the channel
+func (b *fam100Bot) handOver(ch *channel) {
+	target := b.root.shard(ch.ID)
+	if target == b {
+		b.channels[ch.ID] = ch
+		return
+	}
+	done := make(chan struct{})
+	select {
+	case target.call <- func() { target.channels[ch.ID] = ch; close(done) }:
+		<-done
+	case <-b.quit:
+	}
+}
+
 // handleOutbox handles outgoing message from game package
 func (b *fam100Bot) handleOutbox() {
 	for {
 		select {
 		case <-b.quit:
 			return
+		case s := <-b.boardSent:
+			b.setBoardID(s)
 		case rawMsg := <-b.gameOut:
 
 			sent := true
@@ -346,6 +668,10 @@ func (b *fam100Bot) handleOutbox() {
 				sent = false
 				// TODO: log error
 
+			case boardMigrated:
+				b.migrateBoard(msg)
+				sent = false
+
 			case fam100.StateMessage:
 				switch msg.State {
 				case fam100.RoundStarted:
@@ -355,9 +681,8 @@ func (b *fam100Bot) handleOutbox() {
 						text = fmt.Sprintf(fam100.T("Game (id: %d) dimulai\n<b>siapapun boleh menjawab tanpa</b> /join\n"), msg.GameID)
 					}
 					roundStartedCount.Inc(1)
-					text += fmt.Sprintf(fam100.T("Ronde %d dari %d"), msg.Round, fam100.RoundPerGame)
-					text += "\n\n" + formatRoundText(msg.RoundText)
-					b.out <- bot.Message{Chat: bot.Chat{ID: msg.ChanID}, Text: text, Format: bot.HTML, Retry: 3}
+					text += fmt.Sprintf(fam100.T("Ronde %d dari %d"), msg.Round, msg.Rounds)
+					b.postBoard(text+"\n\n", msg.RoundText)
 
 				case fam100.RoundFinished:
 					roundFinishedCount.Inc(1)
@@ -367,18 +692,24 @@ func (b *fam100Bot) handleOutbox() {
 
 				case fam100.Finished:
 					gameFinishedCount.Inc(1)
+					delete(b.boards, msg.ChanID)
 					finishedChan <- msg.ChanID
 				}
 
 			case fam100.QNAMessage:
+				if !msg.ShowUnanswered {
+					answerCorrectCount.Inc(1)
+				}
+				if b.updateBoard(msg) {
+					break
+				}
+
+				// round without board, eg: resumed game
 				text := formatRoundText(msg)
 
 				outMsg := bot.Message{Chat: bot.Chat{ID: msg.ChanID}, Text: text, Format: bot.HTML}
 				if !msg.ShowUnanswered {
-					answerCorrectCount.Inc(1)
 					outMsg.DiscardAfter = time.Now().Add(5 * time.Second)
-				} else {
-					// mesage at the end of timeout
 				}
 				b.out <- outMsg
 
@@ -410,8 +741,10 @@ func (b *fam100Bot) handleOutbox() {
 					sort.Sort(rank)
 					text += "\n<b>Total Score</b>" + formatRankText(rank)
 
-					text += fmt.Sprintf("\nFull Score <a href=\"http://labs.yulrizka.com/fam100/scores.html?c=%s\">Lihat disini</a>\n", msg.ChanID)
-					text += fmt.Sprintf(fam100.T("\nGame selesai!"))
+					if link := channelScoreURL(msg.ChanID); link != "" {
+						text += fmt.Sprintf("\nFull Score <a href=\"%s\">Lihat disini</a>\n", link)
+					}
+					text += fam100.T("\nGame selesai!")
 					motd, _ := messageOfTheDay(msg.ChanID)
 					if motd != "" {
 						text = fmt.Sprintf("%s\n\n%s", text, motd)
@@ -419,7 +752,15 @@ func (b *fam100Bot) handleOutbox() {
 				} else {
 					text = fam100.T("Score sementara:") + text
 				}
-				b.out <- bot.Message{Chat: bot.Chat{ID: msg.ChanID}, Text: text, Format: bot.HTML, Retry: 3}
+				outMsg := bot.Message{Chat: bot.Chat{ID: msg.ChanID}, Text: text, Format: bot.HTML, Retry: 3}
+				if msg.Final {
+					outMsg.Priority = bot.PriorityHigh
+				}
+				b.out <- outMsg
+				if msg.Final && !msg.Stopped {
+					// a stopped game does not count for the tournaments
+					b.recordTournamentGame(msg.ChanID, msg.Rank)
+				}
 
 			case fam100.TickMessage:
 				if msg.TimeLeft == 30*time.Second || msg.TimeLeft == 10*time.Second {
@@ -440,13 +781,35 @@ func (b *fam100Bot) handleOutbox() {
 
 // channel represents channels chat rooms
 type channel struct {
-	ID                string
-	game              *fam100.Game
-	quorumPlayer      map[string]bool
-	players           map[string]string
-	startedAt         time.Time
-	cancelTimer       context.CancelFunc
-	cancelNotifyTimer context.CancelFunc
+	ID           string
+	game         *fam100.Game
+	quorumPlayer map[string]bool
+	players      map[string]string
+	startedAt    time.Time
+	started      bool // game is started, players can answer
+	scheduled    bool // game of a schedule, started after the join window instead of quorum
+	cancelTimer  context.CancelFunc
+	initiator    string   // player who created the game, can start it once quorum is met
+	joined       []string // player IDs in the order they joined
+	lobby        *lobby
+	graceTimer   *time.Timer
+}
+
+// start starts the game, must be called from the handleInbox goroutine owning the channel
+func (c *channel) start() {
+	c.started = true
+	c.game.Start()
+}
+
+// startGame starts the game of the channel, stop of the bot waits for it to end
+func (b *fam100Bot) startGame(ch *channel) {
+	ch.start()
+	running := &b.root.running
+	running.Add(1)
+	go func() {
+		defer running.Done()
+		<-ch.game.Done()
+	}()
 }
 
 func (c *channel) startQuorumTimer(wait time.Duration, out chan bot.Message) {
@@ -476,32 +839,6 @@ func (c *channel) startQuorumTimer(wait time.Duration, out chan bot.Message) {
 	}()
 }
 
-//TODO: refactor into simpler function with game context
-func (c *channel) startQuorumNotifyTimer(wait time.Duration, out chan bot.Message) {
-	var ctx context.Context
-	ctx, c.cancelNotifyTimer = context.WithCancel(context.Background())
-	go func() {
-		select {
-		case <-ctx.Done():
-			return
-		case <-time.After(wait):
-			players := make([]string, 0, len(c.players))
-			for _, p := range c.players {
-				players = append(players, p)
-			}
-
-			text := fmt.Sprintf(
-				fam100.T("<b>%s</b> OK, butuh %d orang lagi, sisa waktu %s"),
-				escape(strings.Join(players, ", ")),
-				minQuorum-len(c.quorumPlayer),
-				quorumWait,
-			)
-			out <- bot.Message{Chat: bot.Chat{ID: c.ID}, Text: text, Format: bot.HTML, DiscardAfter: time.Now().Add(5 * time.Second)}
-			c.cancelNotifyTimer = nil
-		}
-	}()
-}
-
 func messageOfTheDay(chanID string) (string, error) {
 	msgStr, err := fam100.DefaultDB.ChannelConfig(chanID, "motd", "")
 	if err != nil || msgStr == "" {
@@ -5,6 +5,7 @@ import (
 	l "log"
 	"math/rand"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
@@ -14,6 +15,9 @@ import (
 	"github.com/yulrizka/fam100"
 )
 
+// botName is empty since the plugin is not started from telegram
+const botName = ""
+
 func TestMain(m *testing.M) {
 	if _, err := fam100.InitQuestion("../test.db"); err != nil {
 		panic(err)
@@ -28,12 +32,12 @@ func TestMain(m *testing.M) {
 }
 
 func TestQuorumShouldStartGame(t *testing.T) {
-	oMinQuorum := minQuorum
+	oMinQuorum, oLobbyGrace := minQuorum, lobbyGrace
 	defer func() {
-		minQuorum = oMinQuorum
+		minQuorum, lobbyGrace = oMinQuorum, oLobbyGrace
 	}()
-	minQuorum = 2
-	log.SetLevel(zap.ErrorLevel)
+	minQuorum, lobbyGrace = 2, 0
+	log = logger{zap.New(zap.NewJSONEncoder(), zap.ErrorLevel)}
 	fam100.SetLogger(log)
 	// create a new game
 	out := make(chan bot.Message)
@@ -48,6 +52,7 @@ func TestQuorumShouldStartGame(t *testing.T) {
 	player2 := bot.User{ID: "ID2", FirstName: "Player 2"}
 	players := []bot.User{player1, player2}
 	b.start()
+	defer b.stop()
 
 	// send join message 3 time from the same person
 	msg := bot.Message{
@@ -63,8 +68,12 @@ func TestQuorumShouldStartGame(t *testing.T) {
 	if _, ok := reply.(bot.Message); !ok {
 		t.Fatalf("expecting message got %v", reply)
 	}
-	g, ok := b.channels[chanID]
-	if !ok {
+	// the channel is owned by the shard
+	s := b.shard(chanID)
+	result := make(chan *channel)
+	s.call <- func() { result <- s.channels[chanID] }
+	g := <-result
+	if g == nil {
 		t.Fatalf("failed to get channel")
 	}
 	if want, got := 1, len(g.quorumPlayer); want != got {
@@ -98,7 +107,7 @@ func TestQuorumShouldStartGame(t *testing.T) {
 		Text: "/join@" + botName,
 	}
 
-	// notification game is started
+	// game is started, the first question is sent
 	reply = readOutMessage(t, &b)
 	if _, ok := reply.(bot.Message); !ok {
 		t.Fatalf("expecting message got %v", reply)
@@ -114,9 +123,11 @@ func TestQuorumShouldStartGame(t *testing.T) {
 
 	for i := 1; i <= fam100.RoundPerGame; i++ {
 		// question
-		reply = readOutMessage(t, &b)
-		if _, ok := reply.(bot.Message); !ok {
-			t.Fatalf("expecting message got %v", reply)
+		if i > 1 {
+			reply = readOutMessage(t, &b)
+			if _, ok := reply.(bot.Message); !ok {
+				t.Fatalf("expecting message got %v", reply)
+			}
 		}
 
 		question := g.game.CurrentQuestion()
@@ -126,29 +137,25 @@ func TestQuorumShouldStartGame(t *testing.T) {
 				Chat: bot.Chat{ID: chanID, Type: bot.Group},
 				Text: ans.Text[0],
 			}
-
-			// question with score
-			reply = readOutMessage(t, &b)
-			if _, ok := reply.(bot.Message); !ok {
-				t.Fatalf("expecting message got %v", reply)
-			}
 		}
 
-		// ranking
+		// the board is only edited once telegram returns the ID of the question
+		// ranking, the last round shows the final score
 		reply = readOutMessage(t, &b)
-		if _, ok := reply.(bot.Message); !ok {
+		msg, ok := reply.(bot.Message)
+		if !ok {
 			t.Fatalf("expecting message got %v", reply)
 		}
-	}
-	reply = readOutMessage(t, &b)
-	if _, ok := reply.(bot.Message); !ok {
-		t.Fatalf("expecting message got %v", reply)
+		if final := strings.Contains(msg.Text, "Final score"); final != (i == fam100.RoundPerGame) {
+			t.Fatalf("round %d final score %t: %q", i, final, msg.Text)
+		}
 	}
 
-	// Game selesai
-	if want, got := fam100.Finished, g.game.State; want != got {
-		t.Fatalf("state want %s, got %s", want, got)
-	}
+	// Game selesai, the channel is freed
+	waitFor(t, "channel freed", func() bool {
+		s.call <- func() { result <- s.channels[chanID] }
+		return <-result == nil
+	})
 }
 
 func readOutMessage(t *testing.T, b *fam100Bot) fam100.Message {
@@ -219,7 +226,7 @@ func TestGame(t *testing.T) {
 		}
 	}
 
-	log.SetLevel(zap.NoneLevel)
+	log = logger{zap.New(zap.NewJSONEncoder(), zap.FatalLevel+1)}
 	fam100.SetLogger(log)
 	for i := 0; i < 500; i++ {
 		go play(fmt.Sprintf("%d", i))
@@ -230,3 +237,19 @@ func TestGame(t *testing.T) {
 	go metrics.LogScaled(metrics.DefaultRegistry, 1*time.Second, time.Millisecond, l.New(os.Stderr, "", 0))
 	time.Sleep(1*time.Second + 100*time.Millisecond)
 }
+
+func TestFormatPlayerStatsFormerNames(t *testing.T) {
+	oSanitizer := fam100.DefaultNameSanitizer
+	defer func() { fam100.DefaultNameSanitizer = oSanitizer }()
+	fam100.DefaultNameSanitizer = fam100.NewNameSanitizer(fam100.DefaultNameMaxLength, []string{"kasar"})
+
+	s := fam100.PlayerStats{PlayerID: "p1", Name: "Budi", FormerNames: []string{"Budi\u200b", "\u202eOrang Kasar", "Budi S"}}
+	text := formatPlayerStatsText(s)
+	alias := fam100.DefaultNameSanitizer.Player("p1", "Orang Kasar")
+	if want := fmt.Sprintf("sebelumnya dikenal sebagai %s, Budi S</i>", alias); !strings.Contains(text, want) {
+		t.Errorf("former names want %q got %q", want, text)
+	}
+	if strings.Contains(text, "Kasar") || strings.Contains(text, "\u202e") {
+		t.Errorf("former names should be sanitized, got %q", text)
+	}
+}
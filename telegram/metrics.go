@@ -13,6 +13,7 @@ import (
 	"github.com/cyberdelia/go-metrics-graphite"
 	"github.com/rcrowley/go-metrics"
 	"github.com/uber-go/zap"
+	"github.com/yulrizka/bot"
 	"github.com/yulrizka/fam100"
 )
 
@@ -29,38 +30,97 @@ var (
 	channelMigratedCount = metrics.NewRegisteredCounter("channel.migrated.count", metrics.DefaultRegistry)
 	commandJoinCount     = metrics.NewRegisteredCounter("command.join.count", metrics.DefaultRegistry)
 	commandScoreCount    = metrics.NewRegisteredCounter("command.score.count", metrics.DefaultRegistry)
+	commandMeCount       = metrics.NewRegisteredCounter("command.me.count", metrics.DefaultRegistry)
+	commandStatsCount    = metrics.NewRegisteredCounter("command.stats.count", metrics.DefaultRegistry)
 	roundStartedCount    = metrics.NewRegisteredCounter("round.started.count", metrics.DefaultRegistry)
 	roundFinishedCount   = metrics.NewRegisteredCounter("round.finished.count", metrics.DefaultRegistry)
 	roundTimeoutCount    = metrics.NewRegisteredCounter("round.timeout.count", metrics.DefaultRegistry)
 	gameStartedCount     = metrics.NewRegisteredCounter("game.started.count", metrics.DefaultRegistry)
 	gameFinishedCount    = metrics.NewRegisteredCounter("game.finished.count", metrics.DefaultRegistry)
 	answerCorrectCount   = metrics.NewRegisteredCounter("answer.correct.count", metrics.DefaultRegistry)
+	apiUnauthorizedCount = metrics.NewRegisteredCounter("api.unauthorized.count", metrics.DefaultRegistry)
+	messageDroppedCount  = metrics.NewRegisteredCounter("message.dropped.count", metrics.DefaultRegistry)
+	gameInDroppedCount   = metrics.NewRegisteredCounter("game.inDropped.count", metrics.DefaultRegistry)
+	gameResumedCount     = metrics.NewRegisteredCounter("game.resumed.count", metrics.DefaultRegistry)
+	clusterAcquiredCount = metrics.NewRegisteredCounter("cluster.acquired.count", metrics.DefaultRegistry)
+	clusterLostCount     = metrics.NewRegisteredCounter("cluster.lost.count", metrics.DefaultRegistry)
+	clusterForwardCount  = metrics.NewRegisteredCounter("cluster.forwarded.count", metrics.DefaultRegistry)
+	clusterReceiveCount  = metrics.NewRegisteredCounter("cluster.received.count", metrics.DefaultRegistry)
+	clusterDroppedCount  = metrics.NewRegisteredCounter("cluster.dropped.count", metrics.DefaultRegistry)
 
-	channelTotal    = metrics.NewRegisteredGauge("channel.total", metrics.DefaultRegistry)
-	playerTotal     = metrics.NewRegisteredGauge("player.total", metrics.DefaultRegistry)
-	gameActiveTotal = metrics.NewRegisteredGauge("game.active.total", metrics.DefaultRegistry)
-	inboxQueueSize  = metrics.NewRegisteredGauge("inboxQueue.size", metrics.DefaultRegistry)
-	outboxQueueSize = metrics.NewRegisteredGauge("outboxQueue.size", metrics.DefaultRegistry)
+	webhookUpdateCount       = metrics.NewRegisteredCounter("webhook.update.count", metrics.DefaultRegistry)
+	webhookUnauthorizedCount = metrics.NewRegisteredCounter("webhook.unauthorized.count", metrics.DefaultRegistry)
+	webhookBusyCount         = metrics.NewRegisteredCounter("webhook.busy.count", metrics.DefaultRegistry)
+
+	commandScheduleCount  = metrics.NewRegisteredCounter("command.schedule.count", metrics.DefaultRegistry)
+	scheduleReminderCount = metrics.NewRegisteredCounter("schedule.reminder.count", metrics.DefaultRegistry)
+	scheduledGameCount    = metrics.NewRegisteredCounter("game.scheduled.count", metrics.DefaultRegistry)
+
+	commandTournamentCount = metrics.NewRegisteredCounter("command.tournament.count", metrics.DefaultRegistry)
+	tournamentGameCount    = metrics.NewRegisteredCounter("tournament.game.count", metrics.DefaultRegistry)
+	callbackQueryCount     = metrics.NewRegisteredCounter("callbackQuery.count", metrics.DefaultRegistry)
+	commandLeaveCount      = metrics.NewRegisteredCounter("command.leave.count", metrics.DefaultRegistry)
+	commandStopCount       = metrics.NewRegisteredCounter("command.stop.count", metrics.DefaultRegistry)
+
+	broadcastCount       = metrics.NewRegisteredCounter("broadcast.count", metrics.DefaultRegistry)
+	broadcastSentCount   = metrics.NewRegisteredCounter("broadcast.sent.count", metrics.DefaultRegistry)
+	broadcastFailedCount = metrics.NewRegisteredCounter("broadcast.failed.count", metrics.DefaultRegistry)
+
+	commandAdminCount  = metrics.NewRegisteredCounter("command.admin.count", metrics.DefaultRegistry)
+	commandConfigCount = metrics.NewRegisteredCounter("command.config.count", metrics.DefaultRegistry)
+	commandBanCount    = metrics.NewRegisteredCounter("command.ban.count", metrics.DefaultRegistry)
+	bannedMessageCount = metrics.NewRegisteredCounter("message.banned.count", metrics.DefaultRegistry)
+
+	// incoming message per chat type, the chat type is exported as label to prometheus
+	messageChatCount = chatTypeCounters("message.chat.%s.count")
+
+	channelTotal      = metrics.NewRegisteredGauge("channel.total", metrics.DefaultRegistry)
+	playerTotal       = metrics.NewRegisteredGauge("player.total", metrics.DefaultRegistry)
+	gameActiveTotal   = metrics.NewRegisteredGauge("game.active.total", metrics.DefaultRegistry)
+	inboxQueueSize    = metrics.NewRegisteredGauge("inboxQueue.size", metrics.DefaultRegistry)
+	outboxQueueSize   = metrics.NewRegisteredGauge("outboxQueue.size", metrics.DefaultRegistry)
+	clusterOwnedTotal = metrics.NewRegisteredGauge("cluster.owned.total", metrics.DefaultRegistry)
+	instanceTotal     = metrics.NewRegisteredGauge("cluster.instances.total", metrics.DefaultRegistry)
 
 	cmdJoinTimer  = metrics.NewRegisteredTimer("command.join.ns", metrics.DefaultRegistry)
 	cmdScoreTimer = metrics.NewRegisteredTimer("command.score.ns", metrics.DefaultRegistry)
 	cmdHelpTimer  = metrics.NewRegisteredTimer("command.help.ns", metrics.DefaultRegistry)
+	cmdMeTimer    = metrics.NewRegisteredTimer("command.me.ns", metrics.DefaultRegistry)
+	cmdStatsTimer = metrics.NewRegisteredTimer("command.stats.ns", metrics.DefaultRegistry)
+
+	cmdScheduleTimer   = metrics.NewRegisteredTimer("command.schedule.ns", metrics.DefaultRegistry)
+	cmdTournamentTimer = metrics.NewRegisteredTimer("command.tournament.ns", metrics.DefaultRegistry)
+	cmdLeaveTimer      = metrics.NewRegisteredTimer("command.leave.ns", metrics.DefaultRegistry)
+	cmdStopTimer       = metrics.NewRegisteredTimer("command.stop.ns", metrics.DefaultRegistry)
+	cmdAdminTimer      = metrics.NewRegisteredTimer("command.admin.ns", metrics.DefaultRegistry)
+	cmdConfigTimer     = metrics.NewRegisteredTimer("command.config.ns", metrics.DefaultRegistry)
+	cmdBanTimer        = metrics.NewRegisteredTimer("command.ban.ns", metrics.DefaultRegistry)
+
+	apiRequestTimer     = metrics.NewRegisteredTimer("api.request.ns", metrics.DefaultRegistry)
+	webhookRequestTimer = metrics.NewRegisteredTimer("webhook.request.ns", metrics.DefaultRegistry)
 
 	mainHandleMigrationTimer = metrics.NewRegisteredTimer("main.handleMigration.ns", metrics.DefaultRegistry)
 	mainHandleMessageTimer   = metrics.NewRegisteredTimer("main.handleMessage.ns", metrics.DefaultRegistry)
 	mainSendToGameTimer      = metrics.NewRegisteredTimer("main.sendToGame.ns", metrics.DefaultRegistry)
+	mainHandleCallbackTimer  = metrics.NewRegisteredTimer("main.handleCallback.ns", metrics.DefaultRegistry)
 
 	// Todo should be removed
 	// handle say
 	mainHandleSayTimer = metrics.NewRegisteredTimer("main.handleSay.ns", metrics.DefaultRegistry)
 	// handle channles
 	mainHandleChannelsTimer = metrics.NewRegisteredTimer("main.handleChannels.ns", metrics.DefaultRegistry)
+	// handle merge
+	mainHandleMergeTimer = metrics.NewRegisteredTimer("main.handleMerge.ns", metrics.DefaultRegistry)
 	// handle broadcast
 	mainHandleBrodcastTimer = metrics.NewRegisteredTimer("main.handleBrodcast.ns", metrics.DefaultRegistry)
 	// handle join
 	mainHandleJoinTimer = metrics.NewRegisteredTimer("main.handleJoin.ns", metrics.DefaultRegistry)
 	// handle score
 	mainHandleScoreTimer = metrics.NewRegisteredTimer("main.handleScore.ns", metrics.DefaultRegistry)
+	// handle me
+	mainHandleMeTimer = metrics.NewRegisteredTimer("main.handleMe.ns", metrics.DefaultRegistry)
+	// handle stats
+	mainHandleStatsTimer = metrics.NewRegisteredTimer("main.handleStats.ns", metrics.DefaultRegistry)
 	// handle help
 	mainHandleHelpTimer = metrics.NewRegisteredTimer("main.handleHelp.ns", metrics.DefaultRegistry)
 	// handle privateChat
@@ -91,7 +151,17 @@ var (
 	numGoroutine = metrics.NewRegisteredGauge("go.NumGoroutine", metrics.DefaultRegistry)
 )
 
-func initMetrics(b fam100Bot) {
+// chatTypeCounters registers a counter for each chat type, format has the chat type as argument
+func chatTypeCounters(format string) map[bot.ChatType]metrics.Counter {
+	counters := make(map[bot.ChatType]metrics.Counter)
+	for _, chatType := range []bot.ChatType{bot.Private, bot.Group, bot.SuperGroup, bot.Channel} {
+		counters[chatType] = metrics.NewRegisteredCounter(fmt.Sprintf(format, chatType), metrics.DefaultRegistry)
+	}
+
+	return counters
+}
+
+func initMetrics(b *fam100Bot) {
 	tick := time.Tick(gaugeInterval)
 	if graphiteURL != "" {
 		addr, err := net.ResolveTCPAddr("tcp", graphiteURL)
@@ -106,6 +176,14 @@ func initMetrics(b fam100Bot) {
 			go graphite.Graphite(metrics.DefaultRegistry, 10e9, prefix, addr)
 		}
 	}
+	if prometheusAddr != "" {
+		go func() {
+			mux := http.NewServeMux()
+			mux.Handle("/metrics", prometheusHandler(metrics.DefaultRegistry))
+			log.Info("prometheus listener", zap.String("addr", prometheusAddr))
+			log.Error("prometheus listener stopped", zap.Error(http.ListenAndServe(prometheusAddr, mux)))
+		}()
+	}
 	go func() {
 		for range tick {
 			n, err := fam100.DefaultDB.ChannelCount()
@@ -124,7 +202,7 @@ func initMetrics(b fam100Bot) {
 				playerTotal.Update(int64(n))
 			}
 
-			gameActiveTotal.Update(int64(len(b.channels)))
+			gameActiveTotal.Update(int64(b.gameCount()))
 		}
 	}()
 
@@ -132,6 +210,9 @@ func initMetrics(b fam100Bot) {
 		for range time.Tick(1 * time.Second) {
 			inboxQueueSize.Update(int64(len(plugin.in)))
 			outboxQueueSize.Update(int64(len(plugin.out)))
+			for _, s := range plugin.shards {
+				s.metrics.inbox.Update(int64(len(s.in)))
+			}
 		}
 	}()
 
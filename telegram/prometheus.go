@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/uber-go/zap"
+)
+
+var (
+	prometheusAddr      = ""
+	prometheusPrefix    = "fam100"
+	prometheusQuantiles = []float64{0.5, 0.75, 0.95, 0.99, 0.999}
+
+	// prometheusLabels turns a name segment followed by a value into a label,
+	// eg: message.chat.group.count -> fam100_message_chat_total{chat_type="group"}
+	prometheusLabels = map[string]string{
+		"chat":  "chat_type",
+		"shard": "shard",
+	}
+)
+
+// prometheusFamily is metrics with the same name and type but different labels
+type prometheusFamily struct {
+	name    string
+	typ     string
+	samples []string
+}
+
+// prometheusHandler serves the registry in prometheus text exposition format
+func prometheusHandler(r metrics.Registry) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		if err := writePrometheus(w, r); err != nil {
+			log.Error("writing prometheus metrics failed", zap.Error(err))
+		}
+	})
+}
+
+// writePrometheus writes all metrics of the registry. Counters and meters are exported as counter,
+// gauges as gauge, timers as summary in seconds and histograms as summary.
+func writePrometheus(w io.Writer, r metrics.Registry) error {
+	families := make(map[string]*prometheusFamily)
+	add := func(name, typ string, samples ...string) {
+		f, ok := families[name]
+		if !ok {
+			f = &prometheusFamily{name: name, typ: typ}
+			families[name] = f
+		}
+		f.samples = append(f.samples, samples...)
+	}
+
+	all := make(map[string]interface{})
+	r.Each(func(key string, i interface{}) {
+		all[key] = i
+	})
+	keys := make([]string, 0, len(all))
+	for key := range all {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		switch m := all[key].(type) {
+		case metrics.Counter:
+			name, labels := prometheusName(strings.TrimSuffix(key, ".count"))
+			name += "_total"
+			add(name, "counter", prometheusSample(name, labels, float64(m.Count())))
+		case metrics.Meter:
+			name, labels := prometheusName(strings.TrimSuffix(key, ".count"))
+			name += "_total"
+			add(name, "counter", prometheusSample(name, labels, float64(m.Snapshot().Count())))
+		case metrics.Gauge:
+			name, labels := prometheusName(key)
+			add(name, "gauge", prometheusSample(name, labels, float64(m.Value())))
+		case metrics.GaugeFloat64:
+			name, labels := prometheusName(key)
+			add(name, "gauge", prometheusSample(name, labels, m.Value()))
+		case metrics.Timer:
+			t := m.Snapshot()
+			name, labels := prometheusName(strings.TrimSuffix(key, ".ns"))
+			scale := 1.0
+			if strings.HasSuffix(key, ".ns") {
+				name, scale = name+"_seconds", 1e-9
+			}
+			add(name, "summary", prometheusSummary(name, labels, t.Percentiles(prometheusQuantiles), float64(t.Sum())*scale, t.Count(), scale)...)
+		case metrics.Histogram:
+			h := m.Snapshot()
+			name, labels := prometheusName(key)
+			add(name, "summary", prometheusSummary(name, labels, h.Percentiles(prometheusQuantiles), float64(h.Sum()), h.Count(), 1)...)
+		}
+	}
+
+	names := make([]string, 0, len(families))
+	for name := range families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	bw := bufio.NewWriter(w)
+	for _, name := range names {
+		f := families[name]
+		fmt.Fprintf(bw, "# TYPE %s %s\n", f.name, f.typ)
+		for _, s := range f.samples {
+			fmt.Fprintln(bw, s)
+		}
+	}
+
+	return bw.Flush()
+}
+
+// prometheusName converts go-metrics dotted camelCase name into prometheus name and labels
+func prometheusName(key string) (name string, labels []string) {
+	segments := strings.Split(key, ".")
+	parts := []string{prometheusPrefix}
+	for i := 0; i < len(segments); i++ {
+		seg := segments[i]
+		if label, ok := prometheusLabels[seg]; ok && i+1 < len(segments) {
+			parts = append(parts, seg)
+			labels = append(labels, fmt.Sprintf("%s=%q", label, segments[i+1]))
+			i++
+			continue
+		}
+		parts = append(parts, snakeCase(seg))
+	}
+
+	return strings.Join(parts, "_"), labels
+}
+
+func prometheusSample(name string, labels []string, value float64) string {
+	if len(labels) == 0 {
+		return fmt.Sprintf("%s %g", name, value)
+	}
+	return fmt.Sprintf("%s{%s} %g", name, strings.Join(labels, ","), value)
+}
+
+func prometheusSummary(name string, labels []string, percentiles []float64, sum float64, count int64, scale float64) []string {
+	samples := make([]string, 0, len(percentiles)+2)
+	for i, q := range prometheusQuantiles {
+		l := append([]string{fmt.Sprintf("quantile=\"%g\"", q)}, labels...)
+		samples = append(samples, prometheusSample(name, l, percentiles[i]*scale))
+	}
+	samples = append(samples, prometheusSample(name+"_sum", labels, sum))
+	samples = append(samples, prometheusSample(name+"_count", labels, float64(count)))
+
+	return samples
+}
+
+// snakeCase converts camelCase into snake_case and replaces invalid characters with underscore
+func snakeCase(s string) string {
+	var b strings.Builder
+	runes := []rune(s)
+	for i, r := range runes {
+		switch {
+		case unicode.IsUpper(r):
+			if i > 0 && (unicode.IsLower(runes[i-1]) || unicode.IsDigit(runes[i-1])) {
+				b.WriteByte('_')
+			}
+			b.WriteRune(unicode.ToLower(r))
+		case r < unicode.MaxASCII && (unicode.IsLower(r) || unicode.IsDigit(r)):
+			b.WriteRune(r)
+		default:
+			b.WriteByte('_')
+		}
+	}
+
+	return b.String()
+}
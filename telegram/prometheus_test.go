@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+func TestPrometheusName(t *testing.T) {
+	tests := []struct {
+		key    string
+		name   string
+		labels string
+	}{
+		{"db.saveScore", "fam100_db_save_score", ""},
+		{"go.NumGoroutine", "fam100_go_num_goroutine", ""},
+		{"memory.heapAlloc", "fam100_memory_heap_alloc", ""},
+		{"inboxQueue.size", "fam100_inbox_queue_size", ""},
+		{"message.chat.supergroup", "fam100_message_chat", `chat_type="supergroup"`},
+	}
+	for _, tt := range tests {
+		name, labels := prometheusName(tt.key)
+		if want, got := tt.name, name; want != got {
+			t.Errorf("%s: name want %s got %s", tt.key, want, got)
+		}
+		if want, got := tt.labels, strings.Join(labels, ","); want != got {
+			t.Errorf("%s: labels want %s got %s", tt.key, want, got)
+		}
+	}
+}
+
+func TestWritePrometheus(t *testing.T) {
+	r := metrics.NewRegistry()
+	metrics.NewRegisteredCounter("message.incoming.count", r).Inc(3)
+	metrics.NewRegisteredCounter("message.chat.group.count", r).Inc(2)
+	metrics.NewRegisteredCounter("message.chat.private.count", r).Inc(1)
+	metrics.NewRegisteredGauge("memory.heapAlloc", r).Update(1024)
+	timer := metrics.NewRegisteredTimer("db.saveScore.ns", r)
+	timer.Update(2 * time.Second)
+	timer.Update(2 * time.Second)
+
+	var buf bytes.Buffer
+	if err := writePrometheus(&buf, r); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+
+	for _, line := range []string{
+		"# TYPE fam100_message_incoming_total counter\nfam100_message_incoming_total 3\n",
+		"# TYPE fam100_message_chat_total counter\n" +
+			"fam100_message_chat_total{chat_type=\"group\"} 2\n" +
+			"fam100_message_chat_total{chat_type=\"private\"} 1\n",
+		"# TYPE fam100_memory_heap_alloc gauge\nfam100_memory_heap_alloc 1024\n",
+		"# TYPE fam100_db_save_score_seconds summary\n",
+		"fam100_db_save_score_seconds{quantile=\"0.99\"} 2\n",
+		"fam100_db_save_score_seconds_sum 4\n",
+		"fam100_db_save_score_seconds_count 2\n",
+	} {
+		if !strings.Contains(out, line) {
+			t.Errorf("missing %q in\n%s", line, out)
+		}
+	}
+	if want, got := 1, strings.Count(out, "# TYPE fam100_message_chat_total"); want != got {
+		t.Errorf("family should be written once, want %d got %d", want, got)
+	}
+}
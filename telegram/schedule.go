@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/uber-go/zap"
+	"github.com/yulrizka/bot"
+	"github.com/yulrizka/fam100"
+)
+
+var (
+	scheduleInterval   = 30 * time.Second
+	scheduleReminder   = 10 * time.Minute
+	scheduleJoinWindow = 2 * time.Minute
+	scheduleLocation   = time.FixedZone("WIB", 7*60*60)
+	maxSchedules       = 5  // per channel
+	maxScheduleRounds  = 20 // rounds of a scheduled game
+)
+
+// scheduler starts the scheduled games of the channels. Schedules are read from the db on every tick
+// so schedules changed by other instance are picked up
+type scheduler struct {
+	bot   *fam100Bot
+	clock fam100.Clock
+	next  map[int64]*scheduleState
+}
+
+// scheduleState is the upcoming game of a schedule
+type scheduleState struct {
+	spec     string
+	startAt  time.Time
+	reminded bool
+}
+
+func newScheduler(b *fam100Bot, clock fam100.Clock) *scheduler {
+	return &scheduler{bot: b, clock: clock, next: make(map[int64]*scheduleState)}
+}
+
+func (s *scheduler) run() {
+	ticker := s.clock.NewTicker(scheduleInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.bot.quit:
+			return
+		case <-ticker.C():
+			s.tick()
+		}
+	}
+}
+
+// tick sends reminder and opens join window of the schedules that are due
+func (s *scheduler) tick() {
+	schedules, err := fam100.DefaultDB.Schedules("")
+	if err != nil {
+		log.Error("loading schedules failed", zap.Error(err))
+		return
+	}
+
+	now := s.clock.Now().In(scheduleLocation)
+	seen := make(map[int64]bool)
+	for _, sc := range schedules {
+		seen[sc.ID] = true
+		state, ok := s.next[sc.ID]
+		if !ok || state.spec != sc.Spec {
+			cron, err := fam100.ParseCron(sc.Spec)
+			if err != nil {
+				log.Error("invalid schedule", zap.Int64("id", sc.ID), zap.String("spec", sc.Spec), zap.Error(err))
+				continue
+			}
+			state = &scheduleState{spec: sc.Spec, startAt: cron.Next(now)}
+			s.next[sc.ID] = state
+		}
+		if state.startAt.IsZero() || now.Before(state.startAt.Add(-scheduleReminder)) {
+			continue
+		}
+		if s.bot.cluster != nil && !s.bot.cluster.owns(sc.ChanID) {
+			// started by the instance owning the channel
+			continue
+		}
+
+		if !state.reminded && now.Before(state.startAt) {
+			state.reminded = true
+			text := fmt.Sprintf(fam100.T("Kuis terjadwal dimulai jam %s, %d ronde. Siap-siap!"), state.startAt.Format("15:04"), scheduleRounds(sc))
+			s.bot.out <- bot.Message{Chat: bot.Chat{ID: sc.ChanID}, Text: text, Format: bot.HTML}
+			scheduleReminderCount.Inc(1)
+			continue
+		}
+		if now.Before(state.startAt) {
+			continue
+		}
+
+		cron, _ := fam100.ParseCron(sc.Spec)
+		state.startAt, state.reminded = cron.Next(now), false
+		sc := sc
+		shard := s.bot.shard(sc.ChanID)
+		open := func() { shard.openScheduledGame(sc, s.clock) }
+		go func() {
+			select {
+			case shard.call <- open:
+			case <-s.bot.quit:
+			}
+		}()
+	}
+	for id := range s.next {
+		if !seen[id] {
+			delete(s.next, id)
+		}
+	}
+}
+
+func scheduleRounds(s fam100.Schedule) int {
+	if s.Rounds > 0 {
+		return s.Rounds
+	}
+	return fam100.RoundPerGame
+}
+
+// openScheduledGame creates the game and lets players join until the join window is closed
+func (b *fam100Bot) openScheduledGame(s fam100.Schedule, clock fam100.Clock) {
+	chanID := s.ChanID
+	if _, ok := b.channels[chanID]; ok {
+		log.Info("scheduled game skipped, game is running", zap.String("chanID", chanID), zap.Int64("scheduleID", s.ID))
+		return
+	}
+	if disabled, _ := fam100.DefaultDB.ChannelConfig(chanID, "disabled", ""); disabled != "" {
+		return
+	}
+
+	gameIn := make(chan fam100.Message, gameInBufferSize)
+	game, err := fam100.NewGame(chanID, "", gameIn, b.gameOut, fam100.WithRounds(s.Rounds))
+	if err != nil {
+		log.Error("creating scheduled game failed", zap.String("chanID", chanID), zap.Error(err))
+		return
+	}
+	ch := &channel{ID: chanID, game: game, quorumPlayer: make(map[string]bool), players: make(map[string]string), scheduled: true}
+	b.channels[chanID] = ch
+	b.cluster.setActive(chanID, true)
+	scheduledGameCount.Inc(1)
+
+	text := fmt.Sprintf(fam100.T("Kuis terjadwal dimulai dalam %d menit, ketik /join untuk ikut"), int(scheduleJoinWindow.Minutes()))
+	b.out <- bot.Message{Chat: bot.Chat{ID: chanID}, Text: text, Format: bot.HTML}
+	log.Info("scheduled game opened", zap.String("chanID", chanID), zap.Int64("scheduleID", s.ID), zap.Int64("gameID", game.ID))
+
+	go func() {
+		select {
+		case <-clock.After(scheduleJoinWindow):
+		case <-b.quit:
+			return
+		}
+		b.callChannel(ch, func(s *fam100Bot) { s.startScheduledGame(ch.ID, game) })
+	}()
+}
+
+// startScheduledGame starts the game when the join window is closed, cancelled if nobody joined
+func (b *fam100Bot) startScheduledGame(chanID string, game *fam100.Game) {
+	ch, ok := b.channels[chanID]
+	if !ok || ch.game != game || ch.started {
+		return
+	}
+	if len(ch.quorumPlayer) == 0 {
+		game.Cancel()
+		delete(b.channels, chanID)
+		b.cluster.setActive(chanID, false)
+		b.out <- bot.Message{Chat: bot.Chat{ID: chanID}, Text: fam100.T("Kuis terjadwal dibatalkan, tidak ada pemain 😞"), Format: bot.HTML}
+		return
+	}
+	b.startGame(ch)
+}
+
+// cmdSchedule handles "/schedule add|list|remove" for chat admin
+func (b *fam100Bot) cmdSchedule(msg *bot.Message) bool {
+	defer cmdScheduleTimer.UpdateSince(time.Now())
+
+	if !b.isChatAdmin(msg.Chat.ID, msg.From.ID, msg) {
+		return true
+	}
+	commandScheduleCount.Inc(1)
+
+	chanID := msg.Chat.ID
+	reply := func(text string) {
+		b.out <- bot.Message{Chat: bot.Chat{ID: chanID}, Text: text, Format: bot.HTML}
+	}
+	usage := fam100.T("usage:\n/schedule add [menit] [jam] [tanggal] [bulan] [hari] [ronde]\n/schedule list\n/schedule remove [id]\ncontoh, setiap jumat jam 20:00 10 ronde: <code>/schedule add 0 20 * * 5 10</code>")
+	fields := strings.Fields(msg.Text)
+	if len(fields) < 2 {
+		reply(usage)
+		return true
+	}
+
+	switch fields[1] {
+	case "add":
+		if len(fields) != 7 && len(fields) != 8 {
+			reply(usage)
+			return true
+		}
+		spec := strings.Join(fields[2:7], " ")
+		cron, err := fam100.ParseCron(spec)
+		if err != nil {
+			reply(escape(err.Error()))
+			return true
+		}
+		rounds := 0
+		if len(fields) == 8 {
+			if rounds, err = strconv.Atoi(fields[7]); err != nil || rounds < 1 || rounds > maxScheduleRounds {
+				reply(fmt.Sprintf(fam100.T("jumlah ronde harus 1 - %d"), maxScheduleRounds))
+				return true
+			}
+		}
+		schedules, err := fam100.DefaultDB.Schedules(chanID)
+		if err != nil {
+			log.Error("loading schedules failed", zap.String("chanID", chanID), zap.Error(err))
+			return true
+		}
+		if len(schedules) >= maxSchedules {
+			reply(fmt.Sprintf(fam100.T("maksimal %d jadwal per channel"), maxSchedules))
+			return true
+		}
+		s, err := fam100.DefaultDB.AddSchedule(fam100.Schedule{ChanID: chanID, Spec: spec, Rounds: rounds, CreatedBy: msg.From.ID, CreatedAt: time.Now()})
+		if err != nil {
+			log.Error("adding schedule failed", zap.String("chanID", chanID), zap.Error(err))
+			return true
+		}
+		reply(fmt.Sprintf(fam100.T("Jadwal #%d ditambahkan, kuis berikutnya %s"), s.ID, formatScheduleTime(cron.Next(time.Now().In(scheduleLocation)))))
+
+	case "list":
+		schedules, err := fam100.DefaultDB.Schedules(chanID)
+		if err != nil {
+			log.Error("loading schedules failed", zap.String("chanID", chanID), zap.Error(err))
+			return true
+		}
+		reply(formatSchedulesText(schedules, time.Now().In(scheduleLocation)))
+
+	case "remove":
+		if len(fields) != 3 {
+			reply(usage)
+			return true
+		}
+		id, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			reply(usage)
+			return true
+		}
+		removed, err := fam100.DefaultDB.RemoveSchedule(chanID, id)
+		if err != nil {
+			log.Error("removing schedule failed", zap.String("chanID", chanID), zap.Error(err))
+			return true
+		}
+		if !removed {
+			reply(fmt.Sprintf(fam100.T("Jadwal #%d tidak ditemukan"), id))
+			return true
+		}
+		reply(fmt.Sprintf(fam100.T("Jadwal #%d dihapus"), id))
+
+	default:
+		reply(usage)
+	}
+
+	return true
+}
+
+func formatScheduleTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Format("Mon 02 Jan 15:04 MST")
+}
+
+func formatSchedulesText(schedules []fam100.Schedule, now time.Time) string {
+	if len(schedules) == 0 {
+		return fam100.T("Belum ada jadwal")
+	}
+	var b bytes.Buffer
+	fmt.Fprint(&b, fam100.T("<b>Jadwal kuis</b>\n"))
+	for _, s := range schedules {
+		next := "-"
+		if cron, err := fam100.ParseCron(s.Spec); err == nil {
+			next = formatScheduleTime(cron.Next(now))
+		}
+		fmt.Fprintf(&b, fam100.T("#%d <code>%s</code> %d ronde, berikutnya %s\n"), s.ID, s.Spec, scheduleRounds(s), next)
+	}
+
+	return b.String()
+}
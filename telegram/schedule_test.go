@@ -0,0 +1,96 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/uber-go/zap"
+	"github.com/yulrizka/bot"
+	"github.com/yulrizka/fam100"
+)
+
+func TestSchedule(t *testing.T) {
+	oDB, oAdminID := fam100.DefaultDB, adminID
+	defer func() {
+		fam100.DefaultDB, adminID = oDB, oAdminID
+	}()
+	fam100.DefaultDB, adminID = &fam100.MemoryDB{}, "admin"
+	log = logger{zap.New(zap.NewJSONEncoder(), zap.FatalLevel+1)}
+	fam100.SetLogger(log)
+
+	// friday 19:40
+	clock := fam100.NewFakeClock(time.Date(2016, 11, 25, 19, 40, 0, 0, scheduleLocation))
+	b := &fam100Bot{}
+	out := make(chan bot.Message, 100)
+	in, err := b.Init(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.scheduler = newScheduler(b, clock)
+	b.start()
+	defer b.stop()
+
+	chanID := "scheduleChan"
+	send := func(from, text string) {
+		in <- &bot.Message{From: bot.User{ID: from, FirstName: from}, Chat: bot.Chat{ID: chanID, Type: bot.Group}, Text: text, Date: time.Now()}
+	}
+
+	// only chat admin manages the schedules
+	send("p1", "/schedule add 0 20 * * 5 1")
+	send("admin", "/schedule add 0 20 * * 5 1")
+	waitText(t, out, "Jadwal #1 ditambahkan")
+	schedules, _ := fam100.DefaultDB.Schedules(chanID)
+	if want, got := 1, len(schedules); want != got {
+		t.Fatalf("schedules want %d got %d", want, got)
+	}
+
+	waitFor(t, "scheduler ticker", func() bool { return clock.Timers() >= 1 })
+	clock.Advance(11 * time.Minute)
+	waitText(t, out, "Kuis terjadwal dimulai jam 20:00, 1 ronde")
+	clock.Advance(9 * time.Minute)
+	waitText(t, out, "dimulai dalam 2 menit, ketik /join untuk ikut")
+
+	// a single player is enough, the game starts when the join window is closed
+	send("p1", "/join")
+	waitFor(t, "join window timer", func() bool { return clock.Timers() >= 2 })
+	waitFor(t, "player joined", func() bool {
+		joined := make(chan int)
+		b.shard(chanID).call <- func() { joined <- len(b.shard(chanID).channels[chanID].quorumPlayer) }
+		return <-joined == 1
+	})
+	clock.Advance(scheduleJoinWindow)
+	waitText(t, out, "Ronde 1 dari 1")
+}
+
+// waitText returns the first outgoing message containing the text, skipping the others
+func waitText(t *testing.T, out chan bot.Message, what string) bot.Message {
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case msg := <-out:
+			if strings.Contains(msg.Text, what) {
+				return msg
+			}
+		case <-timeout:
+			t.Fatalf("timeout waiting message %q", what)
+		}
+	}
+}
+
+func TestCommandName(t *testing.T) {
+	tests := []struct {
+		text string
+		name string
+	}{
+		{"/schedule add 0 20 * * 5", "/schedule"},
+		{"/schedule@fam100bot list", "/schedule"},
+		{"/schedule@otherbot list", "/schedule@otherbot"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if want, got := tt.name, commandName(tt.text, "fam100bot"); want != got {
+			t.Errorf("%q: want %s got %s", tt.text, want, got)
+		}
+	}
+}
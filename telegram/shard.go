@@ -0,0 +1,229 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sync/atomic"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/uber-go/zap"
+	"github.com/yulrizka/bot"
+	"github.com/yulrizka/fam100"
+)
+
+var (
+	shardCount        = 1
+	shardInBufferSize = 1000
+)
+
+// shardMetrics are the metrics of a single shard, the shard number is exported as label to prometheus
+type shardMetrics struct {
+	inbox       metrics.Gauge   // queued messages in the shard inbox
+	games       metrics.Gauge   // active channels owned by the shard
+	dropped     metrics.Counter // messages dropped because the shard inbox is full
+	gameDropped metrics.Counter // messages dropped because a game inbox is full
+}
+
+func newShardMetrics(i int) *shardMetrics {
+	r := metrics.DefaultRegistry
+	return &shardMetrics{
+		inbox:       metrics.GetOrRegisterGauge(fmt.Sprintf("shard.%d.inbox.size", i), r),
+		games:       metrics.GetOrRegisterGauge(fmt.Sprintf("shard.%d.games.total", i), r),
+		dropped:     metrics.GetOrRegisterCounter(fmt.Sprintf("shard.%d.dropped.count", i), r),
+		gameDropped: metrics.GetOrRegisterCounter(fmt.Sprintf("shard.%d.gameDropped.count", i), r),
+	}
+}
+
+// newShard creates a worker owning part of the channels. A shard has its own inbox and game outbox
+// so a busy channel only slows down the channels on the same shard
+func (b *fam100Bot) newShard(i int) *fam100Bot {
+	return &fam100Bot{
+		in:        make(chan interface{}, shardInBufferSize),
+		out:       b.out,
+		channels:  make(map[string]*channel),
+		name:      b.name,
+		client:    b.client,
+		cluster:   b.cluster,
+		gameOut:   make(chan fam100.Message, gameOutBufferSize),
+		boards:    make(map[string]*board),
+		boardSent: make(chan boardSent),
+		quit:      b.quit,
+		call:      make(chan func()),
+		timeout:   make(chan string, shardInBufferSize),
+		finished:  make(chan string, shardInBufferSize),
+		root:      b,
+		shardID:   i,
+		metrics:   newShardMetrics(i),
+	}
+}
+
+// shard returns the shard owning the channel
+func (b *fam100Bot) shard(chanID string) *fam100Bot {
+	h := fnv.New32a()
+	h.Write([]byte(chanID))
+
+	return b.shards[h.Sum32()%uint32(len(b.shards))]
+}
+
+// route dispatches incoming message, quorum timeout and finished game to the shard owning the channel.
+// It never blocks on a shard except for a channel migration, message for a shard with full inbox is dropped
+func (b *fam100Bot) route() {
+	for {
+		select {
+		case <-b.quit:
+			return
+		case rawMsg := <-b.in:
+			if rawMsg == nil {
+				log.Fatal("route input channel is closed")
+			}
+			chanID := updateChanID(rawMsg)
+			if u, ok := newForwardedUpdate(rawMsg); ok && b.cluster != nil && chanID != "" {
+				if owner := b.cluster.owner(chanID, false); owner != b.cluster.id {
+					// handled by the instance owning the channel
+					if owner != "" {
+						b.cluster.forward(owner, u)
+					}
+					continue
+				}
+			}
+			b.dispatch(chanID, rawMsg)
+		case chanID := <-timeoutChan:
+			b.forward(b.shard(chanID).timeout, chanID)
+		case chanID := <-finishedChan:
+			b.forward(b.shard(chanID).finished, chanID)
+		}
+	}
+}
+
+// updateChanID returns the channel of the update, empty if it does not belong to a channel
+func updateChanID(rawMsg interface{}) string {
+	switch msg := rawMsg.(type) {
+	case *bot.Message:
+		return msg.Chat.ID
+	case *bot.ChannelMigratedMessage:
+		return msg.FromID
+	case *bot.CallbackQuery:
+		if msg.Message != nil {
+			return msg.Message.Chat.ID
+		}
+	}
+	return ""
+}
+
+// channelMigration is a migration dispatched to the shard owning the old ID, done is closed once the
+// channel is handed over to the shard owning the new ID
+type channelMigration struct {
+	msg  *bot.ChannelMigratedMessage
+	done chan struct{}
+}
+
+// dispatch sends the update to the shard owning the channel, dropped when the shard inbox is full.
+// A migration is never dropped and blocks until the handover is done so the next update of the new ID
+// finds the channel
+func (b *fam100Bot) dispatch(chanID string, rawMsg interface{}) {
+	s := b.shard(chanID)
+	if msg, ok := rawMsg.(*bot.ChannelMigratedMessage); ok {
+		b.migrating.Lock()
+		defer b.migrating.Unlock()
+		m := channelMigration{msg: msg, done: make(chan struct{})}
+		select {
+		case s.in <- m:
+		case <-b.quit:
+			return
+		}
+		select {
+		case <-m.done:
+		case <-b.quit:
+		}
+		return
+	}
+	select {
+	case s.in <- rawMsg:
+	default:
+		messageDroppedCount.Inc(1)
+		s.metrics.dropped.Inc(1)
+		log.Warn("shard inbox is full, message dropped", zap.Int("shard", s.shardID), zap.String("chanID", chanID))
+	}
+}
+
+// callShard runs f on the shard without blocking the caller, dropped when the bot is stopped
+func (b *fam100Bot) callShard(s *fam100Bot, f func()) {
+	go func() {
+		select {
+		case s.call <- f:
+		case <-b.quit:
+		}
+	}()
+}
+
+// callChannel runs f on the shard owning the channel without blocking the caller, it follows the channel
+// when it is migrated to another shard
+func (b *fam100Bot) callChannel(ch *channel, f func(s *fam100Bot)) {
+	b.callShard(b, func() {
+		if owner := b.root.shard(ch.ID); owner != b {
+			owner.callChannel(ch, f)
+			return
+		}
+		f(b)
+	})
+}
+
+// forward sends chanID to the shard without blocking the router, these must not be dropped
+// otherwise the channel is never freed. The pending sends end when the bot is stopped
+func (b *fam100Bot) forward(c chan string, chanID string) {
+	select {
+	case c <- chanID:
+	default:
+		go func() {
+			select {
+			case c <- chanID:
+			case <-b.quit:
+			}
+		}()
+	}
+}
+
+// setGames updates number of channels owned by the shard, called from the shard handleInbox
+func (b *fam100Bot) setGames() {
+	n := int64(len(b.channels))
+	atomic.StoreInt64(&b.games, n)
+	b.metrics.games.Update(n)
+	b.metrics.inbox.Update(int64(len(b.in)))
+}
+
+// gameCount returns total active channels of all shards
+func (b *fam100Bot) gameCount() int {
+	if b.root != nil {
+		b = b.root
+	}
+	var n int64
+	for _, s := range b.shards {
+		n += atomic.LoadInt64(&s.games)
+	}
+
+	return int(n)
+}
+
+// callShards runs fn on the handleInbox goroutine of every shard, it gives up after timeout
+func (b *fam100Bot) callShards(fn func(s *fam100Bot), timeout time.Duration) error {
+	done := make(chan struct{}, len(b.shards))
+	deadline := time.After(timeout)
+	for _, s := range b.shards {
+		s := s
+		select {
+		case s.call <- func() { fn(s); done <- struct{}{} }:
+		case <-deadline:
+			return errAPIBusy
+		}
+	}
+	for range b.shards {
+		select {
+		case <-done:
+		case <-deadline:
+			return errAPIBusy
+		}
+	}
+
+	return nil
+}
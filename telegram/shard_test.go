@@ -0,0 +1,204 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/uber-go/zap"
+	"github.com/yulrizka/bot"
+	"github.com/yulrizka/fam100"
+)
+
+func TestShardRouting(t *testing.T) {
+	oShardCount, oMinQuorum, oDB := shardCount, minQuorum, fam100.DefaultDB
+	defer func() {
+		shardCount, minQuorum, fam100.DefaultDB = oShardCount, oMinQuorum, oDB
+	}()
+	shardCount, minQuorum, fam100.DefaultDB = 4, 10, &fam100.MemoryDB{}
+	log = logger{zap.New(zap.NewJSONEncoder(), zap.FatalLevel+1)}
+
+	b := &fam100Bot{}
+	in, err := b.Init(make(chan bot.Message, 1000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.start()
+	defer b.stop()
+
+	nChannels := 50
+	for i := 0; i < nChannels; i++ {
+		in <- &bot.Message{
+			From: bot.User{ID: "p1", FirstName: "Player 1"},
+			Chat: bot.Chat{ID: fmt.Sprintf("shard%d", i), Type: bot.Group},
+			Text: "/join",
+			Date: time.Now(),
+		}
+	}
+
+	timeout := time.After(5 * time.Second)
+	for b.gameCount() != nChannels {
+		select {
+		case <-timeout:
+			t.Fatalf("active games want %d got %d", nChannels, b.gameCount())
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	// every channel is owned by the shard of its ID
+	var mu sync.Mutex
+	used := make(map[int]bool)
+	err = b.callShards(func(s *fam100Bot) {
+		mu.Lock()
+		defer mu.Unlock()
+		for chanID := range s.channels {
+			if want, got := b.shard(chanID).shardID, s.shardID; want != got {
+				t.Errorf("%s shard want %d got %d", chanID, want, got)
+			}
+			used[s.shardID] = true
+		}
+	}, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(used) < 2 {
+		t.Errorf("channels should be spread across shards, got %v", used)
+	}
+}
+
+func TestShardMigration(t *testing.T) {
+	oShardCount, oMinQuorum, oDB := shardCount, minQuorum, fam100.DefaultDB
+	defer func() {
+		shardCount, minQuorum, fam100.DefaultDB = oShardCount, oMinQuorum, oDB
+	}()
+	shardCount, minQuorum, fam100.DefaultDB = 4, 10, &fam100.MemoryDB{}
+	log = logger{zap.New(zap.NewJSONEncoder(), zap.FatalLevel+1)}
+
+	b := &fam100Bot{}
+	in, err := b.Init(make(chan bot.Message, 1000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.start()
+	defer b.stop()
+
+	// the new ID is owned by another shard
+	fromID, toID := "migrateFrom", ""
+	for i := 0; toID == ""; i++ {
+		if id := fmt.Sprintf("migrateTo%d", i); b.shard(id) != b.shard(fromID) {
+			toID = id
+		}
+	}
+	join := func(chanID, userID string) *bot.Message {
+		return &bot.Message{
+			From: bot.User{ID: userID, FirstName: userID},
+			Chat: bot.Chat{ID: chanID, Type: bot.Group},
+			Text: "/join",
+			Date: time.Now(),
+		}
+	}
+	in <- join(fromID, "p1")
+	in <- &bot.ChannelMigratedMessage{FromID: fromID, ToID: toID}
+	// the update right after the migration joins the migrated lobby
+	in <- join(toID, "p2")
+
+	waitFor(t, "p2 joined the migrated lobby", func() bool {
+		var joined int32
+		err := b.callShards(func(s *fam100Bot) {
+			if ch, ok := s.channels[toID]; ok && len(ch.joined) == 2 {
+				atomic.StoreInt32(&joined, 1)
+			}
+		}, time.Second)
+		return err == nil && atomic.LoadInt32(&joined) == 1
+	})
+	err = b.callShards(func(s *fam100Bot) {
+		if _, ok := s.channels[fromID]; ok {
+			t.Errorf("shard %d still has the old ID", s.shardID)
+		}
+		ch, ok := s.channels[toID]
+		if !ok {
+			return
+		}
+		if want, got := b.shard(toID).shardID, s.shardID; want != got {
+			t.Errorf("migrated channel shard want %d got %d", want, got)
+		}
+		if ch.ID != toID || ch.game.ChanID != toID || ch.lobby == nil {
+			t.Errorf("migrated channel id %s game %s lobby %v", ch.ID, ch.game.ChanID, ch.lobby)
+		}
+	}, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 1, b.gameCount(); want != got {
+		t.Errorf("active games want %d got %d", want, got)
+	}
+}
+
+func TestShardBackpressure(t *testing.T) {
+	oShardCount, oBufferSize := shardCount, shardInBufferSize
+	defer func() {
+		shardCount, shardInBufferSize = oShardCount, oBufferSize
+	}()
+	shardCount, shardInBufferSize = 2, 5
+	log = logger{zap.New(zap.NewJSONEncoder(), zap.FatalLevel+1)}
+
+	b := &fam100Bot{}
+	in, err := b.Init(make(chan bot.Message, 10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// only the router is running, the shards are stuck
+	b.goWorker(b.route)
+	defer b.stop()
+
+	busy := b.shard("busy")
+	dropped := busy.metrics.dropped.Count()
+	for i := 0; i < 20; i++ {
+		in <- &bot.Message{Chat: bot.Chat{ID: "busy", Type: bot.Group}, Text: "hello"}
+	}
+
+	// the router is not blocked by the full shard
+	timeout := time.After(5 * time.Second)
+	for len(in) > 0 || busy.metrics.dropped.Count()-dropped < 15 {
+		select {
+		case <-timeout:
+			t.Fatalf("router is blocked, inbox %d dropped %d", len(in), busy.metrics.dropped.Count()-dropped)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	if want, got := shardInBufferSize, len(busy.in); want != got {
+		t.Errorf("shard inbox want %d got %d", want, got)
+	}
+	if want, got := int64(15), busy.metrics.dropped.Count()-dropped; want != got {
+		t.Errorf("dropped want %d got %d", want, got)
+	}
+}
+
+func TestShardForwardStops(t *testing.T) {
+	b := &fam100Bot{quit: make(chan struct{})}
+	stuck := make(chan string)
+	before := runtime.NumGoroutine()
+	for i := 0; i < 10; i++ {
+		b.forward(stuck, "stuck")
+	}
+	if runtime.NumGoroutine() < before+10 {
+		t.Fatalf("forward should wait for the stuck shard")
+	}
+
+	close(b.quit)
+	waitFor(t, "forward to stop", func() bool { return runtime.NumGoroutine() <= before })
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	timeout := time.After(5 * time.Second)
+	for !cond() {
+		select {
+		case <-timeout:
+			t.Fatalf("timeout waiting %s", what)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/uber-go/zap"
+	"github.com/yulrizka/bot"
+	"github.com/yulrizka/fam100"
+)
+
+var (
+	defaultTournamentGames   = 3
+	defaultTournamentQualify = 3
+	maxTournamentGames       = 20
+	maxTournamentQualify     = 10
+	tournamentStandingsLimit = 10
+)
+
+// cmdTournament handles "/tournament". Chat admin creates a tournament or joins the channel to one, the
+// organiser starts the final early or cancels it and everybody can see the standings
+func (b *fam100Bot) cmdTournament(msg *bot.Message) bool {
+	defer cmdTournamentTimer.UpdateSince(time.Now())
+
+	if b.handleDisabled(msg) {
+		return true
+	}
+	commandTournamentCount.Inc(1)
+
+	chanID := msg.Chat.ID
+	reply := func(text string) {
+		b.out <- bot.Message{Chat: bot.Chat{ID: chanID}, Text: text, Format: bot.HTML}
+	}
+	usage := fam100.T("usage:\n/tournament create [nama] [jumlah game] [jumlah finalis]\n/tournament join [nama]\n/tournament standings [nama]\n/tournament final [nama]\n/tournament cancel [nama]\n/tournament list")
+	fields := strings.Fields(msg.Text)
+	if len(fields) < 2 || (fields[1] != "list" && len(fields) < 3) {
+		reply(usage)
+		return true
+	}
+
+	switch fields[1] {
+	case "create":
+		if !b.isChatAdmin(chanID, msg.From.ID, msg) {
+			return true
+		}
+		if len(fields) > 5 {
+			reply(usage)
+			return true
+		}
+		games, qualify := defaultTournamentGames, defaultTournamentQualify
+		var err error
+		if len(fields) > 3 {
+			if games, err = strconv.Atoi(fields[3]); err != nil || games < 1 || games > maxTournamentGames {
+				reply(fmt.Sprintf(fam100.T("jumlah game harus 1 - %d"), maxTournamentGames))
+				return true
+			}
+		}
+		if len(fields) > 4 {
+			if qualify, err = strconv.Atoi(fields[4]); err != nil || qualify < 1 || qualify > maxTournamentQualify {
+				reply(fmt.Sprintf(fam100.T("jumlah finalis harus 1 - %d"), maxTournamentQualify))
+				return true
+			}
+		}
+		t, err := fam100.NewTournament(fields[2], msg.From.ID, chanID, games, qualify)
+		if err != nil {
+			reply(escape(err.Error()))
+			return true
+		}
+		log.Info("tournament created", zap.String("tournament", t.Name), zap.String("chanID", chanID), zap.String("organiser", msg.From.ID))
+		reply(fmt.Sprintf(fam100.T("Turnamen <b>%s</b> dibuat. Setiap group memainkan %d game kualifikasi, %d pemain teratas tiap group masuk final di group ini.\nAdmin group lain ketik <code>/tournament join %s</code> untuk ikut"), t.Name, t.Games, t.Qualify, t.Name))
+
+	case "join":
+		if !b.isChatAdmin(chanID, msg.From.ID, msg) {
+			return true
+		}
+		t, err := fam100.AddTournamentChannel(fields[2], chanID)
+		if err != nil {
+			reply(escape(err.Error()))
+			return true
+		}
+		reply(fmt.Sprintf(fam100.T("Group ini ikut turnamen <b>%s</b>, %d game berikutnya dihitung untuk kualifikasi"), t.Name, t.Games-t.Played[chanID]))
+
+	case "standings":
+		t, err := fam100.DefaultDB.Tournament(fields[2])
+		if err != nil {
+			log.Error("loading tournament failed", zap.String("tournament", fields[2]), zap.Error(err))
+			return true
+		}
+		if t == nil {
+			reply(fmt.Sprintf(fam100.T("turnamen %s tidak ditemukan"), escape(fields[2])))
+			return true
+		}
+		reply(formatTournamentText(*t, chanID))
+
+	case "final":
+		if !b.isOrganiser(fields[2], msg.From.ID) {
+			return true
+		}
+		t, err := fam100.StartTournamentFinal(fields[2])
+		if err != nil {
+			reply(escape(err.Error()))
+			return true
+		}
+		b.announceTournament(t)
+
+	case "cancel":
+		if !b.isOrganiser(fields[2], msg.From.ID) {
+			return true
+		}
+		if err := fam100.CancelTournament(fields[2]); err != nil {
+			reply(escape(err.Error()))
+			return true
+		}
+		reply(fmt.Sprintf(fam100.T("Turnamen <b>%s</b> dibatalkan"), fields[2]))
+
+	case "list":
+		tournaments, err := fam100.ChannelTournaments(chanID)
+		if err != nil {
+			log.Error("loading tournaments failed", zap.String("chanID", chanID), zap.Error(err))
+			return true
+		}
+		if len(tournaments) == 0 {
+			reply(fam100.T("Group ini belum ikut turnamen"))
+			return true
+		}
+		var text bytes.Buffer
+		for _, t := range tournaments {
+			fmt.Fprintf(&text, fam100.T("<b>%s</b> %s, game kualifikasi %d dari %d\n"), t.Name, t.Stage, t.Played[chanID], t.Games)
+		}
+		reply(text.String())
+
+	default:
+		reply(usage)
+	}
+
+	return true
+}
+
+// isOrganiser returns true if the user created the tournament or is a bot moderator
+func (b *fam100Bot) isOrganiser(name, userID string) bool {
+	if hasRole(userID, fam100.RoleModerator) {
+		return true
+	}
+	t, err := fam100.DefaultDB.Tournament(name)
+	if err != nil {
+		log.Error("loading tournament failed", zap.String("tournament", name), zap.Error(err))
+		return false
+	}
+	return t != nil && t.CreatedBy == userID
+}
+
+// isFinalist returns false if the channel plays a tournament final and the player did not qualify
+func isFinalist(chanID, playerID string) (bool, *fam100.Tournament) {
+	t, err := fam100.FinalTournament(chanID)
+	if err != nil {
+		log.Error("loading tournament final failed", zap.String("chanID", chanID), zap.Error(err))
+		return true, nil
+	}
+	if t == nil {
+		return true, nil
+	}
+	return t.IsFinalist(fam100.PlayerID(playerID)), t
+}
+
+// recordTournamentGame adds the score of a finished game to the tournaments of the channel
+func (b *fam100Bot) recordTournamentGame(chanID string, rank fam100.Rank) {
+	tournaments, err := fam100.RecordTournamentGame(chanID, rank)
+	if err != nil {
+		log.Error("recording tournament game failed", zap.String("chanID", chanID), zap.Error(err))
+	}
+	for _, t := range tournaments {
+		tournamentGameCount.Inc(1)
+		if t.Stage == fam100.TournamentQualification {
+			text := fmt.Sprintf(fam100.T("Turnamen <b>%s</b>, game kualifikasi %d dari %d\n"), t.Name, t.Played[chanID], t.Games)
+			standings, err := fam100.TournamentStandings(t.Name, chanID, tournamentStandingsLimit)
+			if err != nil {
+				log.Error("getting tournament standings failed", zap.String("tournament", t.Name), zap.Error(err))
+				continue
+			}
+			text += fam100.T("<b>Klasemen</b>") + formatRankText(standings)
+			b.out <- bot.Message{Chat: bot.Chat{ID: chanID}, Text: text, Format: bot.HTML, Retry: 3}
+			continue
+		}
+		b.announceTournament(t)
+	}
+}
+
+// announceTournament tells every channel of the tournament about the finalists or the winner
+func (b *fam100Bot) announceTournament(t fam100.Tournament) {
+	var text string
+	switch t.Stage {
+	case fam100.TournamentFinal:
+		text = fmt.Sprintf(fam100.T("Kualifikasi turnamen <b>%s</b> selesai! Finalis:"), t.Name) + formatRankText(t.Finalists)
+		text += fam100.T("\nFinal dimainkan di group penyelenggara, finalis ketik /join di sana")
+	case fam100.TournamentFinished:
+		if t.Winner == nil {
+			text = fmt.Sprintf(fam100.T("Turnamen <b>%s</b> selesai tanpa finalis"), t.Name)
+			break
+		}
+		text = fmt.Sprintf(fam100.T("Juara turnamen <b>%s</b>: %s 🏆"), t.Name, escape(fam100.DefaultNameSanitizer.Player(t.Winner.PlayerID, t.Winner.Name)))
+		if standings, err := fam100.TournamentStandings(t.Name, fam100.FinalTable, tournamentStandingsLimit); err == nil {
+			text += "\n<b>Final</b>" + formatRankText(standings)
+		}
+	default:
+		return
+	}
+	for _, chanID := range t.Channels {
+		b.out <- bot.Message{Chat: bot.Chat{ID: chanID}, Text: text, Format: bot.HTML, Retry: 3}
+	}
+}
+
+func formatTournamentText(t fam100.Tournament, chanID string) string {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, fam100.T("Turnamen <b>%s</b> (%s), %d group\n"), t.Name, t.Stage, len(t.Channels))
+	if t.Stage != fam100.TournamentQualification {
+		fmt.Fprint(&b, fam100.T("<b>Final</b>"))
+		standings, err := fam100.TournamentStandings(t.Name, fam100.FinalTable, tournamentStandingsLimit)
+		if err != nil || len(standings) == 0 {
+			standings = t.Finalists
+		}
+		b.WriteString(formatRankText(standings))
+	}
+	if t.HasChannel(chanID) {
+		fmt.Fprintf(&b, fam100.T("<b>Kualifikasi</b> game %d dari %d"), t.Played[chanID], t.Games)
+		standings, err := fam100.TournamentStandings(t.Name, chanID, tournamentStandingsLimit)
+		if err != nil {
+			log.Error("getting tournament standings failed", zap.String("tournament", t.Name), zap.Error(err))
+		}
+		b.WriteString(formatRankText(standings))
+	}
+
+	return b.String()
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/uber-go/zap"
+	"github.com/yulrizka/bot"
+	"github.com/yulrizka/fam100"
+)
+
+func TestTournament(t *testing.T) {
+	oDB, oAdminID := fam100.DefaultDB, adminID
+	defer func() {
+		fam100.DefaultDB, adminID = oDB, oAdminID
+	}()
+	fam100.DefaultDB, adminID = &fam100.MemoryDB{}, "admin"
+	log = logger{zap.New(zap.NewJSONEncoder(), zap.FatalLevel+1)}
+	fam100.SetLogger(log)
+
+	b := &fam100Bot{}
+	out := make(chan bot.Message, 100)
+	in, err := b.Init(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.start()
+	defer b.stop()
+
+	send := func(chanID, from, text string) {
+		in <- &bot.Message{From: bot.User{ID: from, FirstName: from}, Chat: bot.Chat{ID: chanID, Type: bot.Group}, Text: text, Date: time.Now()}
+	}
+
+	send("chanA", "admin", "/tournament create cup 1 1")
+	waitText(t, out, "Turnamen <b>cup</b> dibuat")
+	send("chanB", "admin", "/tournament join cup")
+	waitText(t, out, "Group ini ikut turnamen <b>cup</b>")
+
+	b.recordTournamentGame("chanA", fam100.Rank{{PlayerID: "a1", Name: "A 1", Score: 30}, {PlayerID: "a2", Name: "A 2", Score: 10}})
+	if want, got := "chanA", waitText(t, out, "game kualifikasi 1 dari 1").Chat.ID; want != got {
+		t.Errorf("standings chat want %s got %s", want, got)
+	}
+
+	// last qualification game announces the finalists to every channel
+	b.recordTournamentGame("chanB", fam100.Rank{{PlayerID: "b1", Name: "B 1", Score: 20}})
+	announced := make(map[string]bool)
+	for i := 0; i < 2; i++ {
+		announced[waitText(t, out, "Finalis:").Chat.ID] = true
+	}
+	if !announced["chanA"] || !announced["chanB"] {
+		t.Fatalf("finalists should be announced to both channels, got %v", announced)
+	}
+
+	// only finalists can join the final
+	send("chanA", "a2", "/join")
+	waitText(t, out, "Hanya finalis turnamen cup")
+	send("chanA", "b1", "/join")
+	waitFor(t, "final game created", func() bool { return b.gameCount() == 1 })
+
+	b.recordTournamentGame("chanA", fam100.Rank{{PlayerID: "b1", Name: "B 1", Score: 40}, {PlayerID: "a1", Name: "A 1", Score: 30}})
+	waitText(t, out, "Juara turnamen <b>cup</b>: B 1")
+
+	tour, _ := fam100.DefaultDB.Tournament("cup")
+	if want, got := fam100.TournamentFinished, tour.Stage; want != got {
+		t.Errorf("stage want %s got %s", want, got)
+	}
+
+	// only the organiser cancels
+	send("chanB", "b1", "/tournament cancel cup")
+	send("chanA", "admin", "/tournament cancel cup")
+	waitText(t, out, "Turnamen <b>cup</b> dibatalkan")
+}
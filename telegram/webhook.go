@@ -0,0 +1,116 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"time"
+
+	"github.com/uber-go/zap"
+	"github.com/yulrizka/bot"
+)
+
+var (
+	webhookURL     = ""
+	webhookAddr    = ":8443"
+	webhookCert    = ""
+	webhookKey     = ""
+	webhookMaxBody = int64(1 << 20)
+
+	// telegram only accepts these characters as secret token
+	webhookSecretRe = regexp.MustCompile(`^[A-Za-z0-9_-]{1,256}$`)
+)
+
+const webhookSecretHeader = "X-Telegram-Bot-Api-Secret-Token"
+
+// newWebhookHandler receives updates pushed by telegram and feeds them to the plugin inbox.
+// Telegram retries the update when the inbox is full
+func newWebhookHandler(secret string, in chan interface{}) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer webhookRequestTimer.UpdateSince(time.Now())
+
+		if r.Method != "POST" {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get(webhookSecretHeader)), []byte(secret)) != 1 {
+			webhookUnauthorizedCount.Inc(1)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var update bot.TUpdate
+		if err := json.NewDecoder(io.LimitReader(r.Body, webhookMaxBody)).Decode(&update); err != nil {
+			http.Error(w, "invalid update", http.StatusBadRequest)
+			return
+		}
+		msg := bot.UpdateMessage(update, time.Now())
+		if msg == nil {
+			// other update types are not used by the bot
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		select {
+		case in <- msg:
+			webhookUpdateCount.Inc(1)
+			w.WriteHeader(http.StatusOK)
+		default:
+			webhookBusyCount.Inc(1)
+			log.Warn("inbox is full, webhook update rejected", zap.Int64("updateID", update.UpdateID))
+			http.Error(w, "busy", http.StatusServiceUnavailable)
+		}
+	})
+}
+
+// webhookPath returns path of the webhook url that is served
+func webhookPath(webhookURL string) (string, error) {
+	u, err := url.Parse(webhookURL)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "https" {
+		return "", errors.New("webhook url must be https")
+	}
+	if u.Path == "" {
+		return "/", nil
+	}
+
+	return u.Path, nil
+}
+
+// listenWebhook receives the updates with webhook instead of polling, it returns once the webhook is registered.
+// The listener serves TLS when certificate is set, otherwise it is expected to run behind a reverse proxy
+// terminating TLS. Messages are sent by telegram.StartWebhook
+func listenWebhook(telegram *bot.Telegram, in chan interface{}) {
+	secret := os.Getenv("WEBHOOK_SECRET")
+	if !webhookSecretRe.MatchString(secret) {
+		log.Fatal("WEBHOOK_SECRET must be 1-256 characters of A-Z, a-z, 0-9, _ and -")
+	}
+	path, err := webhookPath(webhookURL)
+	if err != nil {
+		log.Fatal("invalid webhook url", zap.String("url", webhookURL), zap.Error(err))
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(path, newWebhookHandler(secret, in))
+	server := &http.Server{Addr: webhookAddr, Handler: mux, ReadTimeout: 10 * time.Second, WriteTimeout: 10 * time.Second}
+	go func() {
+		log.Info("webhook listener", zap.String("addr", webhookAddr), zap.String("path", path), zap.Bool("tls", webhookCert != ""))
+		var err error
+		if webhookCert != "" {
+			err = server.ListenAndServeTLS(webhookCert, webhookKey)
+		} else {
+			err = server.ListenAndServe()
+		}
+		log.Fatal("webhook listener stopped", zap.Error(err))
+	}()
+
+	if err := telegram.SetWebhook(webhookURL, secret); err != nil {
+		log.Fatal("setting webhook failed", zap.String("url", webhookURL), zap.Error(err))
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/yulrizka/bot"
+)
+
+func webhookRequest(t *testing.T, server *httptest.Server, method, secret, body string) int {
+	req, err := http.NewRequest(method, server.URL+"/hook", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret != "" {
+		req.Header.Set(webhookSecretHeader, secret)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	return resp.StatusCode
+}
+
+func TestWebhook(t *testing.T) {
+	in := make(chan interface{}, 2)
+	server := httptest.NewServer(newWebhookHandler("s3cret", in))
+	defer server.Close()
+
+	join := `{"update_id": 10, "message": {"message_id": 1, "date": 1480000000, "text": "/join",
+		"from": {"id": 7, "first_name": "Foo"}, "chat": {"id": -100, "type": "group", "title": "Group"}}}`
+	tests := []struct {
+		name   string
+		method string
+		secret string
+		body   string
+		status int
+	}{
+		{"get", "GET", "s3cret", "", http.StatusMethodNotAllowed},
+		{"no secret", "POST", "", join, http.StatusUnauthorized},
+		{"wrong secret", "POST", "wrong", join, http.StatusUnauthorized},
+		{"invalid json", "POST", "s3cret", "{", http.StatusBadRequest},
+		{"no message", "POST", "s3cret", `{"update_id": 11, "edited_message": {}}`, http.StatusOK},
+		{"message", "POST", "s3cret", join, http.StatusOK},
+	}
+	for _, tt := range tests {
+		if want, got := tt.status, webhookRequest(t, server, tt.method, tt.secret, tt.body); want != got {
+			t.Errorf("%s: status want %d got %d", tt.name, want, got)
+		}
+	}
+
+	if want, got := 1, len(in); want != got {
+		t.Fatalf("inbox want %d got %d", want, got)
+	}
+	msg, ok := (<-in).(*bot.Message)
+	if !ok {
+		t.Fatal("expecting *bot.Message")
+	}
+	if want, got := "/join", msg.Text; want != got {
+		t.Errorf("text want %s got %s", want, got)
+	}
+	if want, got := "-100", msg.Chat.ID; want != got {
+		t.Errorf("chat want %s got %s", want, got)
+	}
+	if want, got := bot.Group, msg.Chat.Type; want != got {
+		t.Errorf("chat type want %s got %s", want, got)
+	}
+	if want, got := "7", msg.From.ID; want != got {
+		t.Errorf("from want %s got %s", want, got)
+	}
+	if msg.ReceivedAt.IsZero() {
+		t.Error("received at should be set")
+	}
+
+	// full inbox is rejected so telegram retries later
+	in <- nil
+	in <- nil
+	if want, got := http.StatusServiceUnavailable, webhookRequest(t, server, "POST", "s3cret", join); want != got {
+		t.Errorf("full inbox status want %d got %d", want, got)
+	}
+}
+
+func TestWebhookPath(t *testing.T) {
+	tests := []struct {
+		url  string
+		path string
+		err  bool
+	}{
+		{"https://example.com/fam100/hook", "/fam100/hook", false},
+		{"https://example.com", "/", false},
+		{"http://example.com/hook", "", true},
+	}
+	for _, tt := range tests {
+		path, err := webhookPath(tt.url)
+		if want, got := tt.err, err != nil; want != got {
+			t.Errorf("%s: error want %t got %v", tt.url, want, err)
+		}
+		if want, got := tt.path, path; want != got {
+			t.Errorf("%s: path want %s got %s", tt.url, want, got)
+		}
+	}
+}
@@ -0,0 +1,306 @@
+package fam100
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// TournamentStage is the stage of a tournament
+type TournamentStage string
+
+// Tournament stages, qualification games are played in every channel of the tournament then the top players
+// of each channel play the final in the channel of the organiser
+const (
+	TournamentQualification TournamentStage = "qualification"
+	TournamentFinal         TournamentStage = "final"
+	TournamentFinished      TournamentStage = "finished"
+)
+
+// FinalTable is the standings table of the final, qualification tables are named by the channel ID
+const FinalTable = "final"
+
+var (
+	tournamentNameRe = regexp.MustCompile(`^[a-z0-9_-]{1,32}$`)
+
+	// ErrTournamentExists is returned when creating tournament with a name already used
+	ErrTournamentExists = errors.New("tournament already exists")
+
+	// tournamentMu serializes the updates of tournaments by this process
+	tournamentMu sync.Mutex
+)
+
+// Tournament is a league of games across channels, standings of each channel are kept in the db and the
+// top Qualify players of each channel advance to the final
+type Tournament struct {
+	Name        string          `json:"name"`
+	CreatedBy   string          `json:"createdBy"` // organiser
+	CreatedAt   time.Time       `json:"createdAt"`
+	Stage       TournamentStage `json:"stage"`
+	Games       int             `json:"games"`   // qualification games per channel
+	Qualify     int             `json:"qualify"` // players of each channel advancing to the final
+	FinalChanID string          `json:"finalChanID"`
+	Channels    []string        `json:"channels"`
+	Played      map[string]int  `json:"played"` // qualification games played per channel
+	Finalists   Rank            `json:"finalists"`
+	Winner      *PlayerScore    `json:"winner,omitempty"`
+}
+
+// NewTournament creates a tournament with the final played in finalChanID, which also joins the qualification
+func NewTournament(name, organiser, finalChanID string, games, qualify int) (Tournament, error) {
+	t := Tournament{
+		Name:        name,
+		CreatedBy:   organiser,
+		CreatedAt:   time.Now(),
+		Stage:       TournamentQualification,
+		Games:       games,
+		Qualify:     qualify,
+		FinalChanID: finalChanID,
+		Channels:    []string{finalChanID},
+		Played:      make(map[string]int),
+	}
+	if !tournamentNameRe.MatchString(name) {
+		return t, fmt.Errorf("tournament name must be 1-32 characters of a-z, 0-9, _ or -")
+	}
+	if games < 1 || qualify < 1 {
+		return t, fmt.Errorf("games and qualify must be positive")
+	}
+
+	tournamentMu.Lock()
+	defer tournamentMu.Unlock()
+
+	existing, err := DefaultDB.Tournament(name)
+	if err != nil {
+		return t, err
+	}
+	if existing != nil {
+		return t, ErrTournamentExists
+	}
+
+	return t, DefaultDB.SaveTournament(t)
+}
+
+// HasChannel returns true if the channel plays the qualification
+func (t Tournament) HasChannel(chanID string) bool {
+	return containsString(t.Channels, chanID)
+}
+
+// migrateChannel replaces the channel with its new ID, returns false if the tournament does not have the channel
+func (t *Tournament) migrateChannel(fromID, toID string) bool {
+	if !t.HasChannel(fromID) {
+		return false
+	}
+	for i, chanID := range t.Channels {
+		if chanID == fromID {
+			t.Channels[i] = toID
+		}
+	}
+	if t.FinalChanID == fromID {
+		t.FinalChanID = toID
+	}
+	if played, ok := t.Played[fromID]; ok {
+		t.Played[toID] += played
+		delete(t.Played, fromID)
+	}
+	return true
+}
+
+// IsFinalist returns true if the player qualified for the final
+func (t Tournament) IsFinalist(playerID PlayerID) bool {
+	for _, ps := range t.Finalists {
+		if ps.PlayerID == playerID {
+			return true
+		}
+	}
+	return false
+}
+
+// AddTournamentChannel lets the channel play the qualification of the tournament
+func AddTournamentChannel(name, chanID string) (Tournament, error) {
+	tournamentMu.Lock()
+	defer tournamentMu.Unlock()
+
+	t, err := loadTournament(name)
+	if err != nil {
+		return Tournament{}, err
+	}
+	if t.Stage != TournamentQualification {
+		return t, fmt.Errorf("qualification of %s is over", name)
+	}
+	if t.HasChannel(chanID) {
+		return t, nil
+	}
+	t.Channels = append(t.Channels, chanID)
+
+	return t, DefaultDB.SaveTournament(t)
+}
+
+// StartTournamentFinal ends the qualification, eg: when not every channel played all the games
+func StartTournamentFinal(name string) (Tournament, error) {
+	tournamentMu.Lock()
+	defer tournamentMu.Unlock()
+
+	t, err := loadTournament(name)
+	if err != nil {
+		return Tournament{}, err
+	}
+	if t.Stage != TournamentQualification {
+		return t, fmt.Errorf("qualification of %s is over", name)
+	}
+	if err := t.startFinal(); err != nil {
+		return t, err
+	}
+
+	return t, DefaultDB.SaveTournament(t)
+}
+
+// CancelTournament removes the tournament and its standings
+func CancelTournament(name string) error {
+	tournamentMu.Lock()
+	defer tournamentMu.Unlock()
+
+	t, err := loadTournament(name)
+	if err != nil {
+		return err
+	}
+	return DefaultDB.DeleteTournament(t)
+}
+
+// FinalTournament returns the tournament with final running in the channel, nil if there is none
+func FinalTournament(chanID string) (*Tournament, error) {
+	tournaments, err := DefaultDB.ChannelTournaments(chanID)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range tournaments {
+		if t.Stage == TournamentFinal && t.FinalChanID == chanID {
+			return &t, nil
+		}
+	}
+	return nil, nil
+}
+
+// ChannelTournaments returns the tournaments the channel plays in
+func ChannelTournaments(chanID string) ([]Tournament, error) {
+	return DefaultDB.ChannelTournaments(chanID)
+}
+
+// TournamentStandings returns the standings of a table, the channel ID for qualification or FinalTable
+func TournamentStandings(name, table string, limit int) (Rank, error) {
+	return DefaultDB.TournamentStandings(name, table, limit)
+}
+
+// RecordTournamentGame adds the final rank of a game played in the channel to the standings of the
+// tournaments and returns the tournaments that were updated. The final starts once every channel
+// played its qualification games, the tournament is finished after the final game
+func RecordTournamentGame(chanID string, rank Rank) ([]Tournament, error) {
+	tournamentMu.Lock()
+	defer tournamentMu.Unlock()
+
+	rank = DefaultNameSanitizer.Rank(rank)
+	tournaments, err := DefaultDB.ChannelTournaments(chanID)
+	if err != nil {
+		return nil, err
+	}
+	var updated []Tournament
+	for _, t := range tournaments {
+		switch {
+		case t.Stage == TournamentQualification && t.HasChannel(chanID) && t.Played[chanID] < t.Games:
+			if t.Played == nil {
+				t.Played = make(map[string]int)
+			}
+			if err := DefaultDB.AddTournamentScore(t.Name, chanID, rank); err != nil {
+				return updated, err
+			}
+			t.Played[chanID]++
+			if t.qualificationDone() {
+				if err := t.startFinal(); err != nil {
+					return updated, err
+				}
+			}
+
+		case t.Stage == TournamentFinal && t.FinalChanID == chanID:
+			var final Rank
+			for _, ps := range rank {
+				if t.IsFinalist(ps.PlayerID) {
+					final = append(final, ps)
+				}
+			}
+			if len(final) == 0 {
+				// none of the finalists played, wait for the next game
+				continue
+			}
+			if err := DefaultDB.AddTournamentScore(t.Name, FinalTable, final); err != nil {
+				return updated, err
+			}
+			standings, err := DefaultDB.TournamentStandings(t.Name, FinalTable, 1)
+			if err != nil {
+				return updated, err
+			}
+			t.Stage = TournamentFinished
+			if len(standings) > 0 {
+				t.Winner = &standings[0]
+			}
+
+		default:
+			continue
+		}
+		if err := DefaultDB.SaveTournament(t); err != nil {
+			return updated, err
+		}
+		updated = append(updated, t)
+	}
+
+	return updated, nil
+}
+
+func (t Tournament) qualificationDone() bool {
+	for _, chanID := range t.Channels {
+		if t.Played[chanID] < t.Games {
+			return false
+		}
+	}
+	return true
+}
+
+// startFinal picks the top players of every channel as finalist, a player qualified in several channels
+// is listed once
+func (t *Tournament) startFinal() error {
+	var finalists Rank
+	seen := make(map[PlayerID]bool)
+	for _, chanID := range t.Channels {
+		standings, err := DefaultDB.TournamentStandings(t.Name, chanID, t.Qualify)
+		if err != nil {
+			return err
+		}
+		for _, ps := range standings {
+			if !seen[ps.PlayerID] {
+				seen[ps.PlayerID] = true
+				finalists = append(finalists, ps)
+			}
+		}
+	}
+	t.Finalists = finalists
+	t.Stage = TournamentFinal
+	if len(finalists) == 0 {
+		t.Stage = TournamentFinished
+	}
+
+	return nil
+}
+
+func loadTournament(name string) (Tournament, error) {
+	t, err := DefaultDB.Tournament(name)
+	if err != nil {
+		return Tournament{}, err
+	}
+	if t == nil {
+		return Tournament{}, fmt.Errorf("tournament %s not found", name)
+	}
+	if t.Played == nil {
+		t.Played = make(map[string]int)
+	}
+	return *t, nil
+}
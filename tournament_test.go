@@ -0,0 +1,131 @@
+package fam100
+
+import "testing"
+
+func TestTournament(t *testing.T) {
+	name := "test-cup"
+	CancelTournament(name)
+	defer CancelTournament(name)
+
+	if _, err := NewTournament("Bad Name", "org", "chanA", 2, 1); err == nil {
+		t.Error("expecting invalid name error")
+	}
+	if _, err := NewTournament(name, "org", "chanA", 2, 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewTournament(name, "org", "chanA", 2, 1); err != ErrTournamentExists {
+		t.Fatalf("want %v got %v", ErrTournamentExists, err)
+	}
+	if _, err := AddTournamentChannel(name, "chanB"); err != nil {
+		t.Fatal(err)
+	}
+
+	// qualification, 2 games per channel
+	games := []struct {
+		chanID string
+		rank   Rank
+	}{
+		{"chanA", Rank{{PlayerID: "a1", Name: "A 1", Score: 30}, {PlayerID: "a2", Name: "A 2", Score: 20}}},
+		{"chanA", Rank{{PlayerID: "a2", Name: "A 2", Score: 40}}},
+		{"chanA", Rank{{PlayerID: "a1", Name: "A 1", Score: 90}}}, // after the qualification games, ignored
+		{"chanB", Rank{{PlayerID: "b1", Name: "B 1", Score: 10}}},
+		{"other", Rank{{PlayerID: "o1", Name: "O 1", Score: 100}}},
+	}
+	for _, g := range games {
+		if _, err := RecordTournamentGame(g.chanID, g.rank); err != nil {
+			t.Fatal(err)
+		}
+	}
+	standings, err := TournamentStandings(name, "chanA", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 2, len(standings); want != got {
+		t.Fatalf("standings want %d got %d", want, got)
+	}
+	if want, got := PlayerID("a2"), standings[0].PlayerID; want != got {
+		t.Errorf("leader want %s got %s", want, got)
+	}
+	if want, got := 60, standings[0].Score; want != got {
+		t.Errorf("leader score want %d got %d", want, got)
+	}
+	if f, _ := FinalTournament("chanA"); f != nil {
+		t.Fatal("final should not start before chanB played all games")
+	}
+
+	// last qualification game starts the final in the channel of the organiser
+	updated, err := RecordTournamentGame("chanB", Rank{{PlayerID: "b2", Name: "B 2", Score: 50}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 1, len(updated); want != got {
+		t.Fatalf("updated want %d got %d", want, got)
+	}
+	if want, got := TournamentFinal, updated[0].Stage; want != got {
+		t.Fatalf("stage want %s got %s", want, got)
+	}
+	final, err := FinalTournament("chanA")
+	if err != nil || final == nil {
+		t.Fatalf("final not found err %v", err)
+	}
+	for _, id := range []PlayerID{"a2", "b2"} {
+		if !final.IsFinalist(id) {
+			t.Errorf("%s should be finalist", id)
+		}
+	}
+	if final.IsFinalist("a1") {
+		t.Error("a1 should not be finalist")
+	}
+
+	// only finalists score in the final
+	updated, err = RecordTournamentGame("chanA", Rank{{PlayerID: "a1", Name: "A 1", Score: 100}, {PlayerID: "b2", Name: "B 2", Score: 30}, {PlayerID: "a2", Name: "A 2", Score: 20}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 1, len(updated); want != got {
+		t.Fatalf("updated want %d got %d", want, got)
+	}
+	if want, got := TournamentFinished, updated[0].Stage; want != got {
+		t.Fatalf("stage want %s got %s", want, got)
+	}
+	if updated[0].Winner == nil || updated[0].Winner.PlayerID != "b2" {
+		t.Errorf("winner want b2 got %v", updated[0].Winner)
+	}
+	if _, err := AddTournamentChannel(name, "chanC"); err == nil {
+		t.Error("expecting error joining finished tournament")
+	}
+}
+
+func TestTournamentEarlyFinal(t *testing.T) {
+	name := "test-early"
+	CancelTournament(name)
+	defer CancelTournament(name)
+
+	if _, err := NewTournament(name, "org", "chanA", 5, 1); err != nil {
+		t.Fatal(err)
+	}
+	RecordTournamentGame("chanA", Rank{{PlayerID: "a1", Name: "A 1", Score: 30}, {PlayerID: "a2", Name: "A 2", Score: 20}})
+	tour, err := StartTournamentFinal(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := TournamentFinal, tour.Stage; want != got {
+		t.Fatalf("stage want %s got %s", want, got)
+	}
+	if want, got := 1, len(tour.Finalists); want != got {
+		t.Fatalf("finalists want %d got %d", want, got)
+	}
+	if _, err := StartTournamentFinal(name); err == nil {
+		t.Error("expecting error starting final twice")
+	}
+
+	if err := CancelTournament(name); err != nil {
+		t.Fatal(err)
+	}
+	if tour, _ := DefaultDB.Tournament(name); tour != nil {
+		t.Error("cancelled tournament should be removed")
+	}
+	if standings, _ := TournamentStandings(name, "chanA", 0); len(standings) != 0 {
+		t.Errorf("standings should be removed, got %v", standings)
+	}
+}